@@ -0,0 +1,191 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	AccountAsset     = "asset"
+	AccountLiability = "liability"
+	AccountEquity    = "equity"
+	AccountIncome    = "income"
+	AccountExpense   = "expense"
+)
+
+var ErrUnbalancedJournal = errors.New("journal entries must have at least two lines summing to zero")
+
+type Account struct {
+	ID        string    `gorm:"primary_key;column:id"`
+	Type      string    `gorm:"column:type"`
+	Name      string    `gorm:"column:name"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (a *Account) TableName() string {
+	return "accounts"
+}
+
+// creditNormal reports whether credits increase the balance of the account.
+func (a *Account) creditNormal() bool {
+	return a.Type == AccountLiability || a.Type == AccountEquity || a.Type == AccountIncome
+}
+
+// WalletAccount is the account of a wallet, a liability since the balance
+// is money owed to the user.
+func WalletAccount(walletID string) Account {
+	return Account{ID: "wallet:" + walletID, Type: AccountLiability, Name: "wallet " + walletID}
+}
+
+type JournalTransaction struct {
+	ID          int64          `gorm:"primary_key;column:id;autoIncrement"`
+	Description string         `gorm:"column:description"`
+	CreatedAt   time.Time      `gorm:"column:created_at;autoCreateTime"`
+	Entries     []JournalEntry `gorm:"foreignKey:transaction_id;references:id"`
+}
+
+func (t *JournalTransaction) TableName() string {
+	return "journal_transactions"
+}
+
+// JournalEntry is one line of a journal transaction, debits are positive
+// amounts and credits negative ones.
+type JournalEntry struct {
+	ID            int64     `gorm:"primary_key;column:id;autoIncrement"`
+	TransactionID int64     `gorm:"column:transaction_id;index"`
+	AccountID     string    `gorm:"column:account_id;index"`
+	Amount        int64     `gorm:"column:amount"`
+	CreatedAt     time.Time `gorm:"column:created_at;autoCreateTime"`
+	Account       *Account  `gorm:"foreignKey:account_id;references:id"`
+}
+
+func (e *JournalEntry) TableName() string {
+	return "journal_entries"
+}
+
+func Debit(account Account, amount int64) JournalEntry {
+	return JournalEntry{AccountID: account.ID, Amount: amount, Account: &account}
+}
+
+func Credit(account Account, amount int64) JournalEntry {
+	return JournalEntry{AccountID: account.ID, Amount: -amount, Account: &account}
+}
+
+// PostJournal records a balanced journal transaction, it must be called with
+// the transaction of the operation it describes. Accounts of the entries are
+// created when missing.
+func PostJournal(tx *gorm.DB, description string, entries ...JournalEntry) (JournalTransaction, error) {
+	var sum int64
+	for _, entry := range entries {
+		sum += entry.Amount
+	}
+	if len(entries) < 2 || sum != 0 {
+		return JournalTransaction{}, ErrUnbalancedJournal
+	}
+
+	for _, entry := range entries {
+		if entry.Account == nil {
+			continue
+		}
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(entry.Account).Error
+		if err != nil {
+			return JournalTransaction{}, err
+		}
+	}
+
+	journal := JournalTransaction{Description: description}
+	err := tx.Create(&journal).Error
+	if err != nil {
+		return journal, err
+	}
+
+	journal.Entries = make([]JournalEntry, len(entries))
+	for i, entry := range entries {
+		journal.Entries[i] = JournalEntry{TransactionID: journal.ID, AccountID: entry.AccountID, Amount: entry.Amount}
+	}
+	return journal, tx.Create(&journal.Entries).Error
+}
+
+type TrialBalanceLine struct {
+	Account Account
+	Debits  int64
+	Credits int64
+}
+
+// Balance is positive when the account holds value on its normal side.
+func (l TrialBalanceLine) Balance() int64 {
+	if l.Account.creditNormal() {
+		return l.Credits - l.Debits
+	}
+	return l.Debits - l.Credits
+}
+
+type TrialBalance struct {
+	At      time.Time
+	Lines   []TrialBalanceLine
+	Debits  int64
+	Credits int64
+}
+
+// Balanced is false only when entries were written around PostJournal.
+func (b TrialBalance) Balanced() bool {
+	return b.Debits == b.Credits
+}
+
+// TrialBalanceAt totals the debits and credits of every account over the
+// entries posted up to at.
+func TrialBalanceAt(ctx context.Context, db *gorm.DB, at time.Time) (TrialBalance, error) {
+	var rows []struct {
+		AccountID string
+		Debits    int64
+		Credits   int64
+	}
+	err := db.WithContext(ctx).Model(&JournalEntry{}).
+		Select("account_id, sum(greatest(amount, 0)) as debits, sum(greatest(-amount, 0)) as credits").
+		Where("created_at <= ?", at).Group("account_id").Order("account_id").Scan(&rows).Error
+	if err != nil {
+		return TrialBalance{}, err
+	}
+
+	var accounts []Account
+	err = db.WithContext(ctx).Find(&accounts).Error
+	if err != nil {
+		return TrialBalance{}, err
+	}
+	byID := map[string]Account{}
+	for _, account := range accounts {
+		byID[account.ID] = account
+	}
+
+	balance := TrialBalance{At: at, Lines: make([]TrialBalanceLine, len(rows))}
+	for i, row := range rows {
+		balance.Lines[i] = TrialBalanceLine{Account: byID[row.AccountID], Debits: row.Debits, Credits: row.Credits}
+		balance.Lines[i].Account.ID = row.AccountID
+		balance.Debits += row.Debits
+		balance.Credits += row.Credits
+	}
+	return balance, nil
+}
+
+// AccountBalance is the balance of one account over all its entries.
+func AccountBalance(ctx context.Context, db *gorm.DB, accountID string) (int64, error) {
+	var account Account
+	err := db.WithContext(ctx).Take(&account, "id = ?", accountID).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var totals struct {
+		Debits  int64
+		Credits int64
+	}
+	err = db.WithContext(ctx).Model(&JournalEntry{}).
+		Select("coalesce(sum(greatest(amount, 0)), 0) as debits, coalesce(sum(greatest(-amount, 0)), 0) as credits").
+		Where("account_id = ?", accountID).Scan(&totals).Error
+	line := TrialBalanceLine{Account: account, Debits: totals.Debits, Credits: totals.Credits}
+	return line.Balance(), err
+}
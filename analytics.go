@@ -0,0 +1,167 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	IntervalDay   = "day"
+	IntervalWeek  = "week"
+	IntervalMonth = "month"
+)
+
+const maxSeriesBuckets = 1000
+
+var (
+	ErrInvalidInterval = errors.New("interval must be day, week or month")
+	ErrTooManyBuckets  = errors.New("time range has too many buckets for the interval")
+)
+
+// SeriesRange selects the buckets from the one containing From up to the
+// one containing To. Buckets start at midnight in Location, weeks on monday.
+type SeriesRange struct {
+	From     time.Time
+	To       time.Time
+	Interval string
+	Location *time.Location
+}
+
+type SeriesPoint struct {
+	Start time.Time `json:"start"`
+	Value int64     `json:"value"`
+}
+
+type Series struct {
+	Name     string        `json:"name"`
+	Interval string        `json:"interval"`
+	Points   []SeriesPoint `json:"points"`
+}
+
+func truncateToInterval(t time.Time, interval string) time.Time {
+	day := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+	switch interval {
+	case IntervalWeek:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case IntervalMonth:
+		return day.AddDate(0, 0, 1-day.Day())
+	}
+	return day
+}
+
+func nextInterval(t time.Time, interval string) time.Time {
+	switch interval {
+	case IntervalWeek:
+		return t.AddDate(0, 0, 7)
+	case IntervalMonth:
+		return t.AddDate(0, 1, 0)
+	}
+	return t.AddDate(0, 0, 1)
+}
+
+// Buckets returns the start of every bucket plus the end of the last one.
+// Days are calendar days, so around daylight saving changes a bucket is 23
+// or 25 hours long.
+func (r SeriesRange) Buckets() ([]time.Time, error) {
+	if r.Interval != IntervalDay && r.Interval != IntervalWeek && r.Interval != IntervalMonth {
+		return nil, ErrInvalidInterval
+	}
+	location := r.Location
+	if location == nil {
+		location = time.Local
+	}
+
+	start := truncateToInterval(r.From.In(location), r.Interval)
+	end := r.To.In(location)
+	bounds := []time.Time{start}
+	for !start.After(end) {
+		if len(bounds) > maxSeriesBuckets {
+			return nil, ErrTooManyBuckets
+		}
+		start = nextInterval(start, r.Interval)
+		bounds = append(bounds, start)
+	}
+	return bounds, nil
+}
+
+// seriesSource counts value over the rows of table whose column falls in a
+// bucket, column holds unix milliseconds when millis is set.
+type seriesSource struct {
+	name      string
+	table     string
+	column    string
+	value     string
+	condition string
+	millis    bool
+}
+
+var (
+	newUsersSeries       = seriesSource{name: "new_users", table: "users", column: "created_at", value: "count(t.id)"}
+	activeUsersSeries    = seriesSource{name: "active_users", table: "user_logs", column: "created_at", value: "count(distinct t.user_id)", millis: true}
+	completedTodosSeries = seriesSource{name: "todos_completed", table: "todos", column: "completed_at", value: "count(t.id)", condition: "t.deleted_at is null"}
+)
+
+// timeSeries joins the rows against a derived table of the buckets, so
+// empty buckets are kept with a zero value and bucket bounds computed in Go
+// follow the time zone rules of the location exactly.
+func timeSeries(ctx context.Context, db *gorm.DB, source seriesSource, r SeriesRange) (Series, error) {
+	series := Series{Name: source.name, Interval: r.Interval}
+	bounds, err := r.Buckets()
+	if err != nil || len(bounds) < 2 {
+		return series, err
+	}
+
+	selects := make([]string, len(bounds)-1)
+	args := make([]interface{}, 0, 3*len(selects))
+	for i := range selects {
+		selects[i] = "select ? as bucket, ? as bucket_start, ? as bucket_end"
+		if source.millis {
+			args = append(args, i, bounds[i].UnixMilli(), bounds[i+1].UnixMilli())
+		} else {
+			args = append(args, i, bounds[i], bounds[i+1])
+		}
+	}
+
+	on := "t." + source.column + " >= b.bucket_start and t." + source.column + " < b.bucket_end"
+	if source.condition != "" {
+		on += " and " + source.condition
+	}
+
+	var rows []struct {
+		Bucket int
+		Value  int64
+	}
+	err = db.WithContext(ctx).Raw("select b.bucket as bucket, "+source.value+" as value from ("+
+		strings.Join(selects, " union all ")+") b left join "+source.table+" t on "+on+
+		" group by b.bucket order by b.bucket", args...).Scan(&rows).Error
+	if err != nil {
+		return series, err
+	}
+
+	series.Points = make([]SeriesPoint, len(bounds)-1)
+	for i := range series.Points {
+		series.Points[i].Start = bounds[i]
+	}
+	for _, row := range rows {
+		series.Points[row.Bucket].Value = row.Value
+	}
+	return series, nil
+}
+
+func NewUsersSeries(ctx context.Context, db *gorm.DB, r SeriesRange) (Series, error) {
+	return timeSeries(ctx, db, newUsersSeries, r)
+}
+
+// ActiveUsersSeries counts the distinct users with at least one user log in
+// every bucket.
+func ActiveUsersSeries(ctx context.Context, db *gorm.DB, r SeriesRange) (Series, error) {
+	return timeSeries(ctx, db, activeUsersSeries, r)
+}
+
+func CompletedTodosSeries(ctx context.Context, db *gorm.DB, r SeriesRange) (Series, error) {
+	return timeSeries(ctx, db, completedTodosSeries, r)
+}
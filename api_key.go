@@ -0,0 +1,239 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const apiKeyPrefix = "lgg_"
+
+var (
+	ErrInvalidAPIKey = errors.New("api key is invalid, expired or revoked")
+	ErrMissingScope  = errors.New("api key lacks the required scope")
+)
+
+// APIKey grants access to the scopes in Scopes, a comma separated list where
+// "*" grants everything. Like sessions only the hash of the key is stored,
+// Hint keeps its last characters to tell keys apart.
+type APIKey struct {
+	ID          int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Name        string     `gorm:"column:name"`
+	Owner       string     `gorm:"column:owner;index"`
+	KeyHash     string     `gorm:"column:key_hash;type:char(64);uniqueIndex"`
+	Hint        string     `gorm:"column:hint"`
+	Scopes      string     `gorm:"column:scopes"`
+	ExpiresAt   *time.Time `gorm:"column:expires_at"`
+	LastUsedAt  *time.Time `gorm:"column:last_used_at"`
+	RevokedAt   *time.Time `gorm:"column:revoked_at"`
+	RotatedFrom *int64     `gorm:"column:rotated_from"`
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (k *APIKey) TableName() string {
+	return "api_keys"
+}
+
+func (k *APIKey) HasScope(scope string) bool {
+	for _, granted := range strings.Split(k.Scopes, ",") {
+		granted = strings.TrimSpace(granted)
+		if granted == "*" || granted == scope {
+			return true
+		}
+	}
+	return false
+}
+
+type apiKeyContextKey struct{}
+
+func WithAPIKey(ctx context.Context, key APIKey) context.Context {
+	return context.WithValue(ctx, apiKeyContextKey{}, key)
+}
+
+func APIKeyFromContext(ctx context.Context) (APIKey, bool) {
+	key, ok := ctx.Value(apiKeyContextKey{}).(APIKey)
+	return key, ok
+}
+
+// RequireScope is checked by code reached through the middleware, e.g. a
+// repository method, contexts without an API key are not restricted.
+func RequireScope(ctx context.Context, scope string) error {
+	key, ok := APIKeyFromContext(ctx)
+	if ok && !key.HasScope(scope) {
+		return ErrMissingScope
+	}
+	return nil
+}
+
+// APIKeyService issues and checks API keys. Uses are collected in memory and
+// written to last_used_at by FlushUsage, not on every request.
+type APIKeyService struct {
+	DB *gorm.DB
+
+	mutex sync.Mutex
+	used  map[int64]time.Time
+}
+
+func NewAPIKeyService(db *gorm.DB) *APIKeyService {
+	return &APIKeyService{DB: db, used: map[int64]time.Time{}}
+}
+
+// Issue creates a key and returns it in plain text, the only time it is
+// available. A zero ttl never expires.
+func (s *APIKeyService) Issue(ctx context.Context, name string, owner string, scopes []string, ttl time.Duration) (string, APIKey, error) {
+	return s.issue(s.DB.WithContext(ctx), APIKey{Name: name, Owner: owner, Scopes: strings.Join(scopes, ",")}, ttl)
+}
+
+func (s *APIKeyService) issue(db *gorm.DB, key APIKey, ttl time.Duration) (string, APIKey, error) {
+	token, err := newToken(db)
+	if err != nil {
+		return "", key, err
+	}
+	token = apiKeyPrefix + token
+
+	key.KeyHash = hashToken(token)
+	key.Hint = token[len(token)-4:]
+	if ttl > 0 {
+		expiresAt := Now(db).Add(ttl)
+		key.ExpiresAt = &expiresAt
+	}
+	return token, key, db.Create(&key).Error
+}
+
+// Rotate issues a key with the name, owner and scopes of key id, the old key
+// keeps working for grace so clients can switch over.
+func (s *APIKeyService) Rotate(ctx context.Context, id int64, ttl time.Duration, grace time.Duration) (string, APIKey, error) {
+	var token string
+	var rotated APIKey
+	err := s.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var old APIKey
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("revoked_at IS NULL").Take(&old, id).Error
+		if err != nil {
+			return err
+		}
+
+		token, rotated, err = s.issue(tx, APIKey{Name: old.Name, Owner: old.Owner, Scopes: old.Scopes, RotatedFrom: &old.ID}, ttl)
+		if err != nil {
+			return err
+		}
+
+		expiresAt := Now(tx).Add(grace)
+		if old.ExpiresAt != nil && old.ExpiresAt.Before(expiresAt) {
+			return nil
+		}
+		return tx.Model(&old).Update("expires_at", expiresAt).Error
+	})
+	return token, rotated, err
+}
+
+func (s *APIKeyService) Revoke(ctx context.Context, id int64) error {
+	return s.DB.WithContext(ctx).Model(&APIKey{}).Where("id = ? AND revoked_at IS NULL", id).
+		Update("revoked_at", Now(s.DB)).Error
+}
+
+func (s *APIKeyService) Authenticate(ctx context.Context, token string) (APIKey, error) {
+	var key APIKey
+	if !strings.HasPrefix(token, apiKeyPrefix) {
+		return key, ErrInvalidAPIKey
+	}
+
+	now := Now(s.DB)
+	err := s.DB.WithContext(ctx).
+		Where("key_hash = ? AND revoked_at IS NULL AND (expires_at IS NULL OR expires_at > ?)", hashToken(token), now).
+		Take(&key).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return key, ErrInvalidAPIKey
+	}
+	if err != nil {
+		return key, err
+	}
+
+	s.mutex.Lock()
+	s.used[key.ID] = now
+	s.mutex.Unlock()
+	return key, nil
+}
+
+// FlushUsage writes the collected uses with a single UPDATE.
+func (s *APIKeyService) FlushUsage(ctx context.Context) error {
+	s.mutex.Lock()
+	used := s.used
+	s.used = map[int64]time.Time{}
+	s.mutex.Unlock()
+	if len(used) == 0 {
+		return nil
+	}
+
+	ids := make([]int64, 0, len(used))
+	cases := make([]string, 0, len(used))
+	args := make([]interface{}, 0, 2*len(used))
+	for id, at := range used {
+		ids = append(ids, id)
+		cases = append(cases, "when ? then ?")
+		args = append(args, id, at)
+	}
+	err := s.DB.WithContext(ctx).Model(&APIKey{}).Where("id IN ?", ids).
+		Update("last_used_at", gorm.Expr("case id "+strings.Join(cases, " ")+" end", args...)).Error
+	if err != nil {
+		// keep the uses for the next flush unless newer ones came in
+		s.mutex.Lock()
+		for id, at := range used {
+			if _, ok := s.used[id]; !ok {
+				s.used[id] = at
+			}
+		}
+		s.mutex.Unlock()
+	}
+	return err
+}
+
+// RunUsageFlusher flushes every interval until ctx is done, then once more.
+func (s *APIKeyService) RunUsageFlusher(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return s.FlushUsage(context.Background())
+		case <-ticker.C:
+			_ = s.FlushUsage(ctx)
+		}
+	}
+}
+
+func apiKeyFromRequest(r *http.Request) string {
+	if key := r.Header.Get("X-API-Key"); key != "" {
+		return key
+	}
+	return strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+}
+
+// Middleware only lets requests with a valid key holding scope through, the
+// key is available to next with APIKeyFromContext.
+func (s *APIKeyService) Middleware(scope string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		key, err := s.Authenticate(r.Context(), apiKeyFromRequest(r))
+		if errors.Is(err, ErrInvalidAPIKey) {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !key.HasScope(scope) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(WithAPIKey(r.Context(), key)))
+	})
+}
@@ -0,0 +1,212 @@
+package learn_golang_gorm
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ChangePending  = "pending"
+	ChangeExecuted = "executed"
+	ChangeRejected = "rejected"
+	ChangeFailed   = "failed"
+)
+
+const (
+	OperationTransfer   = "wallet_transfer"
+	OperationHardDelete = "hard_delete"
+)
+
+var (
+	ErrSelfApproval     = errors.New("a change must be approved by someone other than its requester")
+	ErrChangeNotPending = errors.New("change is no longer pending")
+	ErrUnknownOperation = errors.New("unknown sensitive operation")
+	ErrNotDeletable     = errors.New("table is not a registered model table")
+)
+
+// PendingChange is a sensitive operation waiting for a second person, the
+// arguments are kept as JSON in Payload until it is approved.
+type PendingChange struct {
+	ID          int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Operation   string     `gorm:"column:operation"`
+	Payload     string     `gorm:"column:payload;type:json"`
+	Status      string     `gorm:"column:status;index"`
+	RequestedBy string     `gorm:"column:requested_by"`
+	DecidedBy   *string    `gorm:"column:decided_by"`
+	Error       string     `gorm:"column:error"`
+	CreatedAt   time.Time  `gorm:"column:created_at;autoCreateTime"`
+	DecidedAt   *time.Time `gorm:"column:decided_at"`
+}
+
+func (c *PendingChange) TableName() string {
+	return "pending_changes"
+}
+
+type TransferPayload struct {
+	From   string `json:"from"`
+	To     string `json:"to"`
+	Amount int64  `json:"amount"`
+}
+
+type HardDeletePayload struct {
+	Table string      `json:"table"`
+	ID    interface{} `json:"id"`
+}
+
+// SensitiveOperation runs an approved change inside the approval transaction.
+type SensitiveOperation func(tx *gorm.DB, payload json.RawMessage) error
+
+var sensitiveOperations = map[string]SensitiveOperation{
+	OperationTransfer: func(tx *gorm.DB, payload json.RawMessage) error {
+		var transfer TransferPayload
+		err := json.Unmarshal(payload, &transfer)
+		if err != nil {
+			return err
+		}
+		return TransferBalance(tx, transfer.From, transfer.To, transfer.Amount)
+	},
+	OperationHardDelete: func(tx *gorm.DB, payload json.RawMessage) error {
+		var hardDelete HardDeletePayload
+		decoder := json.NewDecoder(bytes.NewReader(payload))
+		decoder.UseNumber()
+		err := decoder.Decode(&hardDelete)
+		if err != nil {
+			return err
+		}
+
+		tables, err := ModelTables(tx)
+		if err != nil {
+			return err
+		}
+		for _, table := range tables {
+			if table == hardDelete.Table {
+				return tx.Exec("DELETE FROM ? WHERE id = ?", clause.Table{Name: table}, hardDelete.ID).Error
+			}
+		}
+		return ErrNotDeletable
+	},
+}
+
+func RegisterSensitiveOperation(name string, operation SensitiveOperation) {
+	sensitiveOperations[name] = operation
+}
+
+// LargeTransferThreshold is the amount from which TransferWithApproval
+// needs a second person.
+var LargeTransferThreshold int64 = 10000000
+
+// RequestChange stores a sensitive operation for approval.
+func RequestChange(ctx context.Context, db *gorm.DB, operation string, payload interface{}, requestedBy string) (PendingChange, error) {
+	if _, ok := sensitiveOperations[operation]; !ok {
+		return PendingChange{}, ErrUnknownOperation
+	}
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return PendingChange{}, err
+	}
+
+	change := PendingChange{Operation: operation, Payload: string(data), Status: ChangePending, RequestedBy: requestedBy}
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&change).Error
+		if err != nil {
+			return err
+		}
+		return WriteAuditLog(tx, requestedBy, "request", "pending_changes", fmt.Sprint(change.ID), payload)
+	})
+	return change, err
+}
+
+func lockPendingChange(tx *gorm.DB, id int64, approver string) (PendingChange, error) {
+	var change PendingChange
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&change, id).Error
+	if err != nil {
+		return change, err
+	}
+	if change.Status != ChangePending {
+		return change, ErrChangeNotPending
+	}
+	if change.RequestedBy == approver {
+		return change, ErrSelfApproval
+	}
+	return change, nil
+}
+
+func decideChange(tx *gorm.DB, change *PendingChange, status string, approver string, reason string) error {
+	now := Now(tx)
+	change.Status = status
+	change.DecidedBy = &approver
+	change.DecidedAt = &now
+	change.Error = reason
+	err := tx.Model(change).Select("status", "decided_by", "decided_at", "error").Updates(change).Error
+	if err != nil {
+		return err
+	}
+	return WriteAuditLog(tx, approver, status, "pending_changes", fmt.Sprint(change.ID), map[string]interface{}{
+		"operation": change.Operation,
+		"payload":   json.RawMessage(change.Payload),
+		"error":     reason,
+	})
+}
+
+// Approve executes change id in the same transaction that marks it executed.
+// When the operation fails its effects are rolled back and the change is
+// marked failed, the error is returned.
+func Approve(ctx context.Context, db *gorm.DB, id int64, approver string) (PendingChange, error) {
+	db = db.WithContext(ctx)
+	var change PendingChange
+	var operationErr error
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var err error
+		change, err = lockPendingChange(tx, id, approver)
+		if err != nil {
+			return err
+		}
+
+		operation, ok := sensitiveOperations[change.Operation]
+		if !ok {
+			operationErr = ErrUnknownOperation
+		} else {
+			operationErr = tx.Transaction(func(tx *gorm.DB) error {
+				return operation(tx, json.RawMessage(change.Payload))
+			})
+		}
+		if operationErr != nil {
+			return decideChange(tx, &change, ChangeFailed, approver, operationErr.Error())
+		}
+		return decideChange(tx, &change, ChangeExecuted, approver, "")
+	})
+	if err != nil {
+		return change, err
+	}
+	return change, operationErr
+}
+
+func Reject(ctx context.Context, db *gorm.DB, id int64, approver string, reason string) (PendingChange, error) {
+	var change PendingChange
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		var err error
+		change, err = lockPendingChange(tx, id, approver)
+		if err != nil {
+			return err
+		}
+		return decideChange(tx, &change, ChangeRejected, approver, reason)
+	})
+	return change, err
+}
+
+// TransferWithApproval transfers right away below LargeTransferThreshold,
+// larger amounts return the pending change waiting for approval.
+func TransferWithApproval(ctx context.Context, db *gorm.DB, from string, to string, amount int64, actor string) (*PendingChange, error) {
+	if amount < LargeTransferThreshold {
+		return nil, TransferBalance(db.WithContext(ctx), from, to, amount)
+	}
+	change, err := RequestChange(ctx, db, OperationTransfer, TransferPayload{From: from, To: to, Amount: amount}, actor)
+	return &change, err
+}
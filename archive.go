@@ -0,0 +1,129 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// ArchivePolicy selects the cold rows of Table, rows matching Cold for the
+// cutoff are moved to Table + "_archive".
+type ArchivePolicy struct {
+	Table     string
+	Key       string
+	BatchSize int
+	Cold      func(db *gorm.DB, cutoff time.Time) *gorm.DB
+}
+
+var (
+	UserLogArchive = ArchivePolicy{
+		Table:     "user_logs",
+		Key:       "id",
+		BatchSize: 1000,
+		Cold: func(db *gorm.DB, cutoff time.Time) *gorm.DB {
+			return db.Where("created_at < ?", cutoff.UnixMilli())
+		},
+	}
+	CompletedTodoArchive = ArchivePolicy{
+		Table:     "todos",
+		Key:       "id",
+		BatchSize: 500,
+		Cold: func(db *gorm.DB, cutoff time.Time) *gorm.DB {
+			return db.Where("completed_at IS NOT NULL AND completed_at < ?", cutoff)
+		},
+	}
+)
+
+func ArchiveTable(table string) string {
+	return table + "_archive"
+}
+
+// archiveColumns creates the archive table like the live one when missing
+// and returns the columns both tables have, so a column added to the live
+// table later does not break archiving.
+func archiveColumns(db *gorm.DB, table string) (string, error) {
+	archive := ArchiveTable(table)
+	err := db.Exec("CREATE TABLE IF NOT EXISTS ? LIKE ?", clause.Table{Name: archive}, clause.Table{Name: table}).Error
+	if err != nil {
+		return "", err
+	}
+
+	live, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return "", err
+	}
+	archived, err := db.Migrator().ColumnTypes(archive)
+	if err != nil {
+		return "", err
+	}
+
+	present := map[string]bool{}
+	for _, column := range archived {
+		present[column.Name()] = true
+	}
+	var columns []string
+	for _, column := range live {
+		if present[column.Name()] {
+			columns = append(columns, db.Statement.Quote(column.Name()))
+		}
+	}
+	return strings.Join(columns, ", "), nil
+}
+
+// Archive moves the rows of policy older than cutoff into the archive table,
+// one batch per transaction: the batch is locked, copied with INSERT ...
+// SELECT and deleted, so a row is always in exactly one of both tables.
+func Archive(ctx context.Context, db *gorm.DB, policy ArchivePolicy, cutoff time.Time) (int64, error) {
+	db = db.WithContext(ctx)
+	columns, err := archiveColumns(db, policy.Table)
+	if err != nil {
+		return 0, err
+	}
+
+	var archived int64
+	for {
+		var keys []interface{}
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := policy.Cold(tx.Table(policy.Table), cutoff).
+				Clauses(clause.Locking{Strength: "UPDATE"}).
+				Order(clause.OrderByColumn{Column: clause.Column{Name: policy.Key}}).
+				Limit(policy.BatchSize).Pluck(policy.Key, &keys).Error
+			if err != nil || len(keys) == 0 {
+				return err
+			}
+
+			err = tx.Exec("INSERT INTO ? ("+columns+") SELECT "+columns+" FROM ? WHERE ? IN ?",
+				clause.Table{Name: ArchiveTable(policy.Table)}, clause.Table{Name: policy.Table},
+				clause.Column{Name: policy.Key}, keys).Error
+			if err != nil {
+				return err
+			}
+
+			return tx.Exec("DELETE FROM ? WHERE ? IN ?",
+				clause.Table{Name: policy.Table}, clause.Column{Name: policy.Key}, keys).Error
+		})
+		if err != nil {
+			return archived, err
+		}
+
+		archived += int64(len(keys))
+		if len(keys) < policy.BatchSize {
+			return archived, nil
+		}
+	}
+}
+
+// IncludeArchived makes a query read table together with its archive, e.g.
+// db.Scopes(IncludeArchived("user_logs")).Where("user_id = ?", id).Find(&logs).
+// Both tables need the same columns, as when no column was added since the
+// archive table was created.
+func IncludeArchived(table string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		union := db.Session(&gorm.Session{NewDB: true}).Raw("SELECT * FROM ? UNION ALL SELECT * FROM ?",
+			clause.Table{Name: table}, clause.Table{Name: ArchiveTable(table)})
+		return db.Table("(?) AS "+table, union)
+	}
+}
@@ -0,0 +1,55 @@
+package learn_golang_gorm
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type AuditLog struct {
+	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
+	Actor     string    `gorm:"column:actor"`
+	Action    string    `gorm:"column:action"`
+	Entity    string    `gorm:"column:entity"`
+	EntityID  string    `gorm:"column:entity_id"`
+	Payload   string    `gorm:"column:payload;type:text"`
+	HashChain HashChain `gorm:"embedded"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (a *AuditLog) TableName() string {
+	return "audit_logs"
+}
+
+func (a *AuditLog) ChainID() int64 {
+	return a.ID
+}
+
+func (a *AuditLog) ChainLink() *HashChain {
+	return &a.HashChain
+}
+
+// ChainContent covers CreatedAt, which AppendChained sets from the clock of
+// its transaction.
+func (a *AuditLog) ChainContent() string {
+	return fmt.Sprintf("%s|%s|%s|%s|%s|%d", a.Actor, a.Action, a.Entity, a.EntityID, a.Payload, a.CreatedAt.UnixMilli())
+}
+
+// WriteAuditLog appends an audit record to the hash chain, payload is stored
+// as JSON.
+func WriteAuditLog(tx *gorm.DB, actor string, action string, entity string, entityID string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	return AppendChained(tx, &AuditLog{
+		Actor:    actor,
+		Action:   action,
+		Entity:   entity,
+		EntityID: entityID,
+		Payload:  string(data),
+	})
+}
@@ -0,0 +1,360 @@
+package learn_golang_gorm
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrTableExists = errors.New("table already exists, restore with DropExisting to replace it")
+
+const backupManifestFile = "manifest.json"
+
+type BackupTable struct {
+	Name      string   `json:"name"`
+	Schema    string   `json:"schema"`
+	Columns   []string `json:"columns"`
+	DependsOn []string `json:"depends_on"`
+	Rows      int64    `json:"rows"`
+	File      string   `json:"file"`
+}
+
+type BackupManifest struct {
+	Database  string        `json:"database"`
+	CreatedAt time.Time     `json:"created_at"`
+	Tables    []BackupTable `json:"tables"`
+}
+
+// backupTables describes every base table of the current database, the
+// columns exclude generated ones since they can not be inserted.
+func backupTables(db *gorm.DB) ([]BackupTable, error) {
+	var names []string
+	err := db.Raw(`SELECT table_name FROM information_schema.tables
+		WHERE table_schema = DATABASE() AND table_type = 'BASE TABLE' ORDER BY table_name`).Scan(&names).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var columns []struct {
+		TableName  string
+		ColumnName string
+	}
+	err = db.Raw(`SELECT table_name AS table_name, column_name AS column_name FROM information_schema.columns
+		WHERE table_schema = DATABASE() AND extra NOT LIKE '%GENERATED%' ORDER BY table_name, ordinal_position`).
+		Scan(&columns).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var references []struct {
+		TableName           string
+		ReferencedTableName string
+	}
+	err = db.Raw(`SELECT DISTINCT table_name AS table_name, referenced_table_name AS referenced_table_name
+		FROM information_schema.key_column_usage
+		WHERE table_schema = DATABASE() AND referenced_table_name IS NOT NULL AND referenced_table_name <> table_name`).
+		Scan(&references).Error
+	if err != nil {
+		return nil, err
+	}
+
+	tables := make([]BackupTable, len(names))
+	index := map[string]int{}
+	for i, name := range names {
+		tables[i] = BackupTable{Name: name, File: name + ".jsonl.gz"}
+		index[name] = i
+	}
+	for _, column := range columns {
+		if i, ok := index[column.TableName]; ok {
+			tables[i].Columns = append(tables[i].Columns, column.ColumnName)
+		}
+	}
+	for _, reference := range references {
+		if i, ok := index[reference.TableName]; ok {
+			tables[i].DependsOn = append(tables[i].DependsOn, reference.ReferencedTableName)
+		}
+	}
+
+	for i := range tables {
+		var name, schema string
+		err = db.Raw("SHOW CREATE TABLE ?", clause.Table{Name: tables[i].Name}).Row().Scan(&name, &schema)
+		if err != nil {
+			return nil, err
+		}
+		tables[i].Schema = schema
+	}
+	return tables, nil
+}
+
+func quoteColumns(db *gorm.DB, columns []string) string {
+	quoted := make([]string, len(columns))
+	for i, column := range columns {
+		quoted[i] = db.Statement.Quote(column)
+	}
+	return strings.Join(quoted, ", ")
+}
+
+func dumpTable(tx *gorm.DB, dir string, table *BackupTable) error {
+	kinds, err := exportColumnKinds(tx, table.Name)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(filepath.Join(dir, table.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	writer := gzip.NewWriter(file)
+	encoder := json.NewEncoder(writer)
+
+	rows, err := tx.Table(table.Name).Select(quoteColumns(tx, table.Columns)).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		row := map[string]interface{}{}
+		err = tx.ScanRows(rows, &row)
+		if err != nil {
+			return err
+		}
+		err = encodeExportRow(encoder, row, kinds)
+		if err != nil {
+			return err
+		}
+		table.Rows++
+	}
+	if rows.Err() != nil {
+		return rows.Err()
+	}
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// Backup dumps every table of the database into dir, one gzipped JSON lines
+// file per table next to a manifest with the table definitions. All tables
+// are read in one consistent snapshot transaction, so the backup matches a
+// single point in time even while the application keeps writing.
+func Backup(ctx context.Context, db *gorm.DB, dir string) (BackupManifest, error) {
+	manifest := BackupManifest{CreatedAt: Now(db)}
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return manifest, err
+	}
+
+	err = db.WithContext(ctx).Connection(func(conn *gorm.DB) error {
+		err := conn.Exec("SET TRANSACTION ISOLATION LEVEL REPEATABLE READ").Error
+		if err != nil {
+			return err
+		}
+		err = conn.Exec("START TRANSACTION WITH CONSISTENT SNAPSHOT, READ ONLY").Error
+		if err != nil {
+			return err
+		}
+		defer conn.Exec("ROLLBACK")
+
+		err = conn.Raw("SELECT DATABASE()").Scan(&manifest.Database).Error
+		if err != nil {
+			return err
+		}
+
+		manifest.Tables, err = backupTables(conn)
+		if err != nil {
+			return err
+		}
+
+		for i := range manifest.Tables {
+			err = dumpTable(conn, dir, &manifest.Tables[i])
+			if err != nil {
+				return fmt.Errorf("backup %s: %w", manifest.Tables[i].Name, err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return manifest, err
+	}
+
+	data, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return manifest, err
+	}
+	return manifest, os.WriteFile(filepath.Join(dir, backupManifestFile), data, 0o644)
+}
+
+// restoreLevels groups tables so every table comes after the tables it
+// references, tables of one level are independent of each other. Tables in
+// a reference cycle or referencing a table outside the backup end up in the
+// last level.
+func restoreLevels(tables []BackupTable) [][]BackupTable {
+	restored := map[string]bool{}
+	remaining := append([]BackupTable(nil), tables...)
+	var levels [][]BackupTable
+	for len(remaining) > 0 {
+		var current, next []BackupTable
+		for _, table := range remaining {
+			ready := true
+			for _, dependency := range table.DependsOn {
+				if !restored[dependency] {
+					ready = false
+				}
+			}
+			if ready {
+				current = append(current, table)
+			} else {
+				next = append(next, table)
+			}
+		}
+
+		if len(current) == 0 {
+			current, next = next, nil
+		}
+		for _, table := range current {
+			restored[table.Name] = true
+		}
+		levels = append(levels, current)
+		remaining = next
+	}
+	return levels
+}
+
+type RestoreOptions struct {
+	Parallelism  int
+	BatchSize    int
+	DropExisting bool
+}
+
+func restoreTable(ctx context.Context, db *gorm.DB, dir string, table BackupTable, batchSize int) error {
+	db = db.WithContext(ctx)
+	kinds, err := exportColumnKinds(db, table.Name)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Open(filepath.Join(dir, table.File))
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := gzip.NewReader(file)
+	if err != nil {
+		return err
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	batch := make([]map[string]interface{}, 0, batchSize)
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := db.Table(table.Name).Create(batch).Error
+		batch = batch[:0]
+		return err
+	}
+
+	for scanner.Scan() {
+		row, err := decodeExportRow(scanner.Bytes(), kinds)
+		if err != nil {
+			return err
+		}
+		batch = append(batch, row)
+		if len(batch) == batchSize {
+			err = flush()
+			if err != nil {
+				return err
+			}
+		}
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+	return flush()
+}
+
+// Restore recreates the tables of the backup in dir and loads their rows,
+// referenced tables first and up to Parallelism tables at the same time.
+func Restore(ctx context.Context, db *gorm.DB, dir string, options RestoreOptions) error {
+	if options.Parallelism <= 0 {
+		options.Parallelism = 4
+	}
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, backupManifestFile))
+	if err != nil {
+		return err
+	}
+	var manifest BackupManifest
+	err = json.Unmarshal(data, &manifest)
+	if err != nil {
+		return err
+	}
+
+	db = db.WithContext(ctx)
+	levels := restoreLevels(manifest.Tables)
+	for i := len(levels) - 1; i >= 0; i-- {
+		for _, table := range levels[i] {
+			if !db.Migrator().HasTable(table.Name) {
+				continue
+			}
+			if !options.DropExisting {
+				return fmt.Errorf("%w: %s", ErrTableExists, table.Name)
+			}
+			err = db.Migrator().DropTable(table.Name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	for _, level := range levels {
+		// the largest tables start first so they do not hold up the level
+		sort.Slice(level, func(i, j int) bool { return level[i].Rows > level[j].Rows })
+		for _, table := range level {
+			err = db.Exec(table.Schema).Error
+			if err != nil {
+				return fmt.Errorf("create %s: %w", table.Name, err)
+			}
+		}
+
+		group, groupCtx := errgroup.WithContext(ctx)
+		group.SetLimit(options.Parallelism)
+		for _, table := range level {
+			table := table
+			group.Go(func() error {
+				err := restoreTable(groupCtx, db, dir, table, options.BatchSize)
+				if err != nil {
+					return fmt.Errorf("restore %s: %w", table.Name, err)
+				}
+				return nil
+			})
+		}
+		err = group.Wait()
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,192 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	BatchCreateTodo    = "create_todo"
+	BatchUpdateAddress = "update_address"
+	BatchDeleteLike    = "delete_like"
+)
+
+const MaxBatchOperations = 100
+
+var (
+	ErrUnknownBatchOperation = fmt.Errorf("%w: unknown batch operation", ErrInvalidRequest)
+	ErrBatchTooLarge         = fmt.Errorf("%w: a batch holds at most %d operations", ErrInvalidRequest, MaxBatchOperations)
+	ErrBatchRolledBack       = errors.New("rolled back, another operation of the batch failed")
+)
+
+type BatchOperation struct {
+	Op   string          `json:"op"`
+	Args json.RawMessage `json:"args"`
+}
+
+// BatchResult reports one operation, Result is what it returned when the
+// batch was committed.
+type BatchResult struct {
+	Op     string      `json:"op"`
+	Status int         `json:"status"`
+	Result interface{} `json:"result,omitempty"`
+	Error  string      `json:"error,omitempty"`
+}
+
+// BatchOperationFunc runs an operation for userID in the batch transaction,
+// it may only touch rows of that user.
+type BatchOperationFunc func(tx *gorm.DB, userID string, args json.RawMessage) (interface{}, error)
+
+func decodeBatchArgs(args json.RawMessage, value interface{}) error {
+	err := json.Unmarshal(args, value)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+var batchOperations = map[string]BatchOperationFunc{
+	BatchCreateTodo: func(tx *gorm.DB, userID string, args json.RawMessage) (interface{}, error) {
+		var input struct {
+			Title       string `json:"title"`
+			Description string `json:"description"`
+		}
+		err := decodeBatchArgs(args, &input)
+		if err != nil {
+			return nil, err
+		}
+		todo := Todo{UserId: userID, Title: input.Title, Description: input.Description}
+		return todo, tx.Create(&todo).Error
+	},
+	BatchUpdateAddress: func(tx *gorm.DB, userID string, args json.RawMessage) (interface{}, error) {
+		var input struct {
+			ID      int64  `json:"id"`
+			Address string `json:"address"`
+		}
+		err := decodeBatchArgs(args, &input)
+		if err != nil {
+			return nil, err
+		}
+		var address Address
+		err = tx.Take(&address, "id = ? AND user_id = ?", input.ID, userID).Error
+		if err != nil {
+			return nil, err
+		}
+		return address, tx.Model(&address).Update("address", input.Address).Error
+	},
+	BatchDeleteLike: func(tx *gorm.DB, userID string, args json.RawMessage) (interface{}, error) {
+		var input struct {
+			ProductID string `json:"product_id"`
+		}
+		err := decodeBatchArgs(args, &input)
+		if err != nil {
+			return nil, err
+		}
+		result := tx.Table("user_like_product").Where("user_id = ? AND product_id = ?", userID, input.ProductID).
+			Delete(&userLikeProduct{})
+		if result.Error == nil && result.RowsAffected == 0 {
+			return nil, gorm.ErrRecordNotFound
+		}
+		return nil, result.Error
+	},
+}
+
+func RegisterBatchOperation(name string, operation BatchOperationFunc) {
+	batchOperations[name] = operation
+}
+
+// ExecuteBatch runs operations in order in one transaction, all or none of
+// them take effect. The results always hold one entry per operation, the
+// returned error is the one of the failed operation, or of the transaction
+// when committing failed.
+func ExecuteBatch(ctx context.Context, db *gorm.DB, userID string, operations []BatchOperation) ([]BatchResult, error) {
+	if len(operations) > MaxBatchOperations {
+		return nil, ErrBatchTooLarge
+	}
+
+	results := make([]BatchResult, len(operations))
+	failed := -1
+	err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for i, operation := range operations {
+			run, ok := batchOperations[operation.Op]
+			if !ok {
+				failed = i
+				return fmt.Errorf("%w: %q", ErrUnknownBatchOperation, operation.Op)
+			}
+			result, err := run(tx, userID, operation.Args)
+			if err != nil {
+				failed = i
+				return err
+			}
+			results[i] = BatchResult{Op: operation.Op, Status: http.StatusOK, Result: result}
+		}
+		return nil
+	})
+	if err == nil {
+		return results, nil
+	}
+
+	// without a failed operation the transaction itself failed, e.g. on
+	// commit, and every operation reports why
+	for i := range results {
+		if failed < 0 {
+			results[i] = BatchResult{Op: operations[i].Op, Status: HTTPStatus(err), Error: err.Error()}
+			continue
+		}
+		results[i] = BatchResult{Op: operations[i].Op, Status: http.StatusFailedDependency, Error: ErrBatchRolledBack.Error()}
+	}
+	if failed >= 0 {
+		results[failed] = BatchResult{Op: operations[failed].Op, Status: HTTPStatus(err), Error: err.Error()}
+	}
+	return results, err
+}
+
+// BatchHandler serves POST /batch for the user of the bearer session token,
+// the body is {"operations": [{"op": "create_todo", "args": {...}}, ...]}.
+type BatchHandler struct {
+	DB       *gorm.DB
+	Sessions *SessionStore
+}
+
+func NewBatchHandler(db *gorm.DB, sessions *SessionStore) *BatchHandler {
+	return &BatchHandler{DB: db, Sessions: sessions}
+}
+
+func (h *BatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	session, err := h.Sessions.Validate(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+
+	var body struct {
+		Operations []BatchOperation `json:"operations"`
+	}
+	err = json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode(&body)
+	if err != nil {
+		WriteError(w, fmt.Errorf("%w: %v", ErrInvalidRequest, err))
+		return
+	}
+
+	results, err := ExecuteBatch(r.Context(), h.DB, session.UserID, body.Operations)
+	if results == nil {
+		WriteError(w, err)
+		return
+	}
+	status := http.StatusOK
+	if err != nil {
+		status = HTTPStatus(err)
+	}
+	WriteJSON(w, status, map[string]interface{}{"committed": err == nil, "results": results})
+}
@@ -0,0 +1,103 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// BulkUpdateChunkSize is the number of rows one statement of BulkUpdate
+// updates.
+const BulkUpdateChunkSize = 500
+
+// Changes are the new values of the columns of a row, by field or column
+// name. A value may be an expression like gorm.Expr("price * 2").
+type Changes map[string]interface{}
+
+// BulkUpdate applies the changes of each primary key to the rows of model,
+// with one statement per chunk of rows rather than one per row:
+//
+//	UPDATE products SET price = CASE id WHEN 'p1' THEN 1000 WHEN 'p2' THEN 2000 ELSE price END
+//	WHERE id IN ('p1','p2')
+//
+// The chunks run in a transaction, it returns the number of rows changed.
+// Each chunk is an Updates of model, which sets updated_at.
+func BulkUpdate[K comparable](ctx context.Context, db *gorm.DB, model interface{}, updates map[K]Changes) (int64, error) {
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(model)
+	if err != nil {
+		return 0, err
+	}
+	primary := stmt.Schema.PrioritizedPrimaryField
+	if primary == nil {
+		return 0, fmt.Errorf("%s has no primary key", stmt.Schema.Name)
+	}
+
+	ids := make([]K, 0, len(updates))
+	for id, changes := range updates {
+		for name := range changes {
+			field := stmt.Schema.LookUpField(name)
+			if field == nil || field.DBName == "" {
+				return 0, fmt.Errorf("%w: %s has no column %q", ErrInvalidRequest, stmt.Schema.Table, name)
+			}
+			if field.PrimaryKey {
+				return 0, fmt.Errorf("%w: bulk updates do not change the primary key %q", ErrInvalidRequest, name)
+			}
+		}
+		if len(changes) > 0 {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		return lessValue(ids[i], ids[j])
+	})
+
+	var rows int64
+	err = db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for start := 0; start < len(ids); start += BulkUpdateChunkSize {
+			chunk := ids[start:min(start+BulkUpdateChunkSize, len(ids))]
+			result := tx.Model(model).
+				Where(clause.IN{Column: clause.Column{Table: clause.CurrentTable, Name: primary.DBName}, Values: toValues(chunk)}).
+				Updates(caseUpdates(stmt, primary.DBName, chunk, updates))
+			if result.Error != nil {
+				return result.Error
+			}
+			rows += result.RowsAffected
+		}
+		return nil
+	})
+	return rows, err
+}
+
+// caseUpdates sets each column changed in the chunk to a CASE on the
+// primary key, the rows not changing it keep their value.
+func caseUpdates[K comparable](stmt *gorm.Statement, primary string, chunk []K, updates map[K]Changes) map[string]interface{} {
+	whens := map[string][]interface{}{}
+	for _, id := range chunk {
+		for name, value := range updates[id] {
+			column := stmt.Schema.LookUpField(name).DBName
+			whens[column] = append(whens[column], id, value)
+		}
+	}
+
+	columns := make(map[string]interface{}, len(whens))
+	for column, vars := range whens {
+		sql := "CASE ?" + strings.Repeat(" WHEN ? THEN ?", len(vars)/2) + " ELSE ? END"
+		target := clause.Column{Table: clause.CurrentTable, Name: column}
+		vars = append(append([]interface{}{clause.Column{Table: clause.CurrentTable, Name: primary}}, vars...), target)
+		columns[column] = clause.Expr{SQL: sql, Vars: vars}
+	}
+	return columns
+}
+
+func toValues[K comparable](ids []K) []interface{} {
+	values := make([]interface{}, len(ids))
+	for i, id := range ids {
+		values[i] = id
+	}
+	return values
+}
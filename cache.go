@@ -0,0 +1,238 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+	"gorm.io/gorm"
+)
+
+type CacheStats struct {
+	Hits         int64
+	NegativeHits int64
+	Misses       int64
+	Coalesced    int64
+}
+
+type cacheEntry[V any] struct {
+	value     V
+	err       error
+	expiresAt time.Time
+}
+
+// CachedReader caches the result of load per key for TTL. Concurrent misses
+// of the same key are collapsed with singleflight into a single call of load,
+// so a stampede on a hot row costs one database round trip.
+//
+// Not found results are remembered for NegativeTTL, zero disables negative
+// caching, so repeated lookups of missing keys do not reach the database.
+// Entries expire on Clock, nil is the SystemClock, and the expired ones are
+// swept out while storing at most once per TTL.
+type CachedReader[K comparable, V any] struct {
+	load        func(ctx context.Context, key K) (V, error)
+	ttl         time.Duration
+	NegativeTTL time.Duration
+	Clock       Clock
+
+	mutex   sync.RWMutex
+	entries map[K]cacheEntry[V]
+	sweptAt time.Time
+	group   singleflight.Group
+
+	hits         int64
+	negativeHits int64
+	misses       int64
+	coalesced    int64
+}
+
+func NewCachedReader[K comparable, V any](ttl time.Duration, load func(ctx context.Context, key K) (V, error)) *CachedReader[K, V] {
+	return &CachedReader[K, V]{
+		load:    load,
+		ttl:     ttl,
+		entries: map[K]cacheEntry[V]{},
+	}
+}
+
+func FindByID[V any](db *gorm.DB, preloads ...string) func(ctx context.Context, id string) (V, error) {
+	return func(ctx context.Context, id string) (V, error) {
+		var value V
+		query := db.WithContext(ctx)
+		for _, preload := range preloads {
+			query = query.Preload(preload)
+		}
+		err := query.Take(&value, "id = ?", id).Error
+		return value, err
+	}
+}
+
+func NewUserCache(db *gorm.DB, ttl time.Duration) *CachedReader[string, User] {
+	cache := NewCachedReader(ttl, FindByID[User](db))
+	cache.Clock = DBClock(db)
+	return cache
+}
+
+func NewProductCache(db *gorm.DB, ttl time.Duration) *CachedReader[string, Product] {
+	cache := NewCachedReader(ttl, FindByID[Product](db, "Translations"))
+	cache.Clock = DBClock(db)
+	return cache
+}
+
+func (c *CachedReader[K, V]) now() time.Time {
+	if c.Clock == nil {
+		return SystemClock.Now()
+	}
+	return c.Clock.Now()
+}
+
+func (c *CachedReader[K, V]) lookup(key K) (cacheEntry[V], bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+
+	entry, ok := c.entries[key]
+	if !ok || c.now().After(entry.expiresAt) {
+		return cacheEntry[V]{}, false
+	}
+	return entry, true
+}
+
+func (c *CachedReader[K, V]) store(key K, value V, err error) {
+	ttl := c.ttl
+	if err != nil {
+		if !errors.Is(err, gorm.ErrRecordNotFound) || c.NegativeTTL <= 0 {
+			return
+		}
+		ttl = c.NegativeTTL
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	now := c.now()
+	c.sweep(now)
+	c.entries[key] = cacheEntry[V]{value: value, err: err, expiresAt: now.Add(ttl)}
+}
+
+// sweep deletes the expired entries when the shortest TTL has passed since
+// the last sweep, so missing keys probed once do not stay for good. The
+// mutex is held by the caller.
+func (c *CachedReader[K, V]) sweep(now time.Time) {
+	interval := c.ttl
+	if c.NegativeTTL > 0 && c.NegativeTTL < interval {
+		interval = c.NegativeTTL
+	}
+	if now.Sub(c.sweptAt) < interval {
+		return
+	}
+	for key, entry := range c.entries {
+		if now.After(entry.expiresAt) {
+			delete(c.entries, key)
+		}
+	}
+	c.sweptAt = now
+}
+
+func (c *CachedReader[K, V]) Get(ctx context.Context, key K) (V, error) {
+	if entry, ok := c.lookup(key); ok {
+		if entry.err != nil {
+			atomic.AddInt64(&c.negativeHits, 1)
+		} else {
+			atomic.AddInt64(&c.hits, 1)
+		}
+		return entry.value, entry.err
+	}
+	atomic.AddInt64(&c.misses, 1)
+
+	result := c.group.DoChan(fmt.Sprint(key), func() (interface{}, error) {
+		value, err := c.load(context.WithoutCancel(ctx), key)
+		c.store(key, value, err)
+		return value, err
+	})
+
+	select {
+	case <-ctx.Done():
+		var zero V
+		return zero, ctx.Err()
+	case r := <-result:
+		if r.Shared {
+			atomic.AddInt64(&c.coalesced, 1)
+		}
+		return r.Val.(V), r.Err
+	}
+}
+
+func (c *CachedReader[K, V]) Invalidate(key K) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	delete(c.entries, key)
+}
+
+func (c *CachedReader[K, V]) Stats() CacheStats {
+	return CacheStats{
+		Hits:         atomic.LoadInt64(&c.hits),
+		NegativeHits: atomic.LoadInt64(&c.negativeHits),
+		Misses:       atomic.LoadInt64(&c.misses),
+		Coalesced:    atomic.LoadInt64(&c.coalesced),
+	}
+}
+
+// InvalidateOnCreate registers create callbacks on db that drop the cached
+// entry, most importantly a cached not found, of every row created in the
+// table of V. The entry is dropped after the insert and again after the
+// commit, as a Get between the two may cache the row as not found again.
+// Rows created in a transaction of the caller are only dropped at insert,
+// Invalidate them after committing it.
+func (c *CachedReader[K, V]) InvalidateOnCreate(db *gorm.DB) error {
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(new(V))
+	if err != nil {
+		return err
+	}
+	table := stmt.Schema.Table
+
+	invalidateRows := func(tx *gorm.DB) {
+		if tx.Error != nil || tx.Statement.Schema == nil || tx.Statement.Schema.Table != table {
+			return
+		}
+
+		field := tx.Statement.Schema.PrioritizedPrimaryField
+		if field == nil {
+			return
+		}
+
+		invalidate := func(rv reflect.Value) {
+			value, zero := field.ValueOf(tx.Statement.Context, rv)
+			if zero {
+				return
+			}
+			if key, ok := value.(K); ok {
+				c.Invalidate(key)
+			} else if key, ok := any(fmt.Sprint(value)).(K); ok {
+				c.Invalidate(key)
+			}
+		}
+
+		rv := reflect.Indirect(tx.Statement.ReflectValue)
+		switch rv.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < rv.Len(); i++ {
+				invalidate(reflect.Indirect(rv.Index(i)))
+			}
+		case reflect.Struct:
+			invalidate(rv)
+		}
+	}
+
+	name := fmt.Sprintf("cache:invalidate_%s_%p", table, c)
+	err = db.Callback().Create().After("gorm:create").Register(name, invalidateRows)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Create().After("gorm:commit_or_rollback_transaction").Register(name+"_committed", invalidateRows)
+}
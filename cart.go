@@ -0,0 +1,233 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	CartStatusActive     = "active"
+	CartStatusCheckedOut = "checked_out"
+	CartStatusMerged     = "merged"
+	CartStatusExpired    = "expired"
+)
+
+var (
+	ErrCartVersionConflict = errors.New("cart was modified concurrently")
+	ErrCartNotActive       = errors.New("cart is not active")
+	ErrCartEmpty           = errors.New("cart is empty")
+	ErrInvalidQuantity     = errors.New("quantity must be greater than zero")
+)
+
+type Cart struct {
+	ID        int64      `gorm:"primary_key;column:id;autoIncrement"`
+	UserID    *string    `gorm:"column:user_id"`
+	SessionID string     `gorm:"column:session_id"`
+	Status    string     `gorm:"column:status"`
+	Version   int64      `gorm:"column:version"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time  `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	User      *User      `gorm:"foreignKey:user_id;references:id"`
+	Items     []CartItem `gorm:"foreignKey:cart_id;references:id"`
+}
+
+func (c *Cart) TableName() string {
+	return "carts"
+}
+
+type CartItem struct {
+	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
+	CartID    int64     `gorm:"column:cart_id"`
+	ProductID string    `gorm:"column:product_id"`
+	Quantity  int64     `gorm:"column:quantity"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	Product   Product   `gorm:"foreignKey:product_id;references:id"`
+}
+
+func (i *CartItem) TableName() string {
+	return "cart_items"
+}
+
+func FindActiveCart(db *gorm.DB, userID string) (Cart, error) {
+	var cart Cart
+	err := db.Preload("Items.Product").
+		Where("user_id = ? AND status = ?", userID, CartStatusActive).
+		Take(&cart).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cart = Cart{UserID: &userID, Status: CartStatusActive}
+		err = db.Create(&cart).Error
+	}
+	return cart, err
+}
+
+func FindAnonymousCart(db *gorm.DB, sessionID string) (Cart, error) {
+	var cart Cart
+	err := db.Preload("Items.Product").
+		Where("session_id = ? AND user_id IS NULL AND status = ?", sessionID, CartStatusActive).
+		Take(&cart).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		cart = Cart{SessionID: sessionID, Status: CartStatusActive}
+		err = db.Create(&cart).Error
+	}
+	return cart, err
+}
+
+// bumpCartVersion only succeeds when nobody changed the cart since the caller
+// loaded it, every cart mutation goes through it. It leaves cart alone, the
+// caller catches up with the version once the transaction is committed.
+func bumpCartVersion(tx *gorm.DB, cart *Cart) error {
+	result := tx.Model(&Cart{}).
+		Where("id = ? AND version = ? AND status = ?", cart.ID, cart.Version, CartStatusActive).
+		Update("version", gorm.Expr("version + ?", 1))
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		if cart.Status != CartStatusActive {
+			return ErrCartNotActive
+		}
+		return ErrCartVersionConflict
+	}
+	return nil
+}
+
+// mutateCart runs mutate in a transaction bumping the version of cart, cart
+// gets the new version only when the transaction commits.
+func mutateCart(db *gorm.DB, cart *Cart, mutate func(tx *gorm.DB) error) error {
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := bumpCartVersion(tx, cart)
+		if err != nil {
+			return err
+		}
+		return mutate(tx)
+	})
+	if err == nil {
+		cart.Version++
+	}
+	return err
+}
+
+func AddItem(db *gorm.DB, cart *Cart, productID string, quantity int64) error {
+	if quantity <= 0 {
+		return ErrInvalidQuantity
+	}
+
+	return mutateCart(db, cart, func(tx *gorm.DB) error {
+		return addCartItem(tx, cart.ID, productID, quantity)
+	})
+}
+
+func addCartItem(tx *gorm.DB, cartID int64, productID string, quantity int64) error {
+	var item CartItem
+	err := tx.Take(&item, "cart_id = ? AND product_id = ?", cartID, productID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return tx.Create(&CartItem{CartID: cartID, ProductID: productID, Quantity: quantity}).Error
+	}
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&item).Update("quantity", gorm.Expr("quantity + ?", quantity)).Error
+}
+
+func UpdateQuantity(db *gorm.DB, cart *Cart, productID string, quantity int64) error {
+	if quantity <= 0 {
+		return RemoveItem(db, cart, productID)
+	}
+
+	return mutateCart(db, cart, func(tx *gorm.DB) error {
+		return tx.Model(&CartItem{}).
+			Where("cart_id = ? AND product_id = ?", cart.ID, productID).
+			Update("quantity", quantity).Error
+	})
+}
+
+func RemoveItem(db *gorm.DB, cart *Cart, productID string) error {
+	return mutateCart(db, cart, func(tx *gorm.DB) error {
+		return tx.Where("cart_id = ? AND product_id = ?", cart.ID, productID).Delete(&CartItem{}).Error
+	})
+}
+
+type CartCheckout struct {
+	CartID int64
+	Items  []CartItem
+	Total  int64
+}
+
+func Checkout(db *gorm.DB, cart *Cart) (CartCheckout, error) {
+	checkout := CartCheckout{CartID: cart.ID}
+	err := mutateCart(db, cart, func(tx *gorm.DB) error {
+		err := tx.Preload("Product").Where("cart_id = ?", cart.ID).Find(&checkout.Items).Error
+		if err != nil {
+			return err
+		}
+		if len(checkout.Items) == 0 {
+			return ErrCartEmpty
+		}
+
+		for _, item := range checkout.Items {
+			checkout.Total += item.Product.Price * item.Quantity
+		}
+
+		return tx.Model(&Cart{}).Where("id = ?", cart.ID).Update("status", CartStatusCheckedOut).Error
+	})
+	if err == nil {
+		cart.Status = CartStatusCheckedOut
+	}
+	return checkout, err
+}
+
+// MergeCarts moves the anonymous cart of sessionID into the active cart of
+// userID, quantities of products present in both carts are summed.
+func MergeCarts(db *gorm.DB, sessionID string, userID string) (Cart, error) {
+	var cart Cart
+	err := db.Transaction(func(tx *gorm.DB) error {
+		var anonymous Cart
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Preload("Items").
+			Where("session_id = ? AND user_id IS NULL AND status = ?", sessionID, CartStatusActive).
+			Take(&anonymous).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			cart, err = FindActiveCart(tx, userID)
+			return err
+		}
+		if err != nil {
+			return err
+		}
+
+		cart, err = FindActiveCart(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		err = bumpCartVersion(tx, &cart)
+		if err != nil {
+			return err
+		}
+
+		for _, item := range anonymous.Items {
+			err = addCartItem(tx, cart.ID, item.ProductID, item.Quantity)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Model(&anonymous).Update("status", CartStatusMerged).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Preload("Items.Product").Take(&cart, "id = ?", cart.ID).Error
+	})
+	return cart, err
+}
+
+func ExpireStaleCarts(db *gorm.DB, olderThan time.Duration) (int64, error) {
+	result := db.Model(&Cart{}).
+		Where("status = ? AND updated_at < ?", CartStatusActive, Now(db).Add(-olderThan)).
+		Update("status", CartStatusExpired)
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,300 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	ChangeCreated = "created"
+	ChangeUpdated = "updated"
+)
+
+// ChangeEvent is a row of a tracked table as it was written, its id is the
+// cursor clients resume from.
+type ChangeEvent struct {
+	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
+	UserID    string    `gorm:"column:user_id;index:idx_change_events_user_id"`
+	Entity    string    `gorm:"column:entity"`
+	EntityID  string    `gorm:"column:entity_id"`
+	Action    string    `gorm:"column:action"`
+	Payload   string    `gorm:"column:payload;type:json"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (e *ChangeEvent) TableName() string {
+	return "change_events"
+}
+
+// ChangeFeed records the creates and updates of the tracked tables in
+// change_events within the writing transaction, and wakes its subscribers
+// once it is committed. The events are plain gorm callbacks rather than
+// commit hooks: they are written before the commit, in the same
+// transaction, so a rolled back write leaves no event behind. Writes in a
+// transaction of the caller are only seen at the next PollInterval.
+//
+// Updates without a model, like Model(&Todo{}).Where(...).Update(...),
+// look up the rows they match first and record those. Raw SQL and
+// updates on Table(...) without a model are not seen.
+//
+// Event ids are taken at insert, a transaction committing after a later
+// one may be passed by a reader, so keep tracked writes in short
+// transactions.
+//
+// Notifications are out of scope: this module has no Notification model,
+// todos are the tracked table.
+type ChangeFeed struct {
+	DB           *gorm.DB
+	Sessions     *SessionStore
+	PollInterval time.Duration
+	BatchSize    int
+
+	mutex       sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewChangeFeed(db *gorm.DB, sessions *SessionStore) *ChangeFeed {
+	return &ChangeFeed{
+		DB:           db,
+		Sessions:     sessions,
+		PollInterval: 5 * time.Second,
+		BatchSize:    100,
+		subscribers:  map[chan struct{}]struct{}{},
+	}
+}
+
+const changeFeedKeys = "change_feed:keys"
+
+// matchKeys stashes the primary keys of the rows an update without a model
+// matches, record loads them again after the update.
+func (f *ChangeFeed) matchKeys(db *gorm.DB) {
+	stmt := db.Statement
+	if db.Error != nil || stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil || !stmt.ReflectValue.IsValid() {
+		return
+	}
+	value := reflect.Indirect(stmt.ReflectValue)
+	if value.Kind() != reflect.Struct {
+		return
+	}
+	primary := stmt.Schema.PrioritizedPrimaryField
+	where, ok := stmt.Clauses["WHERE"]
+	if _, zero := primary.ValueOf(stmt.Context, value); !zero || !ok {
+		return
+	}
+
+	keys := reflect.New(reflect.SliceOf(primary.FieldType))
+	err := db.Session(&gorm.Session{NewDB: true}).Model(reflect.New(stmt.Schema.ModelType).Interface()).
+		Clauses(where.Expression).Pluck(primary.DBName, keys.Interface()).Error
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if keys.Elem().Len() > 0 {
+		db.InstanceSet(changeFeedKeys, keys.Elem().Interface())
+	}
+}
+
+// record inserts an event per written row of the statement, rows need a
+// user_id column to be delivered to their owner.
+func (f *ChangeFeed) record(action string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		stmt := db.Statement
+		if db.Error != nil || stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil || !stmt.ReflectValue.IsValid() {
+			return
+		}
+		userField := stmt.Schema.LookUpField("user_id")
+		if userField == nil {
+			return
+		}
+
+		var events []ChangeEvent
+		collect := func(value reflect.Value) {
+			key, zero := stmt.Schema.PrioritizedPrimaryField.ValueOf(stmt.Context, value)
+			userID, _ := userField.ValueOf(stmt.Context, value)
+			if zero || fmt.Sprint(userID) == "" {
+				return
+			}
+			payload, err := json.Marshal(value.Interface())
+			if err != nil {
+				_ = db.AddError(err)
+				return
+			}
+			events = append(events, ChangeEvent{
+				UserID:   fmt.Sprint(userID),
+				Entity:   stmt.Table,
+				EntityID: fmt.Sprint(key),
+				Action:   action,
+				Payload:  string(payload),
+			})
+		}
+
+		value := reflect.Indirect(stmt.ReflectValue)
+		if keys, ok := db.InstanceGet(changeFeedKeys); ok {
+			rows := reflect.New(reflect.SliceOf(stmt.Schema.ModelType))
+			err := db.Session(&gorm.Session{NewDB: true}).Find(rows.Interface(), keys).Error
+			if err != nil {
+				_ = db.AddError(err)
+				return
+			}
+			value = rows.Elem()
+		}
+		switch value.Kind() {
+		case reflect.Slice, reflect.Array:
+			for i := 0; i < value.Len(); i++ {
+				collect(reflect.Indirect(value.Index(i)))
+			}
+		case reflect.Struct:
+			collect(value)
+		}
+		if len(events) == 0 || db.Error != nil {
+			return
+		}
+
+		err := db.Session(&gorm.Session{NewDB: true}).Create(&events).Error
+		if err != nil {
+			_ = db.AddError(err)
+			return
+		}
+		db.InstanceSet("change_feed:recorded", true)
+	}
+}
+
+func (f *ChangeFeed) notifyCommitted(db *gorm.DB) {
+	if _, recorded := db.InstanceGet("change_feed:recorded"); recorded && db.Error == nil {
+		f.notify()
+	}
+}
+
+func (f *ChangeFeed) notify() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for subscriber := range f.subscribers {
+		select {
+		case subscriber <- struct{}{}:
+		default:
+		}
+	}
+}
+
+func (f *ChangeFeed) subscribe() (chan struct{}, func()) {
+	subscriber := make(chan struct{}, 1)
+	f.mutex.Lock()
+	f.subscribers[subscriber] = struct{}{}
+	f.mutex.Unlock()
+	return subscriber, func() {
+		f.mutex.Lock()
+		delete(f.subscribers, subscriber)
+		f.mutex.Unlock()
+	}
+}
+
+// Track registers the callbacks recording the creates and updates of
+// tables made through models on db, e.g. Track(db, "todos").
+func (f *ChangeFeed) Track(db *gorm.DB, tables ...string) error {
+	tracked := map[string]bool{}
+	for _, table := range tables {
+		tracked[table] = true
+	}
+	only := func(callback func(db *gorm.DB)) func(db *gorm.DB) {
+		return func(db *gorm.DB) {
+			if tracked[db.Statement.Table] {
+				callback(db)
+			}
+		}
+	}
+
+	callbacks := db.Callback()
+	err := callbacks.Create().After("gorm:create").Register("change_feed:create", only(f.record(ChangeCreated)))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().Before("gorm:update").Register("change_feed:keys", only(f.matchKeys))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().After("gorm:update").Register("change_feed:update", only(f.record(ChangeUpdated)))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Create().After("gorm:commit_or_rollback_transaction").Register("change_feed:notify", f.notifyCommitted)
+	if err != nil {
+		return err
+	}
+	return callbacks.Update().After("gorm:commit_or_rollback_transaction").Register("change_feed:notify", f.notifyCommitted)
+}
+
+// Events returns the events of userID after the cursor, oldest first.
+func (f *ChangeFeed) Events(ctx context.Context, userID string, after int64) ([]ChangeEvent, error) {
+	var events []ChangeEvent
+	err := f.DB.WithContext(ctx).Where("user_id = ? AND id > ?", userID, after).
+		Order("id").Limit(f.BatchSize).Find(&events).Error
+	return events, err
+}
+
+// ServeHTTP streams the changes of the user of the bearer session token as
+// server-sent events. Clients resume with the Last-Event-ID header, which
+// browsers send on reconnect, or ?cursor=.
+func (f *ChangeFeed) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	session, err := f.Sessions.Validate(r.Context(), strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "streaming unsupported", http.StatusInternalServerError)
+		return
+	}
+
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("cursor")
+	}
+	after, _ := strconv.ParseInt(cursor, 10, 64)
+
+	subscriber, unsubscribe := f.subscribe()
+	defer unsubscribe()
+	ticker := time.NewTicker(f.PollInterval)
+	defer ticker.Stop()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		events, err := f.Events(r.Context(), session.UserID, after)
+		if err != nil {
+			return
+		}
+		for _, event := range events {
+			fmt.Fprintf(w, "id: %d\nevent: %s.%s\ndata: %s\n\n", event.ID, event.Entity, event.Action, event.Payload)
+			after = event.ID
+		}
+		if len(events) == f.BatchSize {
+			flusher.Flush()
+			continue
+		}
+		if len(events) == 0 {
+			// keeps proxies from closing an idle stream
+			fmt.Fprint(w, ": ping\n\n")
+		}
+		flusher.Flush()
+
+		select {
+		case <-r.Context().Done():
+			return
+		case <-subscriber:
+		case <-ticker.C:
+		}
+	}
+}
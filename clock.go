@@ -0,0 +1,79 @@
+package learn_golang_gorm
+
+import (
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Clock tells the time to the hooks and services, and to gorm for the
+// CreatedAt, UpdatedAt and DeletedAt columns.
+type Clock interface {
+	Now() time.Time
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time {
+	return time.Now()
+}
+
+var SystemClock Clock = systemClock{}
+
+// TestClock is a Clock frozen at a time until it is set or advanced.
+type TestClock struct {
+	mutex sync.Mutex
+	now   time.Time
+}
+
+func NewTestClock(now time.Time) *TestClock {
+	return &TestClock{now: now}
+}
+
+func (c *TestClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *TestClock) Set(now time.Time) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = now
+}
+
+func (c *TestClock) Advance(d time.Duration) time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.now = c.now.Add(d)
+	return c.now
+}
+
+type dbClock struct {
+	db *gorm.DB
+}
+
+func (c dbClock) Now() time.Time {
+	return Now(c.db)
+}
+
+// DBClock is the clock of db, for services that tell the time without a
+// query at hand.
+func DBClock(db *gorm.DB) Clock {
+	return dbClock{db: db}
+}
+
+// UseClock makes db and every session of it tell the time with clock.
+func UseClock(db *gorm.DB, clock Clock) {
+	db.Config.NowFunc = clock.Now
+}
+
+// Now is the time on the clock of db, the wall clock unless UseClock or
+// Config.Clock set another.
+func Now(db *gorm.DB) time.Time {
+	if db == nil || db.Config == nil || db.Config.NowFunc == nil {
+		return time.Now()
+	}
+	return db.Config.NowFunc()
+}
@@ -0,0 +1,178 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type CloneOptions struct {
+	Users     int
+	BatchSize int
+	Anonymize bool
+}
+
+type CloneReport struct {
+	Users     int64
+	Wallets   int64
+	Addresses int64
+	Todos     int64
+	Products  int64
+	Likes     int64
+}
+
+type userLikeProduct struct {
+	UserID    string `gorm:"column:user_id"`
+	ProductID string `gorm:"column:product_id"`
+}
+
+// pseudonym is stable per value, so cloning twice gives the same fake data
+// and equal source values stay equal.
+func pseudonym(value string) (string, uint32) {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:4]), binary.BigEndian.Uint32(sum[4:8])
+}
+
+// AnonymizeUser replaces the personal data of user with fake values derived
+// from its id.
+func AnonymizeUser(user *User) {
+	alias, number := pseudonym(user.ID)
+	user.Password = "anonymized"
+	user.Name = Name{FirstName: "User", LastName: alias}
+	user.Email = Email("user-" + alias + "@example.com")
+	if user.Phone != "" {
+		user.Phone = PhoneNumber(fmt.Sprintf("+62800%08d", number%100000000))
+	}
+}
+
+func AnonymizeAddress(address *Address) {
+	alias, number := pseudonym(fmt.Sprint(address.ID))
+	address.Address = fmt.Sprintf("Jalan %s No. %d", alias, number%200+1)
+}
+
+func AnonymizeTodo(todo *Todo) {
+	todo.Description = ""
+}
+
+func upsert(tx *gorm.DB, rows interface{}) error {
+	return tx.Omit(clause.Associations).Clauses(clause.OnConflict{UpdateAll: true}).Create(rows).Error
+}
+
+// CloneSubset copies the first options.Users users with their wallets,
+// addresses, todos and liked products from source to target, so every
+// reference in target resolves. Personal data is anonymized while copying
+// when options.Anonymize is set. Rows already in target are overwritten.
+func CloneSubset(ctx context.Context, source *gorm.DB, target *gorm.DB, options CloneOptions) (CloneReport, error) {
+	source, target = source.WithContext(ctx), target.WithContext(ctx)
+	if options.BatchSize <= 0 {
+		options.BatchSize = 500
+	}
+
+	var report CloneReport
+	err := target.Migrator().AutoMigrate(&User{}, &Wallet{}, &Address{}, &Todo{}, &Product{})
+	if err != nil {
+		return report, err
+	}
+
+	var users []User
+	err = source.Order("id").Limit(options.Users).FindInBatches(&users, options.BatchSize, func(batchTx *gorm.DB, batch int) error {
+		ids := make([]string, len(users))
+		for i := range users {
+			ids[i] = users[i].ID
+			if options.Anonymize {
+				AnonymizeUser(&users[i])
+			}
+		}
+
+		return target.Transaction(func(tx *gorm.DB) error {
+			err := upsert(tx, &users)
+			if err != nil {
+				return err
+			}
+			report.Users += int64(len(users))
+
+			var wallets []Wallet
+			err = source.Where("user_id IN ?", ids).Find(&wallets).Error
+			if err != nil {
+				return err
+			}
+			if len(wallets) > 0 {
+				err = upsert(tx, &wallets)
+				if err != nil {
+					return err
+				}
+				report.Wallets += int64(len(wallets))
+			}
+
+			var addresses []Address
+			err = source.Where("user_id IN ?", ids).Find(&addresses).Error
+			if err != nil {
+				return err
+			}
+			for i := range addresses {
+				if options.Anonymize {
+					AnonymizeAddress(&addresses[i])
+				}
+			}
+			if len(addresses) > 0 {
+				err = upsert(tx, &addresses)
+				if err != nil {
+					return err
+				}
+				report.Addresses += int64(len(addresses))
+			}
+
+			var todos []Todo
+			err = source.Unscoped().Where("user_id IN ?", ids).Order("id").Find(&todos).Error
+			if err != nil {
+				return err
+			}
+			for i := range todos {
+				if options.Anonymize {
+					AnonymizeTodo(&todos[i])
+				}
+			}
+			if len(todos) > 0 {
+				err = upsert(tx, &todos)
+				if err != nil {
+					return err
+				}
+				report.Todos += int64(len(todos))
+			}
+
+			var likes []userLikeProduct
+			err = source.Table("user_like_product").Where("user_id IN ?", ids).Find(&likes).Error
+			if err != nil || len(likes) == 0 {
+				return err
+			}
+
+			productIDs := make([]string, len(likes))
+			for i, like := range likes {
+				productIDs[i] = like.ProductID
+			}
+			var products []Product
+			err = source.Where("id IN ?", productIDs).Find(&products).Error
+			if err != nil {
+				return err
+			}
+			err = upsert(tx, &products)
+			if err != nil {
+				return err
+			}
+			report.Products += int64(len(products))
+
+			err = tx.Table("user_like_product").Clauses(clause.OnConflict{DoNothing: true}).Create(&likes).Error
+			if err != nil {
+				return err
+			}
+			report.Likes += int64(len(likes))
+			return nil
+		})
+	}).Error
+	return report, err
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const (
+	backupUsage  = "backup -dir backups/today"
+	restoreUsage = "restore -dir backups/today [-parallel 4] [-drop]"
+)
+
+func runBackup(args []string) error {
+	flags := flag.NewFlagSet("backup", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory to write the backup to")
+	_ = flags.Parse(args)
+	if *dir == "" {
+		return errors.New("usage: gormctl " + backupUsage)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	manifest, err := learn_golang_gorm.Backup(context.Background(), db, *dir)
+	if err != nil {
+		return err
+	}
+	for _, table := range manifest.Tables {
+		fmt.Printf("%-30s %d rows\n", table.Name, table.Rows)
+	}
+	return nil
+}
+
+func runRestore(args []string) error {
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	dir := flags.String("dir", "", "directory of the backup")
+	parallel := flags.Int("parallel", 4, "tables loaded at the same time")
+	drop := flags.Bool("drop", false, "replace tables that already exist")
+	_ = flags.Parse(args)
+	if *dir == "" {
+		return errors.New("usage: gormctl " + restoreUsage)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	return learn_golang_gorm.Restore(context.Background(), db, *dir, learn_golang_gorm.RestoreOptions{
+		Parallelism:  *parallel,
+		DropExisting: *drop,
+	})
+}
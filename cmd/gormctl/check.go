@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const checkUsage = "check [-repair]"
+
+func runCheck(args []string) error {
+	flags := flag.NewFlagSet("check", flag.ExitOnError)
+	repair := flags.Bool("repair", false, "fix the violations that can be repaired")
+	_ = flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	violations, err := learn_golang_gorm.CheckConsistency(context.Background(), db, *repair)
+	if err != nil {
+		return err
+	}
+	if len(violations) == 0 {
+		fmt.Println("no violations found")
+		return nil
+	}
+
+	unrepaired := 0
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "check\tkey\texpected\tactual\trepaired")
+	for _, violation := range violations {
+		if !violation.Repaired {
+			unrepaired++
+		}
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%t\n", violation.Check, violation.Key,
+			violation.Expected, violation.Actual, violation.Repaired)
+	}
+	err = writer.Flush()
+	if err != nil || unrepaired == 0 {
+		return err
+	}
+	return fmt.Errorf("%d violations left", unrepaired)
+}
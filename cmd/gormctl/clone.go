@@ -0,0 +1,53 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const cloneUsage = "clone -target dsn [-source dsn] [-users n] [-anonymize=false]"
+
+func runClone(args []string) error {
+	flags := flag.NewFlagSet("clone", flag.ExitOnError)
+	sourceDSN := flags.String("source", "", "source database, defaults to DATABASE_DSN")
+	targetDSN := flags.String("target", "", "target database")
+	users := flags.Int("users", 100, "number of users to copy")
+	anonymize := flags.Bool("anonymize", true, "replace personal data with fake values")
+	_ = flags.Parse(args)
+	if *sourceDSN == "" {
+		*sourceDSN = os.Getenv("DATABASE_DSN")
+	}
+	if *sourceDSN == "" {
+		return errMissingDSN
+	}
+	if *targetDSN == "" || *targetDSN == *sourceDSN {
+		return errors.New("usage: gormctl " + cloneUsage)
+	}
+
+	source, err := openDSN(*sourceDSN)
+	if err != nil {
+		return err
+	}
+
+	target, err := openDSN(*targetDSN)
+	if err != nil {
+		return err
+	}
+
+	report, err := learn_golang_gorm.CloneSubset(context.Background(), source, target, learn_golang_gorm.CloneOptions{
+		Users:     *users,
+		Anonymize: *anonymize,
+	})
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("users %d, wallets %d, addresses %d, todos %d, products %d, likes %d\n",
+		report.Users, report.Wallets, report.Addresses, report.Todos, report.Products, report.Likes)
+	return nil
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"context"
+	"flag"
+
+	"learn-golang-gorm/datagen"
+)
+
+const datagenUsage = "datagen [-seed n] [-users n] [-products n] [-user-logs n] [-batch n]"
+
+func runDatagen(args []string) error {
+	flags := flag.NewFlagSet("datagen", flag.ExitOnError)
+	seed := flags.Int64("seed", 1, "seed of the generator, equal seeds generate equal rows")
+	prefix := flags.String("prefix", "gen", "prefix of the generated ids")
+	volume := datagen.Volume{}
+	flags.IntVar(&volume.Users, "users", 1000, "number of users")
+	flags.IntVar(&volume.Products, "products", 100, "number of products")
+	flags.IntVar(&volume.AddressesPerUser, "addresses", 3, "maximum addresses per user")
+	flags.IntVar(&volume.TodosPerUser, "todos", 10, "maximum todos per user")
+	flags.IntVar(&volume.LikesPerUser, "likes", 5, "maximum liked products per user")
+	flags.IntVar(&volume.UserLogs, "user-logs", 10000, "number of user logs")
+	flags.IntVar(&volume.BatchSize, "batch", 1000, "rows per insert")
+	_ = flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	generator := datagen.New(*seed)
+	generator.Prefix = *prefix
+	return datagen.Populate(context.Background(), db, generator, volume)
+}
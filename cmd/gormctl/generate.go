@@ -0,0 +1,47 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	learn_golang_gorm "learn-golang-gorm"
+	"learn-golang-gorm/scaffold"
+)
+
+const generateUsage = "generate model [-dir .] [-version n] [-handlers] [-force] Name field:type[:index|:unique]..."
+
+func runGenerate(args []string) error {
+	if len(args) == 0 || args[0] != "model" {
+		return errors.New("usage: gormctl " + generateUsage)
+	}
+
+	flags := flag.NewFlagSet("generate model", flag.ExitOnError)
+	dir := flags.String("dir", ".", "directory of the learn_golang_gorm package")
+	version := flags.Int64("version", learn_golang_gorm.RequiredSchemaVersion()+1, "version of the migration creating the table")
+	handlers := flags.Bool("handlers", false, "also generate REST handlers")
+	force := flags.Bool("force", false, "overwrite existing files")
+	_ = flags.Parse(args[1:])
+	if flags.NArg() == 0 {
+		return errors.New("usage: gormctl " + generateUsage)
+	}
+
+	model, err := scaffold.NewModel(flags.Arg(0), *version, flags.Args()[1:]...)
+	if err != nil {
+		return err
+	}
+	model.Handlers = *handlers
+	files, err := model.Files()
+	if err != nil {
+		return err
+	}
+	err = scaffold.Write(*dir, files, *force)
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		fmt.Println(filepath.Join(*dir, file.Name))
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const indexesUsage = "indexes [-sql]"
+
+func runIndexes(args []string) error {
+	flags := flag.NewFlagSet("indexes", flag.ExitOnError)
+	onlySQL := flags.Bool("sql", false, "only print the suggested DROP statements")
+	_ = flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	findings, err := learn_golang_gorm.IndexReport(context.Background(), db)
+	if err != nil {
+		return err
+	}
+
+	if *onlySQL {
+		for _, finding := range findings {
+			fmt.Println(finding.SuggestedDrop + ";")
+		}
+		return nil
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(writer, "table\tindex\tcolumns\tfinding\treason")
+	for _, finding := range findings {
+		fmt.Fprintf(writer, "%s\t%s\t%s\t%s\t%s\n", finding.Table, finding.Index,
+			strings.Join(finding.Columns, ", "), finding.Kind, finding.Reason)
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+var errMissingDSN = errors.New("DATABASE_DSN is not set, e.g. user:password@tcp(localhost:3306)/learn_golang_gorm?charset=utf8mb4&parseTime=True&loc=Local")
+
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands = map[string]command{
+	"backup":   {usage: backupUsage, run: runBackup},
+	"check":    {usage: checkUsage, run: runCheck},
+	"clone":    {usage: cloneUsage, run: runClone},
+	"datagen":  {usage: datagenUsage, run: runDatagen},
+	"generate": {usage: generateUsage, run: runGenerate},
+	"indexes":  {usage: indexesUsage, run: runIndexes},
+	"migrate":  {usage: migrateUsage, run: runMigrate},
+	"openapi":  {usage: openapiUsage, run: runOpenAPI},
+	"query":    {usage: queryUsage, run: runQuery},
+	"restore":  {usage: restoreUsage, run: runRestore},
+	"seed":     {usage: seedUsage, run: runSeed},
+	"stats":    {usage: statsUsage, run: runStats},
+}
+
+func usage() {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	fmt.Fprintln(os.Stderr, "usage: gormctl <command> [arguments]")
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+}
+
+func openDB() (*gorm.DB, error) {
+	dsn := os.Getenv("DATABASE_DSN")
+	if dsn == "" {
+		return nil, errMissingDSN
+	}
+	return openDSN(dsn)
+}
+
+func openDSN(dsn string) (*gorm.DB, error) {
+	return gorm.Open(mysql.Open(dsn), &gorm.Config{})
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	command, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	err := command.run(os.Args[2:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
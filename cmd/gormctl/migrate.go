@@ -0,0 +1,67 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+
+	"gorm.io/gorm"
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const migrateUsage = "migrate [-plan] [-down 1 [-allow-destructive] [-export dir]]"
+
+func runMigrate(args []string) error {
+	flags := flag.NewFlagSet("migrate", flag.ExitOnError)
+	plan := flags.Bool("plan", false, "print the statements of the pending migrations instead of applying them")
+	down := flags.Int("down", 0, "roll back the last applied migrations instead")
+	allowDestructive := flags.Bool("allow-destructive", false, "roll back migrations that drop tables or columns")
+	export := flags.String("export", "", "directory to export dropped tables and columns to before the rollback")
+	_ = flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	if *plan {
+		return printPlan(db)
+	}
+	if *down > 0 {
+		options := learn_golang_gorm.RollbackOptions{
+			AllowDestructive: *allowDestructive,
+			OnDataLoss: func(loss learn_golang_gorm.DataLoss) {
+				fmt.Printf("rollback %d %s drops %s\n", loss.Version, loss.Migration, loss)
+			},
+		}
+		if *export != "" {
+			options.BeforeDrop = learn_golang_gorm.ExportBeforeDrop(*export)
+		}
+		return learn_golang_gorm.Rollback(db, *down, options)
+	}
+	return learn_golang_gorm.Migrate(db)
+}
+
+func printPlan(db *gorm.DB) error {
+	plans, err := learn_golang_gorm.PlanMigrations(context.Background(), db)
+	if err != nil {
+		return err
+	}
+	if len(plans) == 0 {
+		fmt.Println("no pending migrations")
+		return nil
+	}
+
+	for _, plan := range plans {
+		fmt.Printf("-- %d %s\n", plan.Version, plan.Name)
+		for _, table := range plan.Tables {
+			fmt.Printf("--   %s: ~%d rows, %d bytes of data, %d bytes of indexes\n",
+				table.Table, table.Rows, table.DataBytes, table.IndexBytes)
+		}
+		for _, statement := range plan.Statements {
+			fmt.Println(statement + ";")
+		}
+		fmt.Println()
+	}
+	return nil
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const openapiUsage = "openapi [-o openapi.json] [-title learn-golang-gorm] [-version 1.0.0]"
+
+func runOpenAPI(args []string) error {
+	flags := flag.NewFlagSet("openapi", flag.ExitOnError)
+	output := flags.String("o", "", "file to write, standard output when empty")
+	title := flags.String("title", "learn-golang-gorm", "title of the API")
+	version := flags.String("version", "1.0.0", "version of the API")
+	_ = flags.Parse(args)
+
+	writer := os.Stdout
+	if *output != "" {
+		file, err := os.Create(*output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	encoder := json.NewEncoder(writer)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(learn_golang_gorm.OpenAPI(*title, *version))
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"os"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const queryUsage = "query [-format json|csv] [-max-rows n] <sql>"
+
+func runQuery(args []string) error {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	format := flags.String("format", "json", "output format, json or csv")
+	maxRows := flags.Int("max-rows", 1000, "maximum number of rows returned")
+	_ = flags.Parse(args)
+	if flags.NArg() != 1 {
+		return errors.New("usage: gormctl " + queryUsage)
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	console := learn_golang_gorm.NewQueryConsole(db, "")
+	console.MaxRows = *maxRows
+	result, err := console.Run(context.Background(), flags.Arg(0))
+	if err != nil {
+		return err
+	}
+
+	if *format == "csv" {
+		return result.WriteCSV(os.Stdout)
+	}
+	return result.WriteJSON(os.Stdout)
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"strings"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const seedUsage = "seed [-profile dev] [-only demo-users,demo-todos]"
+
+func runSeed(args []string) error {
+	flags := flag.NewFlagSet("seed", flag.ExitOnError)
+	profile := flags.String("profile", os.Getenv("APP_ENV"), "profile whose seed sets are run, dev when empty")
+	only := flags.String("only", "", "comma separated seeders to run instead of the sets of the profile")
+	_ = flags.Parse(args)
+	if *profile == "" {
+		*profile = learn_golang_gorm.DefaultProfile
+	}
+
+	config, err := learn_golang_gorm.ProfileConfig(*profile)
+	if err != nil {
+		return err
+	}
+	var names []string
+	if *only != "" {
+		names = strings.Split(*only, ",")
+	}
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+	return learn_golang_gorm.RunSeeders(context.Background(), db, config.SeedSets, names)
+}
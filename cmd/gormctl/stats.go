@@ -0,0 +1,65 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	learn_golang_gorm "learn-golang-gorm"
+)
+
+const statsUsage = "stats [-record] [-since 720h]"
+
+func runStats(args []string) error {
+	flags := flag.NewFlagSet("stats", flag.ExitOnError)
+	record := flags.Bool("record", false, "store a snapshot in table_stats")
+	since := flags.Duration("since", 30*24*time.Hour, "period of the growth report")
+	_ = flags.Parse(args)
+
+	db, err := openDB()
+	if err != nil {
+		return err
+	}
+
+	ctx := context.Background()
+	var stats []learn_golang_gorm.TableStat
+	if *record {
+		err = db.AutoMigrate(&learn_golang_gorm.TableStatSnapshot{})
+		if err != nil {
+			return err
+		}
+		stats, err = learn_golang_gorm.RecordTableStats(ctx, db)
+	} else {
+		stats, err = learn_golang_gorm.TableStats(ctx, db)
+	}
+	if err != nil {
+		return err
+	}
+
+	writer := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(writer, "table\trows\tdata MB\tindex MB\tid headroom\t")
+	for _, stat := range stats {
+		fmt.Fprintf(writer, "%s\t%d\t%.1f\t%.1f\t%.1f%%\t\n", stat.Table, stat.Rows,
+			float64(stat.DataBytes)/(1<<20), float64(stat.IndexBytes)/(1<<20), stat.Headroom()*100)
+	}
+	err = writer.Flush()
+	if err != nil || !db.Migrator().HasTable(&learn_golang_gorm.TableStatSnapshot{}) {
+		return err
+	}
+
+	growth, err := learn_golang_gorm.TableGrowthSince(ctx, db, time.Now().Add(-*since))
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("\ngrowth over the last %s\n", *since)
+	fmt.Fprintln(writer, "table\trows\tMB\trows/day\tMB/day\t")
+	for _, entry := range growth {
+		fmt.Fprintf(writer, "%s\t%d\t%.1f\t%.0f\t%.2f\t\n", entry.Table, entry.Rows,
+			float64(entry.Bytes)/(1<<20), entry.RowsPerDay, entry.BytesPerDay/(1<<20))
+	}
+	return writer.Flush()
+}
@@ -0,0 +1,160 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// Cohort is the users who signed up in the week starting at Week, Active[i]
+// is how many of them have a user log i weeks later.
+type Cohort struct {
+	Week   time.Time `json:"week"`
+	Users  int64     `json:"users"`
+	Active []int64   `json:"active"`
+}
+
+// Retention is the share of the cohort active the given number of weeks
+// after signing up.
+func (c Cohort) Retention(week int) float64 {
+	if c.Users == 0 || week < 0 || week >= len(c.Active) {
+		return 0
+	}
+	return float64(c.Active[week]) / float64(c.Users)
+}
+
+type CohortMatrix struct {
+	Weeks   int      `json:"weeks"`
+	Cohorts []Cohort `json:"cohorts"`
+}
+
+func (m *CohortMatrix) cohort(week time.Time) *Cohort {
+	for i := range m.Cohorts {
+		if m.Cohorts[i].Week.Equal(week) {
+			return &m.Cohorts[i]
+		}
+	}
+	m.Cohorts = append(m.Cohorts, Cohort{Week: week, Active: make([]int64, m.Weeks)})
+	return &m.Cohorts[len(m.Cohorts)-1]
+}
+
+// mysqlCohortSQL computes the whole matrix in the server, cohort sizes are
+// the rows with week -1. Weeks are bucketed in the session time zone.
+const mysqlCohortSQL = `select c.cohort as cohort, -1 as week, count(*) as users from (
+	select id as user_id, date_sub(date(created_at), interval weekday(created_at) day) as cohort
+	from users where created_at >= ? and created_at < ?) c
+	group by c.cohort
+union all
+select c.cohort as cohort, floor(datediff(from_unixtime(l.created_at div 1000), c.cohort) / 7) as week,
+	count(distinct l.user_id) as users from (
+	select id as user_id, date_sub(date(created_at), interval weekday(created_at) day) as cohort
+	from users where created_at >= ? and created_at < ?) c
+	join user_logs l on l.user_id = c.user_id
+		and l.created_at >= unix_timestamp(c.cohort) * 1000
+		and l.created_at < unix_timestamp(c.cohort + interval ? week) * 1000
+	group by c.cohort, week
+order by cohort, week`
+
+func mysqlCohortRetention(ctx context.Context, db *gorm.DB, from time.Time, to time.Time, matrix *CohortMatrix) error {
+	var rows []struct {
+		Cohort time.Time
+		Week   int
+		Users  int64
+	}
+	err := db.WithContext(ctx).Raw(mysqlCohortSQL, from, to, from, to, matrix.Weeks).Scan(&rows).Error
+	if err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		cohort := matrix.cohort(row.Cohort)
+		if row.Week < 0 {
+			cohort.Users = row.Users
+		} else if row.Week < matrix.Weeks {
+			cohort.Active[row.Week] = row.Users
+		}
+	}
+	return nil
+}
+
+// portableCohortRetention is the fallback for other dialects, it streams the
+// signups and the user logs of the period and buckets them in Go.
+func portableCohortRetention(ctx context.Context, db *gorm.DB, from time.Time, to time.Time, matrix *CohortMatrix) error {
+	db = db.WithContext(ctx)
+	var users []struct {
+		ID        string
+		CreatedAt time.Time
+	}
+	err := db.Table("users").Select("id, created_at").
+		Where("created_at >= ? AND created_at < ?", from, to).Order("created_at").Scan(&users).Error
+	if err != nil {
+		return err
+	}
+
+	signups := map[string]time.Time{}
+	for _, user := range users {
+		week := truncateToInterval(user.CreatedAt.In(time.Local), IntervalWeek)
+		signups[user.ID] = week
+		matrix.cohort(week).Users++
+	}
+
+	end := truncateToInterval(to.In(time.Local), IntervalWeek).AddDate(0, 0, 7*(matrix.Weeks+1))
+	rows, err := db.Table("user_logs").Select("user_id, created_at").
+		Where("created_at >= ? AND created_at < ?", from.UnixMilli(), end.UnixMilli()).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	seen := map[string]bool{}
+	for rows.Next() {
+		var userID string
+		var createdAt int64
+		err = rows.Scan(&userID, &createdAt)
+		if err != nil {
+			return err
+		}
+		signup, ok := signups[userID]
+		if !ok {
+			continue
+		}
+
+		active := truncateToInterval(time.UnixMilli(createdAt).In(time.Local), IntervalWeek)
+		week := int(active.Sub(signup).Hours()+12) / (7 * 24)
+		key := userID + "/" + active.Format(time.DateOnly)
+		if active.Before(signup) || week >= matrix.Weeks || seen[key] {
+			continue
+		}
+		seen[key] = true
+		matrix.cohort(signup).Active[week]++
+	}
+	return rows.Err()
+}
+
+// CohortRetention groups the users who signed up between from and to by
+// signup week, weeks start on monday, and counts how many were active in
+// each of the following weeks, the signup week being week 0. A negative
+// number of weeks fails with ErrInvalidRequest.
+func CohortRetention(ctx context.Context, db *gorm.DB, from time.Time, to time.Time, weeks int) (CohortMatrix, error) {
+	if weeks < 0 {
+		return CohortMatrix{}, fmt.Errorf("%w: weeks must not be negative, got %d", ErrInvalidRequest, weeks)
+	}
+	matrix := CohortMatrix{Weeks: weeks}
+	var err error
+	if db.Dialector.Name() == "mysql" {
+		err = mysqlCohortRetention(ctx, db, from, to, &matrix)
+	} else {
+		err = portableCohortRetention(ctx, db, from, to, &matrix)
+	}
+	if err != nil {
+		return matrix, err
+	}
+
+	sort.Slice(matrix.Cohorts, func(i, j int) bool {
+		return matrix.Cohorts[i].Week.Before(matrix.Cohorts[j].Week)
+	})
+	return matrix, nil
+}
@@ -0,0 +1,292 @@
+package learn_golang_gorm
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrChecksumMismatch = errors.New("archive checksum does not match its manifest")
+
+// BlobStore keeps exported archive batches, e.g. a directory or a bucket.
+type BlobStore interface {
+	Put(ctx context.Context, key string, data io.Reader) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+type FileBlobStore struct {
+	Dir string
+}
+
+func (s FileBlobStore) Put(ctx context.Context, key string, data io.Reader) error {
+	path := filepath.Join(s.Dir, filepath.FromSlash(key))
+	err := os.MkdirAll(filepath.Dir(path), 0o755)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(file.Name())
+
+	_, err = io.Copy(file, data)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return err
+	}
+	return os.Rename(file.Name(), path)
+}
+
+func (s FileBlobStore) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return os.Open(filepath.Join(s.Dir, filepath.FromSlash(key)))
+}
+
+func (s FileBlobStore) Delete(ctx context.Context, key string) error {
+	err := os.Remove(filepath.Join(s.Dir, filepath.FromSlash(key)))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// ArchiveManifest describes one exported batch of an archive table.
+type ArchiveManifest struct {
+	ID         int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Table      string     `gorm:"column:table_name;index"`
+	BlobKey    string     `gorm:"column:blob_key"`
+	Format     string     `gorm:"column:format"`
+	FirstKey   string     `gorm:"column:first_key"`
+	LastKey    string     `gorm:"column:last_key"`
+	Rows       int64      `gorm:"column:row_count"`
+	Bytes      int64      `gorm:"column:bytes"`
+	Checksum   string     `gorm:"column:checksum;type:char(64)"`
+	CreatedAt  time.Time  `gorm:"column:created_at;autoCreateTime"`
+	RestoredAt *time.Time `gorm:"column:restored_at"`
+}
+
+func (m *ArchiveManifest) TableName() string {
+	return "archives"
+}
+
+const formatJSONLGzip = "jsonl.gz"
+
+const (
+	columnBinary = "binary"
+	columnTime   = "time"
+)
+
+// exportColumnKinds returns the columns of table that need converting when
+// exported as JSON: raw bytes are base64 encoded since JSON strings must be
+// valid UTF-8, and dates and timestamps are parsed back from RFC 3339. TIME
+// columns are durations, they stay text.
+func exportColumnKinds(db *gorm.DB, table string) (map[string]string, error) {
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+
+	kinds := map[string]string{}
+	for _, columnType := range columnTypes {
+		typeName := strings.ToLower(columnType.DatabaseTypeName())
+		switch {
+		case strings.Contains(typeName, "binary") || strings.Contains(typeName, "blob"):
+			kinds[columnType.Name()] = columnBinary
+		case typeName == "date" || typeName == "datetime" || typeName == "timestamp":
+			kinds[columnType.Name()] = columnTime
+		}
+	}
+	return kinds, nil
+}
+
+func encodeExportRow(encoder *json.Encoder, row map[string]interface{}, kinds map[string]string) error {
+	for column, kind := range kinds {
+		if value, ok := row[column].(string); ok && kind == columnBinary {
+			row[column] = base64.StdEncoding.EncodeToString([]byte(value))
+		}
+	}
+	return encoder.Encode(row)
+}
+
+func decodeExportRow(line []byte, kinds map[string]string) (map[string]interface{}, error) {
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+
+	var row map[string]interface{}
+	err := decoder.Decode(&row)
+	if err != nil {
+		return nil, err
+	}
+	for column, value := range row {
+		text, ok := value.(string)
+		if !ok {
+			continue
+		}
+		switch kinds[column] {
+		case columnBinary:
+			row[column], err = base64.StdEncoding.DecodeString(text)
+		case columnTime:
+			row[column], err = time.Parse(time.RFC3339Nano, text)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	return row, nil
+}
+
+func encodeArchiveBatch(rows []map[string]interface{}, kinds map[string]string) ([]byte, error) {
+	var buffer bytes.Buffer
+	writer := gzip.NewWriter(&buffer)
+	encoder := json.NewEncoder(writer)
+	for _, row := range rows {
+		err := encodeExportRow(encoder, row, kinds)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := writer.Close()
+	return buffer.Bytes(), err
+}
+
+// ExportArchive writes the rows of the archive table of table to store in
+// batches of gzipped JSON lines. Every batch is uploaded first, then its
+// manifest is recorded and its rows deleted in one transaction, so an
+// interrupted export is simply run again.
+func ExportArchive(ctx context.Context, db *gorm.DB, store BlobStore, table string, key string, batchSize int) ([]ArchiveManifest, error) {
+	db = db.WithContext(ctx)
+	archive := ArchiveTable(table)
+	kinds, err := exportColumnKinds(db, archive)
+	if err != nil {
+		return nil, err
+	}
+
+	var manifests []ArchiveManifest
+	for {
+		var rows []map[string]interface{}
+		err = db.Table(archive).Order(clause.OrderByColumn{Column: clause.Column{Name: key}}).
+			Limit(batchSize).Find(&rows).Error
+		if err != nil || len(rows) == 0 {
+			return manifests, err
+		}
+
+		keys := make([]interface{}, len(rows))
+		for i, row := range rows {
+			keys[i] = row[key]
+		}
+
+		data, err := encodeArchiveBatch(rows, kinds)
+		if err != nil {
+			return manifests, err
+		}
+
+		checksum := sha256.Sum256(data)
+		manifest := ArchiveManifest{
+			Table:    table,
+			Format:   formatJSONLGzip,
+			FirstKey: fmt.Sprint(keys[0]),
+			LastKey:  fmt.Sprint(keys[len(keys)-1]),
+			Rows:     int64(len(rows)),
+			Bytes:    int64(len(data)),
+			Checksum: hex.EncodeToString(checksum[:]),
+		}
+		manifest.BlobKey = fmt.Sprintf("%s/%s-%s.%s", table, manifest.FirstKey, manifest.LastKey, formatJSONLGzip)
+
+		err = store.Put(ctx, manifest.BlobKey, bytes.NewReader(data))
+		if err != nil {
+			return manifests, err
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := tx.Create(&manifest).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec("DELETE FROM ? WHERE ? IN ?", clause.Table{Name: archive}, clause.Column{Name: key}, keys).Error
+		})
+		if err != nil {
+			return manifests, err
+		}
+		manifests = append(manifests, manifest)
+	}
+}
+
+// RestoreArchive loads an exported batch back into the archive table after
+// verifying its checksum, from there IncludeArchived reads it again.
+func RestoreArchive(ctx context.Context, db *gorm.DB, store BlobStore, manifestID int64) error {
+	db = db.WithContext(ctx)
+	var manifest ArchiveManifest
+	err := db.Take(&manifest, manifestID).Error
+	if err != nil {
+		return err
+	}
+
+	blob, err := store.Get(ctx, manifest.BlobKey)
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+
+	data, err := io.ReadAll(blob)
+	if err != nil {
+		return err
+	}
+	checksum := sha256.Sum256(data)
+	if hex.EncodeToString(checksum[:]) != manifest.Checksum {
+		return ErrChecksumMismatch
+	}
+
+	archive := ArchiveTable(manifest.Table)
+	kinds, err := exportColumnKinds(db, archive)
+	if err != nil {
+		return err
+	}
+
+	reader, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	var rows []map[string]interface{}
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		row, err := decodeExportRow(scanner.Bytes(), kinds)
+		if err != nil {
+			return err
+		}
+		rows = append(rows, row)
+	}
+	if scanner.Err() != nil {
+		return scanner.Err()
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		if len(rows) > 0 {
+			err := tx.Table(archive).Clauses(clause.Insert{Modifier: "IGNORE"}).CreateInBatches(rows, 500).Error
+			if err != nil {
+				return err
+			}
+		}
+		return tx.Model(&manifest).Update("restored_at", Now(tx)).Error
+	})
+}
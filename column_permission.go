@@ -0,0 +1,159 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const (
+	RoleAdmin   = "admin"
+	RoleSupport = "support"
+	RoleAnalyst = "analyst"
+)
+
+type roleContextKey struct{}
+
+// WithRole sets the role of the caller, queries with db.WithContext(ctx)
+// only read the columns ReadPolicy allows it.
+func WithRole(ctx context.Context, role string) context.Context {
+	return context.WithValue(ctx, roleContextKey{}, role)
+}
+
+func RoleFromContext(ctx context.Context) (string, bool) {
+	role, ok := ctx.Value(roleContextKey{}).(string)
+	return role, ok
+}
+
+// ReadPolicy lists per role and table the columns the role cannot read,
+// in the queried table as well as in joined and preloaded ones. Contexts
+// without a role read everything, a role missing from the policy reads
+// nothing and fails with ErrUnknownRole.
+type ReadPolicy map[string]map[string][]string
+
+var ErrUnknownRole = errors.New("role is not in the read policy")
+
+var DefaultReadPolicy = ReadPolicy{
+	RoleAdmin: {},
+	RoleSupport: {
+		"users":   {"password"},
+		"wallets": {"balance"},
+	},
+	RoleAnalyst: {
+		"users":     {"password", "email", "phone", "first_name", "middle_name", "last_name", "full_name"},
+		"addresses": {"address"},
+	},
+}
+
+// tables returns the hidden columns by table of the role of db, nil when
+// the context has no role.
+func (p ReadPolicy) tables(db *gorm.DB) (map[string][]string, error) {
+	role, ok := RoleFromContext(db.Statement.Context)
+	if !ok {
+		return nil, nil
+	}
+	tables, ok := p[role]
+	if !ok {
+		return nil, fmt.Errorf("%w: %q", ErrUnknownRole, role)
+	}
+	return tables, nil
+}
+
+// joinRelations resolves a join like "Wallet" or "Wallet.User" to its
+// relationships, nil for joins written as SQL.
+func joinRelations(s *schema.Schema, name string) []*schema.Relationship {
+	var relations []*schema.Relationship
+	for _, part := range strings.Split(name, ".") {
+		if s == nil {
+			return nil
+		}
+		relation, ok := s.Relationships.Relations[part]
+		if !ok {
+			return nil
+		}
+		relations = append(relations, relation)
+		s = relation.FieldSchema
+	}
+	return relations
+}
+
+// omit leaves the hidden columns out of the generated select and of the
+// columns of the joins, explicit selects are handled by clear.
+func (p ReadPolicy) omit(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	tables, err := p.tables(db)
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	if tables == nil {
+		return
+	}
+
+	db.Statement.Omits = append(db.Statement.Omits, tables[db.Statement.Table]...)
+	for i, join := range db.Statement.Joins {
+		for _, relation := range joinRelations(db.Statement.Schema, join.Name) {
+			db.Statement.Joins[i].Omits = append(db.Statement.Joins[i].Omits, tables[relation.FieldSchema.Table]...)
+		}
+	}
+}
+
+// clear sets the hidden columns of the queried and joined rows to their
+// zero value.
+func (p ReadPolicy) clear(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	tables, err := p.tables(db)
+	if err != nil || len(tables) == 0 {
+		return
+	}
+
+	hidden := tables[db.Statement.Table]
+	zero := func(s *schema.Schema, value reflect.Value, columns []string) {
+		for _, column := range columns {
+			field := s.LookUpField(column)
+			if field == nil {
+				continue
+			}
+			fieldValue := field.ReflectValueOf(db.Statement.Context, value)
+			fieldValue.Set(reflect.Zero(fieldValue.Type()))
+		}
+	}
+	forEachRow(db, func(row map[string]interface{}) {
+		for _, column := range hidden {
+			if _, ok := row[column]; ok {
+				row[column] = nil
+			}
+		}
+	}, func(value reflect.Value) {
+		zero(db.Statement.Schema, value, hidden)
+		for _, join := range db.Statement.Joins {
+			joined := value
+			for _, relation := range joinRelations(db.Statement.Schema, join.Name) {
+				joined = reflect.Indirect(relation.Field.ReflectValueOf(db.Statement.Context, joined))
+				if joined.Kind() != reflect.Struct {
+					break
+				}
+				zero(relation.FieldSchema, joined, tables[relation.FieldSchema.Table])
+			}
+		}
+	})
+}
+
+// EnforceReadPolicy registers the callbacks applying policy to every query
+// on db, preloads included as they query through the same callbacks.
+func EnforceReadPolicy(db *gorm.DB, policy ReadPolicy) error {
+	err := db.Callback().Query().Before("gorm:query").Register("read_policy:omit", policy.omit)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Query().After("gorm:query").Register("read_policy:clear", policy.clear)
+}
@@ -0,0 +1,191 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"learn-golang-gorm/datafix"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	columnRenameSyncKey = "column_rename:sync"
+	columnRenameKeysKey = "column_rename:keys"
+)
+
+// ColumnRename renames Table.From to Table.To while old and new binaries run
+// side by side, following expand and contract:
+//
+//  1. ExpandMigration adds To next to From.
+//  2. DualWrite copies From into To after every write through gorm.
+//  3. Backfill copies From into To for the rows written before.
+//  4. Reads switch: the model maps the field to To and Switched is set, so
+//     DualWrite now copies To into From for binaries still reading From.
+//  5. ContractMigration drops From once no binary uses it anymore.
+type ColumnRename struct {
+	Table      string
+	From       string
+	To         string
+	Definition string
+	Key        string
+	Switched   bool
+}
+
+func (r ColumnRename) key() string {
+	if r.Key == "" {
+		return "id"
+	}
+	return r.Key
+}
+
+// columns returns the column written by the application and its copy.
+func (r ColumnRename) columns() (string, string) {
+	if r.Switched {
+		return r.To, r.From
+	}
+	return r.From, r.To
+}
+
+func (r ColumnRename) ExpandMigration(version int64) Migration {
+	return Migration{
+		Version: version,
+		Name:    fmt.Sprintf("expand %s %s to %s", r.Table, r.From, r.To),
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE ? ADD COLUMN ? "+r.Definition, clause.Table{Name: r.Table}, clause.Column{Name: r.To}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: r.Table}, clause.Column{Name: r.To}).Error
+		},
+	}
+}
+
+// ContractMigration drops From, rolling it back restores the column with the
+// values of To.
+func (r ColumnRename) ContractMigration(version int64) Migration {
+	return Migration{
+		Version: version,
+		Name:    fmt.Sprintf("contract %s %s to %s", r.Table, r.From, r.To),
+		Up: func(tx *gorm.DB) error {
+			return tx.Exec("ALTER TABLE ? DROP COLUMN ?", clause.Table{Name: r.Table}, clause.Column{Name: r.From}).Error
+		},
+		Down: func(tx *gorm.DB) error {
+			err := tx.Exec("ALTER TABLE ? ADD COLUMN ? "+r.Definition, clause.Table{Name: r.Table}, clause.Column{Name: r.From}).Error
+			if err != nil {
+				return err
+			}
+			return tx.Exec("UPDATE ? SET ? = ?", clause.Table{Name: r.Table}, clause.Column{Name: r.From}, clause.Column{Name: r.To}).Error
+		},
+	}
+}
+
+func primaryKeyValues(stmt *gorm.Statement) []interface{} {
+	if stmt.Schema == nil || stmt.Schema.PrioritizedPrimaryField == nil || !stmt.ReflectValue.IsValid() {
+		return nil
+	}
+
+	field := stmt.Schema.PrioritizedPrimaryField
+	var values []interface{}
+	collect := func(value reflect.Value) {
+		key, zero := field.ValueOf(stmt.Context, value)
+		if !zero {
+			values = append(values, key)
+		}
+	}
+
+	switch stmt.ReflectValue.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < stmt.ReflectValue.Len(); i++ {
+			collect(reflect.Indirect(stmt.ReflectValue.Index(i)))
+		}
+	case reflect.Struct:
+		collect(stmt.ReflectValue)
+	}
+	return values
+}
+
+func (r ColumnRename) applies(db *gorm.DB) bool {
+	if db.Error != nil || db.Statement.Table != r.Table {
+		return false
+	}
+	_, syncing := db.Get(columnRenameSyncKey)
+	return !syncing
+}
+
+// captureKeys locks and remembers the rows an update without primary keys
+// is going to write, its conditions may no longer match them afterwards.
+func (r ColumnRename) captureKeys(db *gorm.DB) {
+	if !r.applies(db) || len(primaryKeyValues(db.Statement)) > 0 {
+		return
+	}
+	where, ok := db.Statement.Clauses["WHERE"]
+	if !ok {
+		return
+	}
+
+	var keys []interface{}
+	err := db.Session(&gorm.Session{NewDB: true}).Table(r.Table).Clauses(where.Expression).
+		Clauses(clause.Locking{Strength: "UPDATE"}).Pluck(r.key(), &keys).Error
+	if err != nil {
+		_ = db.AddError(err)
+		return
+	}
+	db.InstanceSet(columnRenameKeysKey, keys)
+}
+
+// sync copies the written column on the rows of the statement.
+func (r ColumnRename) sync(db *gorm.DB) {
+	if !r.applies(db) || db.RowsAffected == 0 {
+		return
+	}
+
+	keys := primaryKeyValues(db.Statement)
+	if len(keys) == 0 {
+		captured, _ := db.InstanceGet(columnRenameKeysKey)
+		keys, _ = captured.([]interface{})
+	}
+	if len(keys) == 0 {
+		return
+	}
+
+	source, target := r.columns()
+	err := db.Session(&gorm.Session{NewDB: true}).Table(r.Table).Set(columnRenameSyncKey, true).
+		Where(clause.IN{Column: clause.Column{Name: r.key()}, Values: keys}).
+		UpdateColumn(target, gorm.Expr("?", clause.Column{Name: source})).Error
+	if err != nil {
+		_ = db.AddError(err)
+	}
+}
+
+// DualWrite registers the callbacks keeping both columns equal on db.
+func (r ColumnRename) DualWrite(db *gorm.DB) error {
+	name := "column_rename:" + r.Table + "." + r.From
+	err := db.Callback().Create().After("gorm:create").Register(name, r.sync)
+	if err != nil {
+		return err
+	}
+	err = db.Callback().Update().Before("gorm:update").Register(name+":keys", r.captureKeys)
+	if err != nil {
+		return err
+	}
+	return db.Callback().Update().After("gorm:update").Register(name, r.sync)
+}
+
+// Backfill copies the written column into its copy wherever they differ, in
+// chunks through datafix so the run is recorded in data_fix_runs.
+func (r ColumnRename) Backfill(ctx context.Context, db *gorm.DB, actor string) (*datafix.Run, error) {
+	source, target := r.columns()
+	return datafix.Apply(ctx, db, datafix.Fix{
+		Name:  fmt.Sprintf("backfill %s %s from %s", r.Table, target, source),
+		Table: r.Table,
+		Key:   r.key(),
+		Select: func(db *gorm.DB) *gorm.DB {
+			return db.Where("NOT (? <=> ?)", clause.Column{Name: target}, clause.Column{Name: source})
+		},
+		Transform: func(row map[string]interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{target: row[source]}, nil
+		},
+	}, actor)
+}
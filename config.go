@@ -0,0 +1,287 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	gormMysql "gorm.io/driver/mysql"
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+var (
+	ErrInvalidCABundle    = errors.New("CA bundle does not contain any certificate")
+	ErrMissingCredentials = errors.New("database credentials are not configured")
+)
+
+// TLSConfig points to PEM files, CertFile and KeyFile are only needed when
+// the server requires client certificates. InsecureSkipVerify is meant for
+// development against self signed servers.
+type TLSConfig struct {
+	CAFile             string
+	CertFile           string
+	KeyFile            string
+	ServerName         string
+	InsecureSkipVerify bool
+}
+
+func (c TLSConfig) Build() (*tls.Config, error) {
+	config := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+		MinVersion:         tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		bundle, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, ErrInvalidCABundle
+		}
+	}
+
+	if c.CertFile != "" {
+		certificate, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
+
+// Config describes a database connection. Hosts is ordered by preference,
+// the first host is the primary and the others are standbys used when it is
+// unreachable. Credentials take precedence over User and Password, without
+// them CredentialSource is fetched again every RotationInterval. Open fails
+// with ErrMissingCredentials when none of them is set.
+type Config struct {
+	Hosts            []string
+	User             string
+	Password         string
+	Credentials      CredentialProvider
+	CredentialSource CredentialSource
+	RotationInterval time.Duration
+	Database         string
+	TLS              *TLSConfig
+
+	// Namespace selects the schema of a logical environment, like a feature
+	// branch, see CreateNamespace.
+	Namespace string
+
+	MaxOpenConns    int
+	MaxIdleConns    int
+	ConnMaxLifetime time.Duration
+	ConnMaxIdleTime time.Duration
+	LogLevel        logger.LogLevel
+	DryRun          bool
+	// Clock tells the time to gorm and the services, SystemClock when nil.
+	Clock Clock
+	// Entropy is the source of tokens and codes, crypto/rand when nil.
+	Entropy io.Reader
+
+	// Replicas are read replica hosts, each opened with the same settings.
+	Replicas []string
+
+	FailbackInterval time.Duration
+	OnFailover       func(from string, to string)
+
+	// MaskRules masks personal data in queried rows, set it to
+	// DefaultMaskRules for environments working on copied production data.
+	MaskRules []MaskRule
+
+	// Quotas are enforced on every create, e.g. DefaultQuotas.
+	Quotas []Quota
+
+	// Profile labels statements for pprof and runtime/trace, see
+	// ProfileStatements.
+	Profile bool
+
+	// RedactedColumns have their bind values masked in the SQL log,
+	// DefaultRedactedColumns when nil. UnsafeLogParams logs every value, it
+	// is meant for local development only.
+	RedactedColumns []string
+	UnsafeLogParams bool
+
+	// SeedSets are the seed sets provisioned for the environment, see
+	// SeedSets and RunSeeders.
+	SeedSets []string
+
+	// LogSampling thins out the logged queries, its DB is set to the opened
+	// one when nil.
+	LogSampling *LogSampling
+}
+
+// DefaultConfig reads the credentials from DB_USER and DB_PASSWORD, opening
+// it fails while DB_PASSWORD is not set.
+func DefaultConfig() Config {
+	return Config{
+		Hosts:            []string{"localhost:3306"},
+		CredentialSource: EnvCredentialSource{UserVar: "DB_USER", PasswordVar: "DB_PASSWORD"},
+		Database:         "learn_golang_gorm",
+		MaxOpenConns:     100,
+		MaxIdleConns:     10,
+		ConnMaxLifetime:  30 * time.Minute,
+		ConnMaxIdleTime:  5 * time.Minute,
+		LogLevel:         logger.Info,
+		FailbackInterval: 10 * time.Second,
+	}
+}
+
+// mysqlConfig allows the cleartext authentication plugin, needed for IAM
+// tokens, only when the connection is encrypted.
+func (c Config) mysqlConfig() (*mysql.Config, error) {
+	config := mysql.NewConfig()
+	config.User = c.User
+	config.Passwd = c.Password
+	config.DBName = NamespaceDatabase(c.Database, c.Namespace)
+	config.ParseTime = true
+	config.Loc = time.Local
+	config.Params = map[string]string{"charset": "utf8mb4"}
+
+	if c.TLS != nil {
+		tlsConfig, err := c.TLS.Build()
+		if err != nil {
+			return nil, err
+		}
+		config.TLS = tlsConfig
+		config.AllowCleartextPasswords = true
+	}
+	return config, nil
+}
+
+// Open connects through a FailoverConnector, with more than one host the
+// preferred host is checked every FailbackInterval until sql.DB.Close.
+func Open(config Config) (*gorm.DB, error) {
+	if config.Credentials == nil && config.CredentialSource == nil && config.User == "" {
+		return nil, ErrMissingCredentials
+	}
+	if config.Credentials == nil && config.CredentialSource != nil {
+		// fail here rather than on the first query
+		_, err := config.CredentialSource.Fetch(context.Background())
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrMissingCredentials, err)
+		}
+	}
+
+	mysqlConfig, err := config.mysqlConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	connector, err := NewFailoverConnector(mysqlConfig, config.Hosts, config.OnFailover)
+	if err != nil {
+		return nil, err
+	}
+	connector.Credentials = config.Credentials
+	if connector.Credentials == nil && config.CredentialSource != nil {
+		rotating := NewRotatingCredentials(config.CredentialSource)
+		rotating.OnRotate = connector.Rotate
+		connector.Credentials = rotating
+
+		if config.RotationInterval > 0 {
+			ctx, cancel := context.WithCancel(context.Background())
+			go func() {
+				<-connector.Done()
+				cancel()
+			}()
+			go rotating.Run(ctx, config.RotationInterval)
+		}
+	}
+
+	sqlDB := sql.OpenDB(connector)
+	sqlDB.SetMaxOpenConns(config.MaxOpenConns)
+	sqlDB.SetMaxIdleConns(config.MaxIdleConns)
+	sqlDB.SetConnMaxLifetime(config.ConnMaxLifetime)
+	sqlDB.SetConnMaxIdleTime(config.ConnMaxIdleTime)
+
+	if len(config.Hosts) > 1 && config.FailbackInterval > 0 {
+		go connector.Monitor(config.FailbackInterval)
+	}
+
+	clock := config.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	redactedColumns := config.RedactedColumns
+	if redactedColumns == nil {
+		redactedColumns = DefaultRedactedColumns
+	}
+	sqlLogger := NewRedactingLogger(logger.Default, redactedColumns...)
+	sqlLogger.Unsafe = config.UnsafeLogParams
+	var gormLogger logger.Interface = sqlLogger
+	if config.LogSampling != nil {
+		gormLogger = NewSamplingLogger(sqlLogger, config.LogSampling)
+	}
+
+	db, err := gorm.Open(gormMysql.New(gormMysql.Config{Conn: sqlDB}), &gorm.Config{
+		Logger:  gormLogger.LogMode(config.LogLevel),
+		DryRun:  config.DryRun,
+		NowFunc: clock.Now,
+	})
+	if err != nil {
+		_ = sqlDB.Close()
+		return nil, err
+	}
+
+	if config.LogSampling != nil && config.LogSampling.DB == nil {
+		config.LogSampling.DB = db
+	}
+	if config.Entropy != nil {
+		UseEntropy(db, config.Entropy)
+	}
+	if len(config.MaskRules) > 0 {
+		err = EnableMasking(db, config.MaskRules...)
+		if err != nil {
+			_ = sqlDB.Close()
+			return nil, err
+		}
+	}
+	if len(config.Quotas) > 0 {
+		err = EnforceQuotas(db, config.Quotas...)
+		if err != nil {
+			_ = sqlDB.Close()
+			return nil, err
+		}
+	}
+	if config.Profile {
+		err = ProfileStatements(db)
+		if err != nil {
+			_ = sqlDB.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// OpenReplicaRouter opens the primary and every host of Replicas.
+func OpenReplicaRouter(config Config) (*ReplicaRouter, error) {
+	primary, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+
+	var replicas []*Replica
+	for _, host := range config.Replicas {
+		replicaConfig := config
+		replicaConfig.Hosts = []string{host}
+
+		replica, err := Open(replicaConfig)
+		if err != nil {
+			return nil, err
+		}
+		replicas = append(replicas, &Replica{Name: host, DB: replica})
+	}
+	return NewReplicaRouter(primary, replicas...), nil
+}
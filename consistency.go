@@ -0,0 +1,147 @@
+package learn_golang_gorm
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// ConsistencyCheck is an invariant across tables. Find returns the rows
+// breaking it, Repair fixes all of them at once and is nil for violations
+// that need a human to decide, like a wallet whose user is gone.
+type ConsistencyCheck struct {
+	Name   string
+	Tables []string
+	Find   func(db *gorm.DB) ([]ConsistencyViolation, error)
+	Repair func(tx *gorm.DB) error
+}
+
+type ConsistencyViolation struct {
+	Check    string
+	Key      string
+	Expected string
+	Actual   string
+	Repaired bool
+}
+
+func findViolations(sql string) func(db *gorm.DB) ([]ConsistencyViolation, error) {
+	return func(db *gorm.DB) ([]ConsistencyViolation, error) {
+		var violations []ConsistencyViolation
+		err := db.Raw(sql).Scan(&violations).Error
+		return violations, err
+	}
+}
+
+var consistencyChecks = []ConsistencyCheck{
+	{
+		Name:   "wallet_user",
+		Tables: []string{"wallets", "users"},
+		Find: findViolations("select w.id as `key`, '' as expected, w.user_id as actual from wallets w " +
+			"left join users u on u.id = w.user_id where u.id is null order by w.id"),
+	},
+	{
+		Name:   "wallet_ledger_balance",
+		Tables: []string{"wallets", "wallet_transactions"},
+		Find: findViolations("select w.id as `key`, t.balance as expected, w.balance as actual from wallets w " +
+			"join wallet_transactions t on t.id = (select max(id) from wallet_transactions where wallet_id = w.id) " +
+			"where t.balance <> w.balance order by w.id"),
+		Repair: func(tx *gorm.DB) error {
+			return tx.Exec("update wallets w join wallet_transactions t " +
+				"on t.id = (select max(id) from wallet_transactions where wallet_id = w.id) " +
+				"set w.balance = t.balance where t.balance <> w.balance").Error
+		},
+	},
+	{
+		Name:   "like_references",
+		Tables: []string{"user_like_product", "users", "products"},
+		Find: findViolations("select concat(l.user_id, '/', l.product_id) as `key`, '' as expected, " +
+			"case when u.id is null then 'missing user' else 'missing product' end as actual from user_like_product l " +
+			"left join users u on u.id = l.user_id left join products p on p.id = l.product_id " +
+			"where u.id is null or p.id is null order by l.user_id, l.product_id"),
+		Repair: func(tx *gorm.DB) error {
+			return tx.Exec("delete l from user_like_product l " +
+				"left join users u on u.id = l.user_id left join products p on p.id = l.product_id " +
+				"where u.id is null or p.id is null").Error
+		},
+	},
+	{
+		Name:   "product_review_count",
+		Tables: []string{"products", "reviews"},
+		Find: findViolations("select p.id as `key`, coalesce(r.review_count, 0) as expected, p.review_count as actual " +
+			"from products p left join (select product_id, count(*) as review_count from reviews group by product_id) r " +
+			"on r.product_id = p.id where p.review_count <> coalesce(r.review_count, 0) order by p.id"),
+		Repair: RecomputeProductRatings,
+	},
+	{
+		Name:   "product_likes_count",
+		Tables: []string{"products", "user_like_product"},
+		Find: findViolations("select p.id as `key`, coalesce(l.likes_count, 0) as expected, p.likes_count as actual " +
+			"from products p left join (select product_id, count(*) as likes_count from user_like_product group by product_id) l " +
+			"on l.product_id = p.id where p.likes_count <> coalesce(l.likes_count, 0) order by p.id"),
+		Repair: func(tx *gorm.DB) error {
+			return tx.Exec("update products p left join (select product_id, count(*) as likes_count " +
+				"from user_like_product group by product_id) l on l.product_id = p.id " +
+				"set p.likes_count = coalesce(l.likes_count, 0) where p.likes_count <> coalesce(l.likes_count, 0)").Error
+		},
+	},
+	{
+		Name:   "coupon_used_count",
+		Tables: []string{"coupons", "coupon_redemptions"},
+		Find: findViolations("select c.id as `key`, coalesce(r.used_count, 0) as expected, c.used_count as actual " +
+			"from coupons c left join (select coupon_id, count(*) as used_count from coupon_redemptions group by coupon_id) r " +
+			"on r.coupon_id = c.id where c.used_count <> coalesce(r.used_count, 0) order by c.id"),
+		Repair: func(tx *gorm.DB) error {
+			return tx.Exec("update coupons c left join (select coupon_id, count(*) as used_count " +
+				"from coupon_redemptions group by coupon_id) r on r.coupon_id = c.id " +
+				"set c.used_count = coalesce(r.used_count, 0) where c.used_count <> coalesce(r.used_count, 0)").Error
+		},
+	},
+	{
+		Name:   "journal_balanced",
+		Tables: []string{"journal_entries"},
+		Find: findViolations("select transaction_id as `key`, '0' as expected, sum(amount) as actual " +
+			"from journal_entries group by transaction_id having sum(amount) <> 0 order by transaction_id"),
+	},
+}
+
+// RegisterConsistencyCheck adds checks run by CheckConsistency.
+func RegisterConsistencyCheck(check ...ConsistencyCheck) {
+	consistencyChecks = append(consistencyChecks, check...)
+}
+
+// CheckConsistency runs every check whose tables exist and reports the
+// violations found. With repair set, the violations of each repairable
+// check are fixed in one transaction per check.
+func CheckConsistency(ctx context.Context, db *gorm.DB, repair bool) ([]ConsistencyViolation, error) {
+	db = db.WithContext(ctx)
+	var report []ConsistencyViolation
+	for _, check := range consistencyChecks {
+		exists := true
+		for _, table := range check.Tables {
+			exists = exists && db.Migrator().HasTable(table)
+		}
+		if !exists {
+			continue
+		}
+
+		violations, err := check.Find(db)
+		if err != nil {
+			return report, err
+		}
+		for i := range violations {
+			violations[i].Check = check.Name
+		}
+
+		if repair && check.Repair != nil && len(violations) > 0 {
+			err = db.Transaction(check.Repair)
+			if err != nil {
+				return report, err
+			}
+			for i := range violations {
+				violations[i].Repaired = true
+			}
+		}
+		report = append(report, violations...)
+	}
+	return report, nil
+}
@@ -0,0 +1,116 @@
+package learn_golang_gorm
+
+import (
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"net/mail"
+	"regexp"
+	"strings"
+)
+
+var (
+	ErrInvalidEmail       = errors.New("invalid email")
+	ErrInvalidPhoneNumber = errors.New("invalid phone number")
+)
+
+// DefaultCountryCode is used for phone numbers written in national format,
+// e.g. 0812-3456-789 becomes +628123456789.
+var DefaultCountryCode = "62"
+
+type Email string
+
+func NormalizeEmail(value string) (Email, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	if value == "" {
+		return "", nil
+	}
+
+	address, err := mail.ParseAddress(value)
+	if err != nil || address.Address != value {
+		return "", fmt.Errorf("%w: %q", ErrInvalidEmail, value)
+	}
+	return Email(value), nil
+}
+
+func (e Email) Value() (driver.Value, error) {
+	normalized, err := NormalizeEmail(string(e))
+	if err != nil {
+		return nil, err
+	}
+	return string(normalized), nil
+}
+
+func (e *Email) Scan(value interface{}) error {
+	text, err := scanText(value)
+	if err != nil {
+		return err
+	}
+
+	normalized, err := NormalizeEmail(text)
+	if err != nil {
+		return err
+	}
+	*e = normalized
+	return nil
+}
+
+type PhoneNumber string
+
+var (
+	phoneSeparators = strings.NewReplacer(" ", "", "-", "", ".", "", "(", "", ")", "")
+	e164Pattern     = regexp.MustCompile(`^\+[1-9][0-9]{6,14}$`)
+)
+
+func NormalizePhoneNumber(value string) (PhoneNumber, error) {
+	normalized := phoneSeparators.Replace(strings.TrimSpace(value))
+	switch {
+	case normalized == "":
+		return "", nil
+	case strings.HasPrefix(normalized, "00"):
+		normalized = "+" + normalized[2:]
+	case strings.HasPrefix(normalized, "0"):
+		normalized = "+" + DefaultCountryCode + normalized[1:]
+	case !strings.HasPrefix(normalized, "+"):
+		normalized = "+" + normalized
+	}
+
+	if !e164Pattern.MatchString(normalized) {
+		return "", fmt.Errorf("%w: %q", ErrInvalidPhoneNumber, value)
+	}
+	return PhoneNumber(normalized), nil
+}
+
+func (p PhoneNumber) Value() (driver.Value, error) {
+	normalized, err := NormalizePhoneNumber(string(p))
+	if err != nil {
+		return nil, err
+	}
+	return string(normalized), nil
+}
+
+func (p *PhoneNumber) Scan(value interface{}) error {
+	text, err := scanText(value)
+	if err != nil {
+		return err
+	}
+
+	normalized, err := NormalizePhoneNumber(text)
+	if err != nil {
+		return err
+	}
+	*p = normalized
+	return nil
+}
+
+func scanText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case nil:
+		return "", nil
+	case []byte:
+		return string(v), nil
+	case string:
+		return v, nil
+	}
+	return "", fmt.Errorf("cannot scan %T into text", value)
+}
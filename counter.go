@@ -0,0 +1,150 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type counterRow struct {
+	Table string
+	ID    string
+}
+
+// CounterBuffer accumulates increments of hot counters, like the likes and
+// views of a popular product, and applies them every FlushInterval with a
+// single UPDATE per row instead of one per increment. The buffered deltas
+// are lost when the process dies, counters that can be recounted have a
+// consistency check repairing them, see product_likes_count.
+type CounterBuffer struct {
+	DB            *gorm.DB
+	FlushInterval time.Duration
+	OnError       func(err error)
+
+	mutex  sync.Mutex
+	deltas map[counterRow]map[string]int64
+}
+
+func NewCounterBuffer(db *gorm.DB) *CounterBuffer {
+	return &CounterBuffer{
+		DB:            db,
+		FlushInterval: time.Second,
+		deltas:        map[counterRow]map[string]int64{},
+	}
+}
+
+// Add buffers adding delta to column of the row of table with id.
+func (c *CounterBuffer) Add(table string, id string, column string, delta int64) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.add(counterRow{Table: table, ID: id}, column, delta)
+}
+
+func (c *CounterBuffer) add(row counterRow, column string, delta int64) {
+	columns := c.deltas[row]
+	if columns == nil {
+		columns = map[string]int64{}
+		c.deltas[row] = columns
+	}
+	columns[column] += delta
+	if columns[column] == 0 {
+		delete(columns, column)
+	}
+	if len(columns) == 0 {
+		delete(c.deltas, row)
+	}
+}
+
+func (c *CounterBuffer) ProductLiked(productID string, delta int64) {
+	c.Add("products", productID, "likes_count", delta)
+}
+
+func (c *CounterBuffer) ProductViewed(productID string) {
+	c.Add("products", productID, "view_count", 1)
+}
+
+// Pending returns the buffered delta of column of a row.
+func (c *CounterBuffer) Pending(table string, id string, column string) int64 {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.deltas[counterRow{Table: table, ID: id}][column]
+}
+
+// Flush applies the buffered deltas, each row in its own statement so a
+// row is locked only for its update. Deltas not applied are buffered
+// again.
+func (c *CounterBuffer) Flush(ctx context.Context) error {
+	c.mutex.Lock()
+	deltas := c.deltas
+	c.deltas = map[counterRow]map[string]int64{}
+	c.mutex.Unlock()
+
+	rows := make([]counterRow, 0, len(deltas))
+	for row := range deltas {
+		rows = append(rows, row)
+	}
+	sort.Slice(rows, func(i, j int) bool {
+		if rows[i].Table != rows[j].Table {
+			return rows[i].Table < rows[j].Table
+		}
+		return rows[i].ID < rows[j].ID
+	})
+
+	db := c.DB.WithContext(ctx)
+	for i, row := range rows {
+		columns := make([]string, 0, len(deltas[row]))
+		for column := range deltas[row] {
+			columns = append(columns, column)
+		}
+		sort.Strings(columns)
+		sets := make([]string, len(columns))
+		values := make([]interface{}, 0, len(columns)+1)
+		for j, column := range columns {
+			sets[j] = fmt.Sprintf("`%s` = `%s` + ?", column, column)
+			values = append(values, deltas[row][column])
+		}
+		values = append(values, row.ID)
+
+		err := db.Exec(fmt.Sprintf("update `%s` set %s where id = ?", row.Table, strings.Join(sets, ", ")), values...).Error
+		if err != nil {
+			c.mutex.Lock()
+			defer c.mutex.Unlock()
+			for _, failed := range rows[i:] {
+				for column, delta := range deltas[failed] {
+					c.add(failed, column, delta)
+				}
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// Run flushes the buffer until ctx is done, and a last time before
+// returning. Errors are reported to OnError and do not stop it.
+func (c *CounterBuffer) Run(ctx context.Context) error {
+	ticker := time.NewTicker(c.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := c.Flush(context.WithoutCancel(ctx))
+			if err != nil && c.OnError != nil {
+				c.OnError(err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		}
+
+		err := c.Flush(ctx)
+		if err != nil && ctx.Err() == nil && c.OnError != nil {
+			c.OnError(err)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrCouponExpired   = errors.New("coupon is expired")
+	ErrCouponExhausted = errors.New("coupon has reached its maximum uses")
+)
+
+type Coupon struct {
+	ID             int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Code           string     `gorm:"column:code;uniqueIndex"`
+	DiscountAmount int64      `gorm:"column:discount_amount"`
+	MaxUses        int64      `gorm:"column:max_uses"`
+	UsedCount      int64      `gorm:"column:used_count"`
+	ExpiresAt      *time.Time `gorm:"column:expires_at"`
+	CreatedAt      time.Time  `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt      time.Time  `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (c *Coupon) TableName() string {
+	return "coupons"
+}
+
+// couponAlphabet leaves out the letters and digits that look alike, it has
+// 32 symbols so a random byte maps to one without bias.
+const couponAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewCouponCode draws an 8 symbol code from the entropy of db.
+func NewCouponCode(db *gorm.DB) (string, error) {
+	code := make([]byte, 8)
+	_, err := io.ReadFull(Entropy(db), code)
+	if err != nil {
+		return "", err
+	}
+	for i, b := range code {
+		code[i] = couponAlphabet[int(b)%len(couponAlphabet)]
+	}
+	return string(code), nil
+}
+
+// BeforeCreate generates the code of coupons created without one, a
+// collision fails on the unique index.
+func (c *Coupon) BeforeCreate(db *gorm.DB) error {
+	if c.Code != "" {
+		return nil
+	}
+	code, err := NewCouponCode(db)
+	c.Code = code
+	return err
+}
+
+func (c *Coupon) Expired(at time.Time) bool {
+	return c.ExpiresAt != nil && !at.Before(*c.ExpiresAt)
+}
+
+type CouponRedemption struct {
+	ID             int64     `gorm:"primary_key;column:id;autoIncrement"`
+	CouponID       int64     `gorm:"column:coupon_id"`
+	UserID         string    `gorm:"column:user_id"`
+	DiscountAmount int64     `gorm:"column:discount_amount"`
+	CreatedAt      time.Time `gorm:"column:created_at;autoCreateTime"`
+	Coupon         Coupon    `gorm:"foreignKey:coupon_id;references:id"`
+	User           User      `gorm:"foreignKey:user_id;references:id"`
+}
+
+func (r *CouponRedemption) TableName() string {
+	return "coupon_redemptions"
+}
+
+// RedeemCoupon must be called with the transaction of the order that uses the
+// coupon, the usage cap is enforced by the conditional update so concurrent
+// orders can never redeem more than MaxUses times.
+func RedeemCoupon(tx *gorm.DB, code string, userID string) (CouponRedemption, error) {
+	var coupon Coupon
+	err := tx.Take(&coupon, "code = ?", code).Error
+	if err != nil {
+		return CouponRedemption{}, err
+	}
+
+	now := Now(tx)
+	if coupon.Expired(now) {
+		return CouponRedemption{}, ErrCouponExpired
+	}
+
+	result := tx.Model(&Coupon{}).
+		Where("id = ? AND used_count < max_uses", coupon.ID).
+		Where("expires_at IS NULL OR expires_at > ?", now).
+		Update("used_count", gorm.Expr("used_count + ?", 1))
+	if result.Error != nil {
+		return CouponRedemption{}, result.Error
+	}
+	if result.RowsAffected == 0 {
+		return CouponRedemption{}, ErrCouponExhausted
+	}
+
+	redemption := CouponRedemption{
+		CouponID:       coupon.ID,
+		UserID:         userID,
+		DiscountAmount: coupon.DiscountAmount,
+	}
+	err = tx.Create(&redemption).Error
+	return redemption, err
+}
+
+type CouponRedemptionReport struct {
+	Code            string
+	Redemptions     int64
+	TotalDiscount   int64
+	FirstRedeemedAt time.Time
+	LastRedeemedAt  time.Time
+}
+
+func CouponRedemptionReports(db *gorm.DB, from time.Time, to time.Time) ([]CouponRedemptionReport, error) {
+	var reports []CouponRedemptionReport
+	err := db.Model(&CouponRedemption{}).
+		Select("coupons.code as code", "count(*) as redemptions",
+			"sum(coupon_redemptions.discount_amount) as total_discount",
+			"min(coupon_redemptions.created_at) as first_redeemed_at",
+			"max(coupon_redemptions.created_at) as last_redeemed_at").
+		Joins("join coupons on coupons.id = coupon_redemptions.coupon_id").
+		Where("coupon_redemptions.created_at >= ? AND coupon_redemptions.created_at < ?", from, to).
+		Group("coupons.code").
+		Order("redemptions desc").
+		Find(&reports).Error
+	return reports, err
+}
+
+func FindCouponRedemptions(db *gorm.DB, code string) ([]CouponRedemption, error) {
+	var redemptions []CouponRedemption
+	err := db.Joins("Coupon").Where("Coupon.code = ?", code).
+		Order("coupon_redemptions.created_at asc").
+		Find(&redemptions).Error
+	return redemptions, err
+}
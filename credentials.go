@@ -0,0 +1,194 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrMissingAWSCredentials = errors.New("AWS_ACCESS_KEY_ID and AWS_SECRET_ACCESS_KEY are required")
+
+// Credentials are used to authenticate a new connection, a zero ExpiresAt
+// means they do not expire.
+type Credentials struct {
+	User      string
+	Password  string
+	ExpiresAt time.Time
+}
+
+// CredentialProvider is asked for credentials every time a connection to
+// host is established, so rotated passwords and tokens are picked up without
+// restarting the application.
+type CredentialProvider interface {
+	Credentials(ctx context.Context, host string) (Credentials, error)
+}
+
+type CredentialProviderFunc func(ctx context.Context, host string) (Credentials, error)
+
+func (f CredentialProviderFunc) Credentials(ctx context.Context, host string) (Credentials, error) {
+	return f(ctx, host)
+}
+
+func StaticCredentials(user string, password string) CredentialProvider {
+	return CredentialProviderFunc(func(ctx context.Context, host string) (Credentials, error) {
+		return Credentials{User: user, Password: password}, nil
+	})
+}
+
+// FileCredentials reads the password from a file on every connection, e.g. a
+// mounted secret that is rotated in place.
+type FileCredentials struct {
+	User         string
+	PasswordFile string
+}
+
+func (c FileCredentials) Credentials(ctx context.Context, host string) (Credentials, error) {
+	password, err := os.ReadFile(c.PasswordFile)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return Credentials{User: c.User, Password: strings.TrimSpace(string(password))}, nil
+}
+
+// RDSIAMCredentials generates RDS IAM authentication tokens, presigned with
+// AWS Signature Version 4 and valid for 15 minutes. The server only accepts
+// them over TLS.
+type RDSIAMCredentials struct {
+	User            string
+	Region          string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Now             func() time.Time
+}
+
+// NewRDSIAMCredentials takes the AWS keys from the standard environment
+// variables.
+func NewRDSIAMCredentials(user string, region string) (*RDSIAMCredentials, error) {
+	credentials := &RDSIAMCredentials{
+		User:            user,
+		Region:          region,
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Now:             time.Now,
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, ErrMissingAWSCredentials
+	}
+	return credentials, nil
+}
+
+const rdsTokenLifetime = 15 * time.Minute
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+func awsSigningKey(secretAccessKey string, date string, region string, service string) []byte {
+	key := hmacSHA256([]byte("AWS4"+secretAccessKey), date)
+	key = hmacSHA256(key, region)
+	key = hmacSHA256(key, service)
+	return hmacSHA256(key, "aws4_request")
+}
+
+func awsQueryEscape(value string) string {
+	return strings.ReplaceAll(url.QueryEscape(value), "+", "%20")
+}
+
+func (c *RDSIAMCredentials) Credentials(ctx context.Context, host string) (Credentials, error) {
+	now := c.Now().UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + c.Region + "/rds-db/aws4_request"
+
+	params := map[string]string{
+		"Action":              "connect",
+		"DBUser":              c.User,
+		"X-Amz-Algorithm":     "AWS4-HMAC-SHA256",
+		"X-Amz-Credential":    c.AccessKeyID + "/" + scope,
+		"X-Amz-Date":          amzDate,
+		"X-Amz-Expires":       "900",
+		"X-Amz-SignedHeaders": "host",
+	}
+	if c.SessionToken != "" {
+		params["X-Amz-Security-Token"] = c.SessionToken
+	}
+
+	keys := make([]string, 0, len(params))
+	for key := range params {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	query := make([]string, len(keys))
+	for i, key := range keys {
+		query[i] = awsQueryEscape(key) + "=" + awsQueryEscape(params[key])
+	}
+	canonicalQuery := strings.Join(query, "&")
+
+	emptyHash := sha256.Sum256(nil)
+	canonicalRequest := strings.Join([]string{
+		"GET", "/", canonicalQuery, "host:" + host, "", "host", hex.EncodeToString(emptyHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := awsSigningKey(c.SecretAccessKey, date, c.Region, "rds-db")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+
+	return Credentials{
+		User:      c.User,
+		Password:  host + "/?" + canonicalQuery + "&X-Amz-Signature=" + signature,
+		ExpiresAt: now.Add(rdsTokenLifetime),
+	}, nil
+}
+
+// RefreshingCredentials caches the credentials of every host and asks the
+// provider for new ones once they are within Margin of expiring.
+type RefreshingCredentials struct {
+	Provider CredentialProvider
+	Margin   time.Duration
+	Now      func() time.Time
+
+	mutex  sync.Mutex
+	cached map[string]Credentials
+}
+
+func NewRefreshingCredentials(provider CredentialProvider, margin time.Duration) *RefreshingCredentials {
+	return &RefreshingCredentials{
+		Provider: provider,
+		Margin:   margin,
+		Now:      time.Now,
+		cached:   map[string]Credentials{},
+	}
+}
+
+func (c *RefreshingCredentials) Credentials(ctx context.Context, host string) (Credentials, error) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	credentials, ok := c.cached[host]
+	if ok && (credentials.ExpiresAt.IsZero() || c.Now().Add(c.Margin).Before(credentials.ExpiresAt)) {
+		return credentials, nil
+	}
+
+	credentials, err := c.Provider.Credentials(ctx, host)
+	if err != nil {
+		return Credentials{}, err
+	}
+	c.cached[host] = credentials
+	return credentials, nil
+}
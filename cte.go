@@ -0,0 +1,58 @@
+package learn_golang_gorm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type commonTable struct {
+	name  string
+	query *gorm.DB
+}
+
+// with is the WITH clause, written before the SELECT of the query.
+type with struct {
+	recursive bool
+	tables    []commonTable
+}
+
+func (w with) Build(builder clause.Builder) {
+	builder.WriteString("WITH ")
+	if w.recursive {
+		builder.WriteString("RECURSIVE ")
+	}
+	for i, table := range w.tables {
+		if i > 0 {
+			builder.WriteString(", ")
+		}
+		builder.WriteQuoted(clause.Table{Name: table.name})
+		builder.WriteString(" AS (")
+		builder.AddVar(builder, table.query)
+		builder.WriteByte(')')
+	}
+}
+
+func withCTE(name string, query *gorm.DB, recursive bool) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		selectClause := db.Statement.Clauses[clause.Select{}.Name()]
+		w, _ := selectClause.BeforeExpression.(with)
+		w.recursive = w.recursive || recursive
+		w.tables = append(w.tables, commonTable{name: name, query: query})
+		selectClause.BeforeExpression = w
+		db.Statement.Clauses[clause.Select{}.Name()] = selectClause
+		return db
+	}
+}
+
+// WithCTE defines the common table name as query for the main query, which
+// reads it with Table(name) or a join. Several are defined in order.
+func WithCTE(name string, query *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	return withCTE(name, query, false)
+}
+
+// WithRecursiveCTE defines a common table whose query refers to itself,
+// usually a Raw anchor UNION ALL recursive step, e.g. a todo and all of its
+// subtasks.
+func WithRecursiveCTE(name string, query *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	return withCTE(name, query, true)
+}
@@ -0,0 +1,233 @@
+package datafix
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ModeReview = "review"
+	ModeApply  = "apply"
+
+	StatusRunning   = "running"
+	StatusSucceeded = "succeeded"
+	StatusFailed    = "failed"
+)
+
+var ErrInvalidFix = errors.New("invalid data fix")
+
+// Fix declares a production data correction: Select narrows Table down to
+// the affected rows and Transform returns the columns to change for a row,
+// an empty result leaves the row untouched.
+type Fix struct {
+	Name      string
+	Table     string
+	Key       string
+	ChunkSize int
+	Select    func(db *gorm.DB) *gorm.DB
+	Transform func(row map[string]interface{}) (map[string]interface{}, error)
+}
+
+type Change struct {
+	Key    interface{}            `json:"key"`
+	Before map[string]interface{} `json:"before"`
+	After  map[string]interface{} `json:"after"`
+}
+
+type Run struct {
+	ID          int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Name        string     `gorm:"column:name"`
+	Actor       string     `gorm:"column:actor"`
+	Mode        string     `gorm:"column:mode"`
+	Status      string     `gorm:"column:status"`
+	RowsMatched int64      `gorm:"column:rows_matched"`
+	RowsChanged int64      `gorm:"column:rows_changed"`
+	Error       string     `gorm:"column:error"`
+	StartedAt   time.Time  `gorm:"column:started_at"`
+	FinishedAt  *time.Time `gorm:"column:finished_at"`
+}
+
+func (r *Run) TableName() string {
+	return "data_fix_runs"
+}
+
+func (f *Fix) validate() error {
+	if f.Name == "" || f.Table == "" || f.Select == nil || f.Transform == nil {
+		return fmt.Errorf("%w: name, table, select and transform are required", ErrInvalidFix)
+	}
+	if f.Key == "" {
+		f.Key = "id"
+	}
+	if f.ChunkSize <= 0 {
+		f.ChunkSize = 500
+	}
+	return nil
+}
+
+// chunks walks the affected rows in key order, one chunk per transaction,
+// locking the rows of the chunk when lock is set.
+func (f *Fix) chunks(ctx context.Context, db *gorm.DB, lock bool, fn func(tx *gorm.DB, rows []map[string]interface{}) error) error {
+	var last interface{}
+	for {
+		done := false
+		err := db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+			query := tx.Table(f.Table).Scopes(f.Select)
+			if last != nil {
+				query = query.Where(clause.Gt{Column: clause.Column{Name: f.Key}, Value: last})
+			}
+			if lock {
+				query = query.Clauses(clause.Locking{Strength: "UPDATE"})
+			}
+
+			var rows []map[string]interface{}
+			err := query.Order(clause.OrderByColumn{Column: clause.Column{Name: f.Key}}).
+				Limit(f.ChunkSize).Find(&rows).Error
+			if err != nil {
+				return err
+			}
+			if len(rows) == 0 {
+				done = true
+				return nil
+			}
+
+			last = rows[len(rows)-1][f.Key]
+			done = len(rows) < f.ChunkSize
+			return fn(tx, rows)
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+func (f *Fix) change(row map[string]interface{}) (Change, bool, error) {
+	updates, err := f.Transform(row)
+	if err != nil {
+		return Change{}, false, err
+	}
+
+	change := Change{Key: row[f.Key], Before: map[string]interface{}{}, After: map[string]interface{}{}}
+	for column, value := range updates {
+		if fmt.Sprint(row[column]) == fmt.Sprint(value) {
+			continue
+		}
+		change.Before[column] = row[column]
+		change.After[column] = value
+	}
+	return change, len(change.After) > 0, nil
+}
+
+func startRun(db *gorm.DB, fix *Fix, mode string, actor string) (*Run, error) {
+	run := &Run{Name: fix.Name, Actor: actor, Mode: mode, Status: StatusRunning, StartedAt: db.NowFunc()}
+	return run, db.Create(run).Error
+}
+
+func finishRun(db *gorm.DB, run *Run, err error) error {
+	now := db.NowFunc()
+	run.FinishedAt = &now
+	run.Status = StatusSucceeded
+	if err != nil {
+		run.Status = StatusFailed
+		run.Error = err.Error()
+	}
+
+	saveErr := db.Save(run).Error
+	if err != nil {
+		return err
+	}
+	return saveErr
+}
+
+// Review writes the before/after diff of every row the fix would change as
+// JSON lines to w without modifying anything.
+func Review(ctx context.Context, db *gorm.DB, fix Fix, actor string, w io.Writer) (*Run, error) {
+	err := fix.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := startRun(db.WithContext(ctx), &fix, ModeReview, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	encoder := json.NewEncoder(w)
+	err = fix.chunks(ctx, db, false, func(tx *gorm.DB, rows []map[string]interface{}) error {
+		for _, row := range rows {
+			run.RowsMatched++
+			change, changed, err := fix.change(row)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+
+			run.RowsChanged++
+			err = encoder.Encode(change)
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return run, finishRun(db.WithContext(ctx), run, err)
+}
+
+func ReviewFile(ctx context.Context, db *gorm.DB, fix Fix, actor string, path string) (*Run, error) {
+	file, err := os.Create(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	return Review(ctx, db, fix, actor, file)
+}
+
+// Apply executes the fix chunk by chunk, each chunk in its own transaction
+// with its rows locked, and records the outcome in data_fix_runs. A failed
+// chunk is rolled back, the chunks before it stay applied.
+func Apply(ctx context.Context, db *gorm.DB, fix Fix, actor string) (*Run, error) {
+	err := fix.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	run, err := startRun(db.WithContext(ctx), &fix, ModeApply, actor)
+	if err != nil {
+		return nil, err
+	}
+
+	err = fix.chunks(ctx, db, true, func(tx *gorm.DB, rows []map[string]interface{}) error {
+		var changedRows int64
+		for _, row := range rows {
+			change, changed, err := fix.change(row)
+			if err != nil {
+				return err
+			}
+			if !changed {
+				continue
+			}
+
+			err = tx.Table(fix.Table).Where(clause.Eq{Column: clause.Column{Name: fix.Key}, Value: change.Key}).
+				UpdateColumns(change.After).Error
+			if err != nil {
+				return err
+			}
+			changedRows++
+		}
+
+		run.RowsMatched += int64(len(rows))
+		run.RowsChanged += changedRows
+		return nil
+	})
+	return run, finishRun(db.WithContext(ctx), run, err)
+}
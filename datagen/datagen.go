@@ -0,0 +1,303 @@
+package datagen
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	learn_golang_gorm "learn-golang-gorm"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	firstNames  = []string{"Lingga", "Wahyu", "Budi", "Siti", "Agus", "Dewi", "Rina", "Andi", "Putri", "Eko", "Sri", "Joko", "Ayu", "Rizky", "Fajar", "Indah"}
+	lastNames   = []string{"Pratama", "Saputra", "Wijaya", "Lestari", "Santoso", "Kusuma", "Hidayat", "Nugroho", "Permata", "Setiawan", "Utami", "Wibowo"}
+	streets     = []string{"Jalan Merdeka", "Jalan Sudirman", "Jalan Diponegoro", "Jalan Gatot Subroto", "Jalan Ahmad Yani", "Jalan Pahlawan"}
+	cities      = []string{"Jakarta", "Bandung", "Surabaya", "Yogyakarta", "Semarang", "Medan", "Makassar", "Denpasar"}
+	productKind = []string{"Laptop", "Keyboard", "Mouse", "Monitor", "Headset", "Webcam", "Speaker", "Charger"}
+	brands      = []string{"Nusantara", "Garuda", "Merapi", "Bromo", "Komodo", "Rinjani"}
+	todoVerbs   = []string{"Buy", "Call", "Write", "Review", "Fix", "Plan", "Prepare", "Send"}
+	todoObjects = []string{"groceries", "report", "invoice", "meeting notes", "presentation", "budget", "proposal", "birthday gift"}
+	actions     = []string{"Login", "Logout", "View Product", "Add To Cart", "Checkout", "Update Profile"}
+)
+
+// Generator produces realistic looking rows from a seeded source, the same
+// seed always yields the same rows. Timestamps fall within [From, To) and
+// ids start with Prefix so generated rows never collide with real ones.
+type Generator struct {
+	Prefix string
+	From   time.Time
+	To     time.Time
+
+	rand     *rand.Rand
+	users    int
+	products int
+	wallets  int
+}
+
+func New(seed int64) *Generator {
+	to := time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)
+	return &Generator{
+		Prefix: "gen",
+		From:   to.AddDate(-2, 0, 0),
+		To:     to,
+		rand:   rand.New(rand.NewSource(seed)),
+	}
+}
+
+func (g *Generator) pick(values []string) string {
+	return values[g.rand.Intn(len(values))]
+}
+
+// Time returns a timestamp within [From, To).
+func (g *Generator) Time() time.Time {
+	span := g.To.Sub(g.From)
+	return g.From.Add(time.Duration(g.rand.Int63n(int64(span))))
+}
+
+func (g *Generator) after(t time.Time) time.Time {
+	if !t.Before(g.To) {
+		return t
+	}
+	return t.Add(time.Duration(g.rand.Int63n(int64(g.To.Sub(t)))))
+}
+
+func (g *Generator) User() learn_golang_gorm.User {
+	g.users++
+	first, last := g.pick(firstNames), g.pick(lastNames)
+	createdAt := g.Time()
+
+	return learn_golang_gorm.User{
+		ID:        fmt.Sprintf("%s-user-%d", g.Prefix, g.users),
+		Password:  "rahasia",
+		Name:      learn_golang_gorm.Name{FirstName: first, LastName: last},
+		Email:     learn_golang_gorm.Email(fmt.Sprintf("%s.%s%d@example.com", strings.ToLower(first), strings.ToLower(last), g.users)),
+		Phone:     learn_golang_gorm.PhoneNumber(fmt.Sprintf("+628%010d", g.rand.Int63n(10000000000))),
+		CreatedAt: createdAt,
+		UpdatedAt: g.after(createdAt),
+	}
+}
+
+// Wallet returns the wallet of user with a balance of up to 10 million.
+func (g *Generator) Wallet(user learn_golang_gorm.User) learn_golang_gorm.Wallet {
+	g.wallets++
+	return learn_golang_gorm.Wallet{
+		ID:        fmt.Sprintf("%s-wallet-%d", g.Prefix, g.wallets),
+		UserID:    user.ID,
+		Balance:   g.rand.Int63n(10000) * 1000,
+		CreatedAt: user.CreatedAt,
+		UpdatedAt: g.after(user.CreatedAt),
+	}
+}
+
+func (g *Generator) Address(user learn_golang_gorm.User) learn_golang_gorm.Address {
+	createdAt := g.after(user.CreatedAt)
+	return learn_golang_gorm.Address{
+		UserId:    user.ID,
+		Address:   fmt.Sprintf("%s No. %d, %s", g.pick(streets), g.rand.Intn(200)+1, g.pick(cities)),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+}
+
+func (g *Generator) Product() learn_golang_gorm.Product {
+	g.products++
+	createdAt := g.Time()
+	return learn_golang_gorm.Product{
+		ID:        fmt.Sprintf("%s-product-%d", g.Prefix, g.products),
+		Name:      g.pick(brands) + " " + g.pick(productKind),
+		Price:     (g.rand.Int63n(500) + 1) * 10000,
+		Stock:     g.rand.Int63n(1000),
+		CreatedAt: createdAt,
+		UpdatedAt: g.after(createdAt),
+	}
+}
+
+// Todo returns a todo of user, about half of them are completed.
+func (g *Generator) Todo(user learn_golang_gorm.User) learn_golang_gorm.Todo {
+	todo := learn_golang_gorm.Todo{
+		UserId:      user.ID,
+		Title:       g.pick(todoVerbs) + " " + g.pick(todoObjects),
+		Description: "Generated todo",
+	}
+	todo.CreatedAt = g.after(user.CreatedAt)
+	todo.UpdatedAt = todo.CreatedAt
+	if g.rand.Intn(2) == 0 {
+		completedAt := g.after(todo.CreatedAt)
+		todo.CompletedAt = &completedAt
+		todo.UpdatedAt = completedAt
+	}
+	return todo
+}
+
+func (g *Generator) UserLog(user learn_golang_gorm.User) learn_golang_gorm.UserLog {
+	createdAt := g.after(user.CreatedAt).UnixMilli()
+	return learn_golang_gorm.UserLog{
+		UserID:    user.ID,
+		Action:    g.pick(actions),
+		CreatedAt: createdAt,
+		UpdatedAt: createdAt,
+	}
+}
+
+// Volume sets how many rows Populate inserts, the per user counts are upper
+// bounds and vary from user to user.
+type Volume struct {
+	Users            int
+	Products         int
+	AddressesPerUser int
+	TodosPerUser     int
+	LikesPerUser     int
+	UserLogs         int
+	BatchSize        int
+}
+
+type userLikeProduct struct {
+	UserID    string `gorm:"column:user_id"`
+	ProductID string `gorm:"column:product_id"`
+}
+
+// Populate inserts the generated rows in batches, without keeping more than
+// one batch in memory, so volumes like millions of user logs are fine.
+func Populate(ctx context.Context, db *gorm.DB, g *Generator, volume Volume) error {
+	db = db.WithContext(ctx).Omit(clause.Associations).Session(&gorm.Session{})
+	if volume.BatchSize <= 0 {
+		volume.BatchSize = 1000
+	}
+
+	products := make([]learn_golang_gorm.Product, 0, volume.Products)
+	for i := 0; i < volume.Products; i++ {
+		products = append(products, g.Product())
+	}
+	if len(products) > 0 {
+		err := db.CreateInBatches(&products, volume.BatchSize).Error
+		if err != nil {
+			return err
+		}
+	}
+
+	firstUser := g.users + 1
+	users := make([]learn_golang_gorm.User, 0, volume.BatchSize)
+	for created := 0; created < volume.Users; {
+		users = users[:0]
+		for len(users) < volume.BatchSize && created < volume.Users {
+			users = append(users, g.User())
+			created++
+		}
+
+		err := populateUsers(db, g, users, products, volume)
+		if err != nil {
+			return err
+		}
+
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+	}
+
+	if volume.Users == 0 {
+		return nil
+	}
+
+	var logs []learn_golang_gorm.UserLog
+	for created := 0; created < volume.UserLogs; {
+		logs = logs[:0]
+		for len(logs) < volume.BatchSize && created < volume.UserLogs {
+			logs = append(logs, g.UserLog(learn_golang_gorm.User{
+				ID:        fmt.Sprintf("%s-user-%d", g.Prefix, firstUser+g.rand.Intn(volume.Users)),
+				CreatedAt: g.From,
+			}))
+			created++
+		}
+
+		err := db.Create(&logs).Error
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func populateUsers(db *gorm.DB, g *Generator, users []learn_golang_gorm.User, products []learn_golang_gorm.Product, volume Volume) error {
+	var wallets []learn_golang_gorm.Wallet
+	var addresses []learn_golang_gorm.Address
+	var todos []learn_golang_gorm.Todo
+	var likes []userLikeProduct
+	for _, user := range users {
+		wallets = append(wallets, g.Wallet(user))
+		for i := g.rand.Intn(volume.AddressesPerUser + 1); i > 0; i-- {
+			addresses = append(addresses, g.Address(user))
+		}
+		for i := g.rand.Intn(volume.TodosPerUser + 1); i > 0; i-- {
+			todos = append(todos, g.Todo(user))
+		}
+
+		liked := map[string]bool{}
+		for i := g.rand.Intn(volume.LikesPerUser + 1); i > 0 && len(products) > 0; i-- {
+			product := products[g.rand.Intn(len(products))]
+			if !liked[product.ID] {
+				liked[product.ID] = true
+				likes = append(likes, userLikeProduct{UserID: user.ID, ProductID: product.ID})
+			}
+		}
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&users).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Create(&wallets).Error
+		if err != nil {
+			return err
+		}
+		if len(addresses) > 0 {
+			err = tx.CreateInBatches(&addresses, volume.BatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(todos) > 0 {
+			err = tx.CreateInBatches(&todos, volume.BatchSize).Error
+			if err != nil {
+				return err
+			}
+		}
+		if len(likes) > 0 {
+			return tx.Table("user_like_product").CreateInBatches(&likes, volume.BatchSize).Error
+		}
+		return nil
+	})
+}
+
+// LoadTestVolume is what the load-test seed set inserts.
+var LoadTestVolume = Volume{
+	Users:            10000,
+	Products:         1000,
+	AddressesPerUser: 3,
+	TodosPerUser:     10,
+	LikesPerUser:     5,
+	UserLogs:         100000,
+	BatchSize:        1000,
+}
+
+func init() {
+	learn_golang_gorm.RegisterSeeder(learn_golang_gorm.Seeder{
+		Name: "load-test",
+		Sets: []string{learn_golang_gorm.SeedSetLoadTest},
+		Run: func(db *gorm.DB) error {
+			generator := New(1)
+			generator.Prefix = "load"
+			var count int64
+			err := db.Model(&learn_golang_gorm.User{}).Where("id = ?", generator.Prefix+"-user-1").Count(&count).Error
+			if err != nil || count > 0 {
+				return err
+			}
+			return Populate(db.Statement.Context, db, generator, LoadTestVolume)
+		},
+	})
+}
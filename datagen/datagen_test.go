@@ -0,0 +1,22 @@
+package datagen
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGeneratorIsDeterministic(t *testing.T) {
+	first, second := New(42), New(42)
+	for i := 0; i < 100; i++ {
+		user := first.User()
+		assert.Equal(t, user, second.User())
+		assert.Equal(t, first.Todo(user), second.Todo(user))
+		assert.Equal(t, first.Product(), second.Product())
+	}
+
+	user := New(7).User()
+	assert.False(t, user.CreatedAt.Before(first.From))
+	assert.True(t, user.CreatedAt.Before(first.To))
+	assert.NotEqual(t, user, New(8).User())
+}
@@ -0,0 +1,92 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type HistogramBucket struct {
+	From  float64 `json:"from"`
+	To    float64 `json:"to"`
+	Count int64   `json:"count"`
+}
+
+// Distribution summarizes a numeric column, percentiles use the nearest rank
+// method so they are always values present in the data.
+type Distribution struct {
+	Count     int64             `json:"count"`
+	Min       float64           `json:"min"`
+	Max       float64           `json:"max"`
+	Mean      float64           `json:"mean"`
+	P50       float64           `json:"p50"`
+	P90       float64           `json:"p90"`
+	P99       float64           `json:"p99"`
+	Histogram []HistogramBucket `json:"histogram"`
+}
+
+// distribution computes the summary of the value column selected by source
+// and a histogram of equal width buckets between min and max.
+func distribution(ctx context.Context, db *gorm.DB, source func(db *gorm.DB) *gorm.DB, buckets int) (Distribution, error) {
+	db = db.WithContext(ctx)
+	var summary struct {
+		Count                         int64
+		Min, Max, Mean, P50, P90, P99 float64
+	}
+	values := source(db.Session(&gorm.Session{NewDB: true}))
+	ranked := db.Session(&gorm.Session{NewDB: true}).Table("(?) as s", values).
+		Select("s.value, row_number() over (order by s.value) as rn, count(*) over () as n")
+
+	err := db.Table("(?) as t", ranked).Select("count(*) as count, " +
+		"coalesce(min(t.value), 0) as min, coalesce(max(t.value), 0) as max, coalesce(avg(t.value), 0) as mean, " +
+		"coalesce(max(case when t.rn = greatest(ceil(0.50 * t.n), 1) then t.value end), 0) as p50, " +
+		"coalesce(max(case when t.rn = greatest(ceil(0.90 * t.n), 1) then t.value end), 0) as p90, " +
+		"coalesce(max(case when t.rn = greatest(ceil(0.99 * t.n), 1) then t.value end), 0) as p99").
+		Scan(&summary).Error
+	result := Distribution{Count: summary.Count, Min: summary.Min, Max: summary.Max, Mean: summary.Mean,
+		P50: summary.P50, P90: summary.P90, P99: summary.P99}
+	if err != nil || result.Count == 0 || buckets <= 0 {
+		return result, err
+	}
+
+	width := (result.Max - result.Min) / float64(buckets)
+	if width == 0 {
+		buckets, width = 1, 1
+	}
+	var counts []struct {
+		Bucket int
+		Count  int64
+	}
+	err = db.Table("(?) as s", source(db.Session(&gorm.Session{NewDB: true}))).
+		Select("least(floor((s.value - ?) / ?), ?) as bucket, count(*) as count", result.Min, width, buckets-1).
+		Group("bucket").Scan(&counts).Error
+	if err != nil {
+		return result, err
+	}
+
+	result.Histogram = make([]HistogramBucket, buckets)
+	for i := range result.Histogram {
+		result.Histogram[i].From = result.Min + float64(i)*width
+		result.Histogram[i].To = result.Min + float64(i+1)*width
+	}
+	for _, count := range counts {
+		result.Histogram[count.Bucket].Count = count.Count
+	}
+	return result, nil
+}
+
+func WalletBalanceDistribution(ctx context.Context, db *gorm.DB, buckets int) (Distribution, error) {
+	return distribution(ctx, db, func(db *gorm.DB) *gorm.DB {
+		return db.Table("wallets").Select("balance as value")
+	}, buckets)
+}
+
+// TodoCompletionDistribution is the time in seconds from creating a todo to
+// completing it, over the todos completed since the given time.
+func TodoCompletionDistribution(ctx context.Context, db *gorm.DB, since time.Time, buckets int) (Distribution, error) {
+	return distribution(ctx, db, func(db *gorm.DB) *gorm.DB {
+		return db.Table("todos").Select("timestampdiff(second, created_at, completed_at) as value").
+			Where("completed_at IS NOT NULL AND completed_at >= ? AND deleted_at IS NULL", since)
+	}, buckets)
+}
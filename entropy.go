@@ -0,0 +1,45 @@
+package learn_golang_gorm
+
+import (
+	"crypto/rand"
+	"io"
+	mathRand "math/rand"
+
+	"gorm.io/gorm"
+)
+
+// entropy is a gorm plugin carrying the source of randomness of a db, so
+// every session of it finds the same one.
+type entropy struct {
+	source io.Reader
+}
+
+func (e *entropy) Name() string {
+	return "entropy"
+}
+
+func (e *entropy) Initialize(db *gorm.DB) error {
+	return nil
+}
+
+// UseEntropy makes db and every session of it draw the tokens, ids and
+// coupon codes from source instead of crypto/rand.
+func UseEntropy(db *gorm.DB, source io.Reader) {
+	db.Config.Plugins["entropy"] = &entropy{source: source}
+}
+
+// Entropy is the source of randomness of db.
+func Entropy(db *gorm.DB) io.Reader {
+	if db != nil && db.Config != nil {
+		if plugin, ok := db.Config.Plugins["entropy"].(*entropy); ok {
+			return plugin.source
+		}
+	}
+	return rand.Reader
+}
+
+// SeededEntropy is a deterministic source for tests, the same seed always
+// yields the same tokens. It is not safe for concurrent use.
+func SeededEntropy(seed int64) io.Reader {
+	return mathRand.New(mathRand.NewSource(seed))
+}
@@ -0,0 +1,40 @@
+package learn_golang_gorm
+
+import "gorm.io/gorm"
+
+// WhereExists keeps the rows for which sub, correlated to the outer table,
+// finds a row. Unlike a join it never repeats the outer row.
+func WhereExists(sub *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("exists (?)", sub)
+	}
+}
+
+func WhereNotExists(sub *gorm.DB) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Where("not exists (?)", sub)
+	}
+}
+
+// correlated starts a subquery on table for the scopes below.
+func correlated(db *gorm.DB, table string, condition string) *gorm.DB {
+	return db.Session(&gorm.Session{NewDB: true}).Table(table).Select("1").Where(condition)
+}
+
+func UsersWithWallet() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(WhereExists(correlated(db, "wallets", "wallets.user_id = users.id")))
+	}
+}
+
+func UsersWithoutAddresses() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(WhereNotExists(correlated(db, "addresses", "addresses.user_id = users.id")))
+	}
+}
+
+func ProductsNeverLiked() func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Scopes(WhereNotExists(correlated(db, "user_like_product", "user_like_product.product_id = products.id")))
+	}
+}
@@ -0,0 +1,431 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ExportQueued    = "queued"
+	ExportRunning   = "running"
+	ExportCompleted = "completed"
+	ExportFailed    = "failed"
+	ExportExpired   = "expired"
+)
+
+// The scopes of the API keys reading and requesting exports.
+const (
+	ScopeExportsRead  = "exports:read"
+	ScopeExportsWrite = "exports:write"
+)
+
+var (
+	ErrUnknownExport  = fmt.Errorf("%w: unknown export", ErrInvalidRequest)
+	ErrExportNotReady = errors.New("export is not completed")
+	ErrExportExpired  = errors.New("export has expired")
+)
+
+// exportTables are the tables that can be exported, keyed by the name
+// clients request.
+var exportTables = map[string]string{
+	"user_logs": "user_logs",
+}
+
+func RegisterExport(name string, table string) {
+	exportTables[name] = table
+}
+
+type ExportJob struct {
+	ID           int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Export       string     `gorm:"column:export"`
+	RequestedBy  string     `gorm:"column:requested_by;index"`
+	Status       string     `gorm:"column:status;index"`
+	TotalRows    int64      `gorm:"column:total_rows"`
+	ExportedRows int64      `gorm:"column:exported_rows"`
+	BlobKey      string     `gorm:"column:blob_key"`
+	Error        string     `gorm:"column:error"`
+	CreatedAt    time.Time  `gorm:"column:created_at;autoCreateTime"`
+	StartedAt    *time.Time `gorm:"column:started_at"`
+	FinishedAt   *time.Time `gorm:"column:finished_at"`
+	ExpiresAt    *time.Time `gorm:"column:expires_at;index"`
+}
+
+func (j *ExportJob) TableName() string {
+	return "export_jobs"
+}
+
+// Progress is the exported share of the rows, between 0 and 1.
+func (j *ExportJob) Progress() float64 {
+	if j.Status == ExportCompleted {
+		return 1
+	}
+	if j.TotalRows == 0 {
+		return 0
+	}
+	return float64(j.ExportedRows) / float64(j.TotalRows)
+}
+
+func RequestExport(ctx context.Context, db *gorm.DB, export string, requestedBy string) (ExportJob, error) {
+	job := ExportJob{Export: export, RequestedBy: requestedBy, Status: ExportQueued}
+	err := RequireScope(ctx, ScopeExportsWrite)
+	if err != nil {
+		return job, err
+	}
+	if _, ok := exportTables[export]; !ok {
+		return job, ErrUnknownExport
+	}
+	return job, db.WithContext(ctx).Create(&job).Error
+}
+
+// OpenExport returns the CSV file of a completed job.
+func OpenExport(ctx context.Context, db *gorm.DB, store BlobStore, id int64) (io.ReadCloser, error) {
+	err := RequireScope(ctx, ScopeExportsRead)
+	if err != nil {
+		return nil, err
+	}
+	var job ExportJob
+	err = db.WithContext(ctx).Take(&job, id).Error
+	if err != nil {
+		return nil, err
+	}
+	switch job.Status {
+	case ExportCompleted:
+		return store.Get(ctx, job.BlobKey)
+	case ExportExpired:
+		return nil, ErrExportExpired
+	}
+	return nil, ErrExportNotReady
+}
+
+// ExportWorker produces the files of queued export jobs, several workers
+// can share the queue. Files are kept in Store for Retention. An export
+// runs at most Timeout, a job still running after that was left behind by
+// a crashed worker and is claimed again.
+type ExportWorker struct {
+	DB        *gorm.DB
+	Store     BlobStore
+	BatchSize int
+	Retention time.Duration
+	Timeout   time.Duration
+	OnError   func(err error)
+}
+
+func NewExportWorker(db *gorm.DB, store BlobStore) *ExportWorker {
+	return &ExportWorker{DB: db, Store: store, BatchSize: 5000, Retention: 24 * time.Hour, Timeout: time.Hour}
+}
+
+// claim marks the oldest queued or stale running job running, SKIP LOCKED
+// keeps other workers from claiming it too.
+func (w *ExportWorker) claim(ctx context.Context) (*ExportJob, error) {
+	var jobs []ExportJob
+	err := w.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := Now(tx)
+		query := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).Where("status = ?", ExportQueued)
+		if w.Timeout > 0 {
+			query = query.Or("status = ? AND started_at < ?", ExportRunning, now.Add(-w.Timeout))
+		}
+		err := query.Order("id").Limit(1).Find(&jobs).Error
+		if err != nil || len(jobs) == 0 {
+			return err
+		}
+		jobs[0].Status, jobs[0].StartedAt, jobs[0].ExportedRows = ExportRunning, &now, 0
+		return tx.Model(&jobs[0]).Select("status", "started_at", "exported_rows").Updates(&jobs[0]).Error
+	})
+	if err != nil || len(jobs) == 0 {
+		return nil, err
+	}
+	return &jobs[0], nil
+}
+
+// export streams the table as CSV into the store in id order, the job
+// progress is updated after every batch.
+func (w *ExportWorker) export(ctx context.Context, job *ExportJob) error {
+	db := w.DB.WithContext(ctx)
+	table := exportTables[job.Export]
+	if table == "" {
+		return ErrUnknownExport
+	}
+	err := db.Table(table).Count(&job.TotalRows).Error
+	if err != nil {
+		return err
+	}
+	err = db.Model(job).Update("total_rows", job.TotalRows).Error
+	if err != nil {
+		return err
+	}
+
+	// the writer updates job, it is done before the caller reads job again
+	reader, writer := io.Pipe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		writer.CloseWithError(w.writeCSV(db, table, job, writer))
+	}()
+	job.BlobKey = fmt.Sprintf("exports/%d/%s.csv", job.ID, job.Export)
+	err = w.Store.Put(ctx, job.BlobKey, reader)
+	_ = reader.CloseWithError(err)
+	<-done
+	return err
+}
+
+func (w *ExportWorker) writeCSV(db *gorm.DB, table string, job *ExportJob, writer io.Writer) error {
+	inet, err := inetColumns(db, table)
+	if err != nil {
+		return err
+	}
+
+	csvWriter := csv.NewWriter(writer)
+	record := []string(nil)
+	var lastID int64
+	for {
+		rows, err := db.Table(table).Where("id > ?", lastID).Order("id").Limit(w.BatchSize).Rows()
+		if err != nil {
+			return err
+		}
+		scanned, last, err := w.writeBatch(rows, csvWriter, &record, &lastID, inet)
+		rows.Close()
+		if err != nil {
+			return err
+		}
+		if last {
+			csvWriter.Flush()
+			return csvWriter.Error()
+		}
+
+		job.ExportedRows += int64(scanned)
+		err = db.Model(job).Update("exported_rows", job.ExportedRows).Error
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// inetColumns returns the VARBINARY(16) columns of table, which hold INET
+// addresses.
+func inetColumns(db *gorm.DB, table string) (map[string]bool, error) {
+	columnTypes, err := db.Migrator().ColumnTypes(table)
+	if err != nil {
+		return nil, err
+	}
+	inet := map[string]bool{}
+	for _, columnType := range columnTypes {
+		length, ok := columnType.Length()
+		if ok && length == 16 && strings.EqualFold(columnType.DatabaseTypeName(), "varbinary") {
+			inet[columnType.Name()] = true
+		}
+	}
+	return inet, nil
+}
+
+// writeBatch writes the rows to csvWriter through reused buffers, the
+// header first when record is still nil, and moves lastID past them. The
+// inet columns are written as text addresses, like inet6_ntoa does. A
+// table without id is written in one batch.
+func (w *ExportWorker) writeBatch(rows *sql.Rows, csvWriter *csv.Writer, record *[]string, lastID *int64, inet map[string]bool) (int, bool, error) {
+	scanner, err := NewRowScanner(rows)
+	if err != nil {
+		return 0, false, err
+	}
+	defer scanner.Release()
+
+	if *record == nil {
+		err = csvWriter.Write(scanner.Columns)
+		if err != nil {
+			return 0, false, err
+		}
+		*record = make([]string, len(scanner.Columns))
+	}
+	idColumn := -1
+	for i, column := range scanner.Columns {
+		if column == "id" {
+			idColumn = i
+		}
+	}
+
+	scanned := 0
+	for rows.Next() {
+		values, err := scanner.Scan(rows)
+		if err != nil {
+			return scanned, false, err
+		}
+		for i, value := range values {
+			var addr INET
+			if inet[scanner.Columns[i]] && addr.Scan([]byte(value)) == nil {
+				(*record)[i] = addr.String()
+				continue
+			}
+			(*record)[i] = string(value)
+		}
+		err = csvWriter.Write(*record)
+		if err != nil {
+			return scanned, false, err
+		}
+		scanned++
+		if idColumn >= 0 {
+			*lastID, err = strconv.ParseInt(string(values[idColumn]), 10, 64)
+			if err != nil {
+				return scanned, false, err
+			}
+		}
+	}
+	return scanned, scanned == 0 || idColumn < 0, rows.Err()
+}
+
+// RunOnce exports the oldest queued job, it reports whether there was one.
+func (w *ExportWorker) RunOnce(ctx context.Context) (bool, error) {
+	job, err := w.claim(ctx)
+	if err != nil || job == nil {
+		return false, err
+	}
+
+	exportCtx := ctx
+	if w.Timeout > 0 {
+		var cancel context.CancelFunc
+		exportCtx, cancel = context.WithTimeout(ctx, w.Timeout)
+		defer cancel()
+	}
+	exportErr := w.export(exportCtx, job)
+	now := Now(w.DB)
+	updates := map[string]interface{}{"finished_at": now}
+	if exportErr != nil {
+		updates["status"], updates["error"] = ExportFailed, exportErr.Error()
+	} else {
+		updates["status"], updates["blob_key"], updates["expires_at"] = ExportCompleted, job.BlobKey, now.Add(w.Retention)
+	}
+	err = w.DB.WithContext(context.WithoutCancel(ctx)).Model(job).Updates(updates).Error
+	if err == nil {
+		err = exportErr
+	}
+	return true, err
+}
+
+// Expire removes the files of completed jobs past their expiry, when the
+// store can delete.
+func (w *ExportWorker) Expire(ctx context.Context) (int, error) {
+	var jobs []ExportJob
+	err := w.DB.WithContext(ctx).Where("status = ? AND expires_at <= ?", ExportCompleted, Now(w.DB)).Find(&jobs).Error
+	if err != nil {
+		return 0, err
+	}
+
+	deleter, _ := w.Store.(interface {
+		Delete(ctx context.Context, key string) error
+	})
+	for i, job := range jobs {
+		if deleter != nil {
+			err = deleter.Delete(ctx, job.BlobKey)
+			if err != nil {
+				return i, err
+			}
+		}
+		err = w.DB.WithContext(ctx).Model(&job).Update("status", ExportExpired).Error
+		if err != nil {
+			return i, err
+		}
+	}
+	return len(jobs), nil
+}
+
+// Run works through the queue until ctx is done, waiting interval when it
+// is empty. Errors are reported to OnError and do not stop the worker.
+func (w *ExportWorker) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		worked, err := w.RunOnce(ctx)
+		if err == nil {
+			_, err = w.Expire(ctx)
+		}
+		if err != nil && ctx.Err() == nil && w.OnError != nil {
+			w.OnError(err)
+		}
+		if worked {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// ExportHandler serves the export jobs: POST /?export=user_logs queues one,
+// GET /{id} answers its status and GET /{id}/file its file. Mount it behind
+// APIKeyService.Middleware, jobs are requested by the owner of the key and
+// need ScopeExportsWrite, reading them ScopeExportsRead.
+type ExportHandler struct {
+	DB    *gorm.DB
+	Store BlobStore
+}
+
+func NewExportHandler(db *gorm.DB, store BlobStore) *ExportHandler {
+	return &ExportHandler{DB: db, Store: store}
+}
+
+func (h *ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	key, _ := APIKeyFromContext(r.Context())
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" && r.Method == http.MethodPost {
+		job, err := RequestExport(r.Context(), h.DB, r.URL.Query().Get("export"), key.Owner)
+		if err != nil {
+			WriteError(w, err)
+			return
+		}
+		WriteJSON(w, http.StatusAccepted, job)
+		return
+	}
+
+	idText, file := strings.CutSuffix(path, "/file")
+	id, err := strconv.ParseInt(idText, 10, 64)
+	if err != nil || r.Method != http.MethodGet {
+		WriteError(w, gorm.ErrRecordNotFound)
+		return
+	}
+	err = RequireScope(r.Context(), ScopeExportsRead)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	var job ExportJob
+	err = h.DB.WithContext(r.Context()).Take(&job, "id = ? AND requested_by = ?", id, key.Owner).Error
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	if !file {
+		WriteJSON(w, http.StatusOK, map[string]interface{}{"job": job, "progress": job.Progress()})
+		return
+	}
+
+	reader, err := OpenExport(r.Context(), h.DB, h.Store, job.ID)
+	if errors.Is(err, ErrExportNotReady) {
+		WriteJSON(w, http.StatusConflict, map[string]string{"error": err.Error()})
+		return
+	}
+	if errors.Is(err, ErrExportExpired) {
+		WriteJSON(w, http.StatusGone, map[string]string{"error": err.Error()})
+		return
+	}
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	defer reader.Close()
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", job.Export+".csv"))
+	_, _ = io.Copy(w, reader)
+}
@@ -0,0 +1,117 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FacetCategory = "category"
+	FacetPrice    = "price"
+	FacetTag      = "tag"
+	facetTotal    = "total"
+)
+
+// DefaultPriceBounds split the prices into the buckets 0-10000,
+// 10000-50000, ... and 1000000+, the upper bound excluded.
+var DefaultPriceBounds = []int64{0, 10000, 50000, 100000, 500000, 1000000}
+
+type FacetCount struct {
+	Value string
+	Count int64
+}
+
+// ProductListing is a page of the filtered products with the counts of the
+// filtered products per category, price bucket and tag.
+type ProductListing struct {
+	Products   []Product
+	Total      int64
+	Categories []FacetCount
+	Prices     []FacetCount
+	Tags       []FacetCount
+}
+
+func priceBucket(bounds []int64) (string, []string) {
+	labels := make([]string, len(bounds))
+	var cases strings.Builder
+	cases.WriteString("case")
+	for i, bound := range bounds {
+		if i+1 < len(bounds) {
+			labels[i] = fmt.Sprintf("%d-%d", bound, bounds[i+1])
+			cases.WriteString(fmt.Sprintf(" when price < %d then '%s'", bounds[i+1], labels[i]))
+		} else {
+			labels[i] = fmt.Sprintf("%d+", bound)
+			cases.WriteString(fmt.Sprintf(" else '%s' end", labels[i]))
+		}
+	}
+	return cases.String(), labels
+}
+
+// ListProducts returns the page of the products matching filters in order
+// together with their facets. The facets and the total share the filters
+// and come in one query, so a listing costs two round trips.
+func ListProducts(ctx context.Context, db *gorm.DB, page int, size int, order clause.OrderBy, filters ...func(db *gorm.DB) *gorm.DB) (ProductListing, error) {
+	db = db.WithContext(ctx)
+	if len(order.Columns) == 0 {
+		order, _ = ParseSort("", ProductSortFields)
+	}
+
+	var listing ProductListing
+	err := db.Scopes(filters...).Clauses(order).Scopes(Paginate(page, size)).Find(&listing.Products).Error
+	if err != nil {
+		return ProductListing{}, err
+	}
+
+	filtered := func() *gorm.DB {
+		return db.Model(&Product{}).Scopes(filters...)
+	}
+	bucket, labels := priceBucket(DefaultPriceBounds)
+	var counts []struct {
+		Facet string
+		Value string
+		Count int64
+	}
+	err = Combine(filtered().Select("? as facet, '' as value, count(*) as count", facetTotal)).
+		UnionAll(filtered().Select("? as facet, category as value, count(*) as count", FacetCategory).Group("category")).
+		UnionAll(filtered().Select("? as facet, "+bucket+" as value, count(*) as count", FacetPrice).Group("value")).
+		UnionAll(db.Table("taggings tg").Joins("join tags t on t.id = tg.tag_id").
+			Select("? as facet, t.name as value, count(*) as count", FacetTag).
+			Where("tg.taggable_type = ? and tg.taggable_id in (?)", "products", filtered().Select("id")).
+			Group("t.name")).
+		Query().Scan(&counts).Error
+	if err != nil {
+		return ProductListing{}, err
+	}
+
+	prices := map[string]int64{}
+	for _, count := range counts {
+		switch count.Facet {
+		case facetTotal:
+			listing.Total = count.Count
+		case FacetCategory:
+			listing.Categories = append(listing.Categories, FacetCount{Value: count.Value, Count: count.Count})
+		case FacetPrice:
+			prices[count.Value] = count.Count
+		case FacetTag:
+			listing.Tags = append(listing.Tags, FacetCount{Value: count.Value, Count: count.Count})
+		}
+	}
+	// every bucket is listed, empty ones too, in price order
+	for _, label := range labels {
+		listing.Prices = append(listing.Prices, FacetCount{Value: label, Count: prices[label]})
+	}
+	for _, facet := range [][]FacetCount{listing.Categories, listing.Tags} {
+		sort.Slice(facet, func(i, j int) bool {
+			if facet[i].Count != facet[j].Count {
+				return facet[i].Count > facet[j].Count
+			}
+			return facet[i].Value < facet[j].Value
+		})
+	}
+	return listing, nil
+}
@@ -0,0 +1,227 @@
+// Package factories builds valid models and the rows related to them for
+// tests, with defaults that only need overriding where a test cares:
+//
+//	user, err := factories.UserFactory().WithWallet(1_000_000).WithAddresses(2).Create(tx)
+package factories
+
+import (
+	"fmt"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	learn_golang_gorm "learn-golang-gorm"
+
+	"gorm.io/gorm"
+)
+
+var (
+	// run keeps the ids of one test binary apart from the rows an earlier
+	// run left behind.
+	run      = strconv.FormatInt(time.Now().UnixNano(), 36)
+	sequence int64
+)
+
+func next() int64 {
+	return atomic.AddInt64(&sequence, 1)
+}
+
+// ID returns a unique id of kind, e.g. factory-user-l2x9k3-1.
+func ID(kind string) string {
+	return fmt.Sprintf("factory-%s-%s-%d", kind, run, next())
+}
+
+type UserBuilder struct {
+	user      learn_golang_gorm.User
+	addresses int
+	todos     int
+	overrides []func(user *learn_golang_gorm.User)
+}
+
+// UserFactory builds a user with a unique id and email, without wallet or
+// addresses.
+func UserFactory() *UserBuilder {
+	id := ID("user")
+	return &UserBuilder{user: learn_golang_gorm.User{
+		ID:       id,
+		Password: "rahasia",
+		Name:     learn_golang_gorm.Name{FirstName: "Factory", LastName: "User"},
+		Email:    learn_golang_gorm.Email(id + "@example.com"),
+	}}
+}
+
+// With changes the user after the defaults and the other With methods are
+// applied.
+func (b *UserBuilder) With(override func(user *learn_golang_gorm.User)) *UserBuilder {
+	b.overrides = append(b.overrides, override)
+	return b
+}
+
+func (b *UserBuilder) WithID(id string) *UserBuilder {
+	b.user.ID = id
+	return b
+}
+
+func (b *UserBuilder) WithName(first string, last string) *UserBuilder {
+	b.user.Name = learn_golang_gorm.Name{FirstName: first, LastName: last}
+	return b
+}
+
+func (b *UserBuilder) WithWallet(balance int64) *UserBuilder {
+	b.user.Wallet = learn_golang_gorm.Wallet{ID: ID("wallet"), Balance: balance}
+	return b
+}
+
+func (b *UserBuilder) WithAddresses(n int) *UserBuilder {
+	b.addresses = n
+	return b
+}
+
+// WithTodos gives the user n todos, created after the user by Create.
+func (b *UserBuilder) WithTodos(n int) *UserBuilder {
+	b.todos = n
+	return b
+}
+
+func (b *UserBuilder) WithLikes(products ...learn_golang_gorm.Product) *UserBuilder {
+	b.user.LikeProducts = append(b.user.LikeProducts, products...)
+	return b
+}
+
+// Build returns the user with its wallet and addresses, without touching
+// the database.
+func (b *UserBuilder) Build() learn_golang_gorm.User {
+	user := b.user
+	user.Addresses = nil
+	for i := 1; i <= b.addresses; i++ {
+		user.Addresses = append(user.Addresses, learn_golang_gorm.Address{
+			Address: fmt.Sprintf("Jalan Factory No. %d", i),
+		})
+	}
+	for _, override := range b.overrides {
+		override(&user)
+	}
+	if user.Wallet.ID != "" {
+		user.Wallet.UserID = user.ID
+	}
+	for i := range user.Addresses {
+		user.Addresses[i].UserId = user.ID
+	}
+	return user
+}
+
+// Todos returns the todos WithTodos asks for, for a built user.
+func (b *UserBuilder) Todos(user learn_golang_gorm.User) []learn_golang_gorm.Todo {
+	todos := make([]learn_golang_gorm.Todo, 0, b.todos)
+	for i := 1; i <= b.todos; i++ {
+		todos = append(todos, TodoFactory(user.ID).WithTitle(fmt.Sprintf("Factory todo %d", i)).Build())
+	}
+	return todos
+}
+
+// Create inserts the user together with its related rows.
+func (b *UserBuilder) Create(tx *gorm.DB) (learn_golang_gorm.User, error) {
+	user := b.Build()
+	err := tx.Create(&user).Error
+	if err != nil {
+		return user, err
+	}
+	if todos := b.Todos(user); len(todos) > 0 {
+		err = tx.Create(&todos).Error
+	}
+	return user, err
+}
+
+type ProductBuilder struct {
+	product   learn_golang_gorm.Product
+	overrides []func(product *learn_golang_gorm.Product)
+}
+
+// ProductFactory builds an in stock product with a unique id.
+func ProductFactory() *ProductBuilder {
+	return &ProductBuilder{product: learn_golang_gorm.Product{
+		ID:       ID("product"),
+		Name:     "Factory Product",
+		Category: "factory",
+		Price:    100000,
+		Stock:    10,
+	}}
+}
+
+func (b *ProductBuilder) With(override func(product *learn_golang_gorm.Product)) *ProductBuilder {
+	b.overrides = append(b.overrides, override)
+	return b
+}
+
+func (b *ProductBuilder) WithPrice(price int64) *ProductBuilder {
+	b.product.Price = price
+	return b
+}
+
+func (b *ProductBuilder) WithStock(stock int64) *ProductBuilder {
+	b.product.Stock = stock
+	return b
+}
+
+func (b *ProductBuilder) WithCategory(category string) *ProductBuilder {
+	b.product.Category = category
+	return b
+}
+
+func (b *ProductBuilder) Build() learn_golang_gorm.Product {
+	product := b.product
+	for _, override := range b.overrides {
+		override(&product)
+	}
+	return product
+}
+
+func (b *ProductBuilder) Create(tx *gorm.DB) (learn_golang_gorm.Product, error) {
+	product := b.Build()
+	err := tx.Create(&product).Error
+	return product, err
+}
+
+type TodoBuilder struct {
+	todo      learn_golang_gorm.Todo
+	overrides []func(todo *learn_golang_gorm.Todo)
+}
+
+// TodoFactory builds an open todo of the user.
+func TodoFactory(userID string) *TodoBuilder {
+	return &TodoBuilder{todo: learn_golang_gorm.Todo{
+		UserId:      userID,
+		Title:       "Factory todo",
+		Description: "Created by a factory",
+	}}
+}
+
+func (b *TodoBuilder) With(override func(todo *learn_golang_gorm.Todo)) *TodoBuilder {
+	b.overrides = append(b.overrides, override)
+	return b
+}
+
+func (b *TodoBuilder) WithTitle(title string) *TodoBuilder {
+	b.todo.Title = title
+	return b
+}
+
+// Completed marks the todo completed at completedAt.
+func (b *TodoBuilder) Completed(completedAt time.Time) *TodoBuilder {
+	b.todo.CompletedAt = &completedAt
+	return b
+}
+
+func (b *TodoBuilder) Build() learn_golang_gorm.Todo {
+	todo := b.todo
+	for _, override := range b.overrides {
+		override(&todo)
+	}
+	return todo
+}
+
+func (b *TodoBuilder) Create(tx *gorm.DB) (learn_golang_gorm.Todo, error) {
+	todo := b.Build()
+	err := tx.Create(&todo).Error
+	return todo, err
+}
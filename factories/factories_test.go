@@ -0,0 +1,42 @@
+package factories
+
+import (
+	"testing"
+
+	learn_golang_gorm "learn-golang-gorm"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestUserFactory(t *testing.T) {
+	builder := UserFactory().WithWallet(1_000_000).WithAddresses(2).WithTodos(3)
+	user := builder.Build()
+	assert.NotEmpty(t, user.ID)
+	assert.Equal(t, learn_golang_gorm.Email(user.ID+"@example.com"), user.Email)
+	assert.Equal(t, int64(1_000_000), user.Wallet.Balance)
+	assert.Equal(t, user.ID, user.Wallet.UserID)
+	assert.Len(t, user.Addresses, 2)
+	for _, address := range user.Addresses {
+		assert.Equal(t, user.ID, address.UserId)
+	}
+
+	todos := builder.Todos(user)
+	assert.Len(t, todos, 3)
+	assert.Equal(t, user.ID, todos[0].UserId)
+
+	other := UserFactory().WithID("custom").With(func(user *learn_golang_gorm.User) {
+		user.Name.FirstName = "Eko"
+	}).WithAddresses(1).Build()
+	assert.Equal(t, "custom", other.ID)
+	assert.Equal(t, "Eko", other.Name.FirstName)
+	assert.Equal(t, "custom", other.Addresses[0].UserId)
+	assert.Empty(t, other.Wallet.ID)
+	assert.NotEqual(t, user.ID, UserFactory().Build().ID)
+}
+
+func TestProductFactory(t *testing.T) {
+	product := ProductFactory().WithPrice(5000).WithCategory("books").Build()
+	assert.Equal(t, int64(5000), product.Price)
+	assert.Equal(t, "books", product.Category)
+	assert.NotEqual(t, product.ID, ProductFactory().Build().ID)
+}
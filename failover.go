@@ -0,0 +1,238 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// FailoverConnector dials an ordered list of MySQL hosts, the first one is
+// the preferred host. When the active host is unreachable the next host is
+// used, and a monitor flips back once the preferred host answers again.
+// Credentials, when set, are asked for the user and password of every new
+// connection.
+type FailoverConnector struct {
+	Credentials CredentialProvider
+
+	base       *mysql.Config
+	hosts      []string
+	onFailover func(from string, to string)
+
+	mutex      sync.RWMutex
+	active     int
+	generation int
+
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+func NewFailoverConnector(base *mysql.Config, hosts []string, onFailover func(from string, to string)) (*FailoverConnector, error) {
+	if len(hosts) == 0 {
+		return nil, errors.New("at least one host is required")
+	}
+
+	return &FailoverConnector{
+		base:       base.Clone(),
+		hosts:      hosts,
+		onFailover: onFailover,
+		stop:       make(chan struct{}),
+	}, nil
+}
+
+func (c *FailoverConnector) dial(ctx context.Context, index int) (driver.Conn, error) {
+	config := c.base.Clone()
+	config.Net = "tcp"
+	config.Addr = c.hosts[index]
+
+	if c.Credentials != nil {
+		credentials, err := c.Credentials.Credentials(ctx, c.hosts[index])
+		if err != nil {
+			return nil, err
+		}
+		config.User = credentials.User
+		config.Passwd = credentials.Password
+	}
+
+	connector, err := mysql.NewConnector(config)
+	if err != nil {
+		return nil, err
+	}
+	return connector.Connect(ctx)
+}
+
+func (c *FailoverConnector) ActiveHost() string {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.hosts[c.active]
+}
+
+func (c *FailoverConnector) activate(index int) {
+	c.mutex.Lock()
+	from := c.active
+	c.active = index
+	c.mutex.Unlock()
+
+	if from != index && c.onFailover != nil {
+		c.onFailover(c.hosts[from], c.hosts[index])
+	}
+}
+
+// Connect tries the active host first and then every other host in order.
+func (c *FailoverConnector) Connect(ctx context.Context) (driver.Conn, error) {
+	c.mutex.RLock()
+	start := c.active
+	c.mutex.RUnlock()
+
+	var lastErr error
+	for i := 0; i < len(c.hosts); i++ {
+		index := (start + i) % len(c.hosts)
+		conn, err := c.dial(ctx, index)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		c.activate(index)
+
+		c.mutex.RLock()
+		generation := c.generation
+		c.mutex.RUnlock()
+		return &failoverConn{Conn: conn, host: c.hosts[index], generation: generation, connector: c}, nil
+	}
+	return nil, lastErr
+}
+
+// Rotate retires every open connection as it returns to the pool, e.g. after
+// the credentials changed. Queries running on them are not interrupted.
+func (c *FailoverConnector) Rotate() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.generation++
+}
+
+func (c *FailoverConnector) current(host string, generation int) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.hosts[c.active] == host && c.generation == generation
+}
+
+func (c *FailoverConnector) Driver() driver.Driver {
+	return mysql.MySQLDriver{}
+}
+
+// Monitor checks the preferred host every interval while running on another
+// host and switches back when it is reachable again, connections to the old
+// host are then discarded as they return to the pool.
+func (c *FailoverConnector) Monitor(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+
+		c.mutex.RLock()
+		active := c.active
+		c.mutex.RUnlock()
+		if active == 0 {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), interval)
+		conn, err := c.dial(ctx, 0)
+		if err == nil {
+			if pinger, ok := conn.(driver.Pinger); ok {
+				err = pinger.Ping(ctx)
+			}
+			_ = conn.Close()
+		}
+		cancel()
+
+		if err == nil {
+			c.activate(0)
+		}
+	}
+}
+
+// Done is closed together with the connector.
+func (c *FailoverConnector) Done() <-chan struct{} {
+	return c.stop
+}
+
+// Close stops the monitor, it is called by sql.DB.Close.
+func (c *FailoverConnector) Close() error {
+	c.stopOnce.Do(func() {
+		close(c.stop)
+	})
+	return nil
+}
+
+type failoverConn struct {
+	driver.Conn
+	host       string
+	generation int
+	connector  *FailoverConnector
+}
+
+func (c *failoverConn) IsValid() bool {
+	if validator, ok := c.Conn.(driver.Validator); ok && !validator.IsValid() {
+		return false
+	}
+	return c.connector.current(c.host, c.generation)
+}
+
+func (c *failoverConn) ResetSession(ctx context.Context) error {
+	if resetter, ok := c.Conn.(driver.SessionResetter); ok {
+		return resetter.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *failoverConn) Ping(ctx context.Context) error {
+	if pinger, ok := c.Conn.(driver.Pinger); ok {
+		return pinger.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *failoverConn) PrepareContext(ctx context.Context, query string) (driver.Stmt, error) {
+	if preparer, ok := c.Conn.(driver.ConnPrepareContext); ok {
+		return preparer.PrepareContext(ctx, query)
+	}
+	return c.Conn.Prepare(query)
+}
+
+func (c *failoverConn) BeginTx(ctx context.Context, opts driver.TxOptions) (driver.Tx, error) {
+	if beginner, ok := c.Conn.(driver.ConnBeginTx); ok {
+		return beginner.BeginTx(ctx, opts)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *failoverConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Rows, error) {
+	if queryer, ok := c.Conn.(driver.QueryerContext); ok {
+		return queryer.QueryContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *failoverConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	if execer, ok := c.Conn.(driver.ExecerContext); ok {
+		return execer.ExecContext(ctx, query, args)
+	}
+	return nil, driver.ErrSkip
+}
+
+func (c *failoverConn) CheckNamedValue(value *driver.NamedValue) error {
+	if checker, ok := c.Conn.(driver.NamedValueChecker); ok {
+		return checker.CheckNamedValue(value)
+	}
+	return driver.ErrSkip
+}
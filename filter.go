@@ -0,0 +1,203 @@
+package learn_golang_gorm
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidFilter = fmt.Errorf("%w: invalid filter", ErrInvalidRequest)
+
+// Filter is the JSON filter of the list endpoints, either a condition on
+// Field or the And or Or of other filters, e.g.
+//
+//	{"and": [{"field": "price", "op": "gte", "value": 1000},
+//	         {"field": "name", "op": "like", "value": "%book%"}]}
+type Filter struct {
+	And   []Filter    `json:"and,omitempty"`
+	Or    []Filter    `json:"or,omitempty"`
+	Field string      `json:"field,omitempty"`
+	Op    string      `json:"op,omitempty"`
+	Value interface{} `json:"value,omitempty"`
+}
+
+var filterOperators = map[string]string{
+	"eq":       "=",
+	"ne":       "<>",
+	"lt":       "<",
+	"lte":      "<=",
+	"gt":       ">",
+	"gte":      ">=",
+	"like":     "LIKE",
+	"in":       "IN",
+	"null":     "IS NULL",
+	"not_null": "IS NOT NULL",
+}
+
+// FilterFields is the filter whitelist of the model of Table, Columns maps
+// the names the API filters on to columns. A "table.column" is a column of
+// a related table, the row matches when one related row does, Joins
+// correlates each related table to the model.
+type FilterFields struct {
+	Table   string
+	Columns map[string]string
+	Joins   map[string]string
+}
+
+var (
+	UserFilterFields = FilterFields{
+		Table: "users",
+		Columns: map[string]string{
+			"name":           "first_name",
+			"email":          "email",
+			"phone":          "phone",
+			"created_at":     "created_at",
+			"address":        "addresses.address",
+			"wallet_balance": "wallets.balance",
+		},
+		Joins: map[string]string{
+			"addresses": "addresses.user_id = users.id",
+			"wallets":   "wallets.user_id = users.id",
+		},
+	}
+	ProductFilterFields = FilterFields{
+		Table: "products",
+		Columns: map[string]string{
+			"name":       "name",
+			"category":   "category",
+			"price":      "price",
+			"rating":     "average_rating",
+			"likes":      "likes_count",
+			"created_at": "created_at",
+			"liked_by":   "user_like_product.user_id",
+		},
+		Joins: map[string]string{
+			"user_like_product": "user_like_product.product_id = products.id",
+		},
+	}
+	TodoFilterFields = FilterFields{
+		Table: "todos",
+		Columns: map[string]string{
+			"title":        "title",
+			"parent_id":    "parent_id",
+			"created_at":   "created_at",
+			"completed_at": "completed_at",
+		},
+	}
+)
+
+// ParseFilter reads a filter, fields it does not know are an error rather
+// than silently ignored.
+func ParseFilter(data []byte) (Filter, error) {
+	var filter Filter
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	err := decoder.Decode(&filter)
+	if err != nil {
+		return Filter{}, fmt.Errorf("%w: %v", ErrInvalidFilter, err)
+	}
+	return filter, nil
+}
+
+func (f Filter) condition(fields FilterFields) (clause.Expression, error) {
+	column, ok := fields.Columns[f.Field]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, f.Field)
+	}
+	operator, ok := filterOperators[f.Op]
+	if !ok {
+		return nil, fmt.Errorf("%w: unknown operator %q", ErrInvalidFilter, f.Op)
+	}
+
+	target := clause.Column{Table: clause.CurrentTable, Name: column}
+	table, name, joined := strings.Cut(column, ".")
+	if joined {
+		target = clause.Column{Table: table, Name: name}
+	}
+
+	var expr clause.Expression
+	switch f.Op {
+	case "null", "not_null":
+		expr = clause.Expr{SQL: "? " + operator, Vars: []interface{}{target}}
+	case "in":
+		values, ok := f.Value.([]interface{})
+		if !ok || len(values) == 0 {
+			return nil, fmt.Errorf("%w: %s in needs a list of values", ErrInvalidFilter, f.Field)
+		}
+		expr = clause.Expr{SQL: "? IN ?", Vars: []interface{}{target, values}}
+	case "like":
+		if _, ok := f.Value.(string); !ok {
+			return nil, fmt.Errorf("%w: %s like needs a text", ErrInvalidFilter, f.Field)
+		}
+		expr = clause.Expr{SQL: "? LIKE ?", Vars: []interface{}{target, f.Value}}
+	default:
+		switch f.Value.(type) {
+		case string, float64, bool:
+		default:
+			return nil, fmt.Errorf("%w: %s %s needs a single value", ErrInvalidFilter, f.Field, f.Op)
+		}
+		expr = clause.Expr{SQL: "? " + operator + " ?", Vars: []interface{}{target, f.Value}}
+	}
+
+	if !joined {
+		return expr, nil
+	}
+	join, ok := fields.Joins[table]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s has no join", ErrInvalidFilter, table)
+	}
+	return clause.Expr{SQL: "exists (select 1 from ? where " + join + " and ?)",
+		Vars: []interface{}{clause.Table{Name: table}, expr}}, nil
+}
+
+// Expression compiles the filter against the whitelist fields, an empty
+// filter matches every row and gives nil.
+func (f Filter) Expression(fields FilterFields) (clause.Expression, error) {
+	combine := func(filters []Filter, join func(exprs ...clause.Expression) clause.Expression) (clause.Expression, error) {
+		exprs := make([]clause.Expression, 0, len(filters))
+		for _, filter := range filters {
+			expr, err := filter.Expression(fields)
+			if err != nil {
+				return nil, err
+			}
+			if expr != nil {
+				exprs = append(exprs, expr)
+			}
+		}
+		if len(exprs) == 0 {
+			return nil, nil
+		}
+		return join(exprs...), nil
+	}
+
+	switch {
+	case f.Field != "" && (len(f.And) > 0 || len(f.Or) > 0), len(f.And) > 0 && len(f.Or) > 0:
+		return nil, fmt.Errorf("%w: a filter is either a condition, and or or", ErrInvalidFilter)
+	case f.Field != "":
+		return f.condition(fields)
+	case len(f.And) > 0:
+		return combine(f.And, clause.And)
+	case len(f.Or) > 0:
+		return combine(f.Or, clause.Or)
+	}
+	return nil, nil
+}
+
+// Scope is the filter as a scope for db.Scopes, after checking it against
+// fields.
+func (f Filter) Scope(fields FilterFields) (func(db *gorm.DB) *gorm.DB, error) {
+	expr, err := f.Expression(fields)
+	if err != nil {
+		return nil, err
+	}
+	return func(db *gorm.DB) *gorm.DB {
+		if expr == nil {
+			return db
+		}
+		return db.Where(expr)
+	}, nil
+}
@@ -0,0 +1,183 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var ErrFilterTooExpensive = fmt.Errorf("%w: filter too expensive", ErrInvalidRequest)
+
+// FilterCost is what a filter makes the database do. Cost weighs the parts
+// into one number, an unindexed column or a join costs five predicates and
+// a LIKE starting with a wildcard ten.
+type FilterCost struct {
+	Predicates       int
+	Unindexed        int
+	LeadingWildcards int
+	Joins            int
+	Depth            int
+}
+
+func (c FilterCost) Cost() int {
+	return c.Predicates + 5*c.Unindexed + 10*c.LeadingWildcards + 5*c.Joins
+}
+
+// FilterBudget is the cost one endpoint accepts from its callers, zero
+// fields are not limited. Over budget filters are rejected, or with
+// Downgrade first have their leading wildcards dropped, turning contains
+// into starts with matches, which is enough for e.g. a type-ahead.
+type FilterBudget struct {
+	MaxCost       int
+	MaxPredicates int
+	MaxJoins      int
+	MaxDepth      int
+	Downgrade     bool
+}
+
+var (
+	DefaultFilterBudget = FilterBudget{MaxCost: 40, MaxPredicates: 10, MaxJoins: 2, MaxDepth: 3}
+	TypeAheadBudget     = FilterBudget{MaxCost: 10, MaxPredicates: 3, MaxDepth: 2, Downgrade: true}
+)
+
+func (b FilterBudget) allows(cost FilterCost) bool {
+	within := func(value int, limit int) bool {
+		return limit == 0 || value <= limit
+	}
+	return within(cost.Cost(), b.MaxCost) && within(cost.Predicates, b.MaxPredicates) &&
+		within(cost.Joins, b.MaxJoins) && within(cost.Depth, b.MaxDepth)
+}
+
+type FilterCostError struct {
+	Cost   FilterCost
+	Budget FilterBudget
+}
+
+func (e *FilterCostError) Error() string {
+	return fmt.Sprintf("filter costs %d with %d predicates, %d unindexed, %d leading wildcards and %d joins, "+
+		"the budget is %d", e.Cost.Cost(), e.Cost.Predicates, e.Cost.Unindexed, e.Cost.LeadingWildcards,
+		e.Cost.Joins, e.Budget.MaxCost)
+}
+
+func (e *FilterCostError) Unwrap() error {
+	return ErrFilterTooExpensive
+}
+
+// FilterGuard estimates filters before they run, with the indexes of the
+// database loaded once per table.
+type FilterGuard struct {
+	DB *gorm.DB
+
+	mutex   sync.Mutex
+	indexed map[string]map[string]bool
+}
+
+func NewFilterGuard(db *gorm.DB) *FilterGuard {
+	return &FilterGuard{DB: db, indexed: map[string]map[string]bool{}}
+}
+
+// leadingColumns are the columns an index of table starts with, the ones a
+// predicate can use an index for.
+func (g *FilterGuard) leadingColumns(ctx context.Context, table string) (map[string]bool, error) {
+	g.mutex.Lock()
+	defer g.mutex.Unlock()
+	if columns, ok := g.indexed[table]; ok {
+		return columns, nil
+	}
+
+	indexes, err := loadIndexes(ctx, g.DB, []string{table})
+	if err != nil {
+		return nil, err
+	}
+	columns := map[string]bool{}
+	for _, index := range indexes {
+		columns[index.columns[0]] = true
+	}
+	g.indexed[table] = columns
+	return columns, nil
+}
+
+func (g *FilterGuard) estimate(ctx context.Context, filter Filter, fields FilterFields, depth int, cost *FilterCost, joins map[string]bool) error {
+	if depth > cost.Depth {
+		cost.Depth = depth
+	}
+	for _, child := range append(append([]Filter(nil), filter.And...), filter.Or...) {
+		err := g.estimate(ctx, child, fields, depth+1, cost, joins)
+		if err != nil {
+			return err
+		}
+	}
+	if filter.Field == "" {
+		return nil
+	}
+
+	column, ok := fields.Columns[filter.Field]
+	if !ok {
+		return fmt.Errorf("%w: unknown field %q", ErrInvalidFilter, filter.Field)
+	}
+	table := fields.Table
+	if related, name, joined := strings.Cut(column, "."); joined {
+		table, column = related, name
+		joins[related] = true
+	}
+
+	cost.Predicates++
+	indexed, err := g.leadingColumns(ctx, table)
+	if err != nil {
+		return err
+	}
+	if !indexed[column] {
+		cost.Unindexed++
+	}
+	if value, ok := filter.Value.(string); ok && filter.Op == "like" && strings.HasPrefix(value, "%") {
+		cost.LeadingWildcards++
+	}
+	return nil
+}
+
+// Estimate is the cost of filter on the model of fields.
+func (g *FilterGuard) Estimate(ctx context.Context, filter Filter, fields FilterFields) (FilterCost, error) {
+	var cost FilterCost
+	joins := map[string]bool{}
+	err := g.estimate(ctx, filter, fields, 1, &cost, joins)
+	cost.Joins = len(joins)
+	return cost, err
+}
+
+// withoutLeadingWildcards is filter with its contains matches made starts
+// with matches.
+func withoutLeadingWildcards(filter Filter) Filter {
+	downgraded := filter
+	downgraded.And, downgraded.Or = nil, nil
+	for _, child := range filter.And {
+		downgraded.And = append(downgraded.And, withoutLeadingWildcards(child))
+	}
+	for _, child := range filter.Or {
+		downgraded.Or = append(downgraded.Or, withoutLeadingWildcards(child))
+	}
+	if value, ok := filter.Value.(string); ok && filter.Op == "like" {
+		downgraded.Value = strings.TrimLeft(value, "%")
+	}
+	return downgraded
+}
+
+// Check returns the filter to run within budget, filter itself or its
+// downgrade, or a *FilterCostError.
+func (g *FilterGuard) Check(ctx context.Context, filter Filter, fields FilterFields, budget FilterBudget) (Filter, error) {
+	cost, err := g.Estimate(ctx, filter, fields)
+	if err != nil || budget.allows(cost) {
+		return filter, err
+	}
+
+	if budget.Downgrade && cost.LeadingWildcards > 0 {
+		downgraded := withoutLeadingWildcards(filter)
+		cost, err = g.Estimate(ctx, downgraded, fields)
+		if err != nil || budget.allows(cost) {
+			return downgraded, err
+		}
+	}
+	return Filter{}, &FilterCostError{Cost: cost, Budget: budget}
+}
@@ -0,0 +1,194 @@
+package learn_golang_gorm_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	learn_golang_gorm "learn-golang-gorm"
+	"learn-golang-gorm/factories"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm/clause"
+)
+
+// The tests here build their rows with the factories, which import
+// learn_golang_gorm and so cannot be used from its own test package.
+
+var db = learn_golang_gorm.OpenConnection()
+
+func TestAutoCreateUpdate(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user, err := factories.UserFactory().WithWallet(1000000).Create(tx)
+	assert.Nil(t, err)
+	assert.False(t, user.CreatedAt.IsZero())
+
+	var wallet learn_golang_gorm.Wallet
+	err = tx.Take(&wallet, "user_id = ?", user.ID).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000000), wallet.Balance)
+}
+
+func TestSkipAutoCreateUpdate(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user := factories.UserFactory().WithWallet(1000000).Build()
+	err := tx.Omit(clause.Associations).Create(&user).Error
+	assert.Nil(t, err)
+
+	var wallets int64
+	err = tx.Model(&learn_golang_gorm.Wallet{}).Where("user_id = ?", user.ID).Count(&wallets).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), wallets)
+}
+
+func TestUserAndAdresses(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	user, err := factories.UserFactory().WithWallet(1000000).WithAddresses(2).Create(tx)
+	assert.Nil(t, err)
+
+	var loaded learn_golang_gorm.User
+	err = tx.Preload("Addresses").Joins("Wallet").Take(&loaded, "users.id = ?", user.ID).Error
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(loaded.Addresses))
+	assert.Equal(t, user.Wallet.ID, loaded.Wallet.ID)
+}
+
+func TestMasking(t *testing.T) {
+	user, err := factories.UserFactory().WithName("Mask", "").WithAddresses(1).Create(db)
+	assert.Nil(t, err)
+
+	config := learn_golang_gorm.DefaultConfig()
+	config.MaskRules = learn_golang_gorm.DefaultMaskRules
+	maskedDB, err := learn_golang_gorm.Open(config)
+	assert.Nil(t, err)
+
+	var masked learn_golang_gorm.User
+	assert.Nil(t, maskedDB.Preload("Addresses").Take(&masked, "id = ?", user.ID).Error)
+	assert.Equal(t, "Mask", masked.Name.FirstName)
+	assert.Equal(t, "********", masked.Password)
+	assert.Equal(t, learn_golang_gorm.Email(learn_golang_gorm.MaskEmail(string(user.Email))), masked.Email)
+	assert.Equal(t, 1, len(masked.Addresses))
+	assert.Equal(t, learn_golang_gorm.MaskAddress(user.Addresses[0].Address), masked.Addresses[0].Address)
+
+	var rows []map[string]interface{}
+	assert.Nil(t, maskedDB.Table("users").Where("id = ?", user.ID).Find(&rows).Error)
+	assert.Equal(t, "********", rows[0]["password"])
+
+	var plain learn_golang_gorm.User
+	assert.Nil(t, db.Take(&plain, "id = ?", user.ID).Error)
+	assert.Equal(t, user.Password, plain.Password)
+}
+
+func TestReadPolicy(t *testing.T) {
+	user, err := factories.UserFactory().WithWallet(1000).WithAddresses(1).Create(db)
+	assert.Nil(t, err)
+
+	policyDB := learn_golang_gorm.OpenConnection()
+	assert.Nil(t, learn_golang_gorm.EnforceReadPolicy(policyDB, learn_golang_gorm.DefaultReadPolicy))
+
+	support := policyDB.WithContext(learn_golang_gorm.WithRole(context.Background(), learn_golang_gorm.RoleSupport))
+	analyst := policyDB.WithContext(learn_golang_gorm.WithRole(context.Background(), learn_golang_gorm.RoleAnalyst))
+
+	t.Run("User", func(t *testing.T) {
+		var found learn_golang_gorm.User
+		assert.Nil(t, support.Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, "", found.Password)
+		assert.Equal(t, user.Email, found.Email)
+
+		found = learn_golang_gorm.User{}
+		assert.Nil(t, analyst.Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, "", found.Password)
+		assert.Equal(t, learn_golang_gorm.Email(""), found.Email)
+		assert.Equal(t, "", found.Name.FirstName)
+
+		found = learn_golang_gorm.User{}
+		assert.Nil(t, support.Select("id", "password").Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, "", found.Password)
+
+		var row map[string]interface{}
+		assert.Nil(t, support.Table("users").Select("id", "password").Take(&row, "id = ?", user.ID).Error)
+		assert.Nil(t, row["password"])
+
+		found = learn_golang_gorm.User{}
+		assert.Nil(t, policyDB.Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, user.Password, found.Password)
+	})
+
+	t.Run("Wallet", func(t *testing.T) {
+		var wallet learn_golang_gorm.Wallet
+		assert.Nil(t, support.Take(&wallet, "id = ?", user.Wallet.ID).Error)
+		assert.Equal(t, int64(0), wallet.Balance)
+
+		wallet = learn_golang_gorm.Wallet{}
+		assert.Nil(t, analyst.Take(&wallet, "id = ?", user.Wallet.ID).Error)
+		assert.Equal(t, int64(1000), wallet.Balance)
+	})
+
+	t.Run("Address", func(t *testing.T) {
+		var found learn_golang_gorm.User
+		assert.Nil(t, analyst.Preload("Addresses").Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, 1, len(found.Addresses))
+		assert.Equal(t, "", found.Addresses[0].Address)
+
+		found = learn_golang_gorm.User{}
+		assert.Nil(t, support.Preload("Addresses").Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, user.Addresses[0].Address, found.Addresses[0].Address)
+	})
+
+	t.Run("Joins", func(t *testing.T) {
+		var found learn_golang_gorm.User
+		assert.Nil(t, support.Joins("Wallet").Take(&found, "users.id = ?", user.ID).Error)
+		assert.Equal(t, user.Wallet.ID, found.Wallet.ID)
+		assert.Equal(t, int64(0), found.Wallet.Balance)
+		assert.Equal(t, "", found.Password)
+
+		found = learn_golang_gorm.User{}
+		assert.Nil(t, support.Preload("Wallet").Take(&found, "id = ?", user.ID).Error)
+		assert.Equal(t, int64(0), found.Wallet.Balance)
+
+		var wallet learn_golang_gorm.Wallet
+		assert.Nil(t, analyst.Joins("User").Take(&wallet, "wallets.id = ?", user.Wallet.ID).Error)
+		assert.Equal(t, user.ID, wallet.User.ID)
+		assert.Equal(t, "", wallet.User.Password)
+		assert.Equal(t, learn_golang_gorm.Email(""), wallet.User.Email)
+	})
+
+	t.Run("UnknownRole", func(t *testing.T) {
+		var found learn_golang_gorm.User
+		err := policyDB.WithContext(learn_golang_gorm.WithRole(context.Background(), "intern")).Take(&found, "id = ?", user.ID).Error
+		assert.True(t, errors.Is(err, learn_golang_gorm.ErrUnknownRole))
+		assert.Equal(t, http.StatusForbidden, learn_golang_gorm.HTTPStatus(err))
+		assert.Equal(t, "", found.Password)
+	})
+}
+
+func TestPreloadLatest(t *testing.T) {
+	tx := db.Begin()
+	defer tx.Rollback()
+
+	var users []learn_golang_gorm.User
+	for _, addresses := range []int{4, 1, 0} {
+		user, err := factories.UserFactory().WithAddresses(addresses).Create(tx)
+		assert.Nil(t, err)
+		user.Addresses = nil
+		users = append(users, user)
+	}
+
+	err := learn_golang_gorm.PreloadLatest(tx, &users, "Addresses", 2, "id desc")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Jalan Factory No. 4", "Jalan Factory No. 3"}, []string{users[0].Addresses[0].Address, users[0].Addresses[1].Address})
+	assert.Len(t, users[1].Addresses, 1)
+	assert.Equal(t, users[1].ID, users[1].Addresses[0].UserId)
+	assert.NotNil(t, users[2].Addresses)
+	assert.Len(t, users[2].Addresses, 0)
+
+	err = learn_golang_gorm.PreloadLatest(tx, &users, "Wallet", 2, "")
+	assert.True(t, errors.Is(err, learn_golang_gorm.ErrUnsupportedAssociation))
+}
@@ -0,0 +1,93 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrEmptyFunnel = errors.New("funnel needs at least one step")
+
+// Action is the action column of a user log.
+type Action string
+
+type FunnelStep struct {
+	Action         Action  `json:"action"`
+	Users          int64   `json:"users"`
+	Conversion     float64 `json:"conversion"`
+	StepConversion float64 `json:"step_conversion"`
+}
+
+type Funnel struct {
+	Window time.Duration `json:"window"`
+	Steps  []FunnelStep  `json:"steps"`
+}
+
+// funnelSQL chains one CTE per step: s1 has the first occurrence of the
+// first action per user, every later step the first occurrence of its action
+// after the previous step and within window of the first one.
+func funnelSQL(steps int) string {
+	var sql strings.Builder
+	sql.WriteString("with s1 as (select user_id, min(created_at) as started_at, min(created_at) as reached_at " +
+		"from user_logs where action = ? and created_at >= ? and created_at < ? group by user_id)")
+	for i := 2; i <= steps; i++ {
+		fmt.Fprintf(&sql, ", s%d as (select p.user_id, p.started_at, min(l.created_at) as reached_at from s%d p "+
+			"join user_logs l on l.user_id = p.user_id and l.action = ? "+
+			"and l.created_at > p.reached_at and l.created_at <= p.started_at + ? "+
+			"group by p.user_id, p.started_at)", i, i-1)
+	}
+
+	counts := make([]string, steps)
+	for i := range counts {
+		counts[i] = fmt.Sprintf("select %d as step, count(*) as users from s%d", i, i+1)
+	}
+	sql.WriteString(" " + strings.Join(counts, " union all "))
+	return sql.String()
+}
+
+// FunnelQuery counts the users who did the actions of steps in order, the
+// first one between from and to and all of them within window. Other actions
+// may happen in between.
+func FunnelQuery(ctx context.Context, db *gorm.DB, steps []Action, window time.Duration, from time.Time, to time.Time) (Funnel, error) {
+	funnel := Funnel{Window: window}
+	if len(steps) == 0 {
+		return funnel, ErrEmptyFunnel
+	}
+
+	args := []interface{}{string(steps[0]), from.UnixMilli(), to.UnixMilli()}
+	for _, step := range steps[1:] {
+		args = append(args, string(step), window.Milliseconds())
+	}
+
+	var rows []struct {
+		Step  int
+		Users int64
+	}
+	err := db.WithContext(ctx).Raw(funnelSQL(len(steps)), args...).Scan(&rows).Error
+	if err != nil {
+		return funnel, err
+	}
+
+	funnel.Steps = make([]FunnelStep, len(steps))
+	for i, step := range steps {
+		funnel.Steps[i].Action = step
+	}
+	for _, row := range rows {
+		funnel.Steps[row.Step].Users = row.Users
+	}
+	for i := range funnel.Steps {
+		if first := funnel.Steps[0].Users; first > 0 {
+			funnel.Steps[i].Conversion = float64(funnel.Steps[i].Users) / float64(first)
+		}
+		if i == 0 {
+			funnel.Steps[i].StepConversion = funnel.Steps[i].Conversion
+		} else if previous := funnel.Steps[i-1].Users; previous > 0 {
+			funnel.Steps[i].StepConversion = float64(funnel.Steps[i].Users) / float64(previous)
+		}
+	}
+	return funnel, nil
+}
@@ -0,0 +1,80 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+type GeneratedColumn struct {
+	Table      string
+	Name       string
+	Type       string
+	Expression string
+	Stored     bool
+}
+
+func (c GeneratedColumn) definition() string {
+	storage := "virtual"
+	if c.Stored {
+		storage = "stored"
+	}
+	return fmt.Sprintf("%s generated always as (%s) %s", c.Type, c.Expression, storage)
+}
+
+// AddGeneratedColumn adds a MySQL generated column, the model field mapped to
+// it must be read only and skipped by AutoMigrate: `gorm:"column:x;->;-:migration"`.
+func AddGeneratedColumn(db *gorm.DB, column GeneratedColumn) error {
+	if db.Migrator().HasColumn(column.Table, column.Name) {
+		return nil
+	}
+	return db.Exec(fmt.Sprintf("alter table `%s` add column `%s` %s",
+		column.Table, column.Name, column.definition())).Error
+}
+
+func DropGeneratedColumn(db *gorm.DB, table string, name string) error {
+	if !db.Migrator().HasColumn(table, name) {
+		return nil
+	}
+	return db.Migrator().DropColumn(table, name)
+}
+
+// AddColumn adds the column of a model field unless it exists, columns of
+// tables created by AutoMigrate already do.
+func AddColumn(db *gorm.DB, model interface{}, field string) error {
+	if db.Migrator().HasColumn(model, field) {
+		return nil
+	}
+	return db.Migrator().AddColumn(model, field)
+}
+
+func CreateIndex(db *gorm.DB, table string, name string, columns ...string) error {
+	if db.Migrator().HasIndex(table, name) {
+		return nil
+	}
+
+	list := ""
+	for i, column := range columns {
+		if i > 0 {
+			list += ", "
+		}
+		list += "`" + column + "`"
+	}
+	return db.Exec(fmt.Sprintf("create index `%s` on `%s` (%s)", name, table, list)).Error
+}
+
+// CreateFunctionalIndex indexes an expression directly, which needs MySQL
+// 8.0.13 or later; on older servers index a generated column instead.
+func CreateFunctionalIndex(db *gorm.DB, table string, name string, expression string) error {
+	if db.Migrator().HasIndex(table, name) {
+		return nil
+	}
+	return db.Exec(fmt.Sprintf("create index `%s` on `%s` ((%s))", name, table, expression)).Error
+}
+
+func DropIndex(db *gorm.DB, table string, name string) error {
+	if !db.Migrator().HasIndex(table, name) {
+		return nil
+	}
+	return db.Migrator().DropIndex(table, name)
+}
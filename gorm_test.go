@@ -1,38 +1,46 @@
 package learn_golang_gorm
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"database/sql"
+	"database/sql/driver"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"regexp"
+	"runtime/pprof"
+	"runtime/trace"
+	"slices"
 	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"learn-golang-gorm/datafix"
+
+	mysqlDriver "github.com/go-sql-driver/mysql"
 	"github.com/stretchr/testify/assert"
-	"gorm.io/driver/mysql"
 	"gorm.io/gorm"
 	"gorm.io/gorm/clause"
 	"gorm.io/gorm/logger"
 )
 
 func OpenConnection() *gorm.DB {
-	dialect := mysql.Open("root:password@tcp(localhost:3306)/learn_golang_gorm?charset=utf8mb4&parseTime=True&loc=Local")
-	db, err := gorm.Open(dialect, &gorm.Config{
-		Logger: logger.Default.LogMode(logger.Info),
-	})
-	if err != nil {
-		panic(err)
-	}
-
-	sqlDB, err := db.DB()
+	db, err := Open(DefaultConfig())
 	if err != nil {
 		panic(err)
 	}
-
-	sqlDB.SetMaxOpenConns(100)
-	sqlDB.SetMaxIdleConns(10)
-	sqlDB.SetConnMaxLifetime(30 * time.Minute)
-	sqlDB.SetConnMaxIdleTime(5 * time.Minute)
-
 	return db
 }
 
@@ -513,79 +521,6 @@ func TestRetrieveRelationJoin(t *testing.T) {
 	assert.Equal(t, "1", user.Wallet.ID)
 }
 
-func TestAutoCreateUpdate(t *testing.T) {
-	tx := db.Begin()
-	defer tx.Rollback()
-
-	user := User{
-		ID:       "22",
-		Password: "secret",
-		Name: Name{
-			FirstName: "User 22",
-		},
-		Wallet: Wallet{
-			ID:      "22",
-			UserID:  "22",
-			Balance: 1000000,
-		},
-	}
-
-	err := tx.Create(&user).Error
-	assert.Nil(t, err)
-}
-
-func TestSkipAutoCreateUpdate(t *testing.T) {
-	tx := db.Begin()
-	defer tx.Rollback()
-
-	user := User{
-		ID:       "23",
-		Password: "secret",
-		Name: Name{
-			FirstName: "User 23",
-		},
-		Wallet: Wallet{
-			ID:      "23",
-			UserID:  "23",
-			Balance: 1000000,
-		},
-	}
-
-	err := tx.Omit(clause.Associations).Create(&user).Error
-	assert.Nil(t, err)
-}
-
-func TestUserAndAdresses(t *testing.T) {
-	tx := db.Begin()
-	defer tx.Rollback()
-
-	user := User{
-		ID:       "24",
-		Password: "secret",
-		Name: Name{
-			FirstName: "User 24",
-		},
-		Wallet: Wallet{
-			ID:      "24",
-			UserID:  "24",
-			Balance: 1000000,
-		},
-		Addresses: []Address{
-			{
-				UserId:  "24",
-				Address: "Jalan A",
-			},
-			{
-				UserId:  "24",
-				Address: "Jalan B",
-			},
-		},
-	}
-
-	err := tx.Create(&user).Error
-	assert.Nil(t, err)
-}
-
 func TestPreloadJoinOneToMany(t *testing.T) {
 	var users []User
 	err := db.Model(&User{}).Preload("Addresses").Joins("Wallet").Find(&users).Error
@@ -850,3 +785,3271 @@ func TestHook(t *testing.T) {
 
 	fmt.Println(user)
 }
+
+func TestAllocateIDs(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Sequence{})
+	assert.Nil(t, err)
+
+	first, err := AllocateIDs(db, "test_allocate", 10)
+	assert.Nil(t, err)
+
+	second, err := AllocateIDs(db, "test_allocate", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, first+10, second)
+
+	_, err = AllocateIDs(db, "test_allocate", 0)
+	assert.Equal(t, ErrInvalidBlockSize, err)
+}
+
+func TestSequenceAllocatorParallel(t *testing.T) {
+	allocator := NewSequenceAllocator(db, "test_allocator", 20)
+
+	var mutex sync.Mutex
+	ids := map[int64]bool{}
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 10; j++ {
+				id, err := allocator.Next()
+				assert.Nil(t, err)
+
+				mutex.Lock()
+				ids[id] = true
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, 100, len(ids))
+}
+
+func TestSetPrice(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&ProductPrice{})
+	assert.Nil(t, err)
+
+	lastWeek := time.Now().AddDate(0, 0, -7)
+	yesterday := time.Now().AddDate(0, 0, -1)
+
+	err = SetPrice(db, "P001", 1000000, lastWeek)
+	assert.Nil(t, err)
+
+	err = SetPrice(db, "P001", 1500000, yesterday)
+	assert.Nil(t, err)
+
+	err = SetPrice(db, "P001", 900000, lastWeek)
+	assert.Equal(t, ErrPriceNotEffective, err)
+
+	price, err := PriceAt(db, "P001", lastWeek.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1000000), price.Price)
+
+	price, err = PriceAt(db, "P001", time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1500000), price.Price)
+
+	var product Product
+	err = db.Take(&product, "id = ?", "P001").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1500000), product.Price)
+}
+
+func TestRedeemCoupon(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Coupon{}, &CouponRedemption{})
+	assert.Nil(t, err)
+
+	coupon := Coupon{
+		Code:           "HEMAT" + time.Now().Format("20060102150405"),
+		DiscountAmount: 50000,
+		MaxUses:        2,
+	}
+	err = db.Create(&coupon).Error
+	assert.Nil(t, err)
+
+	for _, userID := range []string{"1", "2"} {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			_, err := RedeemCoupon(tx, coupon.Code, userID)
+			return err
+		})
+		assert.Nil(t, err)
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		_, err := RedeemCoupon(tx, coupon.Code, "3")
+		return err
+	})
+	assert.Equal(t, ErrCouponExhausted, err)
+
+	redemptions, err := FindCouponRedemptions(db, coupon.Code)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(redemptions))
+
+	reports, err := CouponRedemptionReports(db, time.Now().Add(-time.Hour), time.Now().Add(time.Hour))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, reports)
+}
+
+func TestRedeemExpiredCoupon(t *testing.T) {
+	expiredAt := time.Now().Add(-time.Hour)
+	coupon := Coupon{
+		Code:           "EXPIRED" + time.Now().Format("20060102150405"),
+		DiscountAmount: 50000,
+		MaxUses:        10,
+		ExpiresAt:      &expiredAt,
+	}
+	err := db.Create(&coupon).Error
+	assert.Nil(t, err)
+
+	_, err = RedeemCoupon(db, coupon.Code, "1")
+	assert.Equal(t, ErrCouponExpired, err)
+}
+
+func TestCart(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Cart{}, &CartItem{})
+	assert.Nil(t, err)
+
+	cart, err := FindAnonymousCart(db, "session-"+time.Now().Format("20060102150405"))
+	assert.Nil(t, err)
+
+	version := cart.Version
+	_, err = Checkout(db, &cart)
+	assert.Equal(t, ErrCartEmpty, err)
+	assert.Equal(t, version, cart.Version)
+	assert.Equal(t, CartStatusActive, cart.Status)
+
+	err = AddItem(db, &cart, "P001", 2)
+	assert.Nil(t, err)
+	assert.Equal(t, version+1, cart.Version)
+
+	stale := cart
+	stale.Version--
+	err = AddItem(db, &stale, "P001", 1)
+	assert.Equal(t, ErrCartVersionConflict, err)
+
+	err = UpdateQuantity(db, &cart, "P001", 3)
+	assert.Nil(t, err)
+
+	merged, err := MergeCarts(db, cart.SessionID, "1")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, merged.Items)
+
+	checkout, err := Checkout(db, &merged)
+	assert.Nil(t, err)
+	assert.NotEqual(t, int64(0), checkout.Total)
+	assert.Equal(t, CartStatusCheckedOut, merged.Status)
+
+	_, err = ExpireStaleCarts(db, 30*24*time.Hour)
+	assert.Nil(t, err)
+}
+
+func TestReview(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Product{}, &Review{})
+	assert.Nil(t, err)
+
+	err = CreateReview(db, &Review{UserID: "1", ProductID: "P001", Rating: 6})
+	assert.Equal(t, ErrInvalidRating, err)
+
+	first := Review{UserID: "1", ProductID: "P001", Rating: 5, Text: "Great"}
+	err = CreateReview(db, &first)
+	assert.Nil(t, err)
+
+	second := Review{UserID: "2", ProductID: "P001", Rating: 3, Text: "Okay"}
+	err = CreateReview(db, &second)
+	assert.Nil(t, err)
+
+	err = CreateReview(db, &Review{UserID: "1", ProductID: "P001", Rating: 4})
+	assert.NotNil(t, err)
+
+	var product Product
+	err = db.Take(&product, "id = ?", "P001").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), product.ReviewCount)
+	assert.Equal(t, float64(4), product.AverageRating)
+
+	err = MarkReviewHelpful(db, second.ID)
+	assert.Nil(t, err)
+
+	reviews, err := ListReviews(db, "P001", ReviewSortHelpful, 1, 10)
+	assert.Nil(t, err)
+	assert.Equal(t, second.ID, reviews[0].ID)
+
+	err = DeleteReview(db, first.ID)
+	assert.Nil(t, err)
+
+	err = RecomputeProductRatings(db)
+	assert.Nil(t, err)
+
+	err = db.Take(&product, "id = ?", "P001").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), product.ReviewCount)
+	assert.Equal(t, float64(3), product.AverageRating)
+}
+
+func TestTagging(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Tag{}, &Tagging{})
+	assert.Nil(t, err)
+
+	tagService := NewTagService(db)
+
+	var product Product
+	err = db.Take(&product, "id = ?", "P001").Error
+	assert.Nil(t, err)
+
+	err = tagService.Attach(&product, "electronic", "sale")
+	assert.Nil(t, err)
+
+	todo := Todo{UserId: "1", Title: "Tagged Todo"}
+	err = db.Create(&todo).Error
+	assert.Nil(t, err)
+
+	err = tagService.Attach(&todo, "sale")
+	assert.Nil(t, err)
+
+	tags, err := tagService.TagsOf(&product)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tags))
+
+	var products []Product
+	truncated, err := tagService.FindByAllTags(&products, "electronic", "sale")
+	assert.Nil(t, err)
+	assert.False(t, truncated)
+	assert.Equal(t, 1, len(products))
+
+	var todos []Todo
+	_, err = tagService.FindByAnyTag(&todos, "electronic", "sale")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(todos))
+
+	other := Todo{UserId: "1", Title: "Tagged Todo"}
+	assert.Nil(t, db.Create(&other).Error)
+	assert.Nil(t, tagService.Attach(&other, "sale"))
+	tagService.MaxRows = 1
+	var tagged []Todo
+	truncated, err = tagService.FindByAnyTag(&tagged, "sale")
+	assert.Nil(t, err)
+	assert.True(t, truncated)
+	assert.Equal(t, 1, len(tagged))
+	tagService.MaxRows = 0
+
+	popular, err := tagService.PopularTags(1)
+	assert.Nil(t, err)
+	assert.Equal(t, "sale", popular[0].Name)
+
+	err = tagService.Detach(&product, "sale")
+	assert.Nil(t, err)
+
+	products = []Product{}
+	_, err = tagService.FindByAllTags(&products, "electronic", "sale")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, len(products))
+}
+
+func TestTodoTree(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Todo{})
+	assert.Nil(t, err)
+
+	root := Todo{UserId: "1", Title: "Release"}
+	err = db.Create(&root).Error
+	assert.Nil(t, err)
+
+	build := Todo{Title: "Build"}
+	err = AddSubtask(db, root.ID, &build)
+	assert.Nil(t, err)
+
+	test := Todo{Title: "Test"}
+	err = AddSubtask(db, root.ID, &test)
+	assert.Nil(t, err)
+
+	compile := Todo{Title: "Compile"}
+	err = AddSubtask(db, build.ID, &compile)
+	assert.Nil(t, err)
+
+	tree, err := FindTodoTree(db, root.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(tree.Children))
+	assert.Equal(t, 1, len(tree.Children[0].Children))
+
+	err = CompleteTodo(db, compile.ID)
+	assert.Nil(t, err)
+
+	err = db.Take(&build, "id = ?", build.ID).Error
+	assert.Nil(t, err)
+	assert.True(t, build.Completed())
+
+	err = db.Take(&root, "id = ?", root.ID).Error
+	assert.Nil(t, err)
+	assert.False(t, root.Completed())
+
+	err = CompleteTodo(db, test.ID)
+	assert.Nil(t, err)
+
+	err = db.Take(&root, "id = ?", root.ID).Error
+	assert.Nil(t, err)
+	assert.True(t, root.Completed())
+}
+
+func TestParseRecurrence(t *testing.T) {
+	rule, err := ParseRecurrence("FREQ=WEEKLY;INTERVAL=2;COUNT=3")
+	assert.Nil(t, err)
+	assert.Equal(t, FrequencyWeekly, rule.Frequency)
+
+	anchor := time.Date(2024, 1, 1, 10, 0, 0, 0, time.Local)
+	dates := rule.Occurrences(anchor, anchor, anchor.AddDate(1, 0, 0))
+	assert.Equal(t, 3, len(dates))
+	assert.Equal(t, time.Date(2024, 1, 29, 0, 0, 0, 0, time.Local), dates[2])
+
+	_, err = ParseRecurrence("FREQ=HOURLY")
+	assert.ErrorIs(t, err, ErrInvalidRecurrence)
+}
+
+func TestMaterializeRecurringTodos(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Todo{})
+	assert.Nil(t, err)
+
+	template := Todo{UserId: "1", Title: "Daily Standup", Recurrence: "FREQ=DAILY"}
+	err = db.Create(&template).Error
+	assert.Nil(t, err)
+
+	until := time.Now().AddDate(0, 0, 6)
+	_, err = MaterializeRecurringTodos(db, until, 100)
+	assert.Nil(t, err)
+
+	created, err := MaterializeRecurringTodos(db, until, 100)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), created)
+
+	var count int64
+	err = db.Model(&Todo{}).Where("template_id = ?", template.ID).Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(7), count)
+}
+
+func TestReminderPoller(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Reminder{})
+	assert.Nil(t, err)
+
+	todo := Todo{UserId: "1", Title: "Pay Bills"}
+	err = db.Create(&todo).Error
+	assert.Nil(t, err)
+
+	reminder := Reminder{TodoID: todo.ID, RemindAt: time.Now().Add(-time.Minute)}
+	err = db.Create(&reminder).Error
+	assert.Nil(t, err)
+
+	failing := NewReminderPoller(db, NotifierFunc(func(ctx context.Context, reminder Reminder) error {
+		return fmt.Errorf("smtp unavailable")
+	}))
+	failing.RetryDelay = 0
+	delivered, err := failing.PollOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, delivered)
+
+	var titles []string
+	poller := NewReminderPoller(db, NotifierFunc(func(ctx context.Context, reminder Reminder) error {
+		titles = append(titles, reminder.Todo.Title)
+		return nil
+	}))
+	_, err = poller.PollOnce(context.Background())
+	assert.Nil(t, err)
+	assert.Contains(t, titles, "Pay Bills")
+
+	err = db.Take(&reminder, "id = ?", reminder.ID).Error
+	assert.Nil(t, err)
+	assert.NotNil(t, reminder.SentAt)
+	assert.Equal(t, 1, reminder.Attempts)
+}
+
+func TestUserPreferences(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&UserPreference{})
+	assert.Nil(t, err)
+
+	preferences, err := GetPreferences(db, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, DefaultPreferences, preferences)
+
+	err = SetPreferences(db, "2", map[string]interface{}{
+		"theme":     "dark",
+		"page_size": 50,
+	})
+	assert.Nil(t, err)
+
+	err = SetPreference(db, "2", "email_notifications", false)
+	assert.Nil(t, err)
+
+	preferences, err = GetPreferences(db, "2")
+	assert.Nil(t, err)
+	assert.Equal(t, "dark", preferences.Theme)
+	assert.Equal(t, 50, preferences.PageSize)
+	assert.False(t, preferences.EmailNotifications)
+	assert.Equal(t, DefaultPreferences.Language, preferences.Language)
+
+	err = SetPreference(db, "2", "font", "comic sans")
+	assert.ErrorIs(t, err, ErrInvalidPreference)
+
+	err = SetPreference(db, "2", "page_size", "fifty")
+	assert.ErrorIs(t, err, ErrInvalidPreference)
+
+	err = SetPreference(db, "2", "theme", "neon")
+	assert.ErrorIs(t, err, ErrInvalidPreference)
+}
+
+func TestProductTranslation(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&ProductTranslation{})
+	assert.Nil(t, err)
+
+	err = UpsertProductTranslation(db, &ProductTranslation{ProductID: "P001", Locale: "id", Name: "Contoh Produk"})
+	assert.Nil(t, err)
+
+	err = UpsertProductTranslation(db, &ProductTranslation{ProductID: "P001", Locale: "en", Name: "Sample Product"})
+	assert.Nil(t, err)
+
+	err = UpsertProductTranslation(db, &ProductTranslation{ProductID: "P001", Locale: "en", Name: "Example Product"})
+	assert.Nil(t, err)
+
+	var product Product
+	err = db.Scopes(PreloadTranslation("en")).Take(&product, "id = ?", "P001").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "Example Product", product.Translation("en").Name)
+
+	product = Product{}
+	err = db.Scopes(PreloadTranslation("ja")).Take(&product, "id = ?", "P001").Error
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(product.Translations))
+	assert.Equal(t, "Contoh Produk", product.Translation("ja").Name)
+}
+
+func TestGlobalSearch(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	results, err := GlobalSearch(ctx, db, "User", 5)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, results)
+	assert.Equal(t, SearchKindUser, results[0].Kind)
+	assert.NotNil(t, results[0].User)
+
+	results, err = GlobalSearch(ctx, db, "Product", 5)
+	assert.Nil(t, err)
+	assert.Equal(t, SearchKindProduct, results[0].Kind)
+
+	results, err = GlobalSearch(ctx, db, "100%", 5)
+	assert.Nil(t, err)
+	assert.Empty(t, results)
+}
+
+func TestQueryGroup(t *testing.T) {
+	group := NewQueryGroup(context.Background(), db)
+
+	var user User
+	var wallet Wallet
+	var userLogs []UserLog
+	group.Go(func(tx *gorm.DB) error {
+		return tx.Take(&user, "id = ?", "1").Error
+	})
+	group.Go(func(tx *gorm.DB) error {
+		return tx.Take(&wallet, "user_id = ?", "1").Error
+	})
+	group.Go(func(tx *gorm.DB) error {
+		return tx.Where("user_id = ?", "1").Order("id desc").Limit(5).Find(&userLogs).Error
+	})
+
+	err := group.Wait()
+	assert.Nil(t, err)
+	assert.Equal(t, "1", user.ID)
+	assert.Equal(t, "1", wallet.UserID)
+
+	group = NewQueryGroup(context.Background(), db)
+	group.Go(func(tx *gorm.DB) error {
+		return tx.Take(&user, "id = ?", "not found").Error
+	})
+	group.Go(func(tx *gorm.DB) error {
+		return tx.Exec("select sleep(5)").Error
+	})
+
+	err = group.Wait()
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
+func TestTransferBalanceNoDeadlock(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&WalletTransaction{}, &LedgerHead{}, &Account{}, &JournalTransaction{}, &JournalEntry{})
+	assert.Nil(t, err)
+
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&[]Wallet{
+		{ID: "TW1", UserID: "1", Balance: 1000000},
+		{ID: "TW2", UserID: "2", Balance: 1000000},
+	}).Error
+	assert.Nil(t, err)
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 50; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			errs <- TransferBalance(db, "TW1", "TW2", 1000)
+		}()
+		go func() {
+			defer wg.Done()
+			errs <- TransferBalance(db, "TW2", "TW1", 1000)
+		}()
+	}
+	wg.Wait()
+	close(errs)
+
+	for err := range errs {
+		assert.Nil(t, err)
+	}
+
+	var total int64
+	err = db.Model(&Wallet{}).Where("id IN ?", []string{"TW1", "TW2"}).Select("sum(balance)").Scan(&total).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2000000), total)
+
+	err = TransferBalance(db, "TW1", "TW2", 5000000)
+	assert.Equal(t, ErrInsufficientBalance, err)
+}
+
+func TestReserveStock(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&Product{})
+	assert.Nil(t, err)
+
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&[]Product{
+		{ID: "S001", Name: "Stock 1", Price: 10000, Stock: 10},
+		{ID: "S002", Name: "Stock 2", Price: 20000, Stock: 1},
+	}).Error
+	assert.Nil(t, err)
+
+	err = ReserveStock(db, map[string]int64{"S001": 2, "S002": 2})
+	assert.ErrorIs(t, err, ErrOutOfStock)
+
+	err = ReserveStock(db, map[string]int64{"S002": 1, "S001": 2})
+	assert.Nil(t, err)
+
+	var product Product
+	err = db.Take(&product, "id = ?", "S001").Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(8), product.Stock)
+
+	err = ReleaseStock(db, map[string]int64{"S001": 2, "S002": 1})
+	assert.Nil(t, err)
+}
+
+func TestIsolationLevel(t *testing.T) {
+	ctx := WithIsolation(context.Background(), sql.LevelReadCommitted)
+
+	var level string
+	err := RunInTransaction(ctx, db, func(tx *gorm.DB) error {
+		return tx.Raw("select @@transaction_isolation").Scan(&level).Error
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, "READ-COMMITTED", level)
+
+	err = RunInTransaction(ctx, db, func(tx *gorm.DB) error {
+		return tx.Raw("select @@transaction_isolation").Scan(&level).Error
+	}, Isolation(sql.LevelSerializable), ReadOnly())
+	assert.Nil(t, err)
+	assert.Equal(t, "SERIALIZABLE", level)
+
+	err = RunInTransaction(context.Background(), db, func(tx *gorm.DB) error {
+		return nil
+	}, Isolation(sql.LevelLinearizable))
+	assert.ErrorIs(t, err, ErrUnsupportedIsolation)
+}
+
+func TestReplicaRouter(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&ReplicationHeartbeat{})
+	assert.Nil(t, err)
+
+	replica := &Replica{Name: "replica-1", DB: db}
+	router := NewReplicaRouter(db, replica)
+
+	err = router.Beat(context.Background())
+	assert.Nil(t, err)
+	router.ProbeLag(context.Background())
+
+	lag, healthy := replica.Lag()
+	assert.True(t, healthy)
+	assert.Less(t, lag, time.Second)
+
+	router.Reader(context.Background())
+	router.Reader(MaxStaleness(context.Background(), 5*time.Second))
+	router.Reader(MaxStaleness(context.Background(), 0))
+
+	stats := router.Stats()
+	assert.Equal(t, int64(1), stats.ReplicaReads)
+	assert.Equal(t, int64(1), stats.PrimaryFallbacks)
+}
+
+func TestCachedReaderCoalescing(t *testing.T) {
+	var loads int64
+	cache := NewCachedReader(time.Minute, func(ctx context.Context, id string) (Product, error) {
+		atomic.AddInt64(&loads, 1)
+		time.Sleep(100 * time.Millisecond)
+		return FindByID[Product](db)(ctx, id)
+	})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			product, err := cache.Get(context.Background(), "P001")
+			assert.Nil(t, err)
+			assert.Equal(t, "P001", product.ID)
+		}()
+	}
+	wg.Wait()
+
+	assert.Equal(t, int64(1), atomic.LoadInt64(&loads))
+	assert.Equal(t, int64(50), cache.Stats().Coalesced)
+
+	_, err := cache.Get(context.Background(), "P001")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), cache.Stats().Hits)
+}
+
+func TestNegativeCaching(t *testing.T) {
+	cache := NewProductCache(db, time.Minute)
+	cache.NegativeTTL = time.Minute
+	err := cache.InvalidateOnCreate(db)
+	assert.Nil(t, err)
+
+	id := "N" + time.Now().Format("20060102150405")
+	_, err = cache.Get(context.Background(), id)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	_, err = cache.Get(context.Background(), id)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+	assert.Equal(t, int64(1), cache.Stats().NegativeHits)
+	assert.Equal(t, int64(1), cache.Stats().Misses)
+
+	err = db.Create(&Product{ID: id, Name: "Negative Cache", Price: 1000}).Error
+	assert.Nil(t, err)
+
+	product, err := cache.Get(context.Background(), id)
+	assert.Nil(t, err)
+	assert.Equal(t, id, product.ID)
+
+	clock := NewTestClock(time.Now())
+	cache.Clock = clock
+	_, err = cache.Get(context.Background(), id+"-missing")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+	clock.Advance(2 * time.Minute)
+	_, err = cache.Get(context.Background(), id)
+	assert.Nil(t, err)
+	_, cached := cache.entries[id+"-missing"]
+	assert.False(t, cached, "expired entries are swept")
+}
+
+func TestLoader(t *testing.T) {
+	var batches int64
+	fetch := FetchByColumn(db, "user_id", func(wallet Wallet) string {
+		return wallet.UserID
+	})
+	loader := NewLoader(10*time.Millisecond, func(ctx context.Context, keys []string) (map[string]Wallet, error) {
+		atomic.AddInt64(&batches, 1)
+		return fetch(ctx, keys)
+	})
+
+	var wg sync.WaitGroup
+	for i := 1; i <= 5; i++ {
+		userID := strconv.Itoa(i)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wallet, err := loader.Load(context.Background(), userID)
+			assert.Nil(t, err)
+			assert.Equal(t, userID, wallet.UserID)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, int64(1), atomic.LoadInt64(&batches))
+
+	_, err := loader.Load(context.Background(), "not found")
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+
+	addresses := NewLoader(10*time.Millisecond, FetchGroupedByColumn(db, "user_id", func(address Address) string {
+		return address.UserId
+	}))
+	userAddresses, err := addresses.Load(context.Background(), "2")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, userAddresses)
+}
+
+func TestGeneratedColumns(t *testing.T) {
+	err := Migrate(db)
+	assert.Nil(t, err)
+
+	version, err := SchemaVersion(db)
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, version, int64(4))
+
+	var users []User
+	err = db.Order("full_name asc").Limit(5).Find(&users).Error
+	assert.Nil(t, err)
+	assert.NotEqual(t, "", users[0].FullName)
+
+	guestBook := GuestBook{Name: "Lingga", Email: "Lingga@Example.COM", Message: "Hello"}
+	err = db.Create(&guestBook).Error
+	assert.Nil(t, err)
+
+	var found GuestBook
+	err = db.Take(&found, "email_lower = ?", "lingga@example.com").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "lingga@example.com", found.EmailLower)
+}
+
+func TestNormalizeContact(t *testing.T) {
+	email, err := NormalizeEmail("  Lingga@Example.COM ")
+	assert.Nil(t, err)
+	assert.Equal(t, Email("lingga@example.com"), email)
+
+	_, err = NormalizeEmail("not an email")
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+
+	phone, err := NormalizePhoneNumber("0812-3456-7890")
+	assert.Nil(t, err)
+	assert.Equal(t, PhoneNumber("+6281234567890"), phone)
+
+	phone, err = NormalizePhoneNumber("(+1) 415 555 2671")
+	assert.Nil(t, err)
+	assert.Equal(t, PhoneNumber("+14155552671"), phone)
+
+	_, err = NormalizePhoneNumber("12")
+	assert.ErrorIs(t, err, ErrInvalidPhoneNumber)
+}
+
+func TestContactColumns(t *testing.T) {
+	err := Migrate(db)
+	assert.Nil(t, err)
+
+	user := User{
+		Password: "secret",
+		Name:     Name{FirstName: "Contact"},
+		Email:    "Contact@Example.com",
+		Phone:    "0812 1111 2222",
+	}
+	err = db.Create(&user).Error
+	assert.Nil(t, err)
+
+	var found User
+	err = db.Take(&found, "email = ? AND phone = ?", Email("CONTACT@example.com"), PhoneNumber("+62 812 1111 2222")).Error
+	assert.Nil(t, err)
+	assert.Equal(t, user.ID, found.ID)
+	assert.Equal(t, Email("contact@example.com"), found.Email)
+
+	err = db.Create(&GuestBook{Name: "Invalid", Email: "invalid", Message: "Hello"}).Error
+	assert.ErrorIs(t, err, ErrInvalidEmail)
+}
+
+func TestUserLogClientInfo(t *testing.T) {
+	err := Migrate(db)
+	assert.Nil(t, err)
+
+	ctx := WithClientInfo(context.Background(), "10.1.2.3", "Mozilla/5.0")
+	userLog := UserLog{UserID: "1", Action: "Login"}
+	err = db.WithContext(ctx).Create(&userLog).Error
+	assert.Nil(t, err)
+
+	ctx = WithClientInfo(context.Background(), "2001:db8::1", "curl/8.0")
+	err = db.WithContext(ctx).Create(&UserLog{UserID: "1", Action: "Login"}).Error
+	assert.Nil(t, err)
+
+	var userLogs []UserLog
+	err = db.Scopes(IPInRange("ip", netip.MustParsePrefix("10.1.0.0/16"))).Find(&userLogs).Error
+	assert.Nil(t, err)
+	assert.NotEmpty(t, userLogs)
+	for _, found := range userLogs {
+		assert.True(t, netip.MustParsePrefix("10.1.0.0/16").Contains(found.IP.Addr))
+	}
+
+	userLogs = []UserLog{}
+	err = db.Scopes(IPInRange("ip", netip.MustParsePrefix("2001:db8::/32"))).Find(&userLogs).Error
+	assert.Nil(t, err)
+	assert.NotEmpty(t, userLogs)
+	assert.Equal(t, "curl/8.0", userLogs[len(userLogs)-1].UserAgent)
+}
+
+func TestLedgerHashChain(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&WalletTransaction{}, &AuditLog{}, &LedgerHead{}, &LedgerAnchor{},
+		&Account{}, &JournalTransaction{}, &JournalEntry{})
+	assert.Nil(t, err)
+
+	err = TransferBalance(db, "TW1", "TW2", 1000)
+	assert.Nil(t, err)
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		return WriteAuditLog(tx, "admin", "update", "wallets", "TW1", map[string]interface{}{"balance": 1000})
+	})
+	assert.Nil(t, err)
+
+	violations, err := VerifyChain[WalletTransaction](context.Background(), db, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+
+	violations, err = VerifyChain[AuditLog](context.Background(), db, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+
+	var last WalletTransaction
+	err = db.Last(&last).Error
+	assert.Nil(t, err)
+
+	err = db.Model(&last).UpdateColumn("amount", 999999).Error
+	assert.Nil(t, err)
+
+	violations, err = VerifyChain[WalletTransaction](context.Background(), db, 0, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(violations))
+	assert.Equal(t, last.ID, violations[0].ID)
+
+	err = ReanchorChain[WalletTransaction](context.Background(), db, last.ID, "test tampering")
+	assert.Nil(t, err)
+
+	violations, err = VerifyChain[WalletTransaction](context.Background(), db, 0, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, violations)
+}
+
+func TestDataFix(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&datafix.Run{})
+	assert.Nil(t, err)
+
+	for i := 0; i < 3; i++ {
+		err = db.Create(&GuestBook{Name: "  Padded " + strconv.Itoa(i) + "  ", Email: "padded@example.com", Message: "Fix me"}).Error
+		assert.Nil(t, err)
+	}
+
+	fix := datafix.Fix{
+		Name:      "trim guest book names",
+		Table:     "guest_books",
+		ChunkSize: 2,
+		Select: func(db *gorm.DB) *gorm.DB {
+			return db.Where("name LIKE ?", " %")
+		},
+		Transform: func(row map[string]interface{}) (map[string]interface{}, error) {
+			return map[string]interface{}{"name": strings.TrimSpace(fmt.Sprint(row["name"]))}, nil
+		},
+	}
+
+	var diff bytes.Buffer
+	run, err := datafix.Review(context.Background(), db, fix, "tester", &diff)
+	assert.Nil(t, err)
+	assert.Equal(t, datafix.StatusSucceeded, run.Status)
+	assert.GreaterOrEqual(t, run.RowsChanged, int64(3))
+	assert.Contains(t, diff.String(), `"name":"Padded 0"`)
+
+	run, err = datafix.Apply(context.Background(), db, fix, "tester")
+	assert.Nil(t, err)
+	assert.Equal(t, datafix.StatusSucceeded, run.Status)
+
+	var count int64
+	err = db.Model(&GuestBook{}).Where("name LIKE ?", " %").Count(&count).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), count)
+}
+
+func TestValidateReadOnlyQuery(t *testing.T) {
+	_, err := ValidateReadOnlyQuery("select id, first_name from users where id = '1';")
+	assert.Nil(t, err)
+
+	_, err = ValidateReadOnlyQuery("/* comment */ with u as (select * from users) select * from u")
+	assert.Nil(t, err)
+
+	for _, query := range []string{
+		"delete from users",
+		"select 1; drop table users",
+		"select * from users for update",
+		"select * from users into outfile '/tmp/users.csv'",
+		"-- select\nupdate users set password = ''",
+	} {
+		_, err = ValidateReadOnlyQuery(query)
+		assert.Equal(t, ErrNotReadOnlyQuery, err, query)
+	}
+}
+
+func TestQueryConsole(t *testing.T) {
+	console := NewQueryConsole(db, "admin-secret")
+	console.MaxRows = 3
+
+	result, err := console.Run(context.Background(), "select id, first_name, middle_name from users order by id")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"id", "first_name", "middle_name"}, result.Columns)
+	assert.Equal(t, 3, len(result.Rows))
+	assert.True(t, result.Truncated)
+
+	_, err = console.Run(context.Background(), "desc")
+	assert.NotNil(t, err)
+	result, err = console.Run(context.Background(), "desc users")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, result.Rows)
+
+	request := httptest.NewRequest(http.MethodPost, "/admin/query?format=csv", strings.NewReader("select id from users order by id"))
+	recorder := httptest.NewRecorder()
+	console.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+
+	request = httptest.NewRequest(http.MethodPost, "/admin/query?format=csv", strings.NewReader("select id from users order by id"))
+	request.Header.Set("Authorization", "Bearer admin-secret")
+	recorder = httptest.NewRecorder()
+	console.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusOK, recorder.Code)
+	assert.True(t, strings.HasPrefix(recorder.Body.String(), "id\n"))
+
+	request = httptest.NewRequest(http.MethodPost, "/admin/query", strings.NewReader("update users set password = ''"))
+	request.Header.Set("Authorization", "Bearer admin-secret")
+	recorder = httptest.NewRecorder()
+	console.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusBadRequest, recorder.Code)
+}
+
+func TestRetryRead(t *testing.T) {
+	assert.True(t, IsTransientError(&mysqlDriver.MySQLError{Number: 2006}))
+	assert.True(t, IsTransientError(fmt.Errorf("query: %w", driver.ErrBadConn)))
+	assert.False(t, IsTransientError(&mysqlDriver.MySQLError{Number: 1062}))
+
+	policy := RetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}
+
+	attempts := 0
+	var user User
+	err := RetryRead(context.Background(), db, policy, func(tx *gorm.DB) error {
+		attempts++
+		if attempts < 3 {
+			return &mysqlDriver.MySQLError{Number: 2013, Message: "Lost connection to MySQL server during query"}
+		}
+		return tx.Take(&user, "id = ?", "1").Error
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, attempts)
+	assert.Equal(t, "1", user.ID)
+
+	attempts = 0
+	err = RetryRead(context.Background(), db, policy, func(tx *gorm.DB) error {
+		attempts++
+		return tx.Create(&UserLog{UserID: "1", Action: "Retry"}).Error
+	})
+	assert.NotNil(t, err)
+	assert.Equal(t, 1, attempts)
+}
+
+func TestFailover(t *testing.T) {
+	var switches []string
+	config := DefaultConfig()
+	config.Hosts = []string{"127.0.0.1:1", "localhost:3306"}
+	config.FailbackInterval = 0
+	config.OnFailover = func(from string, to string) {
+		switches = append(switches, from+" -> "+to)
+	}
+
+	failoverDB, err := Open(config)
+	assert.Nil(t, err)
+
+	var user User
+	err = failoverDB.Take(&user, "id = ?", "1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"127.0.0.1:1 -> localhost:3306"}, switches)
+
+	sqlDB, err := failoverDB.DB()
+	assert.Nil(t, err)
+	assert.Nil(t, sqlDB.Close())
+}
+
+func TestCredentials(t *testing.T) {
+	ctx := context.Background()
+
+	passwordFile := t.TempDir() + "/password"
+	assert.Nil(t, os.WriteFile(passwordFile, []byte("first\n"), 0600))
+	fileCredentials := FileCredentials{User: "app", PasswordFile: passwordFile}
+	credentials, err := fileCredentials.Credentials(ctx, "localhost:3306")
+	assert.Nil(t, err)
+	assert.Equal(t, "first", credentials.Password)
+
+	assert.Nil(t, os.WriteFile(passwordFile, []byte("second"), 0600))
+	credentials, err = fileCredentials.Credentials(ctx, "localhost:3306")
+	assert.Nil(t, err)
+	assert.Equal(t, "second", credentials.Password)
+
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	iam := &RDSIAMCredentials{
+		User: "app", Region: "ap-southeast-1", AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret",
+		Now: func() time.Time { return now },
+	}
+	credentials, err = iam.Credentials(ctx, "db.example.com:3306")
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(credentials.Password, "db.example.com:3306/?Action=connect&DBUser=app&"))
+	assert.Contains(t, credentials.Password, "X-Amz-Credential=AKIDEXAMPLE%2F20240101%2Fap-southeast-1%2Frds-db%2Faws4_request")
+	assert.Contains(t, credentials.Password, "&X-Amz-Signature=")
+	assert.Equal(t, now.Add(15*time.Minute), credentials.ExpiresAt)
+
+	calls := 0
+	refreshing := NewRefreshingCredentials(CredentialProviderFunc(func(ctx context.Context, host string) (Credentials, error) {
+		calls++
+		return iam.Credentials(ctx, host)
+	}), time.Minute)
+	refreshing.Now = func() time.Time { return now }
+
+	_, _ = refreshing.Credentials(ctx, "db.example.com:3306")
+	now = now.Add(13 * time.Minute)
+	_, _ = refreshing.Credentials(ctx, "db.example.com:3306")
+	assert.Equal(t, 1, calls)
+
+	now = now.Add(time.Minute + time.Second)
+	_, _ = refreshing.Credentials(ctx, "db.example.com:3306")
+	assert.Equal(t, 2, calls)
+
+	_, err = TLSConfig{CAFile: passwordFile}.Build()
+	assert.Equal(t, ErrInvalidCABundle, err)
+}
+
+func TestCredentialSources(t *testing.T) {
+	ctx := context.Background()
+
+	t.Setenv("TEST_DB_USER", "app")
+	t.Setenv("TEST_DB_PASSWORD", "secret")
+	credentials, err := EnvCredentialSource{UserVar: "TEST_DB_USER", PasswordVar: "TEST_DB_PASSWORD"}.Fetch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, Credentials{User: "app", Password: "secret"}, credentials)
+
+	password := "first"
+	vault := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/v1/secret/data/learn_golang_gorm", r.URL.Path)
+		assert.Equal(t, "vault-token", r.Header.Get("X-Vault-Token"))
+		fmt.Fprintf(w, `{"data": {"data": {"username": "app", "password": %q}}}`, password)
+	}))
+	defer vault.Close()
+
+	source := VaultCredentialSource{Address: vault.URL, Token: "vault-token", Path: "secret/data/learn_golang_gorm"}
+	rotations := 0
+	rotating := NewRotatingCredentials(source)
+	rotating.OnRotate = func() { rotations++ }
+
+	credentials, err = rotating.Credentials(ctx, "localhost:3306")
+	assert.Nil(t, err)
+	assert.Equal(t, "first", credentials.Password)
+
+	assert.Nil(t, rotating.Refresh(ctx))
+	assert.Equal(t, 0, rotations)
+
+	password = "second"
+	assert.Nil(t, rotating.Refresh(ctx))
+	assert.Equal(t, 1, rotations)
+	credentials, _ = rotating.Credentials(ctx, "localhost:3306")
+	assert.Equal(t, "second", credentials.Password)
+
+	secretsManager := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "secretsmanager.GetSecretValue", r.Header.Get("X-Amz-Target"))
+		assert.True(t, strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/"))
+		fmt.Fprint(w, `{"SecretString": "{\"username\": \"app\", \"password\": \"rotated\"}"}`)
+	}))
+	defer secretsManager.Close()
+
+	aws := &AWSSecretsManagerSource{
+		SecretID: "learn_golang_gorm", Region: "ap-southeast-1", Endpoint: secretsManager.URL,
+		AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "secret", Now: time.Now,
+	}
+	credentials, err = aws.Fetch(ctx)
+	assert.Nil(t, err)
+	assert.Equal(t, Credentials{User: "app", Password: "rotated"}, credentials)
+}
+
+func TestLoadConfig(t *testing.T) {
+	config, err := ProfileConfig("prod")
+	assert.Nil(t, err)
+	assert.Equal(t, 200, config.MaxOpenConns)
+	assert.Equal(t, logger.Error, config.LogLevel)
+	assert.Equal(t, 5*time.Minute, config.RotationInterval)
+	assert.NotNil(t, config.CredentialSource)
+
+	t.Setenv("APP_ENV", "test")
+	t.Setenv("DB_REPLICAS", "replica-1:3306,replica-2:3306")
+	t.Setenv("DB_DRY_RUN", "true")
+	config, err = LoadConfig()
+	assert.Nil(t, err)
+	assert.Equal(t, 10, config.MaxOpenConns)
+	assert.Equal(t, logger.Warn, config.LogLevel)
+	assert.Equal(t, "", config.Password)
+	assert.Equal(t, EnvCredentialSource{UserVar: "DB_USER", PasswordVar: "DB_PASSWORD"}, config.CredentialSource)
+	assert.Equal(t, []string{"replica-1:3306", "replica-2:3306"}, config.Replicas)
+	assert.True(t, config.DryRun)
+
+	t.Setenv("DB_UNSAFE_LOG_PARAMS", "true")
+	_, err = LoadConfig()
+	assert.NotNil(t, err)
+	t.Setenv("APP_ENV", "dev")
+	config, err = LoadConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.UnsafeLogParams)
+	t.Setenv("DB_UNSAFE_LOG_PARAMS", "")
+
+	t.Setenv("APP_ENV", "qa")
+	_, err = LoadConfig()
+	assert.NotNil(t, err)
+
+	config = DefaultConfig()
+	config.CredentialSource = nil
+	_, err = Open(config)
+	assert.True(t, errors.Is(err, ErrMissingCredentials))
+	config.CredentialSource = EnvCredentialSource{UserVar: "DB_USER", PasswordVar: "DB_PASSWORD_NOT_SET"}
+	_, err = Open(config)
+	assert.True(t, errors.Is(err, ErrMissingCredentials))
+}
+
+func TestSchemaGate(t *testing.T) {
+	assert.Nil(t, Migrate(db))
+	assert.Nil(t, CheckSchemaVersion(db))
+
+	registered := migrations
+	defer func() { migrations = registered }()
+	migrations = append(Migrations(), Migration{Version: RequiredSchemaVersion() + 1, Name: "future"})
+
+	var versionErr *SchemaVersionError
+	err := CheckSchemaVersion(db)
+	assert.ErrorAs(t, err, &versionErr)
+	assert.Equal(t, RequiredSchemaVersion(), versionErr.Required)
+
+	_, err = SchemaGate(db, false)
+	assert.NotNil(t, err)
+
+	gateDB := OpenConnection()
+	readOnly, err := SchemaGate(gateDB, true)
+	assert.Nil(t, err)
+	assert.True(t, readOnly)
+
+	var user User
+	assert.Nil(t, gateDB.Take(&user, "id = ?", "1").Error)
+	assert.Equal(t, ErrReadOnlyMode, gateDB.Create(&UserLog{UserID: "1", Action: "Gate"}).Error)
+	assert.Equal(t, ErrReadOnlyMode, gateDB.Exec("delete from user_logs where id = 0").Error)
+}
+
+type RenameSample struct {
+	ID   int64  `gorm:"primary_key;column:id;autoIncrement"`
+	Note string `gorm:"column:note"`
+}
+
+func (s *RenameSample) TableName() string {
+	return "rename_samples"
+}
+
+func TestColumnRename(t *testing.T) {
+	assert.Nil(t, db.Migrator().DropTable(&RenameSample{}))
+	assert.Nil(t, db.Migrator().AutoMigrate(&RenameSample{}, &datafix.Run{}))
+	assert.Nil(t, db.Create(&RenameSample{Note: "before expand"}).Error)
+
+	rename := ColumnRename{Table: "rename_samples", From: "note", To: "remark", Definition: "varchar(255) NULL"}
+	assert.Nil(t, rename.ExpandMigration(0).Up(db))
+
+	renameDB := OpenConnection()
+	assert.Nil(t, rename.DualWrite(renameDB))
+
+	sample := RenameSample{Note: "dual write"}
+	assert.Nil(t, renameDB.Create(&sample).Error)
+	assert.Nil(t, renameDB.Model(&RenameSample{}).Where("note = ?", "dual write").Update("note", "updated").Error)
+
+	var remark string
+	assert.Nil(t, db.Table("rename_samples").Where("id = ?", sample.ID).Pluck("remark", &remark).Error)
+	assert.Equal(t, "updated", remark)
+
+	run, err := rename.Backfill(context.Background(), db, "test")
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), run.RowsChanged)
+
+	var remaining int64
+	assert.Nil(t, db.Table("rename_samples").Where("NOT (note <=> remark)").Count(&remaining).Error)
+	assert.Equal(t, int64(0), remaining)
+
+	assert.Nil(t, rename.ContractMigration(0).Up(db))
+	assert.False(t, db.Migrator().HasColumn(&RenameSample{}, "note"))
+}
+
+func TestCloneSubset(t *testing.T) {
+	assert.Nil(t, db.Exec("CREATE DATABASE IF NOT EXISTS learn_golang_gorm_clone").Error)
+	config := DefaultConfig()
+	config.Database = "learn_golang_gorm_clone"
+	target, err := Open(config)
+	assert.Nil(t, err)
+
+	report, err := CloneSubset(context.Background(), db, target, CloneOptions{Users: 3, BatchSize: 2, Anonymize: true})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), report.Users)
+
+	var users []User
+	assert.Nil(t, target.Order("id").Find(&users).Error)
+	assert.Len(t, users, 3)
+	for _, user := range users {
+		assert.Equal(t, "User", user.Name.FirstName)
+		assert.True(t, strings.HasSuffix(string(user.Email), "@example.com"))
+	}
+
+	var orphans int64
+	err = target.Table("wallets").Where("user_id NOT IN (?)", target.Table("users").Select("id")).Count(&orphans).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(0), orphans)
+
+	again, err := CloneSubset(context.Background(), db, target, CloneOptions{Users: 3, Anonymize: true})
+	assert.Nil(t, err)
+	assert.Equal(t, report, again)
+}
+
+func TestTableStats(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&TableStatSnapshot{}))
+
+	stats, err := RecordTableStats(context.Background(), db)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, stats)
+
+	tables := map[string]TableStat{}
+	for _, stat := range stats {
+		tables[stat.Table] = stat
+	}
+	assert.Contains(t, tables, "users")
+	assert.Nil(t, tables["users"].AutoIncrement)
+	assert.Equal(t, float64(1), tables["users"].Headroom())
+	assert.NotNil(t, tables["user_logs"].AutoIncrement)
+	assert.Greater(t, tables["user_logs"].Headroom(), 0.99)
+
+	growth, err := TableGrowthSince(context.Background(), db, time.Now().Add(-time.Hour))
+	assert.Nil(t, err)
+	assert.NotEmpty(t, growth)
+}
+
+func TestIndexReport(t *testing.T) {
+	findings := redundantIndexes([]tableIndex{
+		{table: "users", name: "PRIMARY", unique: true, columns: []string{"id"}},
+		{table: "users", name: "idx_users_id", unique: true, columns: []string{"id"}},
+		{table: "users", name: "idx_users_email", columns: []string{"email"}},
+		{table: "users", name: "idx_users_email_phone", columns: []string{"email", "phone"}},
+		{table: "users", name: "idx_users_phone", columns: []string{"phone"}},
+		{table: "users", name: "idx_users_phone_copy", columns: []string{"phone"}},
+	})
+
+	redundant := map[string]string{}
+	for _, finding := range findings {
+		redundant[finding.Index] = finding.DominantIndex
+	}
+	assert.Equal(t, map[string]string{
+		"idx_users_id":         "PRIMARY",
+		"idx_users_email":      "idx_users_email_phone",
+		"idx_users_phone_copy": "idx_users_phone",
+	}, redundant)
+
+	report, err := IndexReport(context.Background(), db)
+	assert.Nil(t, err)
+	for _, finding := range report {
+		assert.True(t, strings.HasPrefix(finding.SuggestedDrop, "ALTER TABLE `"+finding.Table+"` DROP INDEX"))
+	}
+}
+
+func TestArchive(t *testing.T) {
+	old := time.Now().Add(-100 * 24 * time.Hour).UnixMilli()
+	logs := []UserLog{
+		{UserID: "archive", Action: "Old", CreatedAt: old, UpdatedAt: old},
+		{UserID: "archive", Action: "Old", CreatedAt: old, UpdatedAt: old},
+		{UserID: "archive", Action: "New"},
+	}
+	assert.Nil(t, db.Create(&logs).Error)
+
+	policy := UserLogArchive
+	policy.BatchSize = 1
+	archived, err := Archive(context.Background(), db, policy, time.Now().Add(-90*24*time.Hour))
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, archived, int64(2))
+
+	var live []UserLog
+	assert.Nil(t, db.Where("user_id = ?", "archive").Find(&live).Error)
+	assert.Len(t, live, 1)
+
+	var all []UserLog
+	err = db.Scopes(IncludeArchived("user_logs")).Where("user_id = ?", "archive").Order("id").Find(&all).Error
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, len(all), 3)
+
+	completedAt := time.Now().Add(-400 * 24 * time.Hour)
+	todo := Todo{UserId: "1", Title: "Archived todo", CompletedAt: &completedAt}
+	assert.Nil(t, db.Create(&todo).Error)
+	_, err = Archive(context.Background(), db, CompletedTodoArchive, time.Now().AddDate(-1, 0, 0))
+	assert.Nil(t, err)
+
+	var found Todo
+	assert.NotNil(t, db.Take(&found, todo.ID).Error)
+	assert.Nil(t, db.Scopes(IncludeArchived("todos")).Take(&found, todo.ID).Error)
+	assert.Equal(t, "Archived todo", found.Title)
+}
+
+func TestColdStorage(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&ArchiveManifest{}))
+
+	ip, err := ParseINET("10.0.0.1")
+	assert.Nil(t, err)
+	old := time.Now().Add(-100 * 24 * time.Hour).UnixMilli()
+	log := UserLog{UserID: "cold", Action: "Old", IP: ip, CreatedAt: old, UpdatedAt: old}
+	assert.Nil(t, db.Create(&log).Error)
+	_, err = Archive(context.Background(), db, UserLogArchive, time.Now().Add(-90*24*time.Hour))
+	assert.Nil(t, err)
+
+	store := FileBlobStore{Dir: t.TempDir()}
+	manifests, err := ExportArchive(context.Background(), db, store, "user_logs", "id", 100)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, manifests)
+
+	var count int64
+	assert.Nil(t, db.Table("user_logs_archive").Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	for _, manifest := range manifests {
+		assert.Nil(t, RestoreArchive(context.Background(), db, store, manifest.ID))
+	}
+
+	var restored UserLog
+	assert.Nil(t, db.Table("user_logs_archive").Take(&restored, log.ID).Error)
+	assert.Equal(t, "cold", restored.UserID)
+	assert.Equal(t, log.IP, restored.IP)
+	assert.Equal(t, old, restored.CreatedAt)
+
+	assert.Nil(t, db.Exec("create table if not exists export_kinds (id int primary key, day date, at datetime, stamp timestamp null, duration time, data blob)").Error)
+	defer db.Migrator().DropTable("export_kinds")
+	kinds, err := exportColumnKinds(db, "export_kinds")
+	assert.Nil(t, err)
+	assert.Equal(t, map[string]string{"day": columnTime, "at": columnTime, "stamp": columnTime, "data": columnBinary}, kinds)
+}
+
+func TestBackupRestore(t *testing.T) {
+	levels := restoreLevels([]BackupTable{
+		{Name: "addresses", DependsOn: []string{"users"}},
+		{Name: "users"},
+		{Name: "user_like_product", DependsOn: []string{"users", "products"}},
+		{Name: "products"},
+	})
+	assert.Equal(t, 2, len(levels))
+	assert.Equal(t, 2, len(levels[0]))
+	assert.Equal(t, "users", levels[0][0].Name)
+	assert.Equal(t, "products", levels[0][1].Name)
+
+	var users, addresses int64
+	assert.Nil(t, db.Model(&User{}).Count(&users).Error)
+	assert.Nil(t, db.Model(&Address{}).Count(&addresses).Error)
+
+	dir := t.TempDir()
+	manifest, err := Backup(context.Background(), db, dir)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, manifest.Tables)
+
+	err = Restore(context.Background(), db, dir, RestoreOptions{})
+	assert.ErrorIs(t, err, ErrTableExists)
+
+	err = Restore(context.Background(), db, dir, RestoreOptions{Parallelism: 2, DropExisting: true})
+	assert.Nil(t, err)
+
+	var restoredUsers, restoredAddresses int64
+	assert.Nil(t, db.Model(&User{}).Count(&restoredUsers).Error)
+	assert.Nil(t, db.Model(&Address{}).Count(&restoredAddresses).Error)
+	assert.Equal(t, users, restoredUsers)
+	assert.Equal(t, addresses, restoredAddresses)
+}
+
+func TestCheckConsistency(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Coupon{}, &CouponRedemption{}))
+
+	coupon := Coupon{Code: "DRIFT" + time.Now().Format("20060102150405"), MaxUses: 10, UsedCount: 3}
+	assert.Nil(t, db.Create(&coupon).Error)
+
+	violations, err := CheckConsistency(context.Background(), db, false)
+	assert.Nil(t, err)
+	found := false
+	for _, violation := range violations {
+		if violation.Check == "coupon_used_count" && violation.Key == strconv.FormatInt(coupon.ID, 10) {
+			found = true
+			assert.Equal(t, "0", violation.Expected)
+			assert.Equal(t, "3", violation.Actual)
+			assert.False(t, violation.Repaired)
+		}
+	}
+	assert.True(t, found)
+
+	_, err = CheckConsistency(context.Background(), db, true)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Take(&coupon, coupon.ID).Error)
+	assert.Equal(t, int64(0), coupon.UsedCount)
+}
+
+func TestDoubleEntryAccounting(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&WalletTransaction{}, &LedgerHead{}, &Account{}, &JournalTransaction{}, &JournalEntry{})
+	assert.Nil(t, err)
+
+	_, err = PostJournal(db, "unbalanced", Debit(WalletAccount("DE1"), 100), Credit(WalletAccount("DE2"), 50))
+	assert.Equal(t, ErrUnbalancedJournal, err)
+
+	err = db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&[]Wallet{
+		{ID: "DE1", UserID: "1", Balance: 10000},
+		{ID: "DE2", UserID: "2", Balance: 10000},
+	}).Error
+	assert.Nil(t, err)
+
+	before, err := AccountBalance(context.Background(), db, WalletAccount("DE2").ID)
+	if err != nil {
+		assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	}
+	assert.Nil(t, TransferBalance(db, "DE1", "DE2", 2500))
+	after, err := AccountBalance(context.Background(), db, WalletAccount("DE2").ID)
+	assert.Nil(t, err)
+	assert.Equal(t, before+2500, after)
+
+	balance, err := TrialBalanceAt(context.Background(), db, time.Now().Add(time.Second))
+	assert.Nil(t, err)
+	assert.True(t, balance.Balanced())
+	assert.NotEmpty(t, balance.Lines)
+}
+
+func TestParseSchedule(t *testing.T) {
+	at := time.Date(2024, 1, 31, 10, 30, 0, 0, time.UTC)
+
+	schedule, err := ParseSchedule("*/15 9-17 * * 1-5")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 1, 31, 10, 45, 0, 0, time.UTC), schedule.Next(at))
+	assert.Equal(t, time.Date(2024, 2, 1, 9, 0, 0, 0, time.UTC), schedule.Next(time.Date(2024, 1, 31, 17, 45, 0, 0, time.UTC)))
+
+	schedule, err = ParseSchedule("@monthly")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC), schedule.Next(at))
+
+	schedule, err = ParseSchedule("0 0 29 2 *")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2028, 2, 29, 0, 0, 0, 0, time.UTC), schedule.Next(at.AddDate(0, 2, 0)))
+
+	schedule, err = ParseSchedule("@every 1h")
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 1, 31, 11, 0, 0, 0, time.UTC), schedule.Next(at))
+
+	_, err = ParseSchedule("60 * * * *")
+	assert.Equal(t, ErrInvalidSchedule, err)
+}
+
+func TestReportScheduler(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&ReportRun{}, &Wallet{}))
+
+	var delivered [][]byte
+	report := BalanceSummaryReport
+	report.Name = "balance_summary_" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	report.Schedule = "@every 1m"
+	scheduler := NewReportScheduler(db, ReportDeliveryFunc(func(ctx context.Context, run ReportRun, data []byte) error {
+		delivered = append(delivered, data)
+		return nil
+	}), report)
+
+	started, err := scheduler.RunDue(context.Background(), time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 0, started)
+
+	started, err = scheduler.RunDue(context.Background(), time.Now().Add(2*time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 1, started)
+	assert.Equal(t, 1, len(delivered))
+	assert.True(t, strings.HasPrefix(string(delivered[0]), "wallets,total_balance"))
+
+	var runs []ReportRun
+	assert.Nil(t, db.Where("report = ?", report.Name).Find(&runs).Error)
+	assert.Equal(t, 1, len(runs))
+	assert.Equal(t, ReportRunSucceeded, runs[0].Status)
+	assert.Equal(t, 1, runs[0].Rows)
+
+	claimed, err := scheduler.run(context.Background(), report, runs[0].ScheduledAt)
+	assert.Nil(t, err)
+	assert.False(t, claimed)
+	assert.Equal(t, 1, len(delivered))
+}
+
+func TestTimeSeries(t *testing.T) {
+	jakarta, err := time.LoadLocation("Asia/Jakarta")
+	assert.Nil(t, err)
+
+	buckets, err := SeriesRange{
+		From:     time.Date(2024, 3, 6, 20, 0, 0, 0, time.UTC),
+		To:       time.Date(2024, 3, 20, 0, 0, 0, 0, time.UTC),
+		Interval: IntervalWeek,
+		Location: jakarta,
+	}.Buckets()
+	assert.Nil(t, err)
+	assert.Equal(t, time.Date(2024, 3, 4, 0, 0, 0, 0, jakarta), buckets[0])
+	assert.Equal(t, 4, len(buckets))
+
+	from := time.Date(2001, 5, 1, 0, 0, 0, 0, jakarta)
+	ip, err := ParseINET("10.0.0.2")
+	assert.Nil(t, err)
+	for _, log := range []UserLog{
+		{UserID: "series-1", Action: "Login", IP: ip, CreatedAt: from.Add(2 * time.Hour).UnixMilli()},
+		{UserID: "series-1", Action: "Logout", IP: ip, CreatedAt: from.Add(3 * time.Hour).UnixMilli()},
+		{UserID: "series-2", Action: "Login", IP: ip, CreatedAt: from.Add(26 * time.Hour).UnixMilli()},
+	} {
+		log := log
+		assert.Nil(t, db.Create(&log).Error)
+	}
+
+	series, err := ActiveUsersSeries(context.Background(), db, SeriesRange{
+		From:     from,
+		To:       from.AddDate(0, 0, 2),
+		Interval: IntervalDay,
+		Location: jakarta,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(series.Points))
+	assert.Equal(t, int64(1), series.Points[0].Value)
+	assert.Equal(t, int64(1), series.Points[1].Value)
+	assert.Equal(t, int64(0), series.Points[2].Value)
+}
+
+func TestCohortRetention(t *testing.T) {
+	signup := time.Date(2002, 3, 5, 10, 0, 0, 0, time.Local)
+	users := []User{
+		{ID: "cohort-1", Password: "rahasia", Name: Name{FirstName: "Cohort 1"}},
+		{ID: "cohort-2", Password: "rahasia", Name: Name{FirstName: "Cohort 2"}},
+	}
+	assert.Nil(t, db.Clauses(clause.OnConflict{DoNothing: true}).Create(&users).Error)
+	assert.Nil(t, db.Exec("update users set created_at = ? where id in ?", signup, []string{"cohort-1", "cohort-2"}).Error)
+
+	ip, err := ParseINET("10.0.0.3")
+	assert.Nil(t, err)
+	for _, log := range []UserLog{
+		{UserID: "cohort-1", Action: "Login", IP: ip, CreatedAt: signup.Add(time.Hour).UnixMilli()},
+		{UserID: "cohort-2", Action: "Login", IP: ip, CreatedAt: signup.Add(2 * time.Hour).UnixMilli()},
+		{UserID: "cohort-1", Action: "Login", IP: ip, CreatedAt: signup.AddDate(0, 0, 8).UnixMilli()},
+	} {
+		log := log
+		assert.Nil(t, db.Create(&log).Error)
+	}
+
+	matrix, err := CohortRetention(context.Background(), db, signup.AddDate(0, 0, -1), signup.AddDate(0, 0, 1), 4)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(matrix.Cohorts))
+	cohort := matrix.Cohorts[0]
+	assert.Equal(t, int64(2), cohort.Users)
+	assert.Equal(t, []int64{2, 1, 0, 0}, cohort.Active)
+	assert.Equal(t, 0.5, cohort.Retention(1))
+
+	_, err = CohortRetention(context.Background(), db, signup, signup, -1)
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+}
+
+func TestFunnelQuery(t *testing.T) {
+	suffix := strconv.FormatInt(time.Now().UnixNano(), 10)
+	view, cart, pay := Action("View"+suffix), Action("Cart"+suffix), Action("Pay"+suffix)
+	start := time.Now().Add(-time.Hour)
+
+	ip, err := ParseINET("10.0.0.4")
+	assert.Nil(t, err)
+	for _, log := range []UserLog{
+		{UserID: "funnel-1", Action: string(view), CreatedAt: start.UnixMilli()},
+		{UserID: "funnel-1", Action: string(cart), CreatedAt: start.Add(time.Minute).UnixMilli()},
+		{UserID: "funnel-1", Action: string(pay), CreatedAt: start.Add(2 * time.Minute).UnixMilli()},
+		{UserID: "funnel-2", Action: string(view), CreatedAt: start.UnixMilli()},
+		{UserID: "funnel-2", Action: string(pay), CreatedAt: start.Add(time.Minute).UnixMilli()},
+		{UserID: "funnel-2", Action: string(cart), CreatedAt: start.Add(20 * time.Minute).UnixMilli()},
+		{UserID: "funnel-3", Action: string(cart), CreatedAt: start.UnixMilli()},
+	} {
+		log := log
+		log.IP = ip
+		assert.Nil(t, db.Create(&log).Error)
+	}
+
+	funnel, err := FunnelQuery(context.Background(), db, []Action{view, cart, pay}, 10*time.Minute, start.Add(-time.Minute), time.Now())
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(funnel.Steps))
+	assert.Equal(t, int64(2), funnel.Steps[0].Users)
+	assert.Equal(t, int64(1), funnel.Steps[1].Users)
+	assert.Equal(t, int64(1), funnel.Steps[2].Users)
+	assert.Equal(t, 0.5, funnel.Steps[2].Conversion)
+	assert.Equal(t, 1.0, funnel.Steps[2].StepConversion)
+
+	_, err = FunnelQuery(context.Background(), db, nil, time.Minute, start, time.Now())
+	assert.Equal(t, ErrEmptyFunnel, err)
+}
+
+func TestLeaderboard(t *testing.T) {
+	users := []User{
+		{ID: "leader-1", Password: "rahasia", Name: Name{FirstName: "Leader", LastName: "One"}},
+		{ID: "leader-2", Password: "rahasia", Name: Name{FirstName: "Leader", LastName: "Two"}},
+		{ID: "leader-3", Password: "rahasia", Name: Name{FirstName: "Leader", LastName: "Three"}},
+	}
+	assert.Nil(t, db.Clauses(clause.OnConflict{DoNothing: true}).Create(&users).Error)
+	assert.Nil(t, db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&[]Wallet{
+		{ID: "LB1", UserID: "leader-1", Balance: 9000000000000},
+		{ID: "LB2", UserID: "leader-2", Balance: 9000000000000},
+		{ID: "LB3", UserID: "leader-3", Balance: 8000000000000},
+	}).Error)
+
+	entries, err := WalletBalanceLeaderboard.Page(context.Background(), db, 1, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(entries))
+	assert.Equal(t, "leader-1", entries[0].ID)
+	assert.Equal(t, "Leader One", entries[0].Name)
+	assert.Equal(t, int64(1), entries[1].Ranking)
+	assert.Equal(t, int64(3), entries[2].Ranking)
+
+	dense := WalletBalanceLeaderboard
+	dense.Ranking = RankDense
+	around, err := dense.AroundMe(context.Background(), db, "leader-2", 1)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(around))
+	assert.Equal(t, "leader-3", around[2].ID)
+	assert.Equal(t, int64(2), around[2].Ranking)
+
+	_, err = WalletBalanceLeaderboard.AroundMe(context.Background(), db, "nobody", 1)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+
+func TestTodoCompletionDistribution(t *testing.T) {
+	assert.Nil(t, db.Unscoped().Where("title = ?", "Distribution").Delete(&Todo{}).Error)
+
+	created := time.Date(2090, 1, 1, 0, 0, 0, 0, time.Local)
+	for i := 1; i <= 10; i++ {
+		completed := created.Add(time.Duration(i*10) * time.Second)
+		todo := Todo{UserId: "1", Title: "Distribution", CompletedAt: &completed}
+		todo.CreatedAt = created
+		assert.Nil(t, db.Create(&todo).Error)
+	}
+
+	distribution, err := TodoCompletionDistribution(context.Background(), db, created, 3)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(10), distribution.Count)
+	assert.Equal(t, 10.0, distribution.Min)
+	assert.Equal(t, 55.0, distribution.Mean)
+	assert.Equal(t, 50.0, distribution.P50)
+	assert.Equal(t, 90.0, distribution.P90)
+	assert.Equal(t, 100.0, distribution.P99)
+	assert.Equal(t, []int64{3, 3, 4}, []int64{
+		distribution.Histogram[0].Count, distribution.Histogram[1].Count, distribution.Histogram[2].Count,
+	})
+}
+
+func TestPivotQuery(t *testing.T) {
+	assert.Nil(t, db.Unscoped().Where("user_id IN ?", []string{"pivot-1", "pivot-2"}).Delete(&Todo{}).Error)
+	now := time.Now()
+	for _, todo := range []Todo{
+		{UserId: "pivot-1", Title: "Open"},
+		{UserId: "pivot-1", Title: "Done", CompletedAt: &now},
+		{UserId: "pivot-1", Title: "Done too", CompletedAt: &now},
+		{UserId: "pivot-2", Title: "Open"},
+	} {
+		todo := todo
+		assert.Nil(t, db.Create(&todo).Error)
+	}
+
+	query := TodosPerUserByStatus
+	source := query.Source
+	query.Source = func(db *gorm.DB) *gorm.DB {
+		return source(db).Where("user_id IN ?", []string{"pivot-1", "pivot-2"})
+	}
+	result, err := query.Run(context.Background(), db)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"row", "completed", "open"}, result.Columns)
+	assert.Equal(t, 2, len(result.Rows))
+	assert.Equal(t, "pivot-1", *result.Rows[0][0])
+	assert.Equal(t, "2", *result.Rows[0][1])
+	assert.Equal(t, "0", *result.Rows[1][1])
+
+	query.MaxColumns = 1
+	_, err = query.Run(context.Background(), db)
+	assert.Equal(t, ErrTooManyPivotColumns, err)
+}
+
+func TestSessionStore(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Session{}))
+	store := NewSessionStore(db)
+	store.RefreshInterval = 0
+	userID := "session-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+
+	ctx := WithClientInfo(context.Background(), "10.0.0.5", "laptop-browser")
+	laptop, session, err := store.Create(ctx, userID, "Laptop")
+	assert.Nil(t, err)
+	assert.Equal(t, "laptop-browser", session.UserAgent)
+	phone, _, err := store.Create(context.Background(), userID, "Phone")
+	assert.Nil(t, err)
+
+	ctx = WithClientInfo(context.Background(), "10.0.0.6", "laptop-browser")
+	validated, err := store.Validate(ctx, laptop)
+	assert.Nil(t, err)
+	assert.Equal(t, "10.0.0.6", validated.LastIP.String())
+	assert.True(t, validated.ExpiresAt.After(session.ExpiresAt))
+
+	sessions, err := store.Active(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(sessions.Rows))
+	assert.False(t, sessions.Truncated)
+	assert.Equal(t, "Laptop", sessions.Rows[0].DeviceName)
+
+	store.MaxRows = 1
+	sessions, err = store.Active(context.Background(), userID)
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(sessions.Rows))
+	assert.True(t, sessions.Truncated)
+	store.MaxRows = 0
+
+	revoked, err := store.RevokeAll(context.Background(), userID, phone)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), revoked)
+	_, err = store.Validate(context.Background(), laptop)
+	assert.Equal(t, ErrSessionExpired, err)
+	_, err = store.Validate(context.Background(), phone)
+	assert.Nil(t, err)
+}
+
+func TestAPIKeys(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&APIKey{}))
+	service := NewAPIKeyService(db)
+
+	token, key, err := service.Issue(context.Background(), "reporting", "ops", []string{"wallets:read"}, time.Hour)
+	assert.Nil(t, err)
+	assert.True(t, strings.HasPrefix(token, "lgg_"))
+	assert.NotEqual(t, token, key.KeyHash)
+
+	handler := service.Middleware("wallets:read", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Nil(t, RequireScope(r.Context(), "wallets:read"))
+		assert.Equal(t, ErrMissingScope, RequireScope(r.Context(), "wallets:write"))
+		w.WriteHeader(http.StatusNoContent)
+	}))
+
+	request := httptest.NewRequest(http.MethodGet, "/wallets", nil)
+	request.Header.Set("X-API-Key", token)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusNoContent, recorder.Code)
+
+	recorder = httptest.NewRecorder()
+	service.Middleware("wallets:write", handler).ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	assert.Nil(t, db.Migrator().AutoMigrate(&ExportJob{}))
+	readToken, readKey, err := service.Issue(context.Background(), "exports", "ops", []string{ScopeExportsRead}, time.Hour)
+	assert.Nil(t, err)
+	exports := service.Middleware(ScopeExportsRead, NewExportHandler(db, FileBlobStore{Dir: t.TempDir()}))
+	exportRequest := httptest.NewRequest(http.MethodPost, "/?export=user_logs", nil)
+	exportRequest.Header.Set("X-API-Key", readToken)
+	recorder = httptest.NewRecorder()
+	exports.ServeHTTP(recorder, exportRequest)
+	assert.Equal(t, http.StatusForbidden, recorder.Code)
+
+	repository := NewGormRepository[Todo, uint](db, TodoFilterFields)
+	repository.ReadScope, repository.WriteScope = "todos:read", "todos:write"
+	ctx := WithAPIKey(context.Background(), readKey)
+	_, err = repository.List(ctx, 1, 10, Filter{})
+	assert.Equal(t, ErrMissingScope, err)
+	assert.Equal(t, ErrMissingScope, repository.Create(ctx, &Todo{UserId: "scopes", Title: "Forbidden"}))
+
+	assert.Nil(t, service.FlushUsage(context.Background()))
+	assert.Nil(t, db.Take(&key, key.ID).Error)
+	assert.NotNil(t, key.LastUsedAt)
+
+	rotatedToken, rotated, err := service.Rotate(context.Background(), key.ID, 0, -time.Second)
+	assert.Nil(t, err)
+	assert.Equal(t, key.ID, *rotated.RotatedFrom)
+	_, err = service.Authenticate(context.Background(), token)
+	assert.Equal(t, ErrInvalidAPIKey, err)
+	_, err = service.Authenticate(context.Background(), rotatedToken)
+	assert.Nil(t, err)
+
+	assert.Nil(t, service.Revoke(context.Background(), rotated.ID))
+	recorder = httptest.NewRecorder()
+	request.Header.Set("X-API-Key", rotatedToken)
+	handler.ServeHTTP(recorder, request)
+	assert.Equal(t, http.StatusUnauthorized, recorder.Code)
+}
+
+func TestAuthenticateLockout(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Session{}, &LoginAttempt{}, &AccountLockout{}, &AuditLog{}, &LedgerHead{}))
+	userID := "login-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	assert.Nil(t, db.Create(&User{ID: userID, Password: "rahasia", Name: Name{FirstName: "Login"}}).Error)
+
+	authenticator := NewAuthenticator(db)
+	authenticator.Policy.MaxFailures = 3
+	ctx := WithClientInfo(context.Background(), "10.0.0.7", "test")
+
+	token, _, err := authenticator.Authenticate(ctx, userID, "rahasia", "Laptop")
+	assert.Nil(t, err)
+	assert.NotEmpty(t, token)
+
+	for i := 0; i < 3; i++ {
+		_, _, err = authenticator.Authenticate(ctx, userID, "salah", "Laptop")
+		assert.Equal(t, ErrInvalidCredentials, err)
+	}
+	_, _, err = authenticator.Authenticate(ctx, userID, "rahasia", "Laptop")
+	var locked *AccountLockedError
+	assert.ErrorAs(t, err, &locked)
+
+	lockouts, err := authenticator.Locked(context.Background())
+	assert.Nil(t, err)
+	assert.NotEmpty(t, lockouts)
+
+	assert.Nil(t, authenticator.Unlock(context.Background(), userID, "admin"))
+	_, _, err = authenticator.Authenticate(ctx, userID, "rahasia", "Laptop")
+	assert.Nil(t, err)
+
+	authenticator.Policy.MaxIPFailures = 1
+	_, _, err = authenticator.Authenticate(ctx, userID, "rahasia", "Laptop")
+	assert.Equal(t, ErrTooManyAttempts, err)
+}
+
+func TestApproval(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&PendingChange{}, &WalletTransaction{}, &AuditLog{}, &LedgerHead{},
+		&Account{}, &JournalTransaction{}, &JournalEntry{})
+	assert.Nil(t, err)
+	assert.Nil(t, db.Clauses(clause.OnConflict{UpdateAll: true}).Create(&[]Wallet{
+		{ID: "AP1", UserID: "1", Balance: 50000000},
+		{ID: "AP2", UserID: "2", Balance: 0},
+	}).Error)
+
+	change, err := TransferWithApproval(context.Background(), db, "AP1", "AP2", 20000000, "alice")
+	assert.Nil(t, err)
+	assert.NotNil(t, change)
+	assert.Equal(t, ChangePending, change.Status)
+
+	_, err = Approve(context.Background(), db, change.ID, "alice")
+	assert.Equal(t, ErrSelfApproval, err)
+
+	approved, err := Approve(context.Background(), db, change.ID, "bob")
+	assert.Nil(t, err)
+	assert.Equal(t, ChangeExecuted, approved.Status)
+
+	var wallet Wallet
+	assert.Nil(t, db.Take(&wallet, "id = ?", "AP2").Error)
+	assert.Equal(t, int64(20000000), wallet.Balance)
+
+	_, err = Approve(context.Background(), db, change.ID, "bob")
+	assert.Equal(t, ErrChangeNotPending, err)
+
+	failing, err := RequestChange(context.Background(), db, OperationTransfer, TransferPayload{From: "AP2", To: "AP1", Amount: 90000000}, "alice")
+	assert.Nil(t, err)
+	failed, err := Approve(context.Background(), db, failing.ID, "bob")
+	assert.Equal(t, ErrInsufficientBalance, err)
+	assert.Equal(t, ChangeFailed, failed.Status)
+
+	_, err = RequestChange(context.Background(), db, OperationHardDelete, HardDeletePayload{Table: "mysql.user", ID: 1}, "alice")
+	assert.Nil(t, err)
+}
+
+func TestQuotas(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&QuotaOverride{}, &QuotaUsage{}))
+	assert.Nil(t, db.Where("user_id = ?", "quota").Delete(&Address{}).Error)
+	assert.Nil(t, db.Where("user_id = ?", "quota").Delete(&QuotaUsage{}).Error)
+	assert.Nil(t, db.Where("user_id = ?", "quota").Delete(&QuotaOverride{}).Error)
+	assert.Nil(t, db.Where("user_id = ?", "quota").Delete(&UserLog{}).Error)
+
+	quotaDB := OpenConnection()
+	assert.Nil(t, EnforceQuotas(quotaDB,
+		Quota{Name: QuotaAddresses, Table: "addresses", Limit: 2},
+		Quota{Name: QuotaDailyLogs, Table: "user_logs", Limit: 3, Daily: true},
+	))
+
+	assert.Nil(t, quotaDB.Create(&[]Address{{UserId: "quota", Address: "One"}, {UserId: "quota", Address: "Two"}}).Error)
+	err := quotaDB.Create(&Address{UserId: "quota", Address: "Three"}).Error
+	var quotaErr *QuotaExceededError
+	assert.ErrorAs(t, err, &quotaErr)
+	assert.Equal(t, int64(2), quotaErr.Limit)
+	assert.ErrorIs(t, err, ErrQuotaExceeded)
+
+	assert.Nil(t, SetQuotaOverride(context.Background(), quotaDB, "quota", QuotaAddresses, 3))
+	assert.Nil(t, quotaDB.Create(&Address{UserId: "quota", Address: "Three"}).Error)
+	assert.Nil(t, SetQuotaOverride(context.Background(), quotaDB, "quota", QuotaAddresses, -1))
+	assert.Nil(t, quotaDB.Create(&Address{UserId: "quota", Address: "Four"}).Error)
+
+	assert.Nil(t, quotaDB.Create(&[]UserLog{{UserID: "quota", Action: "A"}, {UserID: "quota", Action: "B"}}).Error)
+	assert.ErrorIs(t, quotaDB.Create(&[]UserLog{{UserID: "quota", Action: "C"}, {UserID: "quota", Action: "D"}}).Error, ErrQuotaExceeded)
+	assert.Nil(t, quotaDB.Create(&UserLog{UserID: "quota", Action: "C"}).Error)
+	assert.ErrorIs(t, quotaDB.Create(&UserLog{UserID: "quota", Action: "D"}).Error, ErrQuotaExceeded)
+
+	var logs int64
+	assert.Nil(t, db.Model(&UserLog{}).Where("user_id = ?", "quota").Count(&logs).Error)
+	assert.Equal(t, int64(3), logs)
+}
+
+func TestMaintenance(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Setting{}))
+
+	maintenanceDB := OpenConnection()
+	maintenance := NewMaintenance(maintenanceDB)
+	assert.Nil(t, maintenance.Gate(maintenanceDB))
+	defer maintenance.Set(context.Background(), false)
+
+	assert.Nil(t, maintenance.Set(context.Background(), true))
+	assert.True(t, maintenance.Enabled())
+
+	var user User
+	assert.Nil(t, maintenanceDB.Take(&user, "id = ?", "1").Error)
+	assert.Equal(t, ErrMaintenanceMode, maintenanceDB.Create(&UserLog{UserID: "1", Action: "Maintenance"}).Error)
+	assert.Equal(t, ErrMaintenanceMode, maintenanceDB.Exec("delete from user_logs where id = 0").Error)
+	assert.Nil(t, Migrate(maintenanceDB))
+
+	bypass := maintenanceDB.WithContext(WithMaintenanceBypass(context.Background()))
+	assert.Nil(t, bypass.Exec("delete from user_logs where id = 0").Error)
+
+	other := NewMaintenance(db)
+	assert.Nil(t, other.Refresh(context.Background()))
+	assert.True(t, other.Enabled())
+
+	assert.Nil(t, maintenance.Set(context.Background(), false))
+	assert.Nil(t, maintenanceDB.Exec("delete from user_logs where id = 0").Error)
+}
+
+func TestHTTPStatus(t *testing.T) {
+	assert.Equal(t, http.StatusNotFound, HTTPStatus(fmt.Errorf("get: %w", gorm.ErrRecordNotFound)))
+	assert.Equal(t, http.StatusTooManyRequests, HTTPStatus(&QuotaExceededError{Quota: QuotaTodos, Limit: 1}))
+	assert.Equal(t, http.StatusTooManyRequests, HTTPStatus(&AccountLockedError{Until: time.Now()}))
+	assert.Equal(t, http.StatusConflict, HTTPStatus(&mysqlDriver.MySQLError{Number: 1062}))
+	assert.Equal(t, http.StatusConflict, HTTPStatus(ErrVersionConflict))
+	assert.Equal(t, http.StatusServiceUnavailable, HTTPStatus(ErrMaintenanceMode))
+
+	recorder := httptest.NewRecorder()
+	WriteError(recorder, sql.ErrConnDone)
+	assert.Equal(t, http.StatusInternalServerError, recorder.Code)
+	assert.NotContains(t, recorder.Body.String(), sql.ErrConnDone.Error())
+}
+
+func TestOpenAPI(t *testing.T) {
+	registered := apiResources
+	defer func() { apiResources = registered }()
+	RegisterAPIResource(APIResource{Path: "/todos", Model: &Todo{}, Filters: []string{"user_id"}})
+
+	server := httptest.NewServer(OpenAPIHandler("todos", "1.0.0"))
+	defer server.Close()
+	response, err := http.Get(server.URL + "/openapi.json")
+	assert.Nil(t, err)
+	defer response.Body.Close()
+
+	var spec struct {
+		OpenAPI    string                            `json:"openapi"`
+		Paths      map[string]map[string]interface{} `json:"paths"`
+		Components map[string]map[string]interface{} `json:"components"`
+	}
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&spec))
+	assert.Equal(t, "3.0.3", spec.OpenAPI)
+	assert.Contains(t, spec.Paths["/todos"], "get")
+	assert.Contains(t, spec.Paths["/todos"], "post")
+	assert.Contains(t, spec.Paths["/todos/{id}"], "delete")
+	assert.Contains(t, spec.Components["schemas"], "Error")
+
+	todo := spec.Components["schemas"]["Todo"].(map[string]interface{})["properties"].(map[string]interface{})
+	assert.Contains(t, todo, "ID")
+	assert.Contains(t, todo, "Title")
+	assert.Equal(t, map[string]interface{}{"type": "string", "format": "date-time", "nullable": true}, todo["DeletedAt"])
+	assert.Equal(t, "array", todo["Children"].(map[string]interface{})["type"])
+}
+
+func TestChangeFeed(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&ChangeEvent{}, &Session{}))
+
+	feedDB := OpenConnection()
+	sessions := NewSessionStore(feedDB)
+	feed := NewChangeFeed(feedDB, sessions)
+	assert.Nil(t, feed.Track(feedDB, "todos"))
+
+	var cursor int64
+	assert.Nil(t, db.Model(&ChangeEvent{}).Select("coalesce(max(id), 0)").Scan(&cursor).Error)
+
+	todo := Todo{UserId: "feed", Title: "Feed"}
+	assert.Nil(t, feedDB.Create(&todo).Error)
+	assert.Nil(t, feedDB.Model(&todo).Update("title", "Feed updated").Error)
+	assert.Nil(t, feedDB.Create(&UserLog{UserID: "feed", Action: "Untracked"}).Error)
+
+	events, err := feed.Events(context.Background(), "feed", cursor)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, len(events))
+	assert.Equal(t, ChangeCreated, events[0].Action)
+	assert.Equal(t, ChangeUpdated, events[1].Action)
+	assert.Equal(t, fmt.Sprint(todo.ID), events[1].EntityID)
+	assert.Contains(t, events[1].Payload, "Feed updated")
+
+	assert.Nil(t, feedDB.Model(&Todo{}).Where("id = ?", todo.ID).Update("title", "Feed without model").Error)
+	assert.NotNil(t, feedDB.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&Todo{UserId: "feed", Title: "Feed rolled back"}).Error
+		if err != nil {
+			return err
+		}
+		return errors.New("roll back")
+	}))
+	all, err := feed.Events(context.Background(), "feed", cursor)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, len(all))
+	assert.Equal(t, fmt.Sprint(todo.ID), all[2].EntityID)
+	assert.Contains(t, all[2].Payload, "Feed without model")
+
+	token, _, err := sessions.Create(context.Background(), "feed", "test")
+	assert.Nil(t, err)
+	server := httptest.NewServer(feed)
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	request, _ := http.NewRequestWithContext(ctx, http.MethodGet, server.URL, nil)
+	request.Header.Set("Authorization", "Bearer "+token)
+	request.Header.Set("Last-Event-ID", strconv.FormatInt(events[0].ID, 10))
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, "text/event-stream", response.Header.Get("Content-Type"))
+
+	reader := bufio.NewReader(response.Body)
+	line, err := reader.ReadString('\n')
+	assert.Nil(t, err)
+	assert.Equal(t, fmt.Sprintf("id: %d\n", events[1].ID), line)
+	line, _ = reader.ReadString('\n')
+	assert.Equal(t, "event: todos.updated\n", line)
+}
+
+func TestBatch(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Session{}))
+	assert.Nil(t, db.Clauses(clause.OnConflict{UpdateAll: true}).Omit(clause.Associations).Create(&User{ID: "batch", Password: "secret"}).Error)
+	address := Address{UserId: "batch", Address: "Old"}
+	assert.Nil(t, db.Create(&address).Error)
+
+	operations := []BatchOperation{
+		{Op: BatchCreateTodo, Args: json.RawMessage(`{"title": "Batch"}`)},
+		{Op: BatchUpdateAddress, Args: json.RawMessage(fmt.Sprintf(`{"id": %d, "address": "New"}`, address.ID))},
+		{Op: BatchDeleteLike, Args: json.RawMessage(`{"product_id": "missing"}`)},
+	}
+	results, err := ExecuteBatch(context.Background(), db, "batch", operations)
+	assert.ErrorIs(t, err, gorm.ErrRecordNotFound)
+	assert.Equal(t, http.StatusFailedDependency, results[0].Status)
+	assert.Equal(t, http.StatusNotFound, results[2].Status)
+	assert.Nil(t, db.Take(&address, address.ID).Error)
+	assert.Equal(t, "Old", address.Address)
+
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+	results, err = ExecuteBatch(canceled, db, "batch", operations[:1])
+	assert.ErrorIs(t, err, context.Canceled)
+	assert.Equal(t, err.Error(), results[0].Error)
+
+	token, _, err := NewSessionStore(db).Create(context.Background(), "batch", "test")
+	assert.Nil(t, err)
+	server := httptest.NewServer(NewBatchHandler(db, NewSessionStore(db)))
+	defer server.Close()
+
+	body, _ := json.Marshal(map[string]interface{}{"operations": operations[:2]})
+	request, _ := http.NewRequest(http.MethodPost, server.URL+"/batch", bytes.NewReader(body))
+	request.Header.Set("Authorization", "Bearer "+token)
+	response, err := http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	defer response.Body.Close()
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+
+	var answer struct {
+		Committed bool          `json:"committed"`
+		Results   []BatchResult `json:"results"`
+	}
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&answer))
+	assert.True(t, answer.Committed)
+	assert.Equal(t, 2, len(answer.Results))
+	assert.Nil(t, db.Take(&address, address.ID).Error)
+	assert.Equal(t, "New", address.Address)
+
+	_, err = ExecuteBatch(context.Background(), db, "batch", []BatchOperation{{Op: "drop_table"}})
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+}
+
+func TestExportJobs(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&ExportJob{}))
+	ip, err := ParseINET("10.0.0.7")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Create(&UserLog{UserID: "export", Action: "Export", IP: ip}).Error)
+
+	store := FileBlobStore{Dir: t.TempDir()}
+	job, err := RequestExport(context.Background(), db, "user_logs", "tester")
+	assert.Nil(t, err)
+	_, err = OpenExport(context.Background(), db, store, job.ID)
+	assert.Equal(t, ErrExportNotReady, err)
+	_, err = RequestExport(context.Background(), db, "users", "tester")
+	assert.ErrorIs(t, err, ErrUnknownExport)
+
+	worker := NewExportWorker(db, store)
+	worker.BatchSize = 2
+	for {
+		worked, err := worker.RunOnce(context.Background())
+		assert.Nil(t, err)
+		if !worked {
+			break
+		}
+	}
+
+	assert.Nil(t, db.Take(&job, job.ID).Error)
+	assert.Equal(t, ExportCompleted, job.Status)
+	assert.Equal(t, job.TotalRows, job.ExportedRows)
+	assert.Equal(t, float64(1), job.Progress())
+
+	reader, err := OpenExport(context.Background(), db, store, job.ID)
+	assert.Nil(t, err)
+	records, err := csv.NewReader(reader).ReadAll()
+	reader.Close()
+	assert.Nil(t, err)
+	assert.Equal(t, "id", records[0][0])
+	assert.Equal(t, int(job.TotalRows)+1, len(records))
+	ipColumn := slices.Index(records[0], "ip")
+	assert.Equal(t, "10.0.0.7", records[len(records)-1][ipColumn])
+
+	assert.Nil(t, db.Model(&job).Update("expires_at", time.Now().Add(-time.Minute)).Error)
+	expired, err := worker.Expire(context.Background())
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, expired, 1)
+	_, err = OpenExport(context.Background(), db, store, job.ID)
+	assert.Equal(t, ErrExportExpired, err)
+
+	startedAt := time.Now().Add(-2 * worker.Timeout)
+	stale := ExportJob{Export: "user_logs", RequestedBy: "tester", Status: ExportRunning, StartedAt: &startedAt}
+	assert.Nil(t, db.Create(&stale).Error)
+	failing := NewExportWorker(db, failingBlobStore{store})
+	worked, err := failing.RunOnce(context.Background())
+	assert.True(t, worked)
+	assert.NotNil(t, err)
+	assert.Nil(t, db.Take(&stale, stale.ID).Error)
+	assert.Equal(t, ExportFailed, stale.Status)
+}
+
+// failingBlobStore gives up after reading the first bytes of a file.
+type failingBlobStore struct {
+	BlobStore
+}
+
+func (s failingBlobStore) Put(ctx context.Context, key string, data io.Reader) error {
+	_, err := data.Read(make([]byte, 1))
+	if err != nil {
+		return err
+	}
+	return errors.New("store is full")
+}
+
+func TestImport(t *testing.T) {
+	assert.Nil(t, db.Where("id LIKE ?", "IMP%").Delete(&Product{}).Error)
+	assert.Nil(t, db.Create(&Product{ID: "IMP1", Name: "Old", Price: 1}).Error)
+
+	file := strings.NewReader("id,name,price,stock\n" +
+		"IMP1,Keyboard,100000,5\n" +
+		"IMP2,Mouse,50000,10\n" +
+		"IMP3,,20000,1\n" +
+		"IMP4,Cable,cheap,1\n" +
+		"IMP2,Mouse Pad,10000,3\n")
+	plan := ProductImportPlan
+	plan.BatchSize = 2
+	report, err := plan.Import(context.Background(), db, file, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(5), report.Staged)
+	assert.Equal(t, int64(3), report.Invalid)
+	assert.Equal(t, int64(2), report.Merged)
+	assert.Equal(t, []ImportError{
+		{Line: 4, Message: "name is required"},
+		{Line: 5, Message: "price must be a whole number"},
+		{Line: 6, Message: "duplicate of an earlier row"},
+	}, report.Errors)
+	assert.False(t, db.Migrator().HasTable(report.Staging))
+
+	var products []Product
+	assert.Nil(t, db.Where("id LIKE ?", "IMP%").Order("id").Find(&products).Error)
+	assert.Equal(t, 2, len(products))
+	assert.Equal(t, "Keyboard", products[0].Name)
+	assert.Equal(t, int64(100000), products[0].Price)
+	assert.Equal(t, "Mouse", products[1].Name)
+
+	_, err = plan.Import(context.Background(), db, strings.NewReader("name,id\n"), false)
+	assert.ErrorIs(t, err, ErrImportHeader)
+}
+
+func TestImportLoadData(t *testing.T) {
+	file := strings.NewReader("user_id,action,ip,user_agent,created_at\r\n" +
+		"load-data,\"Import, first\",192.0.2.1,curl,1700000000000\r\n" +
+		"load-data,Import,,,\r\n" +
+		"load-data,Import,not an ip,,\r\n" +
+		",Import,,,1700000000000\r\n")
+	report, err := UserLogImportPlan.Import(context.Background(), db, file, false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), report.Staged)
+	assert.Equal(t, int64(2), report.Merged)
+	assert.Equal(t, []ImportError{
+		{Line: 4, Message: "ip must be an address"},
+		{Line: 5, Message: "user_id is required"},
+	}, report.Errors)
+
+	var log UserLog
+	assert.Nil(t, db.Where("user_id = ? AND created_at = ?", "load-data", 1700000000000).Last(&log).Error)
+	assert.Equal(t, "Import, first", log.Action)
+	assert.Equal(t, "192.0.2.1", log.IP.String())
+	assert.Equal(t, "curl", log.UserAgent)
+
+	plan := UserLogImportPlan
+	plan.LoadData = false
+	report, err = plan.Import(context.Background(), db, strings.NewReader("user_id,action,ip,user_agent,created_at\nload-data,Import,,,\n"), false)
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), report.Merged)
+}
+
+func TestLogWriter(t *testing.T) {
+	assert.Nil(t, db.Where("user_id = ?", "log-writer").Delete(&UserLog{}).Error)
+
+	writer := NewLogWriter(db, 10)
+	writer.BatchSize = 3
+	writer.OnError = func(logs []UserLog, err error) {
+		t.Error(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan error)
+	go func() {
+		stopped <- writer.Run(ctx)
+	}()
+
+	clientCtx := WithClientInfo(context.Background(), "10.9.8.7", "writer")
+	for i := 0; i < 7; i++ {
+		assert.Nil(t, writer.Write(clientCtx, UserLog{UserID: "log-writer", Action: "Batched"}))
+	}
+	cancel()
+	assert.Equal(t, context.Canceled, <-stopped)
+	assert.Equal(t, ErrLogWriterClosed, writer.Write(context.Background(), UserLog{UserID: "log-writer"}))
+
+	var logs []UserLog
+	assert.Nil(t, db.Where("user_id = ?", "log-writer").Find(&logs).Error)
+	assert.Equal(t, 7, len(logs))
+	assert.Equal(t, "10.9.8.7", logs[0].IP.String())
+	assert.NotZero(t, logs[0].CreatedAt)
+
+	lossy := NewLogWriter(db, 1)
+	lossy.DropWhenFull = true
+	assert.Nil(t, lossy.Write(context.Background(), UserLog{UserID: "log-writer"}))
+	assert.Nil(t, lossy.Write(context.Background(), UserLog{UserID: "log-writer"}))
+	assert.Equal(t, int64(1), lossy.Dropped())
+}
+
+func TestWriteBehind(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Session{}))
+	journal := t.TempDir() + "/sessions.journal"
+	queue, err := NewWriteBehind(db, journal)
+	assert.Nil(t, err)
+	store := NewSessionStore(db)
+	store.RefreshInterval = 0
+	store.WriteBehind = queue
+
+	token, session, err := store.Create(context.Background(), "write-behind", "Laptop")
+	assert.Nil(t, err)
+	for i := 0; i < 3; i++ {
+		ctx := WithClientInfo(context.Background(), "10.0.0."+strconv.Itoa(i+1), "browser")
+		_, err = store.Validate(ctx, token)
+		assert.Nil(t, err)
+	}
+	assert.Equal(t, 1, queue.Pending())
+	assert.Nil(t, queue.Close())
+
+	recovered, err := NewWriteBehind(db, journal)
+	assert.Nil(t, err)
+	defer recovered.Close()
+	assert.Equal(t, 1, recovered.Pending())
+	assert.Nil(t, recovered.Flush(context.Background()))
+	assert.Equal(t, 0, recovered.Pending())
+
+	var found Session
+	assert.Nil(t, db.Take(&found, session.ID).Error)
+	assert.Equal(t, "10.0.0.3", found.LastIP.String())
+	assert.True(t, found.ExpiresAt.After(session.ExpiresAt))
+
+	empty, err := NewWriteBehind(db, journal)
+	assert.Nil(t, err)
+	assert.Equal(t, 0, empty.Pending())
+	assert.Nil(t, empty.Close())
+}
+
+func TestCounterBuffer(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Product{}))
+	assert.Nil(t, db.Delete(&Product{}, "id = ?", "COUNTER").Error)
+	assert.Nil(t, db.Create(&Product{ID: "COUNTER", Name: "Counter"}).Error)
+
+	counters := NewCounterBuffer(db)
+	var wait sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wait.Add(1)
+		go func() {
+			defer wait.Done()
+			counters.ProductViewed("COUNTER")
+		}()
+	}
+	wait.Wait()
+	counters.ProductLiked("COUNTER", 1)
+	counters.ProductLiked("COUNTER", 1)
+	counters.ProductLiked("COUNTER", -1)
+	assert.Equal(t, int64(50), counters.Pending("products", "COUNTER", "view_count"))
+
+	assert.Nil(t, counters.Flush(context.Background()))
+	assert.Equal(t, int64(0), counters.Pending("products", "COUNTER", "view_count"))
+	var product Product
+	assert.Nil(t, db.Take(&product, "id = ?", "COUNTER").Error)
+	assert.Equal(t, int64(50), product.ViewCount)
+	assert.Equal(t, int64(1), product.LikesCount)
+
+	// the like itself was never stored, the recount repairs the counter
+	_, err := CheckConsistency(context.Background(), db, true)
+	assert.Nil(t, err)
+	assert.Nil(t, db.Take(&product, "id = ?", "COUNTER").Error)
+	assert.Equal(t, int64(0), product.LikesCount)
+	assert.Equal(t, int64(50), product.ViewCount)
+}
+
+func TestTableRouting(t *testing.T) {
+	mainDB := OpenConnection()
+	loggingDB := OpenConnection()
+	router, err := RouteTables(mainDB, DefaultTableRoutes, map[string]*gorm.DB{DatabaseLogging: loggingDB})
+	assert.Nil(t, err)
+	assert.Equal(t, loggingDB, router.Database("user_logs"))
+	assert.Nil(t, router.Database("users"))
+
+	assert.Nil(t, mainDB.Create(&UserLog{UserID: "routed", Action: "Routed"}).Error)
+	var count int64
+	assert.Nil(t, mainDB.Model(&UserLog{}).Where("user_id = ?", "routed").Count(&count).Error)
+	assert.NotZero(t, count)
+
+	err = mainDB.Transaction(func(tx *gorm.DB) error {
+		return tx.Create(&UserLog{UserID: "routed", Action: "Cross"}).Error
+	})
+	var crossErr *CrossDatabaseError
+	assert.ErrorAs(t, err, &crossErr)
+	assert.Equal(t, DatabaseLogging, crossErr.Database)
+	assert.ErrorIs(t, err, ErrCrossDatabaseTransaction)
+
+	// with the logging pool closed only the routed tables fail
+	loggingSQL, err := loggingDB.DB()
+	assert.Nil(t, err)
+	assert.Nil(t, loggingSQL.Close())
+	assert.NotNil(t, mainDB.Create(&UserLog{UserID: "routed", Action: "Closed"}).Error)
+	var users []User
+	assert.Nil(t, mainDB.Limit(1).Find(&users).Error)
+
+	_, err = RouteTables(OpenConnection(), DefaultTableRoutes, nil)
+	assert.NotNil(t, err)
+}
+
+func TestXACoordinator(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&XATransaction{}, &Product{}, &UserLog{}))
+	assert.Nil(t, db.Delete(&Product{}, "id = ?", "XA").Error)
+	coordinator := NewXACoordinator(db, map[string]*gorm.DB{"main": db, DatabaseLogging: OpenConnection()})
+
+	err := coordinator.Run(context.Background(), func(participants map[string]*gorm.DB) error {
+		err := participants["main"].Create(&Product{ID: "XA", Name: "Two Phase", Price: 1}).Error
+		if err != nil {
+			return err
+		}
+		return participants[DatabaseLogging].Create(&UserLog{UserID: "xa", Action: "Create XA"}).Error
+	})
+	assert.Nil(t, err)
+	var product Product
+	assert.Nil(t, db.Take(&product, "id = ?", "XA").Error)
+
+	failure := errors.New("failed")
+	err = coordinator.Run(context.Background(), func(participants map[string]*gorm.DB) error {
+		err := participants["main"].Model(&Product{}).Where("id = ?", "XA").Update("price", 2).Error
+		if err != nil {
+			return err
+		}
+		return failure
+	})
+	assert.Equal(t, failure, err)
+	assert.Nil(t, db.Take(&product, "id = ?", "XA").Error)
+	assert.Equal(t, int64(1), product.Price)
+
+	// a crash after the decision leaves a committing journal entry
+	assert.Nil(t, db.Create(&XATransaction{XID: "gormxa-crashed", Participants: "main," + DatabaseLogging, Status: XACommitting}).Error)
+	finished, err := coordinator.Recover(context.Background())
+	assert.Nil(t, err)
+	assert.GreaterOrEqual(t, finished, 1)
+	var journal XATransaction
+	assert.Nil(t, db.Take(&journal, "xid = ?", "gormxa-crashed").Error)
+	assert.Equal(t, XACommitted, journal.Status)
+}
+
+func TestNamespaces(t *testing.T) {
+	config := DefaultConfig()
+	namespace := "ci_" + strconv.FormatInt(time.Now().UnixNano(), 36)
+	seeded, err := CreateNamespace(context.Background(), config, namespace, func(db *gorm.DB) error {
+		return db.Create(&User{ID: "namespace-user", Name: Name{FirstName: "Namespace"}}).Error
+	})
+	assert.Nil(t, err)
+	defer DropNamespace(context.Background(), config, namespace)
+	pending, err := PendingMigrations(seeded)
+	assert.Nil(t, err)
+	assert.Empty(t, pending)
+
+	namespaces := NewNamespaces(config)
+	defer namespaces.Close()
+	scoped, err := namespaces.DB(WithNamespace(context.Background(), namespace))
+	assert.Nil(t, err)
+	var user User
+	assert.Nil(t, scoped.Take(&user, "id = ?", "namespace-user").Error)
+	assert.Equal(t, "Namespace", user.FullName)
+
+	main, err := namespaces.DB(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, gorm.ErrRecordNotFound, main.Take(&user, "id = ?", "namespace-user").Error)
+
+	listed, err := ListNamespaces(context.Background(), config)
+	assert.Nil(t, err)
+	assert.Contains(t, listed, namespace)
+
+	_, err = namespaces.DB(WithNamespace(context.Background(), "Bad-Name"))
+	assert.Equal(t, ErrInvalidNamespace, err)
+}
+
+func TestInjectedClock(t *testing.T) {
+	clockDB := OpenConnection()
+	defer func() {
+		sqlDB, err := clockDB.DB()
+		if err == nil {
+			_ = sqlDB.Close()
+		}
+	}()
+	clock := NewTestClock(time.Date(2030, 1, 2, 3, 4, 5, 0, time.Local))
+	UseClock(clockDB, clock)
+
+	todo := Todo{UserId: "clock", Title: "Frozen"}
+	assert.Nil(t, clockDB.Create(&todo).Error)
+	assert.True(t, todo.CreatedAt.Equal(clock.Now()))
+	clock.Advance(time.Hour)
+	assert.Nil(t, clockDB.Model(&todo).Update("title", "Advanced").Error)
+	assert.Nil(t, clockDB.Delete(&todo).Error)
+
+	var found Todo
+	assert.Nil(t, clockDB.Unscoped().Take(&found, todo.ID).Error)
+	assert.True(t, found.UpdatedAt.Equal(clock.Now()))
+	assert.True(t, found.DeletedAt.Time.Equal(clock.Now()))
+
+	store := NewSessionStore(clockDB)
+	token, _, err := store.Create(context.Background(), "clock", "Laptop")
+	assert.Nil(t, err)
+	clock.Advance(store.TTL + time.Second)
+	_, err = store.Validate(context.Background(), token)
+	assert.Equal(t, ErrSessionExpired, err)
+
+	loads := 0
+	cache := NewCachedReader(time.Minute, func(ctx context.Context, key string) (string, error) {
+		loads++
+		return key, nil
+	})
+	cache.Clock = DBClock(clockDB)
+	_, _ = cache.Get(context.Background(), "clock")
+	_, _ = cache.Get(context.Background(), "clock")
+	assert.Equal(t, 1, loads)
+	clock.Advance(time.Minute + time.Second)
+	_, _ = cache.Get(context.Background(), "clock")
+	assert.Equal(t, 2, loads)
+
+	assert.Nil(t, clockDB.AutoMigrate(&ReplicationHeartbeat{}, &AuditLog{}, &LedgerHead{}))
+	router := &ReplicaRouter{Primary: clockDB}
+	assert.Nil(t, router.Beat(context.Background()))
+	clock.Advance(time.Second)
+	lag, err := HeartbeatLagProbe(context.Background(), clockDB)
+	assert.Nil(t, err)
+	assert.Equal(t, time.Second, lag)
+
+	actor := "clock-" + strconv.FormatInt(time.Now().UnixNano(), 10)
+	assert.Nil(t, clockDB.Transaction(func(tx *gorm.DB) error {
+		return WriteAuditLog(tx, actor, "tick", "clock", "1", nil)
+	}))
+	var audit AuditLog
+	assert.Nil(t, clockDB.Take(&audit, "actor = ?", actor).Error)
+	assert.True(t, audit.CreatedAt.Equal(clock.Now()))
+}
+
+func TestEntropy(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Session{}, &Coupon{}))
+	first, second := OpenConnection(), OpenConnection()
+	seed := time.Now().UnixNano()
+	UseEntropy(first, SeededEntropy(seed))
+	UseEntropy(second, SeededEntropy(seed))
+
+	firstToken, _, err := NewSessionStore(first).Create(context.Background(), "entropy", "First")
+	assert.Nil(t, err)
+	secondToken, _, err := NewSessionStore(second).Create(context.Background(), "entropy", "Second")
+	// the same seed issues the same token, the unique hash rejects it
+	assert.NotNil(t, err)
+	assert.Equal(t, firstToken, secondToken)
+
+	coupon := Coupon{DiscountAmount: 1000, MaxUses: 1}
+	assert.Nil(t, first.Create(&coupon).Error)
+	assert.Len(t, coupon.Code, 8)
+	collision := Coupon{DiscountAmount: 1000, MaxUses: 1}
+	err = second.Create(&collision).Error
+	assert.Equal(t, coupon.Code, collision.Code)
+	assert.Equal(t, http.StatusConflict, HTTPStatus(err))
+}
+
+func TestSessionOptions(t *testing.T) {
+	ctx := WithSessionOptions(context.Background(), SessionOptions{QueryFields: true, DryRun: true})
+
+	var todo Todo
+	stmt := SessionDB(ctx, db).Take(&todo, "id = ?", 1).Statement
+	assert.Contains(t, stmt.SQL.String(), "`todos`.`title`")
+
+	before := Todo{UserId: "session_options", Title: "Dry run"}
+	assert.Nil(t, SessionDB(ctx, db).Create(&before).Error)
+	var count int64
+	assert.Nil(t, db.Model(&Todo{}).Where("user_id = ?", "session_options").Count(&count).Error)
+	assert.Equal(t, int64(0), count)
+
+	// without options it is the db bound to the context
+	assert.False(t, SessionDB(context.Background(), db).DryRun)
+	assert.False(t, db.DryRun)
+}
+
+func TestStrictSelect(t *testing.T) {
+	strictDB := OpenConnection()
+	strict := NewStrictSelect("users")
+	strict.Reject = true
+	var reported []string
+	strict.OnSelectAll = func(table string) {
+		reported = append(reported, table)
+	}
+	assert.Nil(t, strict.Enforce(strictDB))
+
+	var users []User
+	assert.Equal(t, ErrSelectAll, strictDB.Find(&users).Error)
+	assert.Nil(t, strictDB.Select("id", "email").Find(&users).Error)
+
+	var summaries []struct {
+		ID    string
+		Email string
+	}
+	assert.Nil(t, strictDB.Model(&User{}).Find(&summaries).Error)
+	var count int64
+	assert.Nil(t, strictDB.Model(&User{}).Count(&count).Error)
+	assert.Nil(t, strictDB.WithContext(WithSelectAll(context.Background())).Find(&users).Error)
+
+	// other tables are not checked
+	var todos []Todo
+	assert.Nil(t, strictDB.Limit(1).Find(&todos).Error)
+	assert.Equal(t, int64(1), strict.SelectAll())
+	assert.Equal(t, []string{"users"}, reported)
+}
+
+func TestWithCounts(t *testing.T) {
+	var user User
+	assert.Nil(t, db.Preload("Addresses").Preload("LikeProducts").Take(&user, "id = ?", "1").Error)
+
+	var summary struct {
+		ID                string
+		Email             string
+		AddressesCount    int64
+		LikeProductsCount int64
+	}
+	err := db.Model(&User{}).Select("id", "email").Scopes(WithCounts("Addresses", "LikeProducts")).
+		Take(&summary, "id = ?", "1").Error
+	assert.Nil(t, err)
+	assert.Equal(t, "1", summary.ID)
+	assert.Equal(t, int64(len(user.Addresses)), summary.AddressesCount)
+	assert.Equal(t, int64(len(user.LikeProducts)), summary.LikeProductsCount)
+
+	var products []struct {
+		ID                string
+		LikedByUsersCount int64
+	}
+	err = db.Model(&Product{}).Scopes(WithCounts("LikedByUsers")).Where("id = ?", "P001").Find(&products).Error
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), products[0].LikedByUsersCount)
+
+	err = db.Model(&User{}).Scopes(WithCounts("Unknown")).Find(&products).Error
+	assert.True(t, errors.Is(err, ErrUnknownAssociation))
+}
+
+func TestWhereExists(t *testing.T) {
+	var withWallet []User
+	assert.Nil(t, db.Scopes(UsersWithWallet()).Find(&withWallet).Error)
+	var walletCount int64
+	assert.Nil(t, db.Model(&Wallet{}).Distinct("user_id").Count(&walletCount).Error)
+	assert.Equal(t, walletCount, int64(len(withWallet)))
+
+	var withoutAddresses []User
+	assert.Nil(t, db.Scopes(UsersWithoutAddresses()).Find(&withoutAddresses).Error)
+	for _, user := range withoutAddresses {
+		var addresses int64
+		assert.Nil(t, db.Model(&Address{}).Where("user_id = ?", user.ID).Count(&addresses).Error)
+		assert.Equal(t, int64(0), addresses)
+	}
+
+	var neverLiked []Product
+	assert.Nil(t, db.Scopes(ProductsNeverLiked()).Find(&neverLiked).Error)
+	for _, product := range neverLiked {
+		assert.NotEqual(t, "P001", product.ID)
+	}
+
+	// custom subqueries correlate the same way
+	var todoUsers []User
+	sub := db.Model(&Todo{}).Select("1").Where("todos.user_id = users.id")
+	assert.Nil(t, db.Scopes(WhereExists(sub)).Find(&todoUsers).Error)
+}
+
+func TestSetQuery(t *testing.T) {
+	assert.Nil(t, db.Exec("CREATE TABLE IF NOT EXISTS todos_archive LIKE todos").Error)
+	live := Todo{UserId: "set_query", Title: "Live"}
+	assert.Nil(t, db.Create(&live).Error)
+	defer db.Unscoped().Delete(&live)
+	archived := Todo{UserId: "set_query", Title: "Archived"}
+	archived.ID = live.ID + 1000000
+	assert.Nil(t, db.Table(ArchiveTable("todos")).Create(&archived).Error)
+	defer db.Table(ArchiveTable("todos")).Delete(&Todo{}, "user_id = ?", "set_query")
+
+	columns := []string{"id", "title"}
+	var todos []Todo
+	err := UnionAll(columns,
+		db.Model(&Todo{}).Where("user_id = ?", "set_query"),
+		db.Table(ArchiveTable("todos")).Where("user_id = ?", "set_query"),
+	).Order("id").Find(&todos).Error
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Live", "Archived"}, []string{todos[0].Title, todos[1].Title})
+
+	var page []Todo
+	err = UnionAll(columns,
+		db.Model(&Todo{}).Where("user_id = ?", "set_query"),
+		db.Table(ArchiveTable("todos")).Where("user_id = ?", "set_query"),
+	).Order("id").Scopes(Paginate(2, 1)).Find(&page).Error
+	assert.Nil(t, err)
+	assert.Len(t, page, 1)
+	assert.Equal(t, archived.ID, page[0].ID)
+
+	// the same title from both sides comes once
+	var titles []string
+	err = Combine(db.Model(&Todo{}).Where("id = ?", live.ID), "title").
+		Union(db.Model(&Todo{}).Where("id = ?", live.ID)).
+		Query().Pluck("title", &titles).Error
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"Live"}, titles)
+}
+
+func TestWithCTE(t *testing.T) {
+	todos := []Todo{{UserId: "cte", Title: "Open"}, {UserId: "cte", Title: "Done"}}
+	assert.Nil(t, db.Create(&todos).Error)
+	defer db.Unscoped().Delete(&todos)
+	assert.Nil(t, db.Model(&todos[1]).Update("completed_at", time.Now()).Error)
+
+	var done []Todo
+	err := db.Scopes(
+		WithCTE("mine", db.Model(&Todo{}).Where("user_id = ?", "cte")),
+		WithCTE("done", db.Table("mine").Where("completed_at is not null")),
+	).Table("done").Find(&done).Error
+	assert.Nil(t, err)
+	assert.Len(t, done, 1)
+	assert.Equal(t, "Done", done[0].Title)
+}
+
+func TestParseSort(t *testing.T) {
+	order, err := ParseSort("name, -created_at,name", ProductSortFields)
+	assert.Nil(t, err)
+	assert.Equal(t, []clause.OrderByColumn{
+		{Column: clause.Column{Name: "name"}},
+		{Column: clause.Column{Name: "created_at"}, Desc: true},
+		{Column: clause.Column{Name: "id"}},
+	}, order.Columns)
+
+	var products []Product
+	assert.Nil(t, db.Clauses(order).Find(&products).Error)
+
+	_, err = ParseSort("price,-password", ProductSortFields)
+	var sortErr *SortFieldError
+	assert.True(t, errors.As(err, &sortErr))
+	assert.Equal(t, "password", sortErr.Field)
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+}
+
+func TestListProducts(t *testing.T) {
+	assert.Nil(t, db.AutoMigrate(&Product{}, &Tag{}, &Tagging{}))
+	products := []Product{
+		{ID: "FACET1", Name: "Facet 1", Category: "facet_books", Price: 5000},
+		{ID: "FACET2", Name: "Facet 2", Category: "facet_books", Price: 20000},
+		{ID: "FACET3", Name: "Facet 3", Category: "facet_games", Price: 20000},
+	}
+	assert.Nil(t, db.Create(&products).Error)
+	defer db.Delete(&Product{}, "id like ?", "FACET%")
+	tags := NewTagService(db)
+	assert.Nil(t, tags.Attach(&products[0], "facet_sale"))
+	assert.Nil(t, tags.Attach(&products[1], "facet_sale"))
+	defer db.Delete(&Tagging{}, "taggable_id like ?", "FACET%")
+
+	order, err := ParseSort("-price", ProductSortFields)
+	assert.Nil(t, err)
+	listing, err := ListProducts(context.Background(), db, 1, 2, order, func(db *gorm.DB) *gorm.DB {
+		return db.Where("id like ?", "FACET%")
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(3), listing.Total)
+	assert.Equal(t, []string{"FACET2", "FACET3"}, []string{listing.Products[0].ID, listing.Products[1].ID})
+	assert.Equal(t, []FacetCount{{Value: "facet_books", Count: 2}, {Value: "facet_games", Count: 1}}, listing.Categories)
+	assert.Equal(t, FacetCount{Value: "0-10000", Count: 1}, listing.Prices[0])
+	assert.Equal(t, FacetCount{Value: "10000-50000", Count: 2}, listing.Prices[1])
+	assert.Len(t, listing.Prices, len(DefaultPriceBounds))
+	assert.Equal(t, []FacetCount{{Value: "facet_sale", Count: 2}}, listing.Tags)
+}
+
+func TestSavedSearches(t *testing.T) {
+	assert.Nil(t, db.AutoMigrate(&SavedSearch{}))
+	searches := NewSavedSearches(db)
+	ctx := context.Background()
+
+	search := SavedSearch{
+		UserID:   "saved_search",
+		Name:     "Cheap books",
+		Resource: "products",
+		Filter:   json.RawMessage(`{"and": [{"field": "category", "op": "eq", "value": "saved_books"}, {"field": "price", "op": "lt", "value": 10000}]}`),
+		Sort:     "-price",
+	}
+	assert.Nil(t, searches.Save(ctx, &search))
+	defer db.Delete(&SavedSearch{}, "user_id = ?", "saved_search")
+	products := []Product{
+		{ID: "SAVED1", Category: "saved_books", Price: 5000},
+		{ID: "SAVED2", Category: "saved_books", Price: 8000},
+		{ID: "SAVED3", Category: "saved_books", Price: 20000},
+	}
+	assert.Nil(t, db.Create(&products).Error)
+	defer db.Delete(&Product{}, "id like ?", "SAVED%")
+
+	var found []Product
+	assert.Nil(t, searches.Run(ctx, "saved_search", search.ID, 1, 10, &found))
+	assert.Equal(t, []string{"SAVED2", "SAVED1"}, []string{found[0].ID, found[1].ID})
+	assert.Equal(t, gorm.ErrRecordNotFound, searches.Run(ctx, "someone_else", search.ID, 1, 10, &found))
+
+	token, err := searches.Share(ctx, "saved_search", search.ID)
+	assert.Nil(t, err)
+	again, err := searches.Share(ctx, "saved_search", search.ID)
+	assert.Nil(t, err)
+	assert.Equal(t, token, again)
+	var shared []Product
+	assert.Nil(t, searches.RunShared(ctx, token, 1, 1, &shared))
+	assert.Len(t, shared, 1)
+	assert.Nil(t, searches.Unshare(ctx, "saved_search", search.ID))
+	assert.Equal(t, gorm.ErrRecordNotFound, searches.RunShared(ctx, token, 1, 1, &shared))
+
+	todos := SavedSearch{UserID: "saved_search", Name: "Todos", Resource: "todos",
+		Filter: json.RawMessage(`{"field": "title", "op": "eq", "value": "Saved todo"}`)}
+	assert.Nil(t, searches.Save(ctx, &todos))
+	assert.Nil(t, db.Create(&[]Todo{{UserId: "saved_search", Title: "Saved todo"}, {UserId: "saved_other", Title: "Saved todo"}}).Error)
+	defer db.Unscoped().Delete(&Todo{}, "title = ?", "Saved todo")
+	token, err = searches.Share(ctx, "saved_search", todos.ID)
+	assert.Nil(t, err)
+	var sharedTodos []Todo
+	assert.Nil(t, searches.RunShared(ctx, token, 1, 10, &sharedTodos))
+	assert.Len(t, sharedTodos, 1)
+	assert.Equal(t, "saved_search", sharedTodos[0].UserId)
+
+	users := SavedSearch{UserID: "saved_search", Name: "Users", Resource: "users",
+		Filter: json.RawMessage(`{"field": "email", "op": "like", "value": "saved\\_%@example.com"}`)}
+	assert.Nil(t, searches.Save(ctx, &users))
+	assert.Nil(t, db.Create(&[]User{
+		{ID: "saved_search", Password: "rahasia", Email: "saved_search@example.com"},
+		{ID: "saved_other", Password: "rahasia", Email: "saved_other@example.com"},
+	}).Error)
+	defer db.Delete(&User{}, "id in ?", []string{"saved_search", "saved_other"})
+	token, err = searches.Share(ctx, "saved_search", users.ID)
+	assert.Nil(t, err)
+	var sharedUsers []User
+	assert.Nil(t, searches.RunShared(ctx, token, 1, 10, &sharedUsers))
+	assert.Len(t, sharedUsers, 1)
+	assert.Equal(t, "saved_search", sharedUsers[0].ID)
+	assert.Empty(t, sharedUsers[0].Password)
+
+	invalid := SavedSearch{UserID: "saved_search", Name: "Passwords", Resource: "users",
+		Filter: json.RawMessage(`{"field": "password", "op": "eq", "value": "secret"}`)}
+	err = searches.Save(ctx, &invalid)
+	assert.True(t, errors.Is(err, ErrInvalidFilter))
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+}
+
+func TestFilterGuard(t *testing.T) {
+	guard := NewFilterGuard(db)
+	ctx := context.Background()
+	filter := Filter{And: []Filter{
+		{Field: "email", Op: "eq", Value: "budi@example.com"},
+		{Field: "name", Op: "like", Value: "%budi%"},
+		{Field: "address", Op: "like", Value: "Jalan%"},
+	}}
+	cost, err := guard.Estimate(ctx, filter, UserFilterFields)
+	assert.Nil(t, err)
+	assert.Equal(t, 3, cost.Predicates)
+	// first_name and addresses.address have no index
+	assert.Equal(t, 2, cost.Unindexed)
+	assert.Equal(t, 1, cost.LeadingWildcards)
+	assert.Equal(t, 1, cost.Joins)
+	assert.Equal(t, 2, cost.Depth)
+
+	checked, err := guard.Check(ctx, filter, UserFilterFields, DefaultFilterBudget)
+	assert.Nil(t, err)
+	assert.Equal(t, filter, checked)
+
+	_, err = guard.Check(ctx, filter, UserFilterFields, FilterBudget{MaxCost: 25})
+	var costErr *FilterCostError
+	assert.True(t, errors.As(err, &costErr))
+	assert.Equal(t, http.StatusBadRequest, HTTPStatus(err))
+
+	downgraded, err := guard.Check(ctx, filter, UserFilterFields, FilterBudget{MaxCost: 25, Downgrade: true})
+	assert.Nil(t, err)
+	assert.Equal(t, "budi%", downgraded.And[1].Value)
+
+	searches := NewSavedSearches(db)
+	searches.Guard, searches.Budget = guard, FilterBudget{MaxPredicates: 1}
+	search := SavedSearch{UserID: "filter_guard", Name: "Too many", Resource: "users",
+		Filter: json.RawMessage(`{"and": [{"field": "email", "op": "eq", "value": "a"}, {"field": "phone", "op": "eq", "value": "b"}]}`)}
+	assert.True(t, errors.Is(searches.Save(ctx, &search), ErrFilterTooExpensive))
+}
+
+func TestMaxRows(t *testing.T) {
+	logs := make([]UserLog, 5)
+	for i := range logs {
+		logs[i] = UserLog{UserID: "max_rows", Action: fmt.Sprintf("action %d", i)}
+	}
+	assert.Nil(t, db.Create(&logs).Error)
+	defer db.Delete(&UserLog{}, "user_id = ?", "max_rows")
+
+	limited, err := ListUserLogs(context.Background(), db, "max_rows", 3)
+	assert.Nil(t, err)
+	assert.True(t, limited.Truncated)
+	assert.Len(t, limited.Rows, 3)
+	assert.Equal(t, "action 4", limited.Rows[0].Action)
+
+	limited, err = ListUserLogs(context.Background(), db, "max_rows", 5)
+	assert.Nil(t, err)
+	assert.False(t, limited.Truncated)
+	assert.Len(t, limited.Rows, 5)
+
+	var streamed []string
+	err = Stream(db.Where("user_id = ?", "max_rows"), 2, func(batch []UserLog) error {
+		assert.LessOrEqual(t, len(batch), 2)
+		for _, log := range batch {
+			streamed = append(streamed, log.Action)
+		}
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"action 0", "action 1", "action 2", "action 3", "action 4"}, streamed)
+}
+
+func TestStreamRows(t *testing.T) {
+	logs := []UserLog{{UserID: "stream_rows", Action: "first"}, {UserID: "stream_rows", Action: "second"}}
+	assert.Nil(t, db.Create(&logs).Error)
+	defer db.Delete(&UserLog{}, "user_id = ?", "stream_rows")
+
+	var actions []string
+	var rows []*UserLog
+	err := StreamRows(db.Where("user_id = ?", "stream_rows").Order("id"), func(log *UserLog) error {
+		actions = append(actions, log.Action)
+		rows = append(rows, log)
+		return nil
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"first", "second"}, actions)
+	// every row is scanned into the same value
+	assert.Same(t, rows[0], rows[1])
+}
+
+func seedScanBenchmark(b *testing.B) {
+	var count int64
+	db.Model(&UserLog{}).Where("user_id = ?", "scan_benchmark").Count(&count)
+	if count >= 10000 {
+		return
+	}
+	logs := make([]UserLog, 10000)
+	for i := range logs {
+		logs[i] = UserLog{UserID: "scan_benchmark", Action: fmt.Sprintf("action %d", i)}
+	}
+	if err := db.CreateInBatches(&logs, 1000).Error; err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkScanQueryResult(b *testing.B) {
+	seedScanBenchmark(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Table("user_logs").Where("user_id = ?", "scan_benchmark").Rows()
+		if err != nil {
+			b.Fatal(err)
+		}
+		_, err = scanQueryResult(rows, 0)
+		rows.Close()
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkRowScanner(b *testing.B) {
+	seedScanBenchmark(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		rows, err := db.Table("user_logs").Where("user_id = ?", "scan_benchmark").Rows()
+		if err != nil {
+			b.Fatal(err)
+		}
+		scanner, err := NewRowScanner(rows)
+		if err != nil {
+			b.Fatal(err)
+		}
+		for rows.Next() {
+			if _, err = scanner.Scan(rows); err != nil {
+				b.Fatal(err)
+			}
+		}
+		scanner.Release()
+		rows.Close()
+	}
+}
+
+func BenchmarkFindInBatches(b *testing.B) {
+	seedScanBenchmark(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := Stream(db.Where("user_id = ?", "scan_benchmark"), 1000, func(batch []UserLog) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkStreamRows(b *testing.B) {
+	seedScanBenchmark(b)
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		err := StreamRows(db.Where("user_id = ?", "scan_benchmark"), func(log *UserLog) error {
+			return nil
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestProfileStatements(t *testing.T) {
+	profiled := OpenConnection()
+	assert.Nil(t, ProfileStatements(profiled))
+
+	var buffer bytes.Buffer
+	assert.Nil(t, trace.Start(&buffer))
+	pprof.Do(context.Background(), pprof.Labels("handler", "todos"), func(ctx context.Context) {
+		var todos []Todo
+		assert.Nil(t, profiled.WithContext(ctx).Limit(1).Find(&todos).Error)
+		assert.Nil(t, profiled.WithContext(ctx).Create(&Todo{UserId: "profile", Title: "Profiled"}).Error)
+	})
+	trace.Stop()
+	defer db.Unscoped().Delete(&Todo{}, "user_id = ?", "profile")
+
+	assert.True(t, bytes.Contains(buffer.Bytes(), []byte("gorm query todos")))
+	assert.True(t, bytes.Contains(buffer.Bytes(), []byte("gorm create todos")))
+}
+
+func TestRedactingLogger(t *testing.T) {
+	redacting := NewRedactingLogger(logger.Default, DefaultRedactedColumns...)
+	ctx := context.Background()
+
+	sql := "INSERT INTO `users` (`id`,`password`,`first_name`) VALUES (?,?,?),(?,?,?)"
+	_, params := redacting.ParamsFilter(ctx, sql, "1", "secret", "Eko", "2", "secret", "Budi")
+	assert.Equal(t, []interface{}{"1", "[REDACTED]", "Eko", "2", "[REDACTED]", "Budi"}, params)
+
+	_, params = redacting.ParamsFilter(ctx, "UPDATE `users` SET `password`=?,`updated_at`=? WHERE `id` = ?", "secret", "now", "1")
+	assert.Equal(t, []interface{}{"[REDACTED]", "now", "1"}, params)
+
+	_, params = redacting.ParamsFilter(ctx, "SELECT * FROM `sessions` WHERE `sessions`.`token_hash` IN (?,?) AND user_id = ?", "a", "b", "1")
+	assert.Equal(t, []interface{}{"[REDACTED]", "[REDACTED]", "1"}, params)
+
+	redacting.Statements = []*regexp.Regexp{regexp.MustCompile("(?i)api_keys")}
+	sql, params = redacting.ParamsFilter(ctx, "SELECT * FROM `api_keys` WHERE name = ?", "ci")
+	assert.Equal(t, "SELECT * FROM `api_keys` WHERE name = ?", sql)
+	assert.Equal(t, []interface{}{"[REDACTED]"}, params)
+
+	redacting.Unsafe = true
+	_, params = redacting.ParamsFilter(ctx, "UPDATE `users` SET `password`=?", "secret")
+	assert.Equal(t, []interface{}{"secret"}, params)
+}
+
+type recordingLogger struct {
+	logger.Interface
+	traced []string
+}
+
+func (l *recordingLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	sql, _ := fc()
+	l.traced = append(l.traced, sql)
+}
+
+func TestLogSampling(t *testing.T) {
+	assert.Nil(t, db.Migrator().AutoMigrate(&Setting{}))
+
+	sampling := NewLogSampling(db, 2, 0)
+	recording := &recordingLogger{Interface: logger.Default}
+	sampled := NewSamplingLogger(recording, sampling)
+	trace := func(sql string, begin time.Time, err error) {
+		sampled.Trace(context.Background(), begin, func() (string, int64) { return sql, 1 }, err)
+	}
+
+	for i := 0; i < 4; i++ {
+		trace(fmt.Sprintf("SELECT * FROM `users` WHERE id = '%d'", i), time.Now(), nil)
+	}
+	trace("SELECT * FROM `users` WHERE id = 'x'", time.Now(), gorm.ErrInvalidData)
+	trace("SELECT * FROM `users` WHERE id = 'y'", time.Now().Add(-time.Second), nil)
+	assert.Equal(t, []string{
+		"SELECT * FROM `users` WHERE id = '1'",
+		"SELECT * FROM `users` WHERE id = '3'",
+		"SELECT * FROM `users` WHERE id = 'x'",
+		"SELECT * FROM `users` WHERE id = 'y'",
+	}, recording.traced)
+
+	sampling.Clock = NewTestClock(time.Now())
+	defer sampling.Set(context.Background(), 2, 0)
+	assert.Nil(t, sampling.Set(context.Background(), 1, 1))
+	recording.traced = nil
+	trace("SELECT * FROM `todos` WHERE id = 1", time.Now(), nil)
+	trace("SELECT * FROM `todos` WHERE id = 2", time.Now(), nil)
+	trace("SELECT * FROM `users` WHERE id = 1", time.Now(), nil)
+	assert.Equal(t, []string{"SELECT * FROM `todos` WHERE id = 1", "SELECT * FROM `users` WHERE id = 1"}, recording.traced)
+
+	other := NewLogSampling(db, 10, 0)
+	assert.Nil(t, other.Refresh(context.Background()))
+	every, perSecond := other.Rates()
+	assert.Equal(t, int64(1), every)
+	assert.Equal(t, int64(1), perSecond)
+}
+
+func TestSLOTracker(t *testing.T) {
+	clock := NewTestClock(time.Now())
+	tracker := NewSLOTracker(DefaultObjectives...)
+	tracker.Clock = clock
+	var alerts []BudgetAlert
+	tracker.OnAlert = func(alert BudgetAlert) {
+		alerts = append(alerts, alert)
+	}
+
+	for i := 0; i < 1000; i++ {
+		tracker.Record(OperationRead, time.Millisecond, nil)
+	}
+	tracker.Record(OperationRead, time.Millisecond, gorm.ErrRecordNotFound)
+	assert.Empty(t, tracker.Evaluate())
+	assert.Equal(t, int64(1001), tracker.Status(OperationRead).Total)
+	assert.Equal(t, float64(1), tracker.Status(OperationRead).SuccessBudget)
+
+	for i := 0; i < 20; i++ {
+		tracker.Record(OperationRead, time.Millisecond, sql.ErrConnDone)
+	}
+	assert.InDelta(t, 20.0/1021/0.001, tracker.BurnRate(OperationRead, IndicatorSuccess, time.Hour), 0.001)
+	firing := tracker.Evaluate()
+	assert.Len(t, firing, 2)
+	assert.Len(t, alerts, 2)
+	assert.Equal(t, IndicatorSuccess, alerts[0].Indicator)
+	tracker.Evaluate()
+	assert.Len(t, alerts, 2)
+	assert.True(t, tracker.Status(OperationRead).SuccessBudget < 0)
+
+	clock.Advance(2 * time.Hour)
+	assert.Equal(t, float64(0), tracker.BurnRate(OperationRead, IndicatorSuccess, time.Hour))
+	assert.Len(t, tracker.Evaluate(), 1)
+
+	tracked := OpenConnection()
+	tracker = NewSLOTracker(DefaultObjectives...)
+	assert.Nil(t, tracker.Track(tracked))
+	var todos []Todo
+	assert.Nil(t, tracked.Limit(1).Find(&todos).Error)
+	assert.Nil(t, tracked.Exec("delete from todos where id = 0").Error)
+	var count int64
+	assert.Nil(t, tracked.WithContext(WithOperationClass(context.Background(), OperationReport)).Raw("select count(*) from todos").Scan(&count).Error)
+	assert.Equal(t, int64(1), tracker.Status(OperationRead).Total)
+	assert.Equal(t, int64(1), tracker.Status(OperationWrite).Total)
+	assert.Equal(t, int64(1), tracker.Status(OperationReport).Total)
+}
+
+func TestPlanMigrations(t *testing.T) {
+	pool := &planConnPool{ConnPool: db.Statement.ConnPool, dialector: db.Dialector}
+	tx := db.Session(&gorm.Session{NewDB: true})
+	tx.Statement.ConnPool = pool
+	assert.Nil(t, CreateIndex(tx, "todos", "idx_todos_plan", "title"))
+	assert.Nil(t, tx.Exec("update todos set title = ? where id = ?", "Planned", 0).Error)
+
+	assert.Equal(t, []string{
+		"create index `idx_todos_plan` on `todos` (`title`)",
+		"update todos set title = 'Planned' where id = 0",
+	}, pool.statements)
+	assert.Equal(t, []string{"todos"}, statementTables(pool.statements))
+	assert.False(t, db.Migrator().HasIndex("todos", "idx_todos_plan"))
+
+	assert.Nil(t, Migrate(db))
+	plans, err := PlanMigrations(context.Background(), db)
+	assert.Nil(t, err)
+	assert.Empty(t, plans)
+}
+
+func TestRollbackDataLoss(t *testing.T) {
+	assert.Nil(t, Migrate(db))
+	defer Migrate(db)
+	product := Product{ID: "ROLLBACK1", Name: "Rollback", Category: "rollback_books", Price: 1000}
+	assert.Nil(t, db.Create(&product).Error)
+	defer db.Delete(&Product{}, "id = ?", product.ID)
+
+	var losses []DataLoss
+	err := Rollback(db, 1, RollbackOptions{OnDataLoss: func(loss DataLoss) {
+		losses = append(losses, loss)
+	}})
+	assert.True(t, errors.Is(err, ErrDestructiveMigration))
+	assert.Len(t, losses, 1)
+	assert.Equal(t, "products", losses[0].Table)
+	assert.Equal(t, "category", losses[0].Column)
+	assert.True(t, losses[0].Rows >= 1)
+	assert.True(t, db.Migrator().HasColumn(&Product{}, "Category"))
+
+	dir := t.TempDir()
+	err = Rollback(db, 1, RollbackOptions{AllowDestructive: true, BeforeDrop: ExportBeforeDrop(dir)})
+	assert.Nil(t, err)
+	assert.False(t, db.Migrator().HasColumn(&Product{}, "Category"))
+	_, err = os.Stat(filepath.Join(dir, "12_products_category.jsonl.gz"))
+	assert.Nil(t, err)
+}
+
+func TestMigrationLock(t *testing.T) {
+	ctx := context.Background()
+	first := NewMigrationLock(db)
+	first.Owner = "first"
+	assert.Nil(t, first.Acquire(ctx))
+	defer first.Release(ctx)
+
+	second := NewMigrationLock(db)
+	second.Owner = "second"
+	second.RetryInterval = 10 * time.Millisecond
+	waiting, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, second.Acquire(waiting))
+
+	assert.Nil(t, db.Model(&SchemaMigrationLock{}).Where("id = ?", 1).
+		Update("heartbeat_at", time.Now().Add(-2*first.StaleAfter)).Error)
+	var stale SchemaMigrationLock
+	second.OnTakeover = func(lock SchemaMigrationLock) {
+		stale = lock
+	}
+	assert.Nil(t, second.Acquire(ctx))
+	assert.Equal(t, "first", stale.Owner)
+
+	assert.Nil(t, first.Release(ctx))
+	var lock SchemaMigrationLock
+	assert.Nil(t, db.Take(&lock, "id = ?", 1).Error)
+	assert.Equal(t, "second", lock.Owner)
+	assert.Nil(t, second.Release(ctx))
+
+	zero := &MigrationLock{DB: db, Owner: "zero"}
+	assert.Nil(t, zero.Hold(ctx, func(ctx context.Context) error { return nil }))
+
+	taken := &MigrationLock{DB: db, Owner: "taken", StaleAfter: 30 * time.Millisecond}
+	err := taken.Hold(ctx, func(ctx context.Context) error {
+		err := db.Model(&SchemaMigrationLock{}).Where("id = ?", 1).Update("owner", "thief").Error
+		if err != nil {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(time.Second):
+			return nil
+		}
+	})
+	assert.True(t, errors.Is(err, ErrMigrationLockLost))
+	assert.Nil(t, db.Delete(&SchemaMigrationLock{}, "id = ?", 1).Error)
+	assert.Nil(t, Migrate(db))
+}
+
+func TestSeeders(t *testing.T) {
+	names := func(selected []Seeder) []string {
+		var names []string
+		for _, seeder := range selected {
+			names = append(names, seeder.Name)
+		}
+		return names
+	}
+
+	selected, err := SelectSeeders([]string{SeedSetMinimal}, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"settings"}, names(selected))
+
+	selected, err = SelectSeeders(nil, []string{"demo-todos"})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"demo-users", "demo-todos"}, names(selected))
+
+	_, err = SelectSeeders(nil, []string{"missing"})
+	assert.True(t, errors.Is(err, ErrUnknownSeeder))
+
+	config, err := ProfileConfig("test")
+	assert.Nil(t, err)
+	assert.Equal(t, []string{SeedSetMinimal}, config.SeedSets)
+
+	defer db.Unscoped().Delete(&Product{}, "id LIKE ?", "demo-%")
+	defer db.Unscoped().Delete(&User{}, "id LIKE ?", "demo-%")
+	defer db.Unscoped().Delete(&Wallet{}, "id LIKE ?", "demo-%")
+	defer db.Unscoped().Delete(&Todo{}, "user_id LIKE ?", "demo-%")
+	assert.Nil(t, RunSeeders(context.Background(), db, []string{SeedSetDemo}, nil))
+	assert.Nil(t, RunSeeders(context.Background(), db, []string{SeedSetDemo}, nil))
+	var todos int64
+	assert.Nil(t, db.Model(&Todo{}).Where("user_id = ?", "demo-1").Count(&todos).Error)
+	assert.Equal(t, int64(2), todos)
+}
+
+func TestStateHash(t *testing.T) {
+	ctx := context.Background()
+	before, err := StateHash(ctx, db, "todos", "wallets")
+	assert.Nil(t, err)
+	again, err := StateHash(ctx, db, "wallets", "todos")
+	assert.Nil(t, err)
+	assert.Equal(t, before, again)
+
+	assert.Nil(t, db.Create(&UserLog{UserID: "state", Action: "Unrelated"}).Error)
+	defer db.Delete(&UserLog{}, "user_id = ?", "state")
+	after, err := StateHash(ctx, db, "todos", "wallets")
+	assert.Nil(t, err)
+	assert.Equal(t, before, after)
+
+	todo := Todo{UserId: "state", Title: "Changed"}
+	assert.Nil(t, db.Create(&todo).Error)
+	defer db.Unscoped().Delete(&todo)
+	hashes, err := TableHashes(ctx, db, "todos", "wallets")
+	assert.Nil(t, err)
+	after, err = StateHash(ctx, db, "todos", "wallets")
+	assert.Nil(t, err)
+	assert.NotEqual(t, before, after)
+
+	previous, err := TableHashes(ctx, db, "wallets")
+	assert.Nil(t, err)
+	assert.Equal(t, previous["wallets"], hashes["wallets"])
+}
+
+func TestMemoryRepository(t *testing.T) {
+	todos, err := NewMemoryRepository[Todo, uint](TodoFilterFields)
+	assert.Nil(t, err)
+	_, err = todos.List(context.Background(), 1, 10, Filter{Field: "address", Op: "eq", Value: "x"})
+	assert.True(t, errors.Is(err, ErrInvalidFilter))
+
+	users, err := NewMemoryRepository[User, string](UserFilterFields)
+	assert.Nil(t, err)
+	assert.Nil(t, users.Create(context.Background(), &User{ID: "memory"}))
+	_, err = users.List(context.Background(), 1, 10, Filter{Field: "address", Op: "eq", Value: "x"})
+	assert.True(t, errors.Is(err, ErrUnsupportedFilter))
+}
+
+func TestBulkUpdate(t *testing.T) {
+	products := []Product{
+		{ID: "bulk-1", Name: "Bulk 1", Price: 1000, Stock: 1},
+		{ID: "bulk-2", Name: "Bulk 2", Price: 2000, Stock: 2},
+		{ID: "bulk-3", Name: "Bulk 3", Price: 3000, Stock: 3},
+	}
+	assert.Nil(t, db.Create(&products).Error)
+	defer db.Delete(&Product{}, "id LIKE ?", "bulk-%")
+
+	rows, err := BulkUpdate(context.Background(), db, &Product{}, map[string]Changes{
+		"bulk-1": {"price": 1500},
+		"bulk-2": {"Price": gorm.Expr("price * 2"), "stock": 20},
+		"bulk-9": {"price": 9000},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, int64(2), rows)
+
+	var updated []Product
+	assert.Nil(t, db.Order("id").Find(&updated, "id LIKE ?", "bulk-%").Error)
+	assert.Equal(t, int64(1500), updated[0].Price)
+	assert.Equal(t, int64(1), updated[0].Stock)
+	assert.Equal(t, int64(4000), updated[1].Price)
+	assert.Equal(t, int64(20), updated[1].Stock)
+	assert.Equal(t, int64(3000), updated[2].Price)
+
+	_, err = BulkUpdate(context.Background(), db, &Product{}, map[string]Changes{"bulk-1": {"missing": 1}})
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+	_, err = BulkUpdate(context.Background(), db, &Product{}, map[string]Changes{"bulk-1": {"id": "bulk-4"}})
+	assert.True(t, errors.Is(err, ErrInvalidRequest))
+}
@@ -0,0 +1,53 @@
+package learn_golang_gorm
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+var ErrInvalidRequest = errors.New("invalid request")
+
+// HTTPStatus maps the errors of the package to the status handlers answer
+// with, unknown errors are internal errors.
+func HTTPStatus(err error) int {
+	var lockedErr *AccountLockedError
+	var mysqlErr *mysql.MySQLError
+	switch {
+	case errors.Is(err, gorm.ErrRecordNotFound):
+		return http.StatusNotFound
+	case errors.Is(err, ErrInvalidRequest), errors.Is(err, gorm.ErrInvalidData):
+		return http.StatusBadRequest
+	case errors.Is(err, ErrInvalidAPIKey), errors.Is(err, ErrSessionExpired), errors.Is(err, ErrInvalidCredentials):
+		return http.StatusUnauthorized
+	case errors.Is(err, ErrMissingScope), errors.Is(err, ErrUnknownRole):
+		return http.StatusForbidden
+	case errors.Is(err, gorm.ErrDuplicatedKey), errors.Is(err, ErrVersionConflict), errors.As(err, &mysqlErr) && mysqlErr.Number == 1062:
+		return http.StatusConflict
+	case errors.Is(err, ErrQuotaExceeded), errors.Is(err, ErrTooManyAttempts), errors.As(err, &lockedErr):
+		return http.StatusTooManyRequests
+	case errors.Is(err, ErrMaintenanceMode), errors.Is(err, ErrReadOnlyMode):
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+func WriteJSON(w http.ResponseWriter, status int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(value)
+}
+
+// WriteError answers {"error": "..."} with the status of err, internal
+// errors are not described to the client.
+func WriteError(w http.ResponseWriter, err error) {
+	status := HTTPStatus(err)
+	message := err.Error()
+	if status == http.StatusInternalServerError {
+		message = http.StatusText(status)
+	}
+	WriteJSON(w, status, map[string]string{"error": message})
+}
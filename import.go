@@ -0,0 +1,300 @@
+package learn_golang_gorm
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrImportHeader = fmt.Errorf("%w: import header does not match the plan columns", ErrInvalidRequest)
+
+// ImportValidation marks the staged rows matching Condition invalid with
+// Message, Condition is SQL over the staging columns.
+type ImportValidation struct {
+	Condition string
+	Message   string
+}
+
+// ImportPlan describes how a CSV file with a header of Columns ends up in
+// Target. Rows are staged as text, validated, and the valid ones merged
+// with an upsert on Target's unique keys: Expressions give the SQL of
+// target columns computed from the staging columns, in place of the text
+// of the staged column of the same name, Update the columns replaced when
+// the row exists. Rows repeating Key within the file are invalid.
+//
+// LoadData stages large files with LOAD DATA LOCAL INFILE, it falls back
+// to multi-row inserts where the server or dialect does not allow it.
+type ImportPlan struct {
+	Target      string
+	Columns     []string
+	Key         []string
+	Validations []ImportValidation
+	Expressions map[string]string
+	Update      []string
+	BatchSize   int
+	LoadData    bool
+}
+
+var ProductImportPlan = ImportPlan{
+	Target:  "products",
+	Columns: []string{"id", "name", "price", "stock"},
+	Key:     []string{"id"},
+	Validations: []ImportValidation{
+		{Condition: "coalesce(id, '') = ''", Message: "id is required"},
+		{Condition: "coalesce(name, '') = ''", Message: "name is required"},
+		{Condition: "coalesce(price, '') not regexp '^[0-9]+$'", Message: "price must be a whole number"},
+		{Condition: "coalesce(stock, '') not regexp '^[0-9]+$'", Message: "stock must be a whole number"},
+	},
+	Expressions: map[string]string{"created_at": "now(3)", "updated_at": "now(3)"},
+	Update:      []string{"name", "price", "stock", "updated_at"},
+	LoadData:    true,
+}
+
+// UserLogImportPlan appends user logs, ip is an address and created_at
+// milliseconds since the epoch, now when empty.
+var UserLogImportPlan = ImportPlan{
+	Target:  "user_logs",
+	Columns: []string{"user_id", "action", "ip", "user_agent", "created_at"},
+	Validations: []ImportValidation{
+		{Condition: "coalesce(user_id, '') = ''", Message: "user_id is required"},
+		{Condition: "coalesce(action, '') = ''", Message: "action is required"},
+		{Condition: "coalesce(ip, '') <> '' and inet6_aton(ip) is null", Message: "ip must be an address"},
+		{Condition: "coalesce(created_at, '') not regexp '^[0-9]*$'", Message: "created_at must be milliseconds"},
+	},
+	Expressions: map[string]string{
+		"ip":         "inet6_aton(nullif(ip, ''))",
+		"created_at": "coalesce(nullif(created_at, ''), floor(unix_timestamp(now(3)) * 1000))",
+		"updated_at": "coalesce(nullif(created_at, ''), floor(unix_timestamp(now(3)) * 1000))",
+	},
+	LoadData: true,
+}
+
+type ImportError struct {
+	Line    int64
+	Message string
+}
+
+type ImportReport struct {
+	Staging string
+	Staged  int64
+	Invalid int64
+	Merged  int64
+	Errors  []ImportError
+}
+
+func (p ImportPlan) batchSize() int {
+	if p.BatchSize <= 0 {
+		return 1000
+	}
+	return p.BatchSize
+}
+
+// CreateStaging creates an empty staging table for the plan and returns
+// its name. line is the line of the row in the file, it counts from 2
+// for rows loaded without one.
+func (p ImportPlan) CreateStaging(ctx context.Context, db *gorm.DB) (string, error) {
+	staging := fmt.Sprintf("import_%s_%d", p.Target, time.Now().UnixNano())
+	columns := make([]string, 0, len(p.Columns)+2)
+	columns = append(columns, "line bigint not null auto_increment primary key")
+	for _, column := range p.Columns {
+		columns = append(columns, fmt.Sprintf("`%s` text null", column))
+	}
+	columns = append(columns, "import_error text null")
+	return staging, db.WithContext(ctx).Exec(fmt.Sprintf("create table `%s` (%s) auto_increment = 2", staging, strings.Join(columns, ", "))).Error
+}
+
+// Stage loads the CSV file into staging, the header must list the plan
+// columns in order. With LoadData the rows are sent with LOAD DATA LOCAL
+// INFILE when the server allows it, else with multi-row inserts.
+func (p ImportPlan) Stage(ctx context.Context, db *gorm.DB, staging string, file io.Reader) (int64, error) {
+	reader := bufio.NewReader(file)
+	line, err := reader.ReadString('\n')
+	if err != nil && (err != io.EOF || line == "") {
+		return 0, err
+	}
+	header, err := csv.NewReader(strings.NewReader(line)).Read()
+	if err != nil || strings.Join(header, ",") != strings.Join(p.Columns, ",") {
+		return 0, ErrImportHeader
+	}
+
+	if p.LoadData {
+		staged, err := p.loadData(ctx, db, staging, reader)
+		if !errors.Is(err, errLoadDataUnavailable) {
+			return staged, err
+		}
+	}
+	return p.insert(ctx, db, staging, reader)
+}
+
+func (p ImportPlan) insert(ctx context.Context, db *gorm.DB, staging string, file io.Reader) (int64, error) {
+	reader := csv.NewReader(file)
+	reader.FieldsPerRecord = len(p.Columns)
+	db = db.WithContext(ctx)
+	var staged int64
+	batch := make([]map[string]interface{}, 0, p.batchSize())
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		err := db.Table(staging).Create(&batch).Error
+		batch = batch[:0]
+		return err
+	}
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return staged, fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+		}
+
+		staged++
+		row := map[string]interface{}{"line": staged + 1}
+		for i, column := range p.Columns {
+			row[column] = record[i]
+		}
+		batch = append(batch, row)
+		if len(batch) == cap(batch) {
+			err = flush()
+			if err != nil {
+				return staged, err
+			}
+		}
+	}
+	return staged, flush()
+}
+
+func keyMatch(key []string) string {
+	conditions := make([]string, len(key))
+	for i, column := range key {
+		conditions[i] = fmt.Sprintf("s.`%s` <=> d.`%s`", column, column)
+	}
+	return strings.Join(conditions, " and ")
+}
+
+// Validate runs the validations and the duplicate key check on staging,
+// then reports the invalid rows.
+func (p ImportPlan) Validate(ctx context.Context, db *gorm.DB, staging string) ([]ImportError, error) {
+	db = db.WithContext(ctx)
+	mark := "import_error = concat_ws('; ', import_error, ?)"
+	for _, validation := range p.Validations {
+		err := db.Exec(fmt.Sprintf("update `%s` set %s where %s", staging, mark, validation.Condition), validation.Message).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(p.Key) > 0 {
+		key := "`" + strings.Join(p.Key, "`, `") + "`"
+		err := db.Exec(fmt.Sprintf("update `%s` s join (select %s, min(line) as first_row from `%s` group by %s having count(*) > 1) d on %s "+
+			"set s.%s where s.line > d.first_row", staging, key, staging, key, keyMatch(p.Key), mark),
+			"duplicate of an earlier row").Error
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var errs []ImportError
+	err := db.Table(staging).Select("line, import_error as message").
+		Where("import_error IS NOT NULL").Order("line").Scan(&errs).Error
+	return errs, err
+}
+
+// Merge upserts the valid staged rows into the target, one transaction
+// per batch of rows.
+func (p ImportPlan) Merge(ctx context.Context, db *gorm.DB, staging string) (int64, error) {
+	db = db.WithContext(ctx)
+	targets := make([]string, 0, len(p.Columns)+len(p.Expressions))
+	selects := make([]string, 0, cap(targets))
+	for _, column := range p.Columns {
+		targets = append(targets, "`"+column+"`")
+		if expression, ok := p.Expressions[column]; ok {
+			selects = append(selects, expression)
+		} else {
+			selects = append(selects, "`"+column+"`")
+		}
+	}
+	computed := make([]string, 0, len(p.Expressions))
+	for column := range p.Expressions {
+		if !slices.Contains(p.Columns, column) {
+			computed = append(computed, column)
+		}
+	}
+	sort.Strings(computed)
+	for _, column := range computed {
+		targets = append(targets, "`"+column+"`")
+		selects = append(selects, p.Expressions[column])
+	}
+	updates := make([]string, len(p.Update))
+	for i, column := range p.Update {
+		updates[i] = fmt.Sprintf("`%s` = values(`%s`)", column, column)
+	}
+	upsert := fmt.Sprintf("insert into `%s` (%s) select %s from `%s` where import_error is null and line > ? and line <= ? order by line",
+		p.Target, strings.Join(targets, ", "), strings.Join(selects, ", "), staging)
+	if len(updates) > 0 {
+		upsert += " on duplicate key update " + strings.Join(updates, ", ")
+	}
+
+	var last int64
+	err := db.Table(staging).Select("coalesce(max(line), 0)").Scan(&last).Error
+	if err != nil {
+		return 0, err
+	}
+
+	var merged int64
+	for from := int64(0); from < last; from += int64(p.batchSize()) {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			var valid int64
+			err := tx.Table(staging).Where("import_error IS NULL AND line > ? AND line <= ?", from, from+int64(p.batchSize())).
+				Count(&valid).Error
+			if err != nil {
+				return err
+			}
+			err = tx.Exec(upsert, from, from+int64(p.batchSize())).Error
+			if err == nil {
+				merged += valid
+			}
+			return err
+		})
+		if err != nil {
+			return merged, err
+		}
+	}
+	return merged, nil
+}
+
+// Import stages, validates and merges file, the staging table is dropped
+// afterwards unless keepStaging is set to look into the rejected rows.
+func (p ImportPlan) Import(ctx context.Context, db *gorm.DB, file io.Reader, keepStaging bool) (ImportReport, error) {
+	var report ImportReport
+	staging, err := p.CreateStaging(ctx, db)
+	if err != nil {
+		return report, err
+	}
+	report.Staging = staging
+	if !keepStaging {
+		defer db.Migrator().DropTable(staging)
+	}
+
+	report.Staged, err = p.Stage(ctx, db, staging, file)
+	if err != nil {
+		return report, err
+	}
+	report.Errors, err = p.Validate(ctx, db, staging)
+	if err != nil {
+		return report, err
+	}
+	report.Invalid = int64(len(report.Errors))
+	report.Merged, err = p.Merge(ctx, db, staging)
+	return report, err
+}
@@ -0,0 +1,181 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	IndexUnused    = "unused"
+	IndexRedundant = "redundant"
+)
+
+type IndexFinding struct {
+	Table         string
+	Index         string
+	Columns       []string
+	Kind          string
+	DominantIndex string
+	Reason        string
+	SuggestedDrop string
+}
+
+type tableIndex struct {
+	table   string
+	name    string
+	unique  bool
+	columns []string
+}
+
+func loadIndexes(ctx context.Context, db *gorm.DB, tables []string) ([]tableIndex, error) {
+	var rows []struct {
+		TableName  string
+		IndexName  string
+		NonUnique  bool
+		ColumnName string
+	}
+	err := db.WithContext(ctx).Raw(`SELECT table_name AS table_name, index_name AS index_name,
+		non_unique AS non_unique, coalesce(column_name, '') AS column_name
+		FROM information_schema.statistics
+		WHERE table_schema = DATABASE() AND table_name IN ?
+		ORDER BY table_name, index_name, seq_in_index`, tables).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var indexes []tableIndex
+	for _, row := range rows {
+		last := len(indexes) - 1
+		if last < 0 || indexes[last].table != row.TableName || indexes[last].name != row.IndexName {
+			indexes = append(indexes, tableIndex{table: row.TableName, name: row.IndexName, unique: !row.NonUnique})
+			last++
+		}
+		indexes[last].columns = append(indexes[last].columns, row.ColumnName)
+	}
+	return indexes, nil
+}
+
+func dropIndexSQL(table string, index string) string {
+	return fmt.Sprintf("ALTER TABLE `%s` DROP INDEX `%s`", table, index)
+}
+
+func isPrefix(prefix []string, columns []string) bool {
+	if len(prefix) > len(columns) {
+		return false
+	}
+	for i := range prefix {
+		if !strings.EqualFold(prefix[i], columns[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// redundantIndexes finds indexes whose columns are a leftmost prefix of
+// another index of the same table, unique indexes are only redundant to an
+// index on exactly the same columns that is unique too.
+func redundantIndexes(indexes []tableIndex) []IndexFinding {
+	var findings []IndexFinding
+	for i, index := range indexes {
+		if index.name == "PRIMARY" {
+			continue
+		}
+		for j, other := range indexes {
+			if i == j || other.table != index.table || !isPrefix(index.columns, other.columns) {
+				continue
+			}
+			sameColumns := len(index.columns) == len(other.columns)
+			if index.unique && !(sameColumns && other.unique) {
+				continue
+			}
+			// of two identical indexes only the later one is reported
+			if sameColumns && index.unique == other.unique && other.name != "PRIMARY" && j > i {
+				continue
+			}
+
+			findings = append(findings, IndexFinding{
+				Table:         index.table,
+				Index:         index.name,
+				Columns:       index.columns,
+				Kind:          IndexRedundant,
+				DominantIndex: other.name,
+				Reason:        fmt.Sprintf("columns are a prefix of %s (%s)", other.name, strings.Join(other.columns, ", ")),
+				SuggestedDrop: dropIndexSQL(index.table, index.name),
+			})
+			break
+		}
+	}
+	return findings
+}
+
+// unusedIndexes reads performance_schema, whose counters start at zero on
+// every server restart, so an index is only unused since then. Unique
+// indexes are skipped since they enforce constraints.
+func unusedIndexes(ctx context.Context, db *gorm.DB, indexes []tableIndex, tables []string) ([]IndexFinding, error) {
+	var used []struct {
+		ObjectName string
+		IndexName  string
+		CountStar  int64
+	}
+	err := db.WithContext(ctx).Raw(`SELECT object_name AS object_name, index_name AS index_name, count_star AS count_star
+		FROM performance_schema.table_io_waits_summary_by_index_usage
+		WHERE object_schema = DATABASE() AND object_name IN ? AND index_name IS NOT NULL`, tables).Scan(&used).Error
+	if err != nil {
+		return nil, err
+	}
+
+	counts := map[string]int64{}
+	for _, row := range used {
+		counts[row.ObjectName+"."+row.IndexName] = row.CountStar
+	}
+
+	var findings []IndexFinding
+	for _, index := range indexes {
+		count, tracked := counts[index.table+"."+index.name]
+		if index.unique || !tracked || count > 0 {
+			continue
+		}
+		findings = append(findings, IndexFinding{
+			Table:         index.table,
+			Index:         index.name,
+			Columns:       index.columns,
+			Kind:          IndexUnused,
+			Reason:        "not used since the server started",
+			SuggestedDrop: dropIndexSQL(index.table, index.name),
+		})
+	}
+	return findings, nil
+}
+
+// IndexReport lists the unused and redundant indexes on the tables of the
+// registered models. Check foreign keys before dropping, MySQL needs an
+// index on the referencing columns.
+func IndexReport(ctx context.Context, db *gorm.DB) ([]IndexFinding, error) {
+	tables, err := ModelTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	indexes, err := loadIndexes(ctx, db, tables)
+	if err != nil {
+		return nil, err
+	}
+
+	findings, err := unusedIndexes(ctx, db, indexes, tables)
+	if err != nil {
+		return nil, err
+	}
+	findings = append(findings, redundantIndexes(indexes)...)
+
+	sort.SliceStable(findings, func(i, j int) bool {
+		if findings[i].Table != findings[j].Table {
+			return findings[i].Table < findings[j].Table
+		}
+		return findings[i].Index < findings[j].Index
+	})
+	return findings, nil
+}
@@ -0,0 +1,100 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"fmt"
+	"net/netip"
+
+	"gorm.io/gorm"
+)
+
+// INET stores an IP address in a VARBINARY(16) column, 4 bytes for IPv4 and
+// 16 bytes for IPv6 like MySQL's INET6_ATON, so ranges compare bytewise.
+type INET struct {
+	netip.Addr
+}
+
+func ParseINET(value string) (INET, error) {
+	addr, err := netip.ParseAddr(value)
+	if err != nil {
+		return INET{}, err
+	}
+	return INET{addr.Unmap()}, nil
+}
+
+func (i INET) Value() (driver.Value, error) {
+	if !i.IsValid() {
+		return nil, nil
+	}
+	return i.Unmap().AsSlice(), nil
+}
+
+func (i *INET) Scan(value interface{}) error {
+	switch v := value.(type) {
+	case nil:
+		*i = INET{}
+		return nil
+	case []byte:
+		addr, ok := netip.AddrFromSlice(v)
+		if !ok {
+			return fmt.Errorf("invalid INET length %d", len(v))
+		}
+		*i = INET{addr}
+		return nil
+	}
+	return fmt.Errorf("cannot scan %T into INET", value)
+}
+
+func (i INET) GormDataType() string {
+	return "varbinary(16)"
+}
+
+func lastAddr(prefix netip.Prefix) netip.Addr {
+	bytes := prefix.Masked().Addr().AsSlice()
+	for bit := prefix.Bits(); bit < len(bytes)*8; bit++ {
+		bytes[bit/8] |= 1 << (7 - bit%8)
+	}
+	addr, _ := netip.AddrFromSlice(bytes)
+	return addr
+}
+
+// IPInRange is a scope matching rows whose column holds an address inside
+// cidr, e.g. db.Scopes(IPInRange("ip", prefix)).Find(&userLogs).
+func IPInRange(column string, cidr netip.Prefix) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		if cidr.Addr().Is4In6() {
+			cidr = netip.PrefixFrom(cidr.Addr().Unmap(), cidr.Bits()-96)
+		}
+		first := INET{cidr.Masked().Addr()}
+		last := INET{lastAddr(cidr)}
+
+		length := "length"
+		if db.Dialector.Name() == "sqlserver" {
+			length = "datalength"
+		}
+		return db.Where(column+" BETWEEN ? AND ? AND "+length+"("+column+") = ?", first, last, cidr.Addr().BitLen()/8)
+	}
+}
+
+type clientInfoKey struct{}
+
+type ClientInfo struct {
+	IP        INET
+	UserAgent string
+}
+
+// WithClientInfo attaches the address and user agent of the caller to ctx,
+// user logs created with it (db.WithContext(ctx)) record them automatically.
+func WithClientInfo(ctx context.Context, ip string, userAgent string) context.Context {
+	inet, _ := ParseINET(ip)
+	return context.WithValue(ctx, clientInfoKey{}, ClientInfo{IP: inet, UserAgent: userAgent})
+}
+
+func ClientInfoFromContext(ctx context.Context) (ClientInfo, bool) {
+	if ctx == nil {
+		return ClientInfo{}, false
+	}
+	info, ok := ctx.Value(clientInfoKey{}).(ClientInfo)
+	return info, ok
+}
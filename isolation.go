@@ -0,0 +1,88 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var ErrUnsupportedIsolation = errors.New("isolation level is not supported by the dialect")
+
+type isolationKey struct{}
+
+// WithIsolation returns a context whose transactions started through
+// RunInTransaction use level, unless the operation asks for a level itself.
+func WithIsolation(ctx context.Context, level sql.IsolationLevel) context.Context {
+	return context.WithValue(ctx, isolationKey{}, level)
+}
+
+func IsolationFromContext(ctx context.Context) (sql.IsolationLevel, bool) {
+	level, ok := ctx.Value(isolationKey{}).(sql.IsolationLevel)
+	return level, ok
+}
+
+var supportedIsolations = map[string][]sql.IsolationLevel{
+	"mysql":     {sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable},
+	"postgres":  {sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSerializable},
+	"sqlserver": {sql.LevelReadUncommitted, sql.LevelReadCommitted, sql.LevelRepeatableRead, sql.LevelSnapshot, sql.LevelSerializable},
+	"sqlite":    {sql.LevelSerializable},
+}
+
+func ValidateIsolation(db *gorm.DB, level sql.IsolationLevel) error {
+	if level == sql.LevelDefault {
+		return nil
+	}
+
+	name := db.Dialector.Name()
+	for _, supported := range supportedIsolations[name] {
+		if supported == level {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s on %s", ErrUnsupportedIsolation, level, name)
+}
+
+type TxOptions struct {
+	Isolation sql.IsolationLevel
+	ReadOnly  bool
+}
+
+type TxOption func(options *TxOptions)
+
+func Isolation(level sql.IsolationLevel) TxOption {
+	return func(options *TxOptions) {
+		options.Isolation = level
+	}
+}
+
+func ReadOnly() TxOption {
+	return func(options *TxOptions) {
+		options.ReadOnly = true
+	}
+}
+
+// RunInTransaction runs fn in a transaction bound to ctx. The isolation level
+// given as option wins over the one of WithIsolation, without either the
+// database default is used.
+func RunInTransaction(ctx context.Context, db *gorm.DB, fn func(tx *gorm.DB) error, opts ...TxOption) error {
+	options := TxOptions{}
+	if level, ok := IsolationFromContext(ctx); ok {
+		options.Isolation = level
+	}
+	for _, opt := range opts {
+		opt(&options)
+	}
+
+	err := ValidateIsolation(db, options.Isolation)
+	if err != nil {
+		return err
+	}
+
+	return db.WithContext(ctx).Transaction(fn, &sql.TxOptions{
+		Isolation: options.Isolation,
+		ReadOnly:  options.ReadOnly,
+	})
+}
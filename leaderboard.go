@@ -0,0 +1,93 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	// RankStandard skips positions after ties: 1, 1, 3.
+	RankStandard = "rank"
+	// RankDense does not skip positions after ties: 1, 1, 2.
+	RankDense = "dense_rank"
+)
+
+type LeaderboardEntry struct {
+	Ranking int64  `gorm:"column:ranking" json:"rank"`
+	ID      string `gorm:"column:id" json:"id"`
+	Name    string `gorm:"column:name" json:"name"`
+	Score   int64  `gorm:"column:score" json:"score"`
+}
+
+// Leaderboard ranks the rows of Source, which selects the columns id, name
+// and score, by score descending. Entries with the same rank are ordered by
+// id so pages are stable.
+type Leaderboard struct {
+	Ranking string
+	Source  func(db *gorm.DB) *gorm.DB
+}
+
+var (
+	WalletBalanceLeaderboard = Leaderboard{
+		Ranking: RankStandard,
+		Source: func(db *gorm.DB) *gorm.DB {
+			return db.Table("wallets w").Joins("join users u on u.id = w.user_id").
+				Select("w.user_id as id, concat_ws(' ', u.first_name, u.last_name) as name, w.balance as score")
+		},
+	}
+	MostLikedProductsLeaderboard = Leaderboard{
+		Ranking: RankDense,
+		Source: func(db *gorm.DB) *gorm.DB {
+			return db.Table("products p").Joins("join user_like_product l on l.product_id = p.id").
+				Select("p.id as id, p.name as name, count(*) as score").Group("p.id, p.name")
+		},
+	}
+)
+
+// MostActiveUsersLeaderboard ranks users by their number of user logs since
+// the given time.
+func MostActiveUsersLeaderboard(since time.Time) Leaderboard {
+	return Leaderboard{
+		Ranking: RankDense,
+		Source: func(db *gorm.DB) *gorm.DB {
+			return db.Table("user_logs l").Joins("join users u on u.id = l.user_id").
+				Select("u.id as id, concat_ws(' ', u.first_name, u.last_name) as name, count(*) as score").
+				Where("l.created_at >= ?", since.UnixMilli()).Group("u.id, u.first_name, u.last_name")
+		},
+	}
+}
+
+func (b Leaderboard) ranked(db *gorm.DB) *gorm.DB {
+	ranking := b.Ranking
+	if ranking != RankDense {
+		ranking = RankStandard
+	}
+
+	source := b.Source(db.Session(&gorm.Session{NewDB: true}))
+	return db.Session(&gorm.Session{NewDB: true}).Table("(?) as s", source).
+		Select("s.id, s.name, s.score, " + ranking + "() over (order by s.score desc) as ranking, " +
+			"row_number() over (order by s.score desc, s.id) as position")
+}
+
+func (b Leaderboard) Page(ctx context.Context, db *gorm.DB, page int, size int) ([]LeaderboardEntry, error) {
+	db = db.WithContext(ctx)
+	var entries []LeaderboardEntry
+	err := db.Table("(?) as r", b.ranked(db)).Order("r.position").Scopes(Paginate(page, size)).Find(&entries).Error
+	return entries, err
+}
+
+// AroundMe returns the entry of id with up to neighbors entries ranked
+// before and after it, gorm.ErrRecordNotFound when id is not ranked.
+func (b Leaderboard) AroundMe(ctx context.Context, db *gorm.DB, id string, neighbors int) ([]LeaderboardEntry, error) {
+	db = db.WithContext(ctx)
+	var entries []LeaderboardEntry
+	err := db.Raw("with r as (?) select r.id, r.name, r.score, r.ranking from r "+
+		"join (select position from r where id = ?) me on r.position between me.position - ? and me.position + ? "+
+		"order by r.position", b.ranked(db), id, neighbors, neighbors).Scan(&entries).Error
+	if err == nil && len(entries) == 0 {
+		err = gorm.ErrRecordNotFound
+	}
+	return entries, err
+}
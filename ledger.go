@@ -0,0 +1,229 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// HashChain is embedded by append-only tables, every row stores the hash of
+// the previous row and its own hash over (previous hash + row contents).
+type HashChain struct {
+	PrevHash string `gorm:"column:prev_hash;type:char(64)"`
+	Hash     string `gorm:"column:hash;type:char(64)"`
+}
+
+type Chained interface {
+	TableName() string
+	ChainID() int64
+	ChainContent() string
+	ChainLink() *HashChain
+}
+
+type LedgerHead struct {
+	Table     string    `gorm:"primary_key;column:table_name"`
+	Hash      string    `gorm:"column:hash;type:char(64)"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (h *LedgerHead) TableName() string {
+	return "ledger_heads"
+}
+
+type LedgerAnchor struct {
+	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
+	Table     string    `gorm:"column:table_name"`
+	FromID    int64     `gorm:"column:from_id"`
+	PrevHash  string    `gorm:"column:prev_hash;type:char(64)"`
+	Reason    string    `gorm:"column:reason"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (a *LedgerAnchor) TableName() string {
+	return "ledger_anchors"
+}
+
+func ChainHash(prevHash string, content string) string {
+	sum := sha256.Sum256([]byte(prevHash + "\n" + content))
+	return hex.EncodeToString(sum[:])
+}
+
+func lockLedgerHead(tx *gorm.DB, table string) (LedgerHead, error) {
+	err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&LedgerHead{Table: table}).Error
+	if err != nil {
+		return LedgerHead{}, err
+	}
+
+	var head LedgerHead
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&head, "table_name = ?", table).Error
+	return head, err
+}
+
+// AppendChained inserts record at the end of its chain. The ledger head row
+// is locked for the rest of tx, so appends to the same table are serialized
+// and the chain never forks.
+func AppendChained(tx *gorm.DB, record Chained) error {
+	head, err := lockLedgerHead(tx, record.TableName())
+	if err != nil {
+		return err
+	}
+
+	// the content covers the creation time, take it from the clock of tx
+	stmt := &gorm.Statement{DB: tx}
+	err = stmt.Parse(record)
+	if err != nil {
+		return err
+	}
+	if field := stmt.Schema.LookUpField("CreatedAt"); field != nil {
+		value := reflect.ValueOf(record)
+		if _, zero := field.ValueOf(tx.Statement.Context, value); zero {
+			err = field.Set(tx.Statement.Context, value, Now(tx).Truncate(time.Millisecond))
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	link := record.ChainLink()
+	link.PrevHash = head.Hash
+	link.Hash = ChainHash(head.Hash, record.ChainContent())
+
+	err = tx.Create(record).Error
+	if err != nil {
+		return err
+	}
+
+	return tx.Model(&head).Update("hash", link.Hash).Error
+}
+
+type ChainViolation struct {
+	ID       int64
+	Reason   string
+	Expected string
+	Actual   string
+}
+
+func previousChainHash(db *gorm.DB, table string, id int64) (string, error) {
+	var hashes []string
+	err := db.Table(table).Where("id < ?", id).Order("id desc").Limit(1).Pluck("hash", &hashes).Error
+	if err != nil || len(hashes) == 0 {
+		return "", err
+	}
+	return hashes[0], nil
+}
+
+// VerifyChain recomputes the chain of rows with from <= id <= to, to <= 0
+// means up to the last row, and reports every row that was modified,
+// removed or inserted out of band.
+func VerifyChain[T any, PT interface {
+	*T
+	Chained
+}](ctx context.Context, db *gorm.DB, from int64, to int64) ([]ChainViolation, error) {
+	db = db.WithContext(ctx)
+	table := PT(new(T)).TableName()
+
+	expected, err := previousChainHash(db, table, from)
+	if err != nil {
+		return nil, err
+	}
+
+	query := db.Where("id >= ?", from)
+	if to > 0 {
+		query = query.Where("id <= ?", to)
+	}
+
+	var violations []ChainViolation
+	var rows []T
+	lastID := int64(0)
+	err = query.Order("id asc").FindInBatches(&rows, 500, func(tx *gorm.DB, batch int) error {
+		for i := range rows {
+			record := PT(&rows[i])
+			link := record.ChainLink()
+			if link.PrevHash != expected {
+				violations = append(violations, ChainViolation{
+					ID: record.ChainID(), Reason: "broken link", Expected: expected, Actual: link.PrevHash,
+				})
+			}
+
+			hash := ChainHash(link.PrevHash, record.ChainContent())
+			if link.Hash != hash {
+				violations = append(violations, ChainViolation{
+					ID: record.ChainID(), Reason: "content modified", Expected: hash, Actual: link.Hash,
+				})
+			}
+
+			expected = link.Hash
+			lastID = record.ChainID()
+		}
+		return nil
+	}).Error
+	if err != nil {
+		return nil, err
+	}
+
+	if to <= 0 {
+		var head LedgerHead
+		err = db.Take(&head, "table_name = ?", table).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil, err
+		}
+		if head.Hash != expected {
+			violations = append(violations, ChainViolation{
+				ID: lastID, Reason: "head mismatch", Expected: head.Hash, Actual: expected,
+			})
+		}
+	}
+	return violations, nil
+}
+
+// ReanchorChain accepts the current contents of the rows with id >= from,
+// recomputes their links and hashes and records why in ledger_anchors. It is
+// the repair step after a violation has been investigated.
+func ReanchorChain[T any, PT interface {
+	*T
+	Chained
+}](ctx context.Context, db *gorm.DB, from int64, reason string) error {
+	return db.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		table := PT(new(T)).TableName()
+		head, err := lockLedgerHead(tx, table)
+		if err != nil {
+			return err
+		}
+
+		prevHash, err := previousChainHash(tx, table, from)
+		if err != nil {
+			return err
+		}
+
+		err = tx.Create(&LedgerAnchor{Table: table, FromID: from, PrevHash: prevHash, Reason: reason}).Error
+		if err != nil {
+			return err
+		}
+
+		var rows []T
+		err = tx.Where("id >= ?", from).Order("id asc").FindInBatches(&rows, 500, func(batchTx *gorm.DB, batch int) error {
+			for i := range rows {
+				record := PT(&rows[i])
+				hash := ChainHash(prevHash, record.ChainContent())
+				err := tx.Table(table).Where("id = ?", record.ChainID()).
+					UpdateColumns(map[string]interface{}{"prev_hash": prevHash, "hash": hash}).Error
+				if err != nil {
+					return err
+				}
+				prevHash = hash
+			}
+			return nil
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Model(&head).Update("hash", prevHash).Error
+	})
+}
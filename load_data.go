@@ -0,0 +1,66 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+var errLoadDataUnavailable = errors.New("LOAD DATA LOCAL INFILE is unavailable")
+
+var loadDataDisabledErrors = map[uint16]bool{
+	1148: true, // command not allowed with this MySQL version
+	3948: true, // loading local data is disabled
+}
+
+// countingReader tells whether the server started reading the file, after
+// that the rows cannot be sent again.
+type countingReader struct {
+	io.Reader
+	read int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += int64(n)
+	return n, err
+}
+
+// loadData streams the CSV rows after the header into staging with LOAD
+// DATA LOCAL INFILE, through a reader registered with the driver so no
+// file or allowAllFiles is needed. It returns errLoadDataUnavailable when
+// the dialect is not MySQL or local_infile is off on the server, before
+// anything was read.
+func (p ImportPlan) loadData(ctx context.Context, db *gorm.DB, staging string, file io.Reader) (int64, error) {
+	if db.Dialector.Name() != "mysql" {
+		return 0, errLoadDataUnavailable
+	}
+
+	reader := &countingReader{Reader: file}
+	mysql.RegisterReaderHandler(staging, func() io.Reader {
+		return reader
+	})
+	defer mysql.DeregisterReaderHandler(staging)
+
+	// the last field goes through a variable to drop the \r of CRLF files
+	columns := make([]string, len(p.Columns))
+	for i, column := range p.Columns {
+		columns[i] = "`" + column + "`"
+	}
+	last := p.Columns[len(p.Columns)-1]
+	columns[len(columns)-1] = "@import_last"
+	result := db.WithContext(ctx).Exec(fmt.Sprintf("load data local infile 'Reader::%s' into table `%s` character set utf8mb4 "+
+		"fields terminated by ',' optionally enclosed by '\"' escaped by '' lines terminated by '\\n' (%s) "+
+		"set `%s` = trim(trailing '\\r' from @import_last)", staging, staging, strings.Join(columns, ", "), last))
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(result.Error, &mysqlErr) && loadDataDisabledErrors[mysqlErr.Number] && reader.read == 0 {
+		return 0, errLoadDataUnavailable
+	}
+	return result.RowsAffected, result.Error
+}
@@ -0,0 +1,138 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type loaderBatch[K comparable, V any] struct {
+	keys    []K
+	seen    map[K]bool
+	once    sync.Once
+	done    chan struct{}
+	results map[K]V
+	err     error
+}
+
+// Loader implements the dataloader pattern: keys requested within Wait of
+// each other are collected and fetched with a single query, then every
+// caller gets its own value. It avoids N+1 queries where Preload can not be
+// used, e.g. when composing results of different services.
+type Loader[K comparable, V any] struct {
+	fetch    func(ctx context.Context, keys []K) (map[K]V, error)
+	Wait     time.Duration
+	MaxBatch int
+
+	mutex sync.Mutex
+	batch *loaderBatch[K, V]
+}
+
+func NewLoader[K comparable, V any](wait time.Duration, fetch func(ctx context.Context, keys []K) (map[K]V, error)) *Loader[K, V] {
+	return &Loader[K, V]{
+		fetch:    fetch,
+		Wait:     wait,
+		MaxBatch: 500,
+	}
+}
+
+// FetchByColumn returns a fetcher loading the rows of V whose column is one
+// of the keys, keyed by keyOf.
+func FetchByColumn[K comparable, V any](db *gorm.DB, column string, keyOf func(value V) K) func(ctx context.Context, keys []K) (map[K]V, error) {
+	return func(ctx context.Context, keys []K) (map[K]V, error) {
+		var values []V
+		err := db.WithContext(ctx).Where(column+" IN ?", keys).Find(&values).Error
+		if err != nil {
+			return nil, err
+		}
+
+		results := make(map[K]V, len(values))
+		for _, value := range values {
+			results[keyOf(value)] = value
+		}
+		return results, nil
+	}
+}
+
+// FetchGroupedByColumn is FetchByColumn for one to many relations, every key
+// gets the slice of rows referencing it.
+func FetchGroupedByColumn[K comparable, V any](db *gorm.DB, column string, keyOf func(value V) K) func(ctx context.Context, keys []K) (map[K][]V, error) {
+	return func(ctx context.Context, keys []K) (map[K][]V, error) {
+		var values []V
+		err := db.WithContext(ctx).Where(column+" IN ?", keys).Find(&values).Error
+		if err != nil {
+			return nil, err
+		}
+
+		results := make(map[K][]V, len(keys))
+		for _, value := range values {
+			key := keyOf(value)
+			results[key] = append(results[key], value)
+		}
+		return results, nil
+	}
+}
+
+// dispatch runs once per batch, either when the window elapses or as soon as
+// the batch is full.
+func (l *Loader[K, V]) dispatch(ctx context.Context, batch *loaderBatch[K, V]) {
+	batch.once.Do(func() {
+		l.mutex.Lock()
+		if l.batch == batch {
+			l.batch = nil
+		}
+		l.mutex.Unlock()
+
+		batch.results, batch.err = l.fetch(ctx, batch.keys)
+		close(batch.done)
+	})
+}
+
+func (l *Loader[K, V]) enqueue(ctx context.Context, key K) *loaderBatch[K, V] {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+
+	batch := l.batch
+	if batch == nil {
+		batch = &loaderBatch[K, V]{seen: map[K]bool{}, done: make(chan struct{})}
+		l.batch = batch
+		time.AfterFunc(l.Wait, func() {
+			l.dispatch(context.WithoutCancel(ctx), batch)
+		})
+	}
+
+	if !batch.seen[key] {
+		batch.seen[key] = true
+		batch.keys = append(batch.keys, key)
+	}
+
+	if l.MaxBatch > 0 && len(batch.keys) >= l.MaxBatch {
+		l.batch = nil
+		go l.dispatch(context.WithoutCancel(ctx), batch)
+	}
+	return batch
+}
+
+// Load returns the value of key, or gorm.ErrRecordNotFound when the batch
+// query did not return it.
+func (l *Loader[K, V]) Load(ctx context.Context, key K) (V, error) {
+	batch := l.enqueue(ctx, key)
+
+	var zero V
+	select {
+	case <-ctx.Done():
+		return zero, ctx.Err()
+	case <-batch.done:
+	}
+
+	if batch.err != nil {
+		return zero, batch.err
+	}
+	value, ok := batch.results[key]
+	if !ok {
+		return zero, gorm.ErrRecordNotFound
+	}
+	return value, nil
+}
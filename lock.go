@@ -0,0 +1,24 @@
+package learn_golang_gorm
+
+import (
+	"cmp"
+	"slices"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// LockRows locks the rows of dest's table with the given primary keys using
+// FOR UPDATE. The keys are always locked in ascending order, so two
+// transactions locking an overlapping set of rows can never wait on each
+// other in a cycle.
+func LockRows[K cmp.Ordered](tx *gorm.DB, dest interface{}, ids []K) error {
+	sorted := slices.Clone(ids)
+	slices.Sort(sorted)
+	sorted = slices.Compact(sorted)
+
+	return tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Where("id IN ?", sorted).
+		Order("id asc").
+		Find(dest).Error
+}
@@ -0,0 +1,195 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/logger"
+)
+
+const (
+	SettingLogSampleEvery   = "log_sample_every"
+	SettingLogStatementRate = "log_statement_rate"
+)
+
+// literalPattern matches the values gorm puts into the logged SQL, removing
+// them leaves the statement.
+var literalPattern = regexp.MustCompile(`'(?:[^'\\]|\\.)*'|"(?:[^"\\]|\\.)*"|\b\d+(?:\.\d+)?\b`)
+
+// LogSampling decides which successful queries are logged: one in Every,
+// and of those at most PerSecond a second for the same statement, zero
+// meaning no limit. The settings table overrides both at runtime, like the
+// maintenance flag, see Set and Watch.
+type LogSampling struct {
+	DB        *gorm.DB
+	Every     int64
+	PerSecond int64
+	Clock     Clock
+	OnError   func(err error)
+
+	every     int64
+	perSecond int64
+	counter   uint64
+
+	mutex  sync.Mutex
+	second int64
+	counts map[string]int64
+}
+
+func NewLogSampling(db *gorm.DB, every, perSecond int64) *LogSampling {
+	s := &LogSampling{DB: db, Every: every, PerSecond: perSecond}
+	s.store(every, perSecond)
+	return s
+}
+
+func (s *LogSampling) store(every, perSecond int64) {
+	atomic.StoreInt64(&s.every, every)
+	atomic.StoreInt64(&s.perSecond, perSecond)
+}
+
+// Rates returns the sampling in use, after the last refresh.
+func (s *LogSampling) Rates() (every, perSecond int64) {
+	return atomic.LoadInt64(&s.every), atomic.LoadInt64(&s.perSecond)
+}
+
+// Set changes the sampling for every process watching the settings, this
+// process uses it right away.
+func (s *LogSampling) Set(ctx context.Context, every, perSecond int64) error {
+	settings := []Setting{
+		{Key: SettingLogSampleEvery, Value: strconv.FormatInt(every, 10)},
+		{Key: SettingLogStatementRate, Value: strconv.FormatInt(perSecond, 10)},
+	}
+	err := s.DB.WithContext(WithMaintenanceBypass(ctx)).
+		Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"})}).
+		Create(&settings).Error
+	if err == nil {
+		s.store(every, perSecond)
+	}
+	return err
+}
+
+// Refresh loads the settings, a missing or invalid one goes back to the
+// value given to NewLogSampling.
+func (s *LogSampling) Refresh(ctx context.Context) error {
+	var settings []Setting
+	err := s.DB.WithContext(ctx).Where("`key` IN ?", []string{SettingLogSampleEvery, SettingLogStatementRate}).Find(&settings).Error
+	if err != nil {
+		return err
+	}
+
+	every, perSecond := s.Every, s.PerSecond
+	for _, setting := range settings {
+		value, err := strconv.ParseInt(setting.Value, 10, 64)
+		if err != nil || value < 0 {
+			continue
+		}
+		switch setting.Key {
+		case SettingLogSampleEvery:
+			every = value
+		case SettingLogStatementRate:
+			perSecond = value
+		}
+	}
+	s.store(every, perSecond)
+	return nil
+}
+
+// Watch refreshes the settings every interval until ctx is done. A failed
+// refresh keeps the last known sampling and is reported to OnError.
+func (s *LogSampling) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := s.Refresh(ctx)
+		if err != nil && ctx.Err() == nil && s.OnError != nil {
+			s.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// sampled counts the query and tells whether it is the one in Every.
+func (s *LogSampling) sampled() bool {
+	every := atomic.LoadInt64(&s.every)
+	counter := atomic.AddUint64(&s.counter, 1)
+	return every <= 1 || counter%uint64(every) == 0
+}
+
+// allow counts the statement in the current second, the counts start over
+// every second so statements no longer run are forgotten.
+func (s *LogSampling) allow(statement string) bool {
+	perSecond := atomic.LoadInt64(&s.perSecond)
+	if perSecond <= 0 {
+		return true
+	}
+
+	clock := s.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	second := clock.Now().Unix()
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if second != s.second || s.counts == nil {
+		s.second, s.counts = second, map[string]int64{}
+	}
+	s.counts[statement]++
+	return s.counts[statement] <= perSecond
+}
+
+// SamplingLogger passes errors and queries slower than SlowThreshold to the
+// logger it wraps, and the other queries as Sampling allows.
+type SamplingLogger struct {
+	logger.Interface
+	Sampling      *LogSampling
+	SlowThreshold time.Duration
+}
+
+func NewSamplingLogger(base logger.Interface, sampling *LogSampling) *SamplingLogger {
+	return &SamplingLogger{Interface: base, Sampling: sampling, SlowThreshold: 200 * time.Millisecond}
+}
+
+func (l *SamplingLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.Interface = l.Interface.LogMode(level)
+	return &copied
+}
+
+func (l *SamplingLogger) Trace(ctx context.Context, begin time.Time, fc func() (sql string, rowsAffected int64), err error) {
+	if err != nil || time.Since(begin) >= l.SlowThreshold {
+		l.Interface.Trace(ctx, begin, fc, err)
+		return
+	}
+	if !l.Sampling.sampled() {
+		return
+	}
+
+	sql, rows := fc()
+	if !l.Sampling.allow(literalPattern.ReplaceAllString(sql, "?")) {
+		return
+	}
+	l.Interface.Trace(ctx, begin, func() (string, int64) {
+		return sql, rows
+	}, err)
+}
+
+// ParamsFilter keeps the redaction of the wrapped logger working.
+func (l *SamplingLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if filter, ok := l.Interface.(gorm.ParamsFilter); ok {
+		return filter.ParamsFilter(ctx, sql, params...)
+	}
+	return sql, params
+}
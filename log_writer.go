@@ -0,0 +1,143 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrLogWriterClosed = errors.New("log writer is closed")
+
+// LogWriter buffers user logs and inserts them in batches, when BatchSize
+// logs are buffered or every FlushInterval, instead of a row per Create.
+// Failed inserts are not retried, a lost connection may have committed
+// them, they are reported to OnError which may write the logs elsewhere.
+//
+// Write blocks while the buffer is full, with DropWhenFull it drops the
+// log instead and counts it in Dropped.
+type LogWriter struct {
+	DB            *gorm.DB
+	BatchSize     int
+	FlushInterval time.Duration
+	DropWhenFull  bool
+	OnError       func(logs []UserLog, err error)
+
+	logs     chan UserLog
+	stopping chan struct{}
+	mutex    sync.RWMutex
+	closed   bool
+	dropped  atomic.Int64
+}
+
+// NewLogWriter returns a writer buffering up to capacity logs, start it
+// with Run.
+func NewLogWriter(db *gorm.DB, capacity int) *LogWriter {
+	return &LogWriter{
+		DB:            db,
+		BatchSize:     500,
+		FlushInterval: time.Second,
+		logs:          make(chan UserLog, capacity),
+		stopping:      make(chan struct{}),
+	}
+}
+
+// Write queues log, the client info of ctx and the creation time are
+// taken now since the insert happens later.
+func (w *LogWriter) Write(ctx context.Context, log UserLog) error {
+	if info, ok := ClientInfoFromContext(ctx); ok {
+		if !log.IP.IsValid() {
+			log.IP = info.IP
+		}
+		if log.UserAgent == "" {
+			log.UserAgent = info.UserAgent
+		}
+	}
+	if log.CreatedAt == 0 {
+		log.CreatedAt = Now(w.DB).UnixMilli()
+		log.UpdatedAt = log.CreatedAt
+	}
+
+	w.mutex.RLock()
+	defer w.mutex.RUnlock()
+	if w.closed {
+		return ErrLogWriterClosed
+	}
+	if w.DropWhenFull {
+		select {
+		case w.logs <- log:
+		default:
+			w.dropped.Add(1)
+		}
+		return nil
+	}
+	select {
+	case w.logs <- log:
+		return nil
+	case <-w.stopping:
+		return ErrLogWriterClosed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Dropped is the number of logs dropped because the buffer was full.
+func (w *LogWriter) Dropped() int64 {
+	return w.dropped.Load()
+}
+
+func (w *LogWriter) insert(ctx context.Context, logs []UserLog) {
+	err := w.DB.WithContext(ctx).Create(&logs).Error
+	if err != nil && w.OnError != nil {
+		w.OnError(logs, err)
+	}
+}
+
+// Run inserts the buffered logs until ctx is done, then stops accepting
+// logs and inserts the remaining ones before returning.
+func (w *LogWriter) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]UserLog, 0, w.BatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) > 0 {
+			w.insert(ctx, batch)
+			batch = make([]UserLog, 0, w.BatchSize)
+		}
+	}
+	for {
+		select {
+		case log := <-w.logs:
+			batch = append(batch, log)
+			if len(batch) >= w.BatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+		case <-ctx.Done():
+			// writers blocked on a full buffer give up before the lock
+			close(w.stopping)
+			w.mutex.Lock()
+			w.closed = true
+			w.mutex.Unlock()
+
+			shutdown := context.WithoutCancel(ctx)
+			for {
+				select {
+				case log := <-w.logs:
+					batch = append(batch, log)
+					if len(batch) >= w.BatchSize {
+						flush(shutdown)
+					}
+				default:
+					flush(shutdown)
+					return ctx.Err()
+				}
+			}
+		}
+	}
+}
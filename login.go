@@ -0,0 +1,201 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var (
+	ErrInvalidCredentials = errors.New("invalid user or password")
+	ErrTooManyAttempts    = errors.New("too many failed logins from this address, try again later")
+)
+
+type AccountLockedError struct {
+	Until time.Time
+}
+
+func (e *AccountLockedError) Error() string {
+	return fmt.Sprintf("account is locked until %s", e.Until.Format(time.RFC3339))
+}
+
+type LoginAttempt struct {
+	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
+	UserID    string    `gorm:"column:user_id;index:idx_login_attempts_user_created"`
+	IP        INET      `gorm:"column:ip;type:varbinary(16);index:idx_login_attempts_ip_created"`
+	Succeeded bool      `gorm:"column:succeeded"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime;index:idx_login_attempts_user_created;index:idx_login_attempts_ip_created"`
+}
+
+func (a *LoginAttempt) TableName() string {
+	return "login_attempts"
+}
+
+// AccountLockout is the lock state of an account, failures before ResetAt
+// no longer count towards a new lock.
+type AccountLockout struct {
+	UserID      string    `gorm:"primary_key;column:user_id"`
+	LockedUntil time.Time `gorm:"column:locked_until"`
+	ResetAt     time.Time `gorm:"column:reset_at"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (l *AccountLockout) TableName() string {
+	return "account_lockouts"
+}
+
+// LoginPolicy locks an account for Cooldown after MaxFailures failed logins
+// within Window, and refuses addresses with MaxIPFailures failed logins
+// within Window whatever account they try.
+type LoginPolicy struct {
+	MaxFailures   int
+	MaxIPFailures int
+	Window        time.Duration
+	Cooldown      time.Duration
+}
+
+var DefaultLoginPolicy = LoginPolicy{
+	MaxFailures:   5,
+	MaxIPFailures: 50,
+	Window:        15 * time.Minute,
+	Cooldown:      15 * time.Minute,
+}
+
+type Authenticator struct {
+	DB       *gorm.DB
+	Sessions *SessionStore
+	Policy   LoginPolicy
+}
+
+func NewAuthenticator(db *gorm.DB) *Authenticator {
+	return &Authenticator{DB: db, Sessions: NewSessionStore(db), Policy: DefaultLoginPolicy}
+}
+
+func (a *Authenticator) checkAddress(db *gorm.DB, ip INET, now time.Time) error {
+	if !ip.IsValid() || a.Policy.MaxIPFailures <= 0 {
+		return nil
+	}
+	var failures int64
+	err := db.Model(&LoginAttempt{}).
+		Where("ip = ? AND succeeded = ? AND created_at > ?", ip, false, now.Add(-a.Policy.Window)).
+		Count(&failures).Error
+	if err == nil && failures >= int64(a.Policy.MaxIPFailures) {
+		err = ErrTooManyAttempts
+	}
+	return err
+}
+
+// recordFailure counts the failures of the account since the window start
+// or the last reset, whichever is later, and locks it when there are too
+// many. The lockout row is locked so concurrent failures are counted once.
+func (a *Authenticator) recordFailure(tx *gorm.DB, userID string, ip INET, now time.Time) error {
+	err := tx.Create(&LoginAttempt{UserID: userID, IP: ip, CreatedAt: now}).Error
+	if err != nil || a.Policy.MaxFailures <= 0 {
+		return err
+	}
+
+	err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&AccountLockout{UserID: userID, LockedUntil: now, ResetAt: now}).Error
+	if err != nil {
+		return err
+	}
+	var lockout AccountLockout
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&lockout, "user_id = ?", userID).Error
+	if err != nil {
+		return err
+	}
+
+	since := now.Add(-a.Policy.Window)
+	if lockout.ResetAt.After(since) {
+		since = lockout.ResetAt
+	}
+	var failures int64
+	err = tx.Model(&LoginAttempt{}).
+		Where("user_id = ? AND succeeded = ? AND created_at >= ?", userID, false, since).
+		Count(&failures).Error
+	if err != nil || failures < int64(a.Policy.MaxFailures) {
+		return err
+	}
+
+	return tx.Model(&lockout).Updates(map[string]interface{}{
+		"locked_until": now.Add(a.Policy.Cooldown),
+		"reset_at":     now.Add(a.Policy.Cooldown),
+	}).Error
+}
+
+// Authenticate checks the password of userID and starts a session on
+// success. Every attempt is recorded, the client address is taken from the
+// client info of ctx. Locked accounts get an *AccountLockedError without
+// their password being checked.
+func (a *Authenticator) Authenticate(ctx context.Context, userID string, password string, deviceName string) (string, Session, error) {
+	db := a.DB.WithContext(ctx)
+	// attempts are compared with now, keep it at the precision stored
+	now := Now(a.DB).Truncate(time.Millisecond)
+	info, _ := ClientInfoFromContext(ctx)
+
+	err := a.checkAddress(db, info.IP, now)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	var lockout AccountLockout
+	err = db.Where("user_id = ? AND locked_until > ?", userID, now).Limit(1).Find(&lockout).Error
+	if err != nil {
+		return "", Session{}, err
+	}
+	if lockout.UserID != "" {
+		return "", Session{}, &AccountLockedError{Until: lockout.LockedUntil}
+	}
+
+	var user User
+	err = db.Select("id", "password").Take(&user, "id = ?", userID).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return "", Session{}, err
+	}
+	if err != nil || subtle.ConstantTimeCompare([]byte(user.Password), []byte(password)) != 1 {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			return a.recordFailure(tx, userID, info.IP, now)
+		})
+		if err != nil {
+			return "", Session{}, err
+		}
+		return "", Session{}, ErrInvalidCredentials
+	}
+
+	err = db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(&LoginAttempt{UserID: userID, IP: info.IP, Succeeded: true, CreatedAt: now}).Error
+		if err != nil {
+			return err
+		}
+		return tx.Model(&AccountLockout{}).Where("user_id = ?", userID).Update("reset_at", now).Error
+	})
+	if err != nil {
+		return "", Session{}, err
+	}
+	return a.Sessions.Create(ctx, userID, deviceName)
+}
+
+// Locked lists the accounts locked right now.
+func (a *Authenticator) Locked(ctx context.Context) ([]AccountLockout, error) {
+	var lockouts []AccountLockout
+	err := a.DB.WithContext(ctx).Where("locked_until > ?", Now(a.DB)).Order("locked_until").Find(&lockouts).Error
+	return lockouts, err
+}
+
+// Unlock lifts the lock of userID before its cooldown ends, recorded in the
+// audit log under the admin's name.
+func (a *Authenticator) Unlock(ctx context.Context, userID string, admin string) error {
+	return a.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := Now(a.DB)
+		result := tx.Model(&AccountLockout{}).Where("user_id = ? AND locked_until > ?", userID, now).
+			Updates(map[string]interface{}{"locked_until": now, "reset_at": now})
+		if result.Error != nil || result.RowsAffected == 0 {
+			return result.Error
+		}
+		return WriteAuditLog(tx, admin, "unlock", "account_lockouts", userID, nil)
+	})
+}
@@ -0,0 +1,135 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const SettingMaintenance = "maintenance"
+
+var ErrMaintenanceMode = errors.New("database is in maintenance, try again later")
+
+type Setting struct {
+	Key       string    `gorm:"primary_key;column:key"`
+	Value     string    `gorm:"column:value"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (s *Setting) TableName() string {
+	return "settings"
+}
+
+type maintenanceBypassKey struct{}
+
+// WithMaintenanceBypass lets writes through during maintenance, for the
+// migrations and backfills the maintenance is for.
+func WithMaintenanceBypass(ctx context.Context) context.Context {
+	return context.WithValue(ctx, maintenanceBypassKey{}, true)
+}
+
+func bypassesMaintenance(ctx context.Context) bool {
+	bypass, _ := ctx.Value(maintenanceBypassKey{}).(bool)
+	return bypass
+}
+
+// Maintenance keeps the maintenance flag of the settings table in memory so
+// checking it costs no query, Watch refreshes it.
+type Maintenance struct {
+	DB      *gorm.DB
+	OnError func(err error)
+
+	enabled int32
+}
+
+func NewMaintenance(db *gorm.DB) *Maintenance {
+	return &Maintenance{DB: db}
+}
+
+func (m *Maintenance) Enabled() bool {
+	return atomic.LoadInt32(&m.enabled) == 1
+}
+
+func (m *Maintenance) store(enabled bool) {
+	value := int32(0)
+	if enabled {
+		value = 1
+	}
+	atomic.StoreInt32(&m.enabled, value)
+}
+
+// Set turns maintenance on or off for every process watching the flag, this
+// process sees it right away.
+func (m *Maintenance) Set(ctx context.Context, enabled bool) error {
+	setting := Setting{Key: SettingMaintenance, Value: "off"}
+	if enabled {
+		setting.Value = "on"
+	}
+	err := m.DB.WithContext(WithMaintenanceBypass(ctx)).
+		Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"value", "updated_at"})}).
+		Create(&setting).Error
+	if err == nil {
+		m.store(enabled)
+	}
+	return err
+}
+
+func (m *Maintenance) Refresh(ctx context.Context) error {
+	var settings []Setting
+	err := m.DB.WithContext(ctx).Where("`key` = ?", SettingMaintenance).Limit(1).Find(&settings).Error
+	if err != nil {
+		return err
+	}
+	m.store(len(settings) == 1 && settings[0].Value == "on")
+	return nil
+}
+
+// Watch refreshes the flag every interval until ctx is done. A failed
+// refresh keeps the last known state and is reported to OnError.
+func (m *Maintenance) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		err := m.Refresh(ctx)
+		if err != nil && ctx.Err() == nil && m.OnError != nil {
+			m.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Maintenance) rejectWrites(db *gorm.DB) {
+	if m.Enabled() && !bypassesMaintenance(db.Statement.Context) {
+		_ = db.AddError(ErrMaintenanceMode)
+	}
+}
+
+// Gate makes every create, update, delete and Exec on db fail with
+// ErrMaintenanceMode while maintenance is on, unless its context comes
+// from WithMaintenanceBypass. Queries keep working.
+func (m *Maintenance) Gate(db *gorm.DB) error {
+	callbacks := db.Callback()
+	err := callbacks.Create().Before("gorm:create").Register("maintenance:create", m.rejectWrites)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().Before("gorm:update").Register("maintenance:update", m.rejectWrites)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Delete().Before("gorm:delete").Register("maintenance:delete", m.rejectWrites)
+	if err != nil {
+		return err
+	}
+	return callbacks.Raw().Before("gorm:raw").Register("maintenance:raw", m.rejectWrites)
+}
@@ -0,0 +1,130 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// Masker returns the value shown in place of a non empty column value.
+type Masker func(value string) string
+
+func MaskSecret(value string) string {
+	return "********"
+}
+
+// MaskEmail keeps emails unique and stable, like the anonymized clone.
+func MaskEmail(value string) string {
+	alias, _ := pseudonym(strings.ToLower(value))
+	return "user-" + alias + "@example.com"
+}
+
+func MaskPhone(value string) string {
+	_, number := pseudonym(value)
+	return fmt.Sprintf("+62800%08d", number%100000000)
+}
+
+func MaskAddress(value string) string {
+	alias, number := pseudonym(value)
+	return fmt.Sprintf("Jalan %s No. %d", alias, number%200+1)
+}
+
+type MaskRule struct {
+	Table  string
+	Column string
+	Mask   Masker
+}
+
+var DefaultMaskRules = []MaskRule{
+	{Table: "users", Column: "password", Mask: MaskSecret},
+	{Table: "users", Column: "email", Mask: MaskEmail},
+	{Table: "users", Column: "phone", Mask: MaskPhone},
+	{Table: "addresses", Column: "address", Mask: MaskAddress},
+}
+
+// forEachRow calls mapRow or structRow for every row queried by db.
+func forEachRow(db *gorm.DB, mapRow func(row map[string]interface{}), structRow func(value reflect.Value)) {
+	switch dest := db.Statement.Dest.(type) {
+	case *map[string]interface{}:
+		mapRow(*dest)
+		return
+	case map[string]interface{}:
+		mapRow(dest)
+		return
+	case *[]map[string]interface{}:
+		for _, row := range *dest {
+			mapRow(row)
+		}
+		return
+	}
+
+	if db.Statement.Schema == nil {
+		return
+	}
+	value := reflect.Indirect(db.Statement.ReflectValue)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			structRow(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		structRow(value)
+	}
+}
+
+// masking maps table and column to the masker of its rule.
+type masking map[string]map[string]Masker
+
+func (m masking) mask(db *gorm.DB) {
+	if db.Error != nil {
+		return
+	}
+	maskers, ok := m[db.Statement.Table]
+	if !ok {
+		return
+	}
+
+	forEachRow(db, func(row map[string]interface{}) {
+		for column, mask := range maskers {
+			switch value := row[column].(type) {
+			case string:
+				if value != "" {
+					row[column] = mask(value)
+				}
+			case []byte:
+				if len(value) > 0 {
+					row[column] = mask(string(value))
+				}
+			}
+		}
+	}, func(value reflect.Value) {
+		for column, mask := range maskers {
+			field := db.Statement.Schema.LookUpField(column)
+			if field == nil {
+				continue
+			}
+			fieldValue := field.ReflectValueOf(db.Statement.Context, value)
+			if fieldValue.Kind() == reflect.String && fieldValue.String() != "" {
+				fieldValue.SetString(mask(fieldValue.String()))
+			}
+		}
+	})
+}
+
+// EnableMasking replaces the columns of rules with their masked value in
+// everything queried through db, preloads included. It is meant for staging
+// and development databases holding a copy of production data. Rows scanned
+// with Raw or Rows are not masked, and saving a masked row writes the
+// masked values back.
+func EnableMasking(db *gorm.DB, rules ...MaskRule) error {
+	m := masking{}
+	for _, rule := range rules {
+		if m[rule.Table] == nil {
+			m[rule.Table] = map[string]Masker{}
+		}
+		m[rule.Table][rule.Column] = rule.Mask
+	}
+	return db.Callback().Query().After("gorm:query").Register("masking:query", m.mask)
+}
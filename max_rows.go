@@ -0,0 +1,69 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"reflect"
+
+	"gorm.io/gorm"
+)
+
+// DefaultMaxRows bounds the list methods called without a limit of their
+// own.
+const DefaultMaxRows = 10000
+
+// Limited is a result cut at a maximum, Truncated tells more rows matched.
+// Callers that need every row stream them instead.
+type Limited[T any] struct {
+	Rows      []T
+	Truncated bool
+}
+
+// FindLimited loads at most maxRows rows of query, DefaultMaxRows when not
+// positive. It fetches one row more to know whether the result is cut.
+func FindLimited[T any](query *gorm.DB, maxRows int) (Limited[T], error) {
+	var rows []T
+	truncated, err := findLimited(query, maxRows, &rows)
+	if err != nil {
+		return Limited[T]{}, err
+	}
+	return Limited[T]{Rows: rows, Truncated: truncated}, nil
+}
+
+// findLimited is FindLimited into dest, a pointer to a slice, for callers
+// that only know the model at run time. It tells whether the rows are cut.
+func findLimited(query *gorm.DB, maxRows int, dest interface{}) (bool, error) {
+	if maxRows <= 0 {
+		maxRows = DefaultMaxRows
+	}
+
+	err := query.Limit(maxRows + 1).Find(dest).Error
+	if err != nil {
+		return false, err
+	}
+	rows := reflect.ValueOf(dest).Elem()
+	if rows.Len() <= maxRows {
+		return false, nil
+	}
+	rows.Set(rows.Slice(0, maxRows))
+	return true, nil
+}
+
+// Stream hands every row of query to fn in batches of batchSize, walking
+// the primary key so memory stays at one batch however many rows match.
+func Stream[T any](query *gorm.DB, batchSize int, fn func(batch []T) error) error {
+	var batch []T
+	return query.FindInBatches(&batch, batchSize, func(tx *gorm.DB, _ int) error {
+		return fn(batch)
+	}).Error
+}
+
+// ListUserLogs returns the latest logs of a user, at most maxRows of them.
+func ListUserLogs(ctx context.Context, db *gorm.DB, userID string, maxRows int) (Limited[UserLog], error) {
+	return FindLimited[UserLog](db.WithContext(ctx).Where("user_id = ?", userID).Order("id desc"), maxRows)
+}
+
+// StreamUserLogs hands all logs of a user to fn, oldest first, for exports
+// that cannot stop at a maximum.
+func StreamUserLogs(ctx context.Context, db *gorm.DB, userID string, fn func(logs []UserLog) error) error {
+	return Stream(db.WithContext(ctx).Where("user_id = ?", userID), 500, fn)
+}
@@ -0,0 +1,430 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var ErrUnsupportedFilter = errors.New("filter is not supported in memory")
+
+// MemoryRepository is a Repository of T kept in a map, for unit tests that
+// do without a database. It follows the GormRepository: auto increment keys,
+// created and updated times, soft deletes and the filters on the columns of
+// T, compared like MySQL does with its case insensitive collation. Filters
+// on related tables fail with ErrUnsupportedFilter, and hooks do not run.
+type MemoryRepository[T any, K comparable] struct {
+	Fields FilterFields
+	Clock  Clock
+
+	mutex  sync.Mutex
+	schema *schema.Schema
+	rows   map[K]T
+	next   int64
+}
+
+func NewMemoryRepository[T any, K comparable](fields FilterFields) (*MemoryRepository[T, K], error) {
+	parsed, err := schema.Parse(new(T), &sync.Map{}, schema.NamingStrategy{})
+	if err != nil {
+		return nil, err
+	}
+	if parsed.PrioritizedPrimaryField == nil {
+		return nil, fmt.Errorf("%s has no primary key", parsed.Name)
+	}
+	return &MemoryRepository[T, K]{Fields: fields, schema: parsed, rows: map[K]T{}}, nil
+}
+
+func (r *MemoryRepository[T, K]) now() time.Time {
+	if r.Clock == nil {
+		return SystemClock.Now()
+	}
+	return r.Clock.Now()
+}
+
+func (r *MemoryRepository[T, K]) key(ctx context.Context, row reflect.Value) (K, bool) {
+	value, zero := r.schema.PrioritizedPrimaryField.ValueOf(ctx, row)
+	key, _ := value.(K)
+	return key, zero
+}
+
+// deleted tells whether the row is soft deleted.
+func (r *MemoryRepository[T, K]) deleted(ctx context.Context, row reflect.Value) bool {
+	for _, field := range r.schema.Fields {
+		if field.FieldType == deletedAtType {
+			value, _ := field.ValueOf(ctx, row)
+			return value.(gorm.DeletedAt).Valid
+		}
+	}
+	return false
+}
+
+func (r *MemoryRepository[T, K]) Create(ctx context.Context, row *T) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	value := reflect.ValueOf(row).Elem()
+	primary := r.schema.PrioritizedPrimaryField
+	if _, zero := primary.ValueOf(ctx, value); zero && primary.AutoIncrement {
+		r.next++
+		err := primary.Set(ctx, value, r.next)
+		if err != nil {
+			return err
+		}
+	}
+	key, _ := r.key(ctx, value)
+	if _, ok := r.rows[key]; ok {
+		return gorm.ErrDuplicatedKey
+	}
+	if number, err := strconv.ParseInt(fmt.Sprint(key), 10, 64); err == nil && number > r.next {
+		r.next = number
+	}
+
+	now := r.now()
+	for _, field := range r.schema.Fields {
+		if _, zero := field.ValueOf(ctx, value); zero && (field.AutoCreateTime > 0 || field.AutoUpdateTime > 0) {
+			err := field.Set(ctx, value, now)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	r.rows[key] = *row
+	return nil
+}
+
+func (r *MemoryRepository[T, K]) Get(ctx context.Context, id K) (T, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	row, ok := r.rows[id]
+	if !ok || r.deleted(ctx, reflect.ValueOf(&row).Elem()) {
+		var zero T
+		return zero, gorm.ErrRecordNotFound
+	}
+	return row, nil
+}
+
+func (r *MemoryRepository[T, K]) List(ctx context.Context, page int, size int, filter Filter) (Limited[T], error) {
+	_, err := filter.Expression(r.Fields)
+	if err != nil {
+		return Limited[T]{}, err
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	keys := make([]K, 0, len(r.rows))
+	for key := range r.rows {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return lessValue(keys[i], keys[j])
+	})
+
+	offset, limit := PageBounds(page, size)
+	rows := []T{}
+	for _, key := range keys {
+		row := r.rows[key]
+		value := reflect.ValueOf(&row).Elem()
+		if r.deleted(ctx, value) {
+			continue
+		}
+		matches, err := r.match(ctx, filter, value)
+		if err != nil {
+			return Limited[T]{}, err
+		}
+		if !matches {
+			continue
+		}
+		if offset > 0 {
+			offset--
+			continue
+		}
+		if len(rows) == limit {
+			return Limited[T]{Rows: rows, Truncated: true}, nil
+		}
+		rows = append(rows, row)
+	}
+	return Limited[T]{Rows: rows}, nil
+}
+
+func (r *MemoryRepository[T, K]) Update(ctx context.Context, row *T) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	value := reflect.ValueOf(row).Elem()
+	key, _ := r.key(ctx, value)
+	stored, ok := r.rows[key]
+	if !ok || r.deleted(ctx, reflect.ValueOf(&stored).Elem()) {
+		return gorm.ErrRecordNotFound
+	}
+
+	version := versionField(r.schema)
+	if version != nil {
+		current, _ := version.ValueOf(ctx, value)
+		previous, _ := version.ValueOf(ctx, reflect.ValueOf(&stored).Elem())
+		if current != previous {
+			return ErrVersionConflict
+		}
+		addVersion(ctx, version, value, 1)
+	}
+
+	now := r.now()
+	for _, field := range r.schema.Fields {
+		var err error
+		switch {
+		case field.DBName == "created_at":
+			createdAt, _ := field.ValueOf(ctx, reflect.ValueOf(&stored).Elem())
+			err = field.Set(ctx, value, createdAt)
+		case field.AutoUpdateTime > 0:
+			err = field.Set(ctx, value, now)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	r.rows[key] = *row
+	return nil
+}
+
+func (r *MemoryRepository[T, K]) Delete(ctx context.Context, id K) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	row, ok := r.rows[id]
+	value := reflect.ValueOf(&row).Elem()
+	if !ok || r.deleted(ctx, value) {
+		return gorm.ErrRecordNotFound
+	}
+	for _, field := range r.schema.Fields {
+		if field.FieldType == deletedAtType {
+			err := field.Set(ctx, value, gorm.DeletedAt{Time: r.now(), Valid: true})
+			if err != nil {
+				return err
+			}
+			r.rows[id] = row
+			return nil
+		}
+	}
+	delete(r.rows, id)
+	return nil
+}
+
+// match evaluates the filter, already checked against Fields, on a row.
+func (r *MemoryRepository[T, K]) match(ctx context.Context, filter Filter, row reflect.Value) (bool, error) {
+	switch {
+	case len(filter.And) > 0:
+		for _, f := range filter.And {
+			matches, err := r.match(ctx, f, row)
+			if err != nil || !matches {
+				return false, err
+			}
+		}
+		return true, nil
+	case len(filter.Or) > 0:
+		for _, f := range filter.Or {
+			matches, err := r.match(ctx, f, row)
+			if err != nil || matches {
+				return matches, err
+			}
+		}
+		return false, nil
+	case filter.Field == "":
+		return true, nil
+	}
+
+	column := r.Fields.Columns[filter.Field]
+	field := r.schema.LookUpField(column)
+	if strings.Contains(column, ".") || field == nil {
+		return false, fmt.Errorf("%w: %s", ErrUnsupportedFilter, filter.Field)
+	}
+	value, _ := field.ValueOf(ctx, row)
+	value = columnValue(value)
+
+	switch filter.Op {
+	case "null":
+		return value == nil, nil
+	case "not_null":
+		return value != nil, nil
+	case "in":
+		for _, candidate := range filter.Value.([]interface{}) {
+			if compareValue(value, candidate) == 0 {
+				return true, nil
+			}
+		}
+		return false, nil
+	case "like":
+		return value != nil && likePattern(filter.Value.(string)).MatchString(fmt.Sprint(value)), nil
+	}
+
+	comparison := compareValue(value, filter.Value)
+	if comparison == incomparable {
+		return false, nil
+	}
+	switch filter.Op {
+	case "eq":
+		return comparison == 0, nil
+	case "ne":
+		return comparison != 0, nil
+	case "lt":
+		return comparison < 0, nil
+	case "lte":
+		return comparison <= 0, nil
+	case "gt":
+		return comparison > 0, nil
+	}
+	return comparison >= 0, nil
+}
+
+// columnValue is the value of a field as the database holds it: nil for
+// NULL, a string, float64, bool or time.Time otherwise.
+func columnValue(value interface{}) interface{} {
+	if valuer, ok := value.(driver.Valuer); ok {
+		var err error
+		value, err = valuer.Value()
+		if err != nil {
+			return nil
+		}
+	}
+
+	reflected := reflect.ValueOf(value)
+	for reflected.Kind() == reflect.Pointer {
+		if reflected.IsNil() {
+			return nil
+		}
+		reflected = reflected.Elem()
+	}
+	if !reflected.IsValid() {
+		return nil
+	}
+	if t, ok := reflected.Interface().(time.Time); ok {
+		return t
+	}
+	switch reflected.Kind() {
+	case reflect.String:
+		return reflected.String()
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(reflected.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(reflected.Uint())
+	case reflect.Float32, reflect.Float64:
+		return reflected.Float()
+	case reflect.Bool:
+		return reflected.Bool()
+	}
+	return fmt.Sprint(reflected.Interface())
+}
+
+const incomparable = 2
+
+var filterTimeLayouts = []string{time.RFC3339Nano, "2006-01-02 15:04:05.999999999", "2006-01-02"}
+
+// compareValue compares a column value with a filter value like MySQL,
+// converting the filter value to the type of the column. Comparing with
+// NULL or a value that does not convert is incomparable.
+func compareValue(value interface{}, filter interface{}) int {
+	if value == nil || filter == nil {
+		return incomparable
+	}
+	if b, ok := filter.(bool); ok {
+		filter = 0.0
+		if b {
+			filter = 1.0
+		}
+	}
+	if b, ok := value.(bool); ok {
+		value = 0.0
+		if b {
+			value = 1.0
+		}
+	}
+
+	switch value := value.(type) {
+	case time.Time:
+		text, ok := filter.(string)
+		if !ok {
+			return incomparable
+		}
+		for _, layout := range filterTimeLayouts {
+			if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+				return value.Compare(t)
+			}
+		}
+		return incomparable
+	case float64:
+		number, ok := filter.(float64)
+		if text, isText := filter.(string); isText {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(text), 64)
+			number, ok = parsed, err == nil
+		}
+		if !ok {
+			return incomparable
+		}
+		return compareOrdered(value, number)
+	case string:
+		if number, ok := filter.(float64); ok {
+			parsed, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+			if err != nil {
+				return incomparable
+			}
+			return compareOrdered(parsed, number)
+		}
+		return strings.Compare(strings.ToLower(value), strings.ToLower(fmt.Sprint(filter)))
+	}
+	return incomparable
+}
+
+func compareOrdered(a, b float64) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
+
+// lessValue orders primary keys, numbers by value and the rest as text.
+func lessValue(a, b interface{}) bool {
+	x, y := columnValue(a), columnValue(b)
+	if x, ok := x.(float64); ok {
+		if y, ok := y.(float64); ok {
+			return x < y
+		}
+	}
+	return fmt.Sprint(x) < fmt.Sprint(y)
+}
+
+// likePattern turns a LIKE pattern into a case insensitive regular
+// expression, with \ escaping % and _.
+func likePattern(pattern string) *regexp.Regexp {
+	var expr strings.Builder
+	expr.WriteString("(?is)^")
+	escaped := false
+	for _, c := range pattern {
+		switch {
+		case escaped:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+			escaped = false
+		case c == '\\':
+			escaped = true
+		case c == '%':
+			expr.WriteString(".*")
+		case c == '_':
+			expr.WriteString(".")
+		default:
+			expr.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	expr.WriteString("$")
+	return regexp.MustCompile(expr.String())
+}
@@ -0,0 +1,166 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+type Migration struct {
+	Version int64
+	Name    string
+	Up      func(tx *gorm.DB) error
+	Down    func(tx *gorm.DB) error
+}
+
+type SchemaMigration struct {
+	Version   int64     `gorm:"primary_key;column:version;autoIncrement:false"`
+	Name      string    `gorm:"column:name"`
+	AppliedAt time.Time `gorm:"column:applied_at;autoCreateTime"`
+}
+
+func (m *SchemaMigration) TableName() string {
+	return "schema_migrations"
+}
+
+var migrations []Migration
+
+// RegisterMigration adds m to the migrations applied by Migrate, versions
+// must be unique and are applied in ascending order.
+func RegisterMigration(m Migration) {
+	for _, registered := range migrations {
+		if registered.Version == m.Version {
+			panic(fmt.Sprintf("migration %d is registered twice", m.Version))
+		}
+	}
+
+	migrations = append(migrations, m)
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].Version < migrations[j].Version
+	})
+}
+
+func Migrations() []Migration {
+	return append([]Migration(nil), migrations...)
+}
+
+func appliedMigrations(db *gorm.DB) (map[int64]bool, error) {
+	err := db.Migrator().AutoMigrate(&SchemaMigration{})
+	if err != nil {
+		return nil, err
+	}
+
+	var versions []int64
+	err = db.Model(&SchemaMigration{}).Pluck("version", &versions).Error
+	if err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int64]bool, len(versions))
+	for _, version := range versions {
+		applied[version] = true
+	}
+	return applied, nil
+}
+
+func PendingMigrations(db *gorm.DB) ([]Migration, error) {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var pending []Migration
+	for _, migration := range migrations {
+		if !applied[migration.Version] {
+			pending = append(pending, migration)
+		}
+	}
+	return pending, nil
+}
+
+// Migrate applies every pending migration, each one in its own transaction
+// together with its schema_migrations row. MySQL commits DDL implicitly, so
+// a migration should only hold one DDL statement to stay restartable. It
+// runs during maintenance, and under the MigrationLock so only one instance
+// applies them.
+func Migrate(db *gorm.DB) error {
+	db = db.WithContext(WithMaintenanceBypass(db.Statement.Context))
+	return NewMigrationLock(db).Hold(db.Statement.Context, func(ctx context.Context) error {
+		return migrate(db.WithContext(ctx))
+	})
+}
+
+func migrate(db *gorm.DB) error {
+	pending, err := PendingMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for _, migration := range pending {
+		err = db.Transaction(func(tx *gorm.DB) error {
+			err := migration.Up(tx)
+			if err != nil {
+				return err
+			}
+			return tx.Create(&SchemaMigration{Version: migration.Version, Name: migration.Name}).Error
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+// Rollback reverts the last steps applied migrations in descending order,
+// a migration dropping data only as options allow.
+func Rollback(db *gorm.DB, steps int, options RollbackOptions) error {
+	db = db.WithContext(WithMaintenanceBypass(db.Statement.Context))
+	return NewMigrationLock(db).Hold(db.Statement.Context, func(ctx context.Context) error {
+		return rollback(db.WithContext(ctx), steps, options)
+	})
+}
+
+func rollback(db *gorm.DB, steps int, options RollbackOptions) error {
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return err
+	}
+
+	for i := len(migrations) - 1; i >= 0 && steps > 0; i-- {
+		migration := migrations[i]
+		if !applied[migration.Version] {
+			continue
+		}
+		steps--
+
+		if migration.Down != nil {
+			err = checkDataLoss(db, migration, options)
+			if err != nil {
+				return err
+			}
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			if migration.Down != nil {
+				err := migration.Down(tx)
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Delete(&SchemaMigration{}, "version = ?", migration.Version).Error
+		})
+		if err != nil {
+			return fmt.Errorf("rollback %d %s: %w", migration.Version, migration.Name, err)
+		}
+	}
+	return nil
+}
+
+func SchemaVersion(db *gorm.DB) (int64, error) {
+	var version int64
+	err := db.Model(&SchemaMigration{}).Select("coalesce(max(version), 0)").Scan(&version).Error
+	return version, err
+}
@@ -0,0 +1,197 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrMigrationLockLost = errors.New("migration lock was taken over by another instance")
+
+// SchemaMigrationLock is the single row of the instance migrating the
+// schema, it is deleted again when the instance is done.
+type SchemaMigrationLock struct {
+	ID          int       `gorm:"primary_key;column:id;autoIncrement:false"`
+	Owner       string    `gorm:"column:owner;type:varchar(255)"`
+	AcquiredAt  time.Time `gorm:"column:acquired_at"`
+	HeartbeatAt time.Time `gorm:"column:heartbeat_at"`
+}
+
+func (l *SchemaMigrationLock) TableName() string {
+	return "schema_migrations_lock"
+}
+
+// MigrationLock keeps instances starting at the same time from running the
+// same migrations twice. The holder renews its heartbeat while it runs, a
+// lock whose heartbeat is older than StaleAfter belongs to a crashed
+// instance and is taken over. StaleAfter and RetryInterval that are not
+// positive mean a minute and a second.
+type MigrationLock struct {
+	DB            *gorm.DB
+	Owner         string
+	StaleAfter    time.Duration
+	RetryInterval time.Duration
+	OnTakeover    func(stale SchemaMigrationLock)
+}
+
+func NewMigrationLock(db *gorm.DB) *MigrationLock {
+	host, _ := os.Hostname()
+	return &MigrationLock{
+		DB:            db,
+		Owner:         fmt.Sprintf("%s:%d:%d", host, os.Getpid(), time.Now().UnixNano()),
+		StaleAfter:    time.Minute,
+		RetryInterval: time.Second,
+	}
+}
+
+func (l *MigrationLock) staleAfter() time.Duration {
+	if l.StaleAfter <= 0 {
+		return time.Minute
+	}
+	return l.StaleAfter
+}
+
+func (l *MigrationLock) retryInterval() time.Duration {
+	if l.RetryInterval <= 0 {
+		return time.Second
+	}
+	return l.RetryInterval
+}
+
+// tryAcquire inserts the lock row, or takes it over when it is stale.
+func (l *MigrationLock) tryAcquire(ctx context.Context) (bool, error) {
+	db := l.DB.WithContext(ctx)
+	now := Now(db)
+	result := db.Clauses(clause.Insert{Modifier: "IGNORE"}).
+		Create(&SchemaMigrationLock{ID: 1, Owner: l.Owner, AcquiredAt: now, HeartbeatAt: now})
+	if result.Error != nil || result.RowsAffected == 1 {
+		return result.Error == nil, result.Error
+	}
+
+	var stale SchemaMigrationLock
+	err := db.Take(&stale, "id = ?", 1).Error
+	if err != nil {
+		return false, err
+	}
+	if now.Sub(stale.HeartbeatAt) < l.staleAfter() {
+		return false, nil
+	}
+
+	// the heartbeat in the condition makes only one of the instances
+	// noticing the stale lock take it over
+	result = db.Model(&SchemaMigrationLock{}).
+		Where("id = ? AND owner = ? AND heartbeat_at = ?", 1, stale.Owner, stale.HeartbeatAt).
+		Updates(map[string]interface{}{"owner": l.Owner, "acquired_at": now, "heartbeat_at": now})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return false, result.Error
+	}
+	if l.OnTakeover != nil {
+		l.OnTakeover(stale)
+	}
+	return true, nil
+}
+
+// Acquire waits until the lock is held by l or ctx is done.
+func (l *MigrationLock) Acquire(ctx context.Context) error {
+	err := l.DB.WithContext(ctx).Migrator().AutoMigrate(&SchemaMigrationLock{})
+	if err != nil {
+		return err
+	}
+
+	for {
+		acquired, err := l.tryAcquire(ctx)
+		if err != nil || acquired {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(l.retryInterval()):
+		}
+	}
+}
+
+// heartbeat renews the heartbeat of the lock, it fails with
+// ErrMigrationLockLost when l no longer holds it.
+func (l *MigrationLock) heartbeat(ctx context.Context) error {
+	db := l.DB.WithContext(ctx)
+	result := db.Model(&SchemaMigrationLock{}).Where("id = ? AND owner = ?", 1, l.Owner).
+		Update("heartbeat_at", Now(db))
+	if result.Error != nil || result.RowsAffected > 0 {
+		return result.Error
+	}
+
+	// MySQL does not count a row the update leaves as it was
+	var held int64
+	err := db.Model(&SchemaMigrationLock{}).Where("id = ? AND owner = ?", 1, l.Owner).Count(&held).Error
+	if err == nil && held == 0 {
+		return ErrMigrationLockLost
+	}
+	return err
+}
+
+func (l *MigrationLock) Release(ctx context.Context) error {
+	return l.DB.WithContext(ctx).Delete(&SchemaMigrationLock{}, "id = ? AND owner = ?", 1, l.Owner).Error
+}
+
+// Hold runs fn with the lock held, renewing its heartbeat every third of
+// StaleAfter, and releases it afterwards. When another instance takes the
+// lock over, or the heartbeat keeps failing for StaleAfter, the context of
+// fn is cancelled and Hold fails with ErrMigrationLockLost.
+func (l *MigrationLock) Hold(ctx context.Context, fn func(ctx context.Context) error) error {
+	err := l.Acquire(ctx)
+	if err != nil {
+		return err
+	}
+
+	held, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var lost error
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(max(l.staleAfter()/3, time.Millisecond))
+		defer ticker.Stop()
+		renewed := Now(l.DB)
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+			}
+
+			err := l.heartbeat(held)
+			switch {
+			case errors.Is(err, ErrMigrationLockLost):
+				lost = err
+			case err != nil && Now(l.DB).Sub(renewed) >= l.staleAfter():
+				lost = fmt.Errorf("%w: heartbeat failing since %s: %v", ErrMigrationLockLost, renewed.Format(time.RFC3339), err)
+			case err == nil:
+				renewed = Now(l.DB)
+			}
+			if lost != nil {
+				cancel()
+				return
+			}
+		}
+	}()
+
+	err = fn(held)
+	close(done)
+	<-stopped
+	if lost != nil {
+		return lost
+	}
+	releaseErr := l.Release(context.WithoutCancel(ctx))
+	if err != nil {
+		return err
+	}
+	return releaseErr
+}
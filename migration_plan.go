@@ -0,0 +1,109 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+
+	"gorm.io/gorm"
+)
+
+var ErrPlanPrepare = errors.New("prepared statements can not be planned")
+
+var statementTablePattern = regexp.MustCompile("(?i)^\\s*(?:alter\\s+table|(?:create|drop)\\s+(?:unique\\s+|fulltext\\s+)?index\\s+\\S+\\s+on|drop\\s+table(?:\\s+if\\s+exists)?|create\\s+table(?:\\s+if\\s+not\\s+exists)?|rename\\s+table|update|insert\\s+(?:ignore\\s+)?into|delete\\s+from)\\s+`?(\\w+)`?")
+
+// MigrationPlan is what a pending migration would run, Tables are the
+// current sizes of the tables its statements change.
+type MigrationPlan struct {
+	Version    int64
+	Name       string
+	Statements []string
+	Tables     []TableStat
+}
+
+// planConnPool runs the queries of a migration, the checks whether a column
+// or index exists, and records its other statements instead of executing
+// them.
+type planConnPool struct {
+	gorm.ConnPool
+	dialector  gorm.Dialector
+	statements []string
+}
+
+func (p *planConnPool) PrepareContext(ctx context.Context, query string) (*sql.Stmt, error) {
+	return nil, ErrPlanPrepare
+}
+
+func (p *planConnPool) ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	p.statements = append(p.statements, p.dialector.Explain(query, args...))
+	return driverResult(0), nil
+}
+
+type driverResult int64
+
+func (r driverResult) LastInsertId() (int64, error) {
+	return 0, nil
+}
+
+func (r driverResult) RowsAffected() (int64, error) {
+	return int64(r), nil
+}
+
+func statementTables(statements []string) []string {
+	seen := map[string]bool{}
+	var tables []string
+	for _, statement := range statements {
+		match := statementTablePattern.FindStringSubmatch(statement)
+		if match != nil && !seen[match[1]] {
+			seen[match[1]] = true
+			tables = append(tables, match[1])
+		}
+	}
+	return tables
+}
+
+// captureStatements returns the statements step would execute on db.
+func captureStatements(db *gorm.DB, step func(tx *gorm.DB) error) ([]string, error) {
+	pool := &planConnPool{ConnPool: db.Statement.ConnPool, dialector: db.Dialector}
+	tx := db.Session(&gorm.Session{NewDB: true})
+	tx.Statement.ConnPool = pool
+	err := step(tx)
+	return pool.statements, err
+}
+
+// PlanMigrations renders the statements of every pending migration without
+// applying any. Each migration is planned against the current schema, so a
+// migration depending on an earlier pending one may plan statements the
+// real run skips, or the other way round.
+func PlanMigrations(ctx context.Context, db *gorm.DB) ([]MigrationPlan, error) {
+	db = db.WithContext(WithMaintenanceBypass(ctx))
+	pending, err := PendingMigrations(db)
+	if err != nil || len(pending) == 0 {
+		return nil, err
+	}
+	stats, err := TableStats(ctx, db)
+	if err != nil {
+		return nil, err
+	}
+
+	plans := make([]MigrationPlan, 0, len(pending))
+	for _, migration := range pending {
+		statements, err := captureStatements(db, migration.Up)
+		if err != nil {
+			return nil, fmt.Errorf("plan migration %d %s: %w", migration.Version, migration.Name, err)
+		}
+
+		plan := MigrationPlan{Version: migration.Version, Name: migration.Name, Statements: statements}
+		for _, table := range statementTables(statements) {
+			for _, stat := range stats {
+				if stat.Table == table {
+					plan.Tables = append(plan.Tables, stat)
+				}
+			}
+		}
+		plans = append(plans, plan)
+	}
+	return plans, nil
+}
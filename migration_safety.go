@@ -0,0 +1,173 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrDestructiveMigration = errors.New("rollback drops data, it has to be allowed explicitly")
+
+var (
+	dropTablePattern  = regexp.MustCompile("(?i)^\\s*drop\\s+table\\s+(?:if\\s+exists\\s+)?`?(\\w+)`?")
+	dropColumnPattern = regexp.MustCompile("(?i)^\\s*alter\\s+table\\s+`?(\\w+)`?\\s+drop\\s+(column\\s+)?`?(\\w+)`?")
+)
+
+// DataLoss is a table, or a column of it when Column is set, a rollback
+// drops together with the rows holding a value in it.
+type DataLoss struct {
+	Version   int64
+	Migration string
+	Table     string
+	Column    string
+	Rows      int64
+}
+
+func (l DataLoss) String() string {
+	if l.Column == "" {
+		return fmt.Sprintf("table %s with %d rows", l.Table, l.Rows)
+	}
+	return fmt.Sprintf("column %s.%s with %d values", l.Table, l.Column, l.Rows)
+}
+
+type DestructiveMigrationError struct {
+	Version   int64
+	Migration string
+	Losses    []DataLoss
+}
+
+func (e *DestructiveMigrationError) Error() string {
+	losses := make([]string, len(e.Losses))
+	for i, loss := range e.Losses {
+		losses[i] = loss.String()
+	}
+	return fmt.Sprintf("rollback %d %s drops %s", e.Version, e.Migration, strings.Join(losses, ", "))
+}
+
+func (e *DestructiveMigrationError) Unwrap() error {
+	return ErrDestructiveMigration
+}
+
+// RollbackOptions decide what happens to the data a Down migration drops.
+// Without AllowDestructive such a rollback stops before it, OnDataLoss is
+// told about every drop either way, and BeforeDrop runs before an allowed
+// one, e.g. ExportBeforeDrop.
+type RollbackOptions struct {
+	AllowDestructive bool
+	OnDataLoss       func(loss DataLoss)
+	BeforeDrop       func(ctx context.Context, db *gorm.DB, loss DataLoss) error
+}
+
+// dataLosses plans the Down of migration and counts what its drops lose,
+// generated columns can be computed again and lose nothing.
+func dataLosses(db *gorm.DB, migration Migration) ([]DataLoss, error) {
+	statements, err := captureStatements(db, migration.Down)
+	if err != nil {
+		return nil, err
+	}
+
+	var losses []DataLoss
+	for _, statement := range statements {
+		loss := DataLoss{Version: migration.Version, Migration: migration.Name}
+		if match := dropTablePattern.FindStringSubmatch(statement); match != nil {
+			loss.Table = match[1]
+			if !db.Migrator().HasTable(loss.Table) {
+				continue
+			}
+			err = db.Table(loss.Table).Count(&loss.Rows).Error
+		} else if match := dropColumnPattern.FindStringSubmatch(statement); match != nil {
+			switch strings.ToLower(match[3]) {
+			case "index", "key", "primary", "foreign", "constraint", "check":
+				if match[2] == "" {
+					continue
+				}
+			}
+			loss.Table, loss.Column = match[1], match[3]
+			var generated int64
+			err = db.Raw(`SELECT count(*) FROM information_schema.columns WHERE table_schema = DATABASE()
+				AND table_name = ? AND column_name = ? AND extra LIKE '%GENERATED%'`, loss.Table, loss.Column).
+				Scan(&generated).Error
+			if err != nil {
+				return nil, err
+			}
+			if generated > 0 {
+				continue
+			}
+			err = db.Table(loss.Table).Where("? IS NOT NULL", clause.Column{Name: loss.Column}).Count(&loss.Rows).Error
+		} else {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		losses = append(losses, loss)
+	}
+	return losses, nil
+}
+
+// ExportBeforeDrop dumps what a rollback drops into dir before it runs, the
+// whole table or the primary key with the column, in the gzipped JSON lines
+// format of Backup.
+func ExportBeforeDrop(dir string) func(ctx context.Context, db *gorm.DB, loss DataLoss) error {
+	return func(ctx context.Context, db *gorm.DB, loss DataLoss) error {
+		err := os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return err
+		}
+
+		db = db.WithContext(ctx)
+		table := BackupTable{Name: loss.Table, File: fmt.Sprintf("%d_%s.jsonl.gz", loss.Version, loss.Table)}
+		if loss.Column != "" {
+			err = db.Raw(`SELECT column_name FROM information_schema.key_column_usage WHERE table_schema = DATABASE()
+				AND table_name = ? AND constraint_name = 'PRIMARY' ORDER BY ordinal_position`, loss.Table).
+				Scan(&table.Columns).Error
+			if err != nil {
+				return err
+			}
+			table.Columns = append(table.Columns, loss.Column)
+			table.File = fmt.Sprintf("%d_%s_%s.jsonl.gz", loss.Version, loss.Table, loss.Column)
+		} else {
+			err = db.Raw(`SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE()
+				AND table_name = ? AND extra NOT LIKE '%GENERATED%' ORDER BY ordinal_position`, loss.Table).
+				Scan(&table.Columns).Error
+			if err != nil {
+				return err
+			}
+		}
+		return dumpTable(db, dir, &table)
+	}
+}
+
+func checkDataLoss(db *gorm.DB, migration Migration, options RollbackOptions) error {
+	losses, err := dataLosses(db, migration)
+	if err != nil {
+		return fmt.Errorf("rollback %d %s: %w", migration.Version, migration.Name, err)
+	}
+	if options.OnDataLoss != nil {
+		for _, loss := range losses {
+			options.OnDataLoss(loss)
+		}
+	}
+	if len(losses) == 0 {
+		return nil
+	}
+	if !options.AllowDestructive {
+		return &DestructiveMigrationError{Version: migration.Version, Migration: migration.Name, Losses: losses}
+	}
+
+	if options.BeforeDrop != nil {
+		for _, loss := range losses {
+			err = options.BeforeDrop(db.Statement.Context, db, loss)
+			if err != nil {
+				return fmt.Errorf("rollback %d %s: export %s: %w", migration.Version, migration.Name, loss, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package learn_golang_gorm
+
+import "gorm.io/gorm"
+
+func init() {
+	RegisterMigration(Migration{
+		Version: 1,
+		Name:    "add users full_name",
+		Up: func(tx *gorm.DB) error {
+			return AddGeneratedColumn(tx, GeneratedColumn{
+				Table:      "users",
+				Name:       "full_name",
+				Type:       "varchar(300)",
+				Expression: "concat_ws(' ', nullif(first_name, ''), nullif(middle_name, ''), nullif(last_name, ''))",
+				Stored:     true,
+			})
+		},
+		Down: func(tx *gorm.DB) error {
+			return DropGeneratedColumn(tx, "users", "full_name")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 2,
+		Name:    "index users full_name",
+		Up: func(tx *gorm.DB) error {
+			return CreateIndex(tx, "users", "idx_users_full_name", "full_name")
+		},
+		Down: func(tx *gorm.DB) error {
+			return DropIndex(tx, "users", "idx_users_full_name")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 3,
+		Name:    "add guest_books email_lower",
+		Up: func(tx *gorm.DB) error {
+			return AddGeneratedColumn(tx, GeneratedColumn{
+				Table:      "guest_books",
+				Name:       "email_lower",
+				Type:       "varchar(255)",
+				Expression: "lower(email)",
+			})
+		},
+		Down: func(tx *gorm.DB) error {
+			return DropGeneratedColumn(tx, "guest_books", "email_lower")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 4,
+		Name:    "index guest_books email_lower",
+		Up: func(tx *gorm.DB) error {
+			return CreateIndex(tx, "guest_books", "idx_guest_books_email_lower", "email_lower")
+		},
+		Down: func(tx *gorm.DB) error {
+			return DropIndex(tx, "guest_books", "idx_guest_books_email_lower")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 5,
+		Name:    "add users email",
+		Up: func(tx *gorm.DB) error {
+			return AddColumn(tx, &User{}, "Email")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&User{}, "Email")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 6,
+		Name:    "add users phone",
+		Up: func(tx *gorm.DB) error {
+			return AddColumn(tx, &User{}, "Phone")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&User{}, "Phone")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 7,
+		Name:    "index users email and phone",
+		Up: func(tx *gorm.DB) error {
+			err := CreateIndex(tx, "users", "idx_users_email", "email")
+			if err != nil {
+				return err
+			}
+			return CreateIndex(tx, "users", "idx_users_phone", "phone")
+		},
+		Down: func(tx *gorm.DB) error {
+			err := DropIndex(tx, "users", "idx_users_phone")
+			if err != nil {
+				return err
+			}
+			return DropIndex(tx, "users", "idx_users_email")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 8,
+		Name:    "add user_logs ip",
+		Up: func(tx *gorm.DB) error {
+			return AddColumn(tx, &UserLog{}, "IP")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&UserLog{}, "IP")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 9,
+		Name:    "add user_logs user_agent",
+		Up: func(tx *gorm.DB) error {
+			return AddColumn(tx, &UserLog{}, "UserAgent")
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropColumn(&UserLog{}, "UserAgent")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 10,
+		Name:    "index user_logs ip",
+		Up: func(tx *gorm.DB) error {
+			return CreateIndex(tx, "user_logs", "idx_user_logs_ip", "ip")
+		},
+		Down: func(tx *gorm.DB) error {
+			return DropIndex(tx, "user_logs", "idx_user_logs_ip")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 11,
+		Name:    "add products likes_count view_count",
+		Up: func(tx *gorm.DB) error {
+			for _, field := range []string{"LikesCount", "ViewCount"} {
+				err := AddColumn(tx, &Product{}, field)
+				if err != nil {
+					return err
+				}
+			}
+			return tx.Exec("update products p set p.likes_count = " +
+				"(select count(*) from user_like_product l where l.product_id = p.id)").Error
+		},
+		Down: func(tx *gorm.DB) error {
+			err := tx.Migrator().DropColumn(&Product{}, "ViewCount")
+			if err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Product{}, "LikesCount")
+		},
+	})
+	RegisterMigration(Migration{
+		Version: 12,
+		Name:    "add products category",
+		Up: func(tx *gorm.DB) error {
+			err := AddColumn(tx, &Product{}, "Category")
+			if err != nil {
+				return err
+			}
+			return CreateIndex(tx, "products", "idx_products_category", "category")
+		},
+		Down: func(tx *gorm.DB) error {
+			err := DropIndex(tx, "products", "idx_products_category")
+			if err != nil {
+				return err
+			}
+			return tx.Migrator().DropColumn(&Product{}, "Category")
+		},
+	})
+}
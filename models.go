@@ -0,0 +1,22 @@
+package learn_golang_gorm
+
+var models = []interface{}{
+	&User{}, &UserLog{}, &UserPreference{}, &Address{}, &Wallet{}, &WalletTransaction{},
+	&Product{}, &ProductPrice{}, &ProductTranslation{}, &Review{}, &Tag{}, &Tagging{},
+	&Todo{}, &Reminder{}, &GuestBook{}, &Cart{}, &CartItem{}, &Coupon{}, &CouponRedemption{},
+	&Sequence{}, &AuditLog{}, &LedgerHead{}, &LedgerAnchor{}, &ReplicationHeartbeat{}, &SchemaMigration{},
+	&Account{}, &JournalTransaction{}, &JournalEntry{}, &ReportRun{}, &Session{}, &APIKey{},
+	&LoginAttempt{}, &AccountLockout{}, &PendingChange{}, &SchemaMigrationLock{},
+	&QuotaOverride{}, &QuotaUsage{}, &Setting{}, &ChangeEvent{}, &ExportJob{},
+	&XATransaction{}, &SavedSearch{},
+}
+
+// RegisterModel adds models to the ones reported on by the table
+// statistics and index reports.
+func RegisterModel(model ...interface{}) {
+	models = append(models, model...)
+}
+
+func Models() []interface{} {
+	return append([]interface{}(nil), models...)
+}
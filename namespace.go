@@ -0,0 +1,185 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+var ErrInvalidNamespace = errors.New("namespace must be 1 to 32 lowercase letters, digits or underscores")
+
+var namespacePattern = regexp.MustCompile(`^[a-z0-9_]{1,32}$`)
+
+// NamespaceDatabase is the schema of namespace next to database, database
+// itself for the empty namespace.
+func NamespaceDatabase(database string, namespace string) string {
+	if namespace == "" {
+		return database
+	}
+	return database + "__" + namespace
+}
+
+// Seed fills a freshly migrated database.
+type Seed func(db *gorm.DB) error
+
+// CreateNamespace provisions the schema of namespace on the server of
+// config: it creates the schema, migrates it and runs the seeds. It returns
+// a connection to the namespace, provisioning an existing namespace only
+// applies the pending migrations and the seeds again.
+func CreateNamespace(ctx context.Context, config Config, namespace string, seeds ...Seed) (*gorm.DB, error) {
+	if !namespacePattern.MatchString(namespace) {
+		return nil, ErrInvalidNamespace
+	}
+
+	admin := config
+	admin.Namespace = ""
+	err := withDB(admin, func(db *gorm.DB) error {
+		return db.WithContext(ctx).Exec(fmt.Sprintf("create database if not exists `%s` character set utf8mb4",
+			NamespaceDatabase(config.Database, namespace))).Error
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	config.Namespace = namespace
+	db, err := Open(config)
+	if err != nil {
+		return nil, err
+	}
+	err = provision(db.WithContext(ctx), seeds)
+	if err != nil {
+		closeDB(db)
+		return nil, err
+	}
+	return db, nil
+}
+
+// provision creates the tables of the models, then applies the migrations
+// for what AutoMigrate does not create, like generated columns.
+func provision(db *gorm.DB, seeds []Seed) error {
+	err := db.Migrator().AutoMigrate(Models()...)
+	if err != nil {
+		return err
+	}
+	err = Migrate(db)
+	if err != nil {
+		return err
+	}
+	for _, seed := range seeds {
+		err = seed(db)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DropNamespace drops the schema of namespace and everything in it.
+func DropNamespace(ctx context.Context, config Config, namespace string) error {
+	if !namespacePattern.MatchString(namespace) {
+		return ErrInvalidNamespace
+	}
+	config.Namespace = ""
+	return withDB(config, func(db *gorm.DB) error {
+		return db.WithContext(ctx).Exec(fmt.Sprintf("drop database if exists `%s`",
+			NamespaceDatabase(config.Database, namespace))).Error
+	})
+}
+
+// ListNamespaces returns the namespaces provisioned next to the database of
+// config.
+func ListNamespaces(ctx context.Context, config Config) ([]string, error) {
+	config.Namespace = ""
+	var schemas []string
+	err := withDB(config, func(db *gorm.DB) error {
+		return db.WithContext(ctx).Raw("select schema_name from information_schema.schemata where schema_name like ? order by schema_name",
+			strings.ReplaceAll(config.Database, "_", `\_`)+`\_\_%`).Scan(&schemas).Error
+	})
+	namespaces := make([]string, len(schemas))
+	for i, schema := range schemas {
+		namespaces[i] = strings.TrimPrefix(schema, config.Database+"__")
+	}
+	return namespaces, err
+}
+
+func withDB(config Config, fn func(db *gorm.DB) error) error {
+	db, err := Open(config)
+	if err != nil {
+		return err
+	}
+	defer closeDB(db)
+	return fn(db)
+}
+
+func closeDB(db *gorm.DB) {
+	if sqlDB, err := db.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+}
+
+type namespaceKey struct{}
+
+// WithNamespace routes the queries made through Namespaces.DB with ctx to
+// namespace, e.g. from a header of a preview deployment.
+func WithNamespace(ctx context.Context, namespace string) context.Context {
+	return context.WithValue(ctx, namespaceKey{}, namespace)
+}
+
+func NamespaceFromContext(ctx context.Context) (string, bool) {
+	namespace, ok := ctx.Value(namespaceKey{}).(string)
+	return namespace, ok
+}
+
+// Namespaces keeps a connection per namespace, opened on first use with
+// Config. Contexts without a namespace use Config.Namespace.
+type Namespaces struct {
+	Config Config
+
+	mutex sync.Mutex
+	dbs   map[string]*gorm.DB
+}
+
+func NewNamespaces(config Config) *Namespaces {
+	return &Namespaces{Config: config, dbs: map[string]*gorm.DB{}}
+}
+
+// DB returns the session of the namespace of ctx.
+func (n *Namespaces) DB(ctx context.Context) (*gorm.DB, error) {
+	namespace, ok := NamespaceFromContext(ctx)
+	if !ok {
+		namespace = n.Config.Namespace
+	}
+	if namespace != "" && !namespacePattern.MatchString(namespace) {
+		return nil, ErrInvalidNamespace
+	}
+
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	db, ok := n.dbs[namespace]
+	if !ok {
+		config := n.Config
+		config.Namespace = namespace
+		var err error
+		db, err = Open(config)
+		if err != nil {
+			return nil, err
+		}
+		n.dbs[namespace] = db
+	}
+	return db.WithContext(ctx), nil
+}
+
+// Close closes the connections of every namespace.
+func (n *Namespaces) Close() {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	for namespace, db := range n.dbs {
+		closeDB(db)
+		delete(n.dbs, namespace)
+	}
+}
@@ -0,0 +1,283 @@
+package learn_golang_gorm
+
+import (
+	"database/sql/driver"
+	"encoding/json"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+// APIResource is a model served with the REST handlers of the scaffold
+// generator at Path, Filters are the query parameters its list filters on.
+type APIResource struct {
+	Path    string
+	Model   interface{}
+	Filters []string
+}
+
+var (
+	apiResourcesMutex sync.Mutex
+	apiResources      []APIResource
+)
+
+// RegisterAPIResource adds resource to the OpenAPI specification, generated
+// handlers register themselves.
+func RegisterAPIResource(resource APIResource) {
+	apiResourcesMutex.Lock()
+	defer apiResourcesMutex.Unlock()
+	apiResources = append(apiResources, resource)
+}
+
+func APIResources() []APIResource {
+	apiResourcesMutex.Lock()
+	defer apiResourcesMutex.Unlock()
+	return append([]APIResource(nil), apiResources...)
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	deletedAtType = reflect.TypeOf(gorm.DeletedAt{})
+	valuerType    = reflect.TypeOf((*driver.Valuer)(nil)).Elem()
+	marshalerType = reflect.TypeOf((*json.Marshaler)(nil)).Elem()
+)
+
+func schemaRef(name string) map[string]interface{} {
+	return map[string]interface{}{"$ref": "#/components/schemas/" + name}
+}
+
+// openAPISchemas collects the component schemas of the models.
+type openAPISchemas map[string]interface{}
+
+func (s openAPISchemas) schema(t reflect.Type) map[string]interface{} {
+	nullable := false
+	for t.Kind() == reflect.Pointer {
+		t, nullable = t.Elem(), true
+	}
+
+	var schema map[string]interface{}
+	switch {
+	case t == timeType:
+		schema = map[string]interface{}{"type": "string", "format": "date-time"}
+	case t == deletedAtType:
+		schema, nullable = map[string]interface{}{"type": "string", "format": "date-time"}, true
+	case t.Implements(valuerType) || t.Implements(marshalerType) || reflect.PointerTo(t).Implements(marshalerType):
+		// custom column types like Email or INET
+		schema = map[string]interface{}{"type": "string"}
+	default:
+		switch t.Kind() {
+		case reflect.Bool:
+			schema = map[string]interface{}{"type": "boolean"}
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+			schema = map[string]interface{}{"type": "integer", "format": "int32"}
+		case reflect.Int64, reflect.Uint64:
+			schema = map[string]interface{}{"type": "integer", "format": "int64"}
+		case reflect.Float32, reflect.Float64:
+			schema = map[string]interface{}{"type": "number"}
+		case reflect.Slice, reflect.Array:
+			if t.Elem().Kind() == reflect.Uint8 {
+				schema = map[string]interface{}{"type": "string", "format": "byte"}
+			} else {
+				schema = map[string]interface{}{"type": "array", "items": s.schema(t.Elem())}
+			}
+		case reflect.Map:
+			schema = map[string]interface{}{"type": "object", "additionalProperties": s.schema(t.Elem())}
+		case reflect.Struct:
+			s.add(t)
+			schema = schemaRef(t.Name())
+		case reflect.Interface:
+			schema = map[string]interface{}{}
+		default:
+			schema = map[string]interface{}{"type": "string"}
+		}
+	}
+
+	if nullable {
+		if _, isRef := schema["$ref"]; isRef {
+			return map[string]interface{}{"allOf": []interface{}{schema}, "nullable": true}
+		}
+		schema["nullable"] = true
+	}
+	return schema
+}
+
+// properties follows encoding/json: embedded structs are flattened, json
+// tags rename or skip fields.
+func (s openAPISchemas) properties(t reflect.Type, properties map[string]interface{}) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+		if name == "-" || !field.IsExported() {
+			continue
+		}
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			s.properties(field.Type, properties)
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = s.schema(field.Type)
+	}
+}
+
+func (s openAPISchemas) add(t reflect.Type) {
+	if _, ok := s[t.Name()]; ok {
+		return
+	}
+	// placeholder first, models referencing each other end here
+	s[t.Name()] = nil
+
+	properties := map[string]interface{}{}
+	s.properties(t, properties)
+	s[t.Name()] = map[string]interface{}{"type": "object", "properties": properties}
+}
+
+func errorResponse(description string) map[string]interface{} {
+	return map[string]interface{}{
+		"description": description,
+		"content":     map[string]interface{}{"application/json": map[string]interface{}{"schema": schemaRef("Error")}},
+	}
+}
+
+func jsonContent(schema map[string]interface{}) map[string]interface{} {
+	return map[string]interface{}{"application/json": map[string]interface{}{"schema": schema}}
+}
+
+func (r APIResource) paths(schemas openAPISchemas) (map[string]interface{}, map[string]interface{}) {
+	t := reflect.TypeOf(r.Model)
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	schemas.add(t)
+	model := schemaRef(t.Name())
+	tag := []string{t.Name()}
+	defaultError := errorResponse("error")
+	notFound := errorResponse("not found")
+
+	listParameters := []interface{}{
+		map[string]interface{}{"$ref": "#/components/parameters/page"},
+		map[string]interface{}{"$ref": "#/components/parameters/size"},
+	}
+	parsed, _ := schema.Parse(r.Model, &sync.Map{}, schema.NamingStrategy{})
+	for _, filter := range r.Filters {
+		filterSchema := map[string]interface{}{"type": "string"}
+		if parsed != nil {
+			if field := parsed.LookUpField(filter); field != nil {
+				filterSchema = schemas.schema(field.FieldType)
+			}
+		}
+		listParameters = append(listParameters, map[string]interface{}{
+			"name": filter, "in": "query", "schema": filterSchema,
+		})
+	}
+
+	collection := map[string]interface{}{
+		"get": map[string]interface{}{
+			"tags":        tag,
+			"operationId": "list" + t.Name(),
+			"parameters":  listParameters,
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": "a page", "content": jsonContent(map[string]interface{}{"type": "array", "items": model})},
+				"default": defaultError,
+			},
+		},
+		"post": map[string]interface{}{
+			"tags":        tag,
+			"operationId": "create" + t.Name(),
+			"requestBody": map[string]interface{}{"required": true, "content": jsonContent(model)},
+			"responses": map[string]interface{}{
+				"201":     map[string]interface{}{"description": "created", "content": jsonContent(model)},
+				"400":     errorResponse("invalid body"),
+				"default": defaultError,
+			},
+		},
+	}
+
+	item := map[string]interface{}{
+		"parameters": []interface{}{map[string]interface{}{
+			"name": "id", "in": "path", "required": true, "schema": map[string]interface{}{"type": "integer", "format": "int64"},
+		}},
+		"get": map[string]interface{}{
+			"tags":        tag,
+			"operationId": "get" + t.Name(),
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": "found", "content": jsonContent(model)},
+				"404":     notFound,
+				"default": defaultError,
+			},
+		},
+		"put": map[string]interface{}{
+			"tags":        tag,
+			"operationId": "update" + t.Name(),
+			"requestBody": map[string]interface{}{"required": true, "content": jsonContent(model)},
+			"responses": map[string]interface{}{
+				"200":     map[string]interface{}{"description": "updated", "content": jsonContent(model)},
+				"400":     errorResponse("invalid body"),
+				"404":     notFound,
+				"default": defaultError,
+			},
+		},
+		"delete": map[string]interface{}{
+			"tags":        tag,
+			"operationId": "delete" + t.Name(),
+			"responses": map[string]interface{}{
+				"204":     map[string]interface{}{"description": "deleted"},
+				"404":     notFound,
+				"default": defaultError,
+			},
+		},
+	}
+	return collection, item
+}
+
+// OpenAPI returns the OpenAPI 3 specification of the registered resources.
+func OpenAPI(title string, version string) map[string]interface{} {
+	schemas := openAPISchemas{
+		"Error": map[string]interface{}{
+			"type":       "object",
+			"properties": map[string]interface{}{"error": map[string]interface{}{"type": "string"}},
+			"required":   []string{"error"},
+		},
+	}
+
+	paths := map[string]interface{}{}
+	for _, resource := range APIResources() {
+		collection, item := resource.paths(schemas)
+		path := "/" + strings.Trim(resource.Path, "/")
+		paths[path] = collection
+		paths[path+"/{id}"] = item
+	}
+
+	return map[string]interface{}{
+		"openapi": "3.0.3",
+		"info":    map[string]interface{}{"title": title, "version": version},
+		"paths":   paths,
+		"components": map[string]interface{}{
+			"schemas": schemas,
+			"parameters": map[string]interface{}{
+				"page": map[string]interface{}{
+					"name": "page", "in": "query",
+					"schema": map[string]interface{}{"type": "integer", "minimum": 1, "default": 1},
+				},
+				"size": map[string]interface{}{
+					"name": "size", "in": "query",
+					"schema": map[string]interface{}{"type": "integer", "minimum": 1, "maximum": MaxPageSize, "default": DefaultPageSize},
+				},
+			},
+		},
+	}
+}
+
+// OpenAPIHandler serves the specification as JSON, mount it at
+// /openapi.json.
+func OpenAPIHandler(title string, version string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		WriteJSON(w, http.StatusOK, OpenAPI(title, version))
+	})
+}
@@ -0,0 +1,30 @@
+package learn_golang_gorm
+
+import "gorm.io/gorm"
+
+const (
+	DefaultPageSize = 20
+	MaxPageSize     = 100
+)
+
+// PageBounds returns the offset and limit of page, out of range pages and
+// sizes fall back to the first page and DefaultPageSize or MaxPageSize.
+func PageBounds(page int, size int) (offset int, limit int) {
+	if page < 1 {
+		page = 1
+	}
+	if size < 1 {
+		size = DefaultPageSize
+	}
+	if size > MaxPageSize {
+		size = MaxPageSize
+	}
+	return (page - 1) * size, size
+}
+
+func Paginate(page int, size int) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		offset, limit := PageBounds(page, size)
+		return db.Offset(offset).Limit(limit)
+	}
+}
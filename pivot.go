@@ -0,0 +1,123 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	PivotCount = "count"
+	PivotSum   = "sum"
+	PivotAvg   = "avg"
+	PivotMin   = "min"
+	PivotMax   = "max"
+)
+
+const DefaultMaxPivotColumns = 50
+
+var (
+	ErrTooManyPivotColumns = errors.New("pivot would generate more columns than allowed")
+	ErrInvalidAggregate    = errors.New("aggregate must be count, sum, avg, min or max")
+)
+
+// PivotQuery turns the rows of Source into a cross tab: one row per distinct
+// Row expression, one column per distinct Column expression, and each cell
+// the Aggregate of Value over the rows having both, rows with a NULL column
+// are left out. Row, Column and Value are SQL expressions over Source, never
+// pass user input as them.
+type PivotQuery struct {
+	Source     func(db *gorm.DB) *gorm.DB
+	Row        string
+	Column     string
+	Aggregate  string
+	Value      string
+	MaxColumns int
+}
+
+var (
+	TodosPerUserByStatus = PivotQuery{
+		Source: func(db *gorm.DB) *gorm.DB {
+			return db.Table("todos").Where("deleted_at IS NULL")
+		},
+		Row:       "user_id",
+		Column:    "case when completed_at is null then 'open' else 'completed' end",
+		Aggregate: PivotCount,
+	}
+	ReviewsPerProductByMonth = PivotQuery{
+		Source: func(db *gorm.DB) *gorm.DB {
+			return db.Table("reviews")
+		},
+		Row:       "product_id",
+		Column:    "date_format(created_at, '%Y-%m')",
+		Aggregate: PivotCount,
+	}
+)
+
+func (q PivotQuery) cell(index int) (string, error) {
+	when := "case when pivot_column = ? then pivot_value end"
+	switch q.Aggregate {
+	case PivotCount, PivotSum, PivotAvg, PivotMin, PivotMax:
+		return fmt.Sprintf("%s(%s) as c%d", q.Aggregate, when, index), nil
+	}
+	return "", ErrInvalidAggregate
+}
+
+// Run returns the cross tab with the row key as first column and the pivot
+// columns in ascending order, cells without rows are NULL for every
+// aggregate but count.
+func (q PivotQuery) Run(ctx context.Context, db *gorm.DB) (QueryResult, error) {
+	db = db.WithContext(ctx)
+	maxColumns := q.MaxColumns
+	if maxColumns <= 0 {
+		maxColumns = DefaultMaxPivotColumns
+	}
+
+	var columns []sql.NullString
+	err := q.Source(db.Session(&gorm.Session{NewDB: true})).
+		Distinct(q.Column+" as pivot_column").Order("pivot_column").Limit(maxColumns+1).
+		Pluck("pivot_column", &columns).Error
+	if err != nil {
+		return QueryResult{}, err
+	}
+	if len(columns) > maxColumns {
+		return QueryResult{}, ErrTooManyPivotColumns
+	}
+
+	result := QueryResult{Columns: []string{"row"}}
+	selects := []string{"pivot_row"}
+	args := make([]interface{}, 0, len(columns))
+	for _, column := range columns {
+		if !column.Valid {
+			continue
+		}
+		cell, err := q.cell(len(args))
+		if err != nil {
+			return QueryResult{}, err
+		}
+		selects = append(selects, cell)
+		args = append(args, column)
+		result.Columns = append(result.Columns, column.String)
+	}
+
+	value := q.Value
+	if value == "" {
+		value = "1"
+	}
+	source := q.Source(db.Session(&gorm.Session{NewDB: true})).
+		Select(q.Row + " as pivot_row, " + q.Column + " as pivot_column, " + value + " as pivot_value")
+	rows, err := db.Table("(?) as p", source).Select(strings.Join(selects, ", "), args...).
+		Group("pivot_row").Order("pivot_row").Rows()
+	if err != nil {
+		return QueryResult{}, err
+	}
+	defer rows.Close()
+
+	scanned, err := scanQueryResult(rows, 0)
+	result.Rows = scanned.Rows
+	return result, err
+}
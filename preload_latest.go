@@ -0,0 +1,114 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var ErrUnsupportedAssociation = fmt.Errorf("%w: unsupported association", ErrInvalidRequest)
+
+func supportsWindowFunctions(db *gorm.DB) bool {
+	switch db.Dialector.Name() {
+	case "mysql", "postgres", "sqlite", "sqlserver":
+		return true
+	}
+	return false
+}
+
+// PreloadLatest fills association, a has many of parents, a pointer to the
+// loaded rows, with only the first n rows per parent in order, e.g. the 3
+// latest addresses of each user with "created_at desc". Without order the
+// rows come by primary key.
+func PreloadLatest(db *gorm.DB, parents interface{}, association string, n int, order string) error {
+	stmt := &gorm.Statement{DB: db}
+	err := stmt.Parse(parents)
+	if err != nil {
+		return err
+	}
+	relation, ok := stmt.Schema.Relationships.Relations[association]
+	if !ok {
+		return fmt.Errorf("%w: %s of %s", ErrUnknownAssociation, association, stmt.Schema.Name)
+	}
+	if relation.Type != schema.HasMany || len(relation.References) != 1 || relation.References[0].PrimaryKey == nil {
+		return fmt.Errorf("%w: %s of %s is not a has many", ErrUnsupportedAssociation, association, stmt.Schema.Name)
+	}
+	reference := relation.References[0]
+	if order == "" && relation.FieldSchema.PrioritizedPrimaryField != nil {
+		order = stmt.Quote(relation.FieldSchema.PrioritizedPrimaryField.DBName)
+	}
+
+	// parents by key, the same parent may be listed twice
+	byKey := map[string][]reflect.Value{}
+	var keys []interface{}
+	collect := func(parent reflect.Value) {
+		parent = reflect.Indirect(parent)
+		field := relation.Field.ReflectValueOf(db.Statement.Context, parent)
+		field.Set(reflect.MakeSlice(relation.Field.IndirectFieldType, 0, 0))
+		key, zero := reference.PrimaryKey.ValueOf(db.Statement.Context, parent)
+		if zero {
+			return
+		}
+		id := fmt.Sprint(key)
+		if _, ok := byKey[id]; !ok {
+			keys = append(keys, key)
+		}
+		byKey[id] = append(byKey[id], parent)
+	}
+	value := reflect.Indirect(reflect.ValueOf(parents))
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			collect(value.Index(i))
+		}
+	case reflect.Struct:
+		collect(value)
+	}
+	if len(keys) == 0 || n <= 0 {
+		return nil
+	}
+
+	children := reflect.New(reflect.SliceOf(relation.FieldSchema.ModelType))
+	model := reflect.New(relation.FieldSchema.ModelType).Interface()
+	foreignKey := clause.Column{Name: reference.ForeignKey.DBName}
+	tx := db.Session(&gorm.Session{NewDB: true})
+	if supportsWindowFunctions(db) {
+		ranked := tx.Model(model).
+			Select(stmt.Quote(relation.FieldSchema.Table)+".*, row_number() over (partition by ? order by "+order+") as preload_rank", foreignKey).
+			Where(clause.IN{Column: foreignKey, Values: keys})
+		err = tx.Table("(?) as ranked", ranked).Where("preload_rank <= ?", n).
+			Order(clause.OrderByColumn{Column: foreignKey}).Order("preload_rank").
+			Find(children.Interface()).Error
+	} else {
+		for _, key := range keys {
+			part := reflect.New(children.Elem().Type())
+			err = tx.Model(model).Where(clause.Eq{Column: foreignKey, Value: key}).Order(order).Limit(n).
+				Find(part.Interface()).Error
+			if err != nil {
+				break
+			}
+			children.Elem().Set(reflect.AppendSlice(children.Elem(), part.Elem()))
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	pointers := relation.Field.IndirectFieldType.Elem().Kind() == reflect.Ptr
+	for i := 0; i < children.Elem().Len(); i++ {
+		child := children.Elem().Index(i)
+		key, _ := reference.ForeignKey.ValueOf(db.Statement.Context, child)
+		for _, parent := range byKey[fmt.Sprint(key)] {
+			field := relation.Field.ReflectValueOf(db.Statement.Context, parent)
+			if pointers {
+				field.Set(reflect.Append(field, child.Addr()))
+			} else {
+				field.Set(reflect.Append(field, child))
+			}
+		}
+	}
+	return nil
+}
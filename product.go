@@ -3,12 +3,19 @@ package learn_golang_gorm
 import "time"
 
 type Product struct {
-	ID           string    `gorm:"primary_key;column:id"`
-	Name         string    `gorm:"column:name"`
-	Price        int64     `gorm:"column:price"`
-	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime"`
-	UpdatedAt    time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
-	LikedByUsers []User    `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:product_id;references:id;joinReferences:user_id"`
+	ID            string               `gorm:"primary_key;column:id"`
+	Name          string               `gorm:"column:name"`
+	Category      string               `gorm:"column:category;type:varchar(100);index"`
+	Price         int64                `gorm:"column:price"`
+	Stock         int64                `gorm:"column:stock"`
+	AverageRating float64              `gorm:"column:average_rating"`
+	ReviewCount   int64                `gorm:"column:review_count"`
+	LikesCount    int64                `gorm:"column:likes_count"`
+	ViewCount     int64                `gorm:"column:view_count"`
+	CreatedAt     time.Time            `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt     time.Time            `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	LikedByUsers  []User               `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:product_id;references:id;joinReferences:user_id"`
+	Translations  []ProductTranslation `gorm:"foreignKey:product_id;references:id"`
 }
 
 func (p *Product) TableName() string {
@@ -0,0 +1,81 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrPriceNotEffective = errors.New("new price must start after the current price")
+
+type ProductPrice struct {
+	ID            int64      `gorm:"primary_key;column:id;autoIncrement"`
+	ProductID     string     `gorm:"column:product_id"`
+	Price         int64      `gorm:"column:price"`
+	EffectiveFrom time.Time  `gorm:"column:effective_from"`
+	EffectiveTo   *time.Time `gorm:"column:effective_to"`
+	CreatedAt     time.Time  `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt     time.Time  `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	Product       Product    `gorm:"foreignKey:product_id;references:id"`
+}
+
+func (p *ProductPrice) TableName() string {
+	return "product_prices"
+}
+
+func PriceAt(db *gorm.DB, productID string, at time.Time) (ProductPrice, error) {
+	var price ProductPrice
+	err := db.Where("product_id = ? AND effective_from <= ?", productID, at).
+		Where("effective_to IS NULL OR effective_to > ?", at).
+		Order("effective_from desc").
+		Take(&price).Error
+	return price, err
+}
+
+// SetPrice closes the currently open price interval at from and opens a new
+// one, the previous rows are never rewritten so history stays auditable.
+func SetPrice(db *gorm.DB, productID string, price int64, from time.Time) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var current ProductPrice
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("product_id = ? AND effective_to IS NULL", productID).
+			Order("effective_from desc").
+			Take(&current).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+
+		if err == nil {
+			if !from.After(current.EffectiveFrom) {
+				return ErrPriceNotEffective
+			}
+
+			err = tx.Model(&current).Update("effective_to", from).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		err = tx.Create(&ProductPrice{
+			ProductID:     productID,
+			Price:         price,
+			EffectiveFrom: from,
+		}).Error
+		if err != nil {
+			return err
+		}
+
+		if from.After(Now(tx)) {
+			return nil
+		}
+		return tx.Model(&Product{}).Where("id = ?", productID).Update("price", price).Error
+	})
+}
+
+// PriceHistory returns the prices of a product, oldest first, at most
+// DefaultMaxRows of them.
+func PriceHistory(db *gorm.DB, productID string) (Limited[ProductPrice], error) {
+	return FindLimited[ProductPrice](db.Where("product_id = ?", productID).Order("effective_from asc"), DefaultMaxRows)
+}
@@ -0,0 +1,63 @@
+package learn_golang_gorm
+
+import (
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const DefaultLocale = "id"
+
+type ProductTranslation struct {
+	ID          int64     `gorm:"primary_key;column:id;autoIncrement"`
+	ProductID   string    `gorm:"column:product_id;uniqueIndex:idx_product_translations_product_locale"`
+	Locale      string    `gorm:"column:locale;uniqueIndex:idx_product_translations_product_locale"`
+	Name        string    `gorm:"column:name"`
+	Description string    `gorm:"column:description"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (t *ProductTranslation) TableName() string {
+	return "product_translations"
+}
+
+// PreloadTranslation preloads only the rows needed to resolve locale, the
+// requested one and DefaultLocale, for every product of the query.
+func PreloadTranslation(locale string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		return db.Preload("Translations", "locale IN ?", []string{locale, DefaultLocale})
+	}
+}
+
+// Translation resolves the preloaded translations of the product, falling
+// back from locale to DefaultLocale and finally to the product name.
+func (p *Product) Translation(locale string) ProductTranslation {
+	var fallback *ProductTranslation
+	for i := range p.Translations {
+		translation := &p.Translations[i]
+		if translation.Locale == locale {
+			return *translation
+		}
+		if translation.Locale == DefaultLocale {
+			fallback = translation
+		}
+	}
+
+	if fallback != nil {
+		return *fallback
+	}
+	return ProductTranslation{ProductID: p.ID, Locale: DefaultLocale, Name: p.Name}
+}
+
+func UpsertProductTranslation(db *gorm.DB, translation *ProductTranslation) error {
+	return db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "product_id"}, {Name: "locale"}},
+		DoUpdates: clause.AssignmentColumns([]string{"name", "description", "updated_at"}),
+	}).Create(translation).Error
+}
+
+func DeleteProductTranslation(db *gorm.DB, productID string, locale string) error {
+	return db.Where("product_id = ? AND locale = ?", productID, locale).Delete(&ProductTranslation{}).Error
+}
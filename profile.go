@@ -0,0 +1,135 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"gorm.io/gorm/logger"
+)
+
+const DefaultProfile = "dev"
+
+// Profile adjusts the configuration of one environment. Parent names the
+// profile applied before this one, so a profile only lists what differs.
+type Profile struct {
+	Parent string
+	Apply  func(config *Config)
+}
+
+var profiles = map[string]Profile{
+	"dev": {
+		Apply: func(config *Config) {
+			config.LogLevel = logger.Info
+			config.SeedSets = []string{SeedSetMinimal, SeedSetDemo}
+		},
+	},
+	"test": {
+		Parent: "dev",
+		Apply: func(config *Config) {
+			config.MaxOpenConns = 10
+			config.MaxIdleConns = 2
+			config.LogLevel = logger.Warn
+			config.SeedSets = []string{SeedSetMinimal}
+		},
+	},
+	"staging": {
+		Apply: func(config *Config) {
+			config.MaxOpenConns = 50
+			config.MaxIdleConns = 10
+			config.LogLevel = logger.Warn
+			config.RotationInterval = 5 * time.Minute
+			config.SeedSets = []string{SeedSetMinimal, SeedSetDemo}
+		},
+	},
+	"load": {
+		Parent: "staging",
+		Apply: func(config *Config) {
+			config.SeedSets = []string{SeedSetMinimal, SeedSetLoadTest}
+		},
+	},
+	"prod": {
+		Parent: "staging",
+		Apply: func(config *Config) {
+			config.MaxOpenConns = 200
+			config.MaxIdleConns = 50
+			config.LogLevel = logger.Error
+			config.SeedSets = []string{SeedSetMinimal}
+		},
+	},
+}
+
+// RegisterProfile adds or replaces the profile called name.
+func RegisterProfile(name string, profile Profile) {
+	profiles[name] = profile
+}
+
+// ProfileConfig returns DefaultConfig with the ancestors of the profile and
+// then the profile itself applied.
+func ProfileConfig(name string) (Config, error) {
+	var chain []Profile
+	seen := map[string]bool{}
+	for current := name; current != ""; {
+		profile, ok := profiles[current]
+		if !ok {
+			return Config{}, fmt.Errorf("unknown profile %q", current)
+		}
+		if seen[current] {
+			return Config{}, fmt.Errorf("profile %q inherits from itself", current)
+		}
+		seen[current] = true
+		chain = append(chain, profile)
+		current = profile.Parent
+	}
+
+	config := DefaultConfig()
+	for i := len(chain) - 1; i >= 0; i-- {
+		if chain[i].Apply != nil {
+			chain[i].Apply(&config)
+		}
+	}
+	return config, nil
+}
+
+// LoadConfig selects the profile with APP_ENV, dev when unset, and applies
+// the DB_HOSTS, DB_REPLICAS, DB_NAME, DB_NAMESPACE, DB_DRY_RUN, DB_PROFILE
+// and DB_UNSAFE_LOG_PARAMS overrides on top of it. Parameters are never
+// logged outside dev, DB_UNSAFE_LOG_PARAMS in another profile is an error.
+func LoadConfig() (Config, error) {
+	name := os.Getenv("APP_ENV")
+	if name == "" {
+		name = DefaultProfile
+	}
+
+	config, err := ProfileConfig(name)
+	if err != nil {
+		return Config{}, err
+	}
+
+	if hosts := os.Getenv("DB_HOSTS"); hosts != "" {
+		config.Hosts = strings.Split(hosts, ",")
+	}
+	if replicas := os.Getenv("DB_REPLICAS"); replicas != "" {
+		config.Replicas = strings.Split(replicas, ",")
+	}
+	if database := os.Getenv("DB_NAME"); database != "" {
+		config.Database = database
+	}
+	if namespace := os.Getenv("DB_NAMESPACE"); namespace != "" {
+		config.Namespace = namespace
+	}
+	if os.Getenv("DB_DRY_RUN") == "true" {
+		config.DryRun = true
+	}
+	if os.Getenv("DB_PROFILE") == "true" {
+		config.Profile = true
+	}
+	if os.Getenv("DB_UNSAFE_LOG_PARAMS") == "true" {
+		if name != DefaultProfile {
+			return Config{}, fmt.Errorf("DB_UNSAFE_LOG_PARAMS is only allowed in the %s profile, not %q", DefaultProfile, name)
+		}
+		config.UnsafeLogParams = true
+	}
+	return config, nil
+}
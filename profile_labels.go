@@ -0,0 +1,78 @@
+package learn_golang_gorm
+
+import (
+	"runtime/pprof"
+	"runtime/trace"
+
+	"gorm.io/gorm"
+)
+
+func startProfiling(operation string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		ctx := db.Statement.Context
+		table := db.Statement.Table
+		pprof.SetGoroutineLabels(pprof.WithLabels(ctx, pprof.Labels("operation", operation, "table", table)))
+		db.InstanceSet("profile:region", trace.StartRegion(ctx, "gorm "+operation+" "+table))
+	}
+}
+
+// endProfiling goes back to the labels of the statement context, the ones
+// set with pprof.Do around the call.
+func endProfiling(db *gorm.DB) {
+	if region, ok := db.InstanceGet("profile:region"); ok {
+		region.(*trace.Region).End()
+	}
+	pprof.SetGoroutineLabels(db.Statement.Context)
+}
+
+// ProfileStatements labels every statement on db with its operation and
+// table, so CPU and heap profiles attribute the time spent scanning and
+// building it, and marks it as a region in execution traces.
+func ProfileStatements(db *gorm.DB) error {
+	callbacks := db.Callback()
+	err := callbacks.Create().Before("*").Register("profile:start", startProfiling("create"))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Create().After("*").Register("profile:end", endProfiling)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Query().Before("*").Register("profile:start", startProfiling("query"))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Query().After("*").Register("profile:end", endProfiling)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().Before("*").Register("profile:start", startProfiling("update"))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().After("*").Register("profile:end", endProfiling)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Delete().Before("*").Register("profile:start", startProfiling("delete"))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Delete().After("*").Register("profile:end", endProfiling)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Row().Before("*").Register("profile:start", startProfiling("row"))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Row().After("*").Register("profile:end", endProfiling)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Raw().Before("*").Register("profile:start", startProfiling("raw"))
+	if err != nil {
+		return err
+	}
+	return callbacks.Raw().After("*").Register("profile:end", endProfiling)
+}
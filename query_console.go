@@ -0,0 +1,202 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrNotReadOnlyQuery = errors.New("only read only SELECT statements are allowed")
+
+var (
+	sqlCommentPattern   = regexp.MustCompile(`(?s)/\*.*?\*/|--[^\n]*|#[^\n]*`)
+	readOnlyPrefix      = regexp.MustCompile(`(?i)^(select|with|show|explain|describe|desc)\b`)
+	forbiddenInReadOnly = regexp.MustCompile(`(?i)\binto\s+(outfile|dumpfile)\b|\bfor\s+update\b|\bfor\s+share\b|\block\s+in\s+share\s+mode\b`)
+)
+
+type QueryResult struct {
+	Columns   []string    `json:"columns"`
+	Rows      [][]*string `json:"rows"`
+	Truncated bool        `json:"truncated"`
+}
+
+func (r QueryResult) WriteJSON(w io.Writer) error {
+	return json.NewEncoder(w).Encode(r)
+}
+
+// WriteCSV writes the header and the rows, NULL is written as an empty field.
+func (r QueryResult) WriteCSV(w io.Writer) error {
+	writer := csv.NewWriter(w)
+	err := writer.Write(r.Columns)
+	if err != nil {
+		return err
+	}
+
+	record := make([]string, len(r.Columns))
+	for _, row := range r.Rows {
+		for i, value := range row {
+			record[i] = ""
+			if value != nil {
+				record[i] = *value
+			}
+		}
+		err = writer.Write(record)
+		if err != nil {
+			return err
+		}
+	}
+
+	writer.Flush()
+	return writer.Error()
+}
+
+// scanQueryResult reads at most maxRows rows as strings, maxRows <= 0 reads
+// all of them.
+func scanQueryResult(rows *sql.Rows, maxRows int) (QueryResult, error) {
+	var result QueryResult
+	var err error
+	result.Columns, err = rows.Columns()
+	if err != nil {
+		return result, err
+	}
+
+	for rows.Next() {
+		if maxRows > 0 && len(result.Rows) >= maxRows {
+			result.Truncated = true
+			break
+		}
+
+		values := make([]sql.NullString, len(result.Columns))
+		dest := make([]interface{}, len(values))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		err = rows.Scan(dest...)
+		if err != nil {
+			return result, err
+		}
+
+		row := make([]*string, len(values))
+		for i := range values {
+			if values[i].Valid {
+				row[i] = &values[i].String
+			}
+		}
+		result.Rows = append(result.Rows, row)
+	}
+	return result, rows.Err()
+}
+
+// ValidateReadOnlyQuery accepts a single SELECT, WITH, SHOW, EXPLAIN or
+// DESCRIBE statement that neither writes files nor takes row locks.
+func ValidateReadOnlyQuery(query string) (string, error) {
+	stripped := strings.TrimSpace(sqlCommentPattern.ReplaceAllString(query, " "))
+	stripped = strings.TrimSpace(strings.TrimSuffix(stripped, ";"))
+
+	if !readOnlyPrefix.MatchString(stripped) || strings.Contains(stripped, ";") ||
+		forbiddenInReadOnly.MatchString(stripped) {
+		return "", ErrNotReadOnlyQuery
+	}
+	return stripped, nil
+}
+
+// QueryConsole runs ad hoc queries for support and debugging. Besides the
+// statement check every query runs in a READ ONLY transaction, with a
+// server side execution time limit and at most MaxRows rows returned.
+type QueryConsole struct {
+	DB         *gorm.DB
+	MaxRows    int
+	Timeout    time.Duration
+	AdminToken string
+}
+
+func NewQueryConsole(db *gorm.DB, adminToken string) *QueryConsole {
+	return &QueryConsole{
+		DB:         db,
+		MaxRows:    1000,
+		Timeout:    10 * time.Second,
+		AdminToken: adminToken,
+	}
+}
+
+func (c *QueryConsole) Run(ctx context.Context, query string) (QueryResult, error) {
+	query, err := ValidateReadOnlyQuery(query)
+	if err != nil {
+		return QueryResult{}, err
+	}
+
+	if len(query) >= 6 && strings.EqualFold(query[:6], "select") && c.DB.Dialector.Name() == "mysql" {
+		query = fmt.Sprintf("select /*+ MAX_EXECUTION_TIME(%d) */%s", c.Timeout.Milliseconds(), query[6:])
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+
+	var result QueryResult
+	err = RunInTransaction(ctx, c.DB, func(tx *gorm.DB) error {
+		rows, err := tx.Raw(query).Rows()
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		result, err = scanQueryResult(rows, c.MaxRows)
+		return err
+	}, ReadOnly())
+	return result, err
+}
+
+func (c *QueryConsole) authorized(r *http.Request) bool {
+	token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return c.AdminToken != "" && subtle.ConstantTimeCompare([]byte(token), []byte(c.AdminToken)) == 1
+}
+
+// ServeHTTP accepts the query as the body of a POST request and answers in
+// JSON, or CSV with ?format=csv. Requests need the admin bearer token.
+func (c *QueryConsole) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !c.authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, 64*1024))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	result, err := c.Run(r.Context(), string(body))
+	if errors.Is(err, ErrNotReadOnlyQuery) {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+		return
+	}
+
+	w.Header().Set("X-Truncated", strconv.FormatBool(result.Truncated))
+	if r.URL.Query().Get("format") == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		_ = result.WriteCSV(w)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = result.WriteJSON(w)
+}
@@ -0,0 +1,41 @@
+package learn_golang_gorm
+
+import (
+	"context"
+
+	"golang.org/x/sync/errgroup"
+	"gorm.io/gorm"
+)
+
+// QueryGroup runs independent queries concurrently, each on its own session
+// bound to the group context, the first failing query cancels the others.
+type QueryGroup struct {
+	db    *gorm.DB
+	ctx   context.Context
+	group *errgroup.Group
+}
+
+func NewQueryGroup(ctx context.Context, db *gorm.DB) *QueryGroup {
+	group, ctx := errgroup.WithContext(ctx)
+	return &QueryGroup{
+		db:    db,
+		ctx:   ctx,
+		group: group,
+	}
+}
+
+// SetLimit bounds how many queries of the group hold a pooled connection at
+// the same time.
+func (g *QueryGroup) SetLimit(n int) {
+	g.group.SetLimit(n)
+}
+
+func (g *QueryGroup) Go(query func(tx *gorm.DB) error) {
+	g.group.Go(func() error {
+		return query(g.db.Session(&gorm.Session{NewDB: true, Context: g.ctx}))
+	})
+}
+
+func (g *QueryGroup) Wait() error {
+	return g.group.Wait()
+}
@@ -0,0 +1,191 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	QuotaTodos     = "todos"
+	QuotaAddresses = "addresses"
+	QuotaDailyLogs = "daily_logs"
+)
+
+var ErrQuotaExceeded = errors.New("quota exceeded")
+
+type QuotaExceededError struct {
+	Quota string
+	Limit int64
+}
+
+func (e *QuotaExceededError) Error() string {
+	return fmt.Sprintf("quota %s of %d exceeded", e.Quota, e.Limit)
+}
+
+func (e *QuotaExceededError) Unwrap() error {
+	return ErrQuotaExceeded
+}
+
+// Quota limits the rows a user creates in Table, in total or when Daily per
+// UTC day. A negative Limit is unlimited.
+type Quota struct {
+	Name  string
+	Table string
+	Limit int64
+	Daily bool
+}
+
+var DefaultQuotas = []Quota{
+	{Name: QuotaTodos, Table: "todos", Limit: 1000},
+	{Name: QuotaAddresses, Table: "addresses", Limit: 10},
+	{Name: QuotaDailyLogs, Table: "user_logs", Limit: 10000, Daily: true},
+}
+
+// QuotaOverride replaces the default limit of a quota for one user.
+type QuotaOverride struct {
+	UserID string `gorm:"primary_key;column:user_id"`
+	Quota  string `gorm:"primary_key;column:quota"`
+	Limit  int64  `gorm:"column:limit"`
+}
+
+func (o *QuotaOverride) TableName() string {
+	return "quota_overrides"
+}
+
+// QuotaUsage serializes the checks of a user's quota, daily quotas also keep
+// their count in Used with one row per Period.
+type QuotaUsage struct {
+	UserID string `gorm:"primary_key;column:user_id"`
+	Quota  string `gorm:"primary_key;column:quota"`
+	Period string `gorm:"primary_key;column:period;type:varchar(10)"`
+	Used   int64  `gorm:"column:used"`
+}
+
+func (u *QuotaUsage) TableName() string {
+	return "quota_usages"
+}
+
+func SetQuotaOverride(ctx context.Context, db *gorm.DB, userID string, quota string, limit int64) error {
+	return db.WithContext(ctx).Clauses(clause.OnConflict{DoUpdates: clause.AssignmentColumns([]string{"limit"})}).
+		Create(&QuotaOverride{UserID: userID, Quota: quota, Limit: limit}).Error
+}
+
+func (q Quota) limit(tx *gorm.DB, userID string) (int64, error) {
+	var overrides []QuotaOverride
+	err := tx.Where("user_id = ? AND quota = ?", userID, q.Name).Limit(1).Find(&overrides).Error
+	if err != nil || len(overrides) == 0 {
+		return q.Limit, err
+	}
+	return overrides[0].Limit, nil
+}
+
+// check runs in the transaction of the create, the lock on the usage row
+// makes concurrent creates of the same user wait for each other.
+func (q Quota) check(tx *gorm.DB, model interface{}, userID string, adding int64) error {
+	limit, err := q.limit(tx, userID)
+	if err != nil || limit < 0 {
+		return err
+	}
+
+	usage := QuotaUsage{UserID: userID, Quota: q.Name}
+	if q.Daily {
+		usage.Period = Now(tx).UTC().Format("2006-01-02")
+		usage.Used = adding
+		err = tx.Clauses(clause.OnConflict{DoUpdates: clause.Assignments(map[string]interface{}{
+			"used": gorm.Expr("used + ?", adding),
+		})}).Create(&usage).Error
+		if err != nil {
+			return err
+		}
+		err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Take(&usage, "user_id = ? AND quota = ? AND period = ?", userID, q.Name, usage.Period).Error
+		if err == nil && usage.Used > limit {
+			err = &QuotaExceededError{Quota: q.Name, Limit: limit}
+		}
+		return err
+	}
+
+	err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&usage).Error
+	if err != nil {
+		return err
+	}
+	err = tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Take(&usage, "user_id = ? AND quota = ? AND period = ?", userID, q.Name, usage.Period).Error
+	if err != nil {
+		return err
+	}
+	var used int64
+	err = tx.Model(model).Where("user_id = ?", userID).Count(&used).Error
+	if err == nil && used+adding > limit {
+		err = &QuotaExceededError{Quota: q.Name, Limit: limit}
+	}
+	return err
+}
+
+// creating counts the rows of the create statement per user.
+func creatingPerUser(db *gorm.DB) map[string]int64 {
+	field := db.Statement.Schema.LookUpField("user_id")
+	if field == nil {
+		return nil
+	}
+
+	created := map[string]int64{}
+	add := func(value reflect.Value) {
+		userID, zero := field.ValueOf(db.Statement.Context, value)
+		if id, ok := userID.(string); ok && !zero {
+			created[id]++
+		}
+	}
+	value := reflect.Indirect(db.Statement.ReflectValue)
+	switch value.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < value.Len(); i++ {
+			add(reflect.Indirect(value.Index(i)))
+		}
+	case reflect.Struct:
+		add(value)
+	}
+	return created
+}
+
+// EnforceQuotas makes creates on db fail with a *QuotaExceededError when
+// they would take a user over one of quotas.
+func EnforceQuotas(db *gorm.DB, quotas ...Quota) error {
+	byTable := map[string][]Quota{}
+	for _, quota := range quotas {
+		byTable[quota.Table] = append(byTable[quota.Table], quota)
+	}
+
+	return db.Callback().Create().Before("gorm:create").Register("quota:create", func(db *gorm.DB) {
+		tableQuotas, ok := byTable[db.Statement.Table]
+		if db.Error != nil || !ok || db.Statement.Schema == nil {
+			return
+		}
+
+		tx := db.Session(&gorm.Session{NewDB: true})
+		model := reflect.New(db.Statement.Schema.ModelType).Interface()
+		created := creatingPerUser(db)
+		userIDs := make([]string, 0, len(created))
+		for userID := range created {
+			userIDs = append(userIDs, userID)
+		}
+		// same lock order for every create
+		sort.Strings(userIDs)
+
+		for _, userID := range userIDs {
+			for _, quota := range tableQuotas {
+				err := quota.check(tx, model, userID, created[userID])
+				if err != nil {
+					_ = db.AddError(err)
+					return
+				}
+			}
+		}
+	})
+}
@@ -0,0 +1,95 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"regexp"
+	"strings"
+
+	"gorm.io/gorm/logger"
+)
+
+const redacted = "[REDACTED]"
+
+// DefaultRedactedColumns are the columns whose values never reach the log.
+var DefaultRedactedColumns = []string{"password", "token_hash", "key_hash", "share_token"}
+
+var (
+	insertColumnsPattern = regexp.MustCompile("(?is)^\\s*insert\\s+(?:ignore\\s+)?into\\s+\\S+\\s*\\(([^)]*)\\)\\s*values")
+	// the column compared to or assigned the placeholder at the end
+	placeholderColumnPattern = regexp.MustCompile("(?i)`?(\\w+)`?\\s*(?:=|<>|!=|<=|>=|<|>|\\s+like|\\s+in\\s*\\()\\s*$")
+)
+
+// RedactingLogger logs the SQL text as it is but masks the bind values of
+// Columns, and all values of statements matching one of Statements. Unsafe
+// logs everything, for local development only.
+type RedactingLogger struct {
+	logger.Interface
+	Columns    map[string]bool
+	Statements []*regexp.Regexp
+	Unsafe     bool
+}
+
+func NewRedactingLogger(base logger.Interface, columns ...string) *RedactingLogger {
+	l := &RedactingLogger{Interface: base, Columns: map[string]bool{}}
+	for _, column := range columns {
+		l.Columns[strings.ToLower(column)] = true
+	}
+	return l
+}
+
+func (l *RedactingLogger) LogMode(level logger.LogLevel) logger.Interface {
+	copied := *l
+	copied.Interface = l.Interface.LogMode(level)
+	return &copied
+}
+
+// ParamsFilter is called by gorm with the statement and its bind values
+// before they are put together for the log.
+func (l *RedactingLogger) ParamsFilter(ctx context.Context, sql string, params ...interface{}) (string, []interface{}) {
+	if l.Unsafe || len(params) == 0 {
+		return sql, params
+	}
+	filtered := append([]interface{}(nil), params...)
+	for _, pattern := range l.Statements {
+		if pattern.MatchString(sql) {
+			for i := range filtered {
+				filtered[i] = redacted
+			}
+			return sql, filtered
+		}
+	}
+
+	var insertColumns []string
+	values := len(sql)
+	if match := insertColumnsPattern.FindStringSubmatchIndex(sql); match != nil {
+		for _, column := range strings.Split(sql[match[2]:match[3]], ",") {
+			insertColumns = append(insertColumns, strings.ToLower(strings.Trim(strings.TrimSpace(column), "`\"")))
+		}
+		values = match[1]
+	}
+
+	param, inserted := 0, 0
+	column, previous := "", 0
+	for i := 0; i < len(sql) && param < len(filtered); i++ {
+		if sql[i] != '?' {
+			continue
+		}
+		switch {
+		case i >= values && len(insertColumns) > 0 && !strings.Contains(strings.ToLower(sql[values:i]), "on duplicate"):
+			column = insertColumns[inserted%len(insertColumns)]
+			inserted++
+		case param > 0 && strings.TrimSpace(sql[previous:i]) == ",":
+			// the next value of the same IN list
+		default:
+			column = ""
+			if match := placeholderColumnPattern.FindStringSubmatch(sql[max(0, i-128):i]); match != nil {
+				column = strings.ToLower(match[1])
+			}
+		}
+		if l.Columns[column] {
+			filtered[param] = redacted
+		}
+		param, previous = param+1, i+1
+	}
+	return sql, filtered
+}
@@ -0,0 +1,128 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Reminder struct {
+	ID        int64      `gorm:"primary_key;column:id;autoIncrement"`
+	TodoID    uint       `gorm:"column:todo_id"`
+	RemindAt  time.Time  `gorm:"column:remind_at;index"`
+	SentAt    *time.Time `gorm:"column:sent_at"`
+	FailedAt  *time.Time `gorm:"column:failed_at"`
+	Attempts  int        `gorm:"column:attempts"`
+	LastError string     `gorm:"column:last_error"`
+	CreatedAt time.Time  `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time  `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	Todo      Todo       `gorm:"foreignKey:todo_id;references:id"`
+}
+
+func (r *Reminder) TableName() string {
+	return "reminders"
+}
+
+type Notifier interface {
+	Notify(ctx context.Context, reminder Reminder) error
+}
+
+type NotifierFunc func(ctx context.Context, reminder Reminder) error
+
+func (f NotifierFunc) Notify(ctx context.Context, reminder Reminder) error {
+	return f(ctx, reminder)
+}
+
+type ReminderPoller struct {
+	DB          *gorm.DB
+	Notifier    Notifier
+	BatchSize   int
+	MaxAttempts int
+	RetryDelay  time.Duration
+	OnError     func(err error)
+}
+
+func NewReminderPoller(db *gorm.DB, notifier Notifier) *ReminderPoller {
+	return &ReminderPoller{
+		DB:          db,
+		Notifier:    notifier,
+		BatchSize:   100,
+		MaxAttempts: 5,
+		RetryDelay:  time.Minute,
+	}
+}
+
+// PollOnce claims the due reminders with SKIP LOCKED so several pollers can
+// run side by side, failed deliveries are rescheduled with a linear backoff
+// until MaxAttempts is reached.
+func (p *ReminderPoller) PollOnce(ctx context.Context) (int, error) {
+	delivered := 0
+	err := p.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		now := Now(tx)
+
+		var reminders []Reminder
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE", Options: "SKIP LOCKED"}).
+			Where("sent_at IS NULL AND failed_at IS NULL AND remind_at <= ?", now).
+			Order("remind_at asc").
+			Limit(p.BatchSize).
+			Find(&reminders).Error
+		if err != nil {
+			return err
+		}
+
+		for _, reminder := range reminders {
+			err = tx.Take(&reminder.Todo, "id = ?", reminder.TodoID).Error
+			if err == nil {
+				err = p.Notifier.Notify(ctx, reminder)
+			}
+
+			if err == nil {
+				delivered++
+				err = tx.Model(&reminder).Update("sent_at", now).Error
+				if err != nil {
+					return err
+				}
+				continue
+			}
+
+			attempts := reminder.Attempts + 1
+			updates := map[string]interface{}{
+				"attempts":   attempts,
+				"last_error": err.Error(),
+				"remind_at":  now.Add(time.Duration(attempts) * p.RetryDelay),
+			}
+			if attempts >= p.MaxAttempts {
+				updates["failed_at"] = now
+			}
+
+			err = tx.Model(&reminder).Updates(updates).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	return delivered, err
+}
+
+// Run polls every interval until ctx is done, errors of a single poll are
+// reported to OnError and do not stop the poller.
+func (p *ReminderPoller) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, err := p.PollOnce(ctx)
+		if err != nil && ctx.Err() == nil && p.OnError != nil {
+			p.OnError(err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
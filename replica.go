@@ -0,0 +1,203 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrReplicaNotReplicating = errors.New("replica is not replicating")
+
+type ReplicationHeartbeat struct {
+	ID     int       `gorm:"primary_key;column:id"`
+	BeatAt time.Time `gorm:"column:beat_at;type:datetime(6)"`
+}
+
+func (h *ReplicationHeartbeat) TableName() string {
+	return "replication_heartbeats"
+}
+
+type LagProbe func(ctx context.Context, replica *gorm.DB) (time.Duration, error)
+
+// HeartbeatLagProbe measures the lag as the age of the heartbeat row that the
+// primary keeps updating through ReplicaRouter.Beat, on the clock of replica.
+func HeartbeatLagProbe(ctx context.Context, replica *gorm.DB) (time.Duration, error) {
+	var heartbeat ReplicationHeartbeat
+	err := replica.WithContext(ctx).Take(&heartbeat, "id = ?", 1).Error
+	if err != nil {
+		return 0, err
+	}
+	return Now(replica).Sub(heartbeat.BeatAt), nil
+}
+
+// ReplicaStatusLagProbe reads Seconds_Behind_Source from SHOW REPLICA STATUS,
+// falling back to the pre 8.0.22 column name.
+func ReplicaStatusLagProbe(ctx context.Context, replica *gorm.DB) (time.Duration, error) {
+	rows, err := replica.WithContext(ctx).Raw("show replica status").Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return 0, err
+	}
+	if !rows.Next() {
+		return 0, ErrReplicaNotReplicating
+	}
+
+	values := make([]sql.RawBytes, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	err = rows.Scan(dest...)
+	if err != nil {
+		return 0, err
+	}
+
+	for i, column := range columns {
+		if column == "Seconds_Behind_Source" || column == "Seconds_Behind_Master" {
+			if values[i] == nil {
+				return 0, ErrReplicaNotReplicating
+			}
+			seconds, err := strconv.ParseInt(string(values[i]), 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return time.Duration(seconds) * time.Second, nil
+		}
+	}
+	return 0, ErrReplicaNotReplicating
+}
+
+type Replica struct {
+	Name string
+	DB   *gorm.DB
+
+	mutex   sync.RWMutex
+	lag     time.Duration
+	healthy bool
+}
+
+func (r *Replica) Lag() (time.Duration, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.lag, r.healthy
+}
+
+type maxStalenessKey struct{}
+
+// MaxStaleness allows reads made with ctx to be served by a replica lagging
+// at most d behind the primary.
+func MaxStaleness(ctx context.Context, d time.Duration) context.Context {
+	return context.WithValue(ctx, maxStalenessKey{}, d)
+}
+
+type ReplicaRouterStats struct {
+	ReplicaReads     int64
+	PrimaryFallbacks int64
+}
+
+type ReplicaRouter struct {
+	Primary   *gorm.DB
+	Replicas  []*Replica
+	Probe     LagProbe
+	Heartbeat bool
+
+	next             uint64
+	replicaReads     int64
+	primaryFallbacks int64
+}
+
+// NewReplicaRouter probes the lag with the heartbeat table by default, set
+// Probe to ReplicaStatusLagProbe and Heartbeat to false to use the replica
+// status instead.
+func NewReplicaRouter(primary *gorm.DB, replicas ...*Replica) *ReplicaRouter {
+	return &ReplicaRouter{
+		Primary:   primary,
+		Replicas:  replicas,
+		Probe:     HeartbeatLagProbe,
+		Heartbeat: true,
+	}
+}
+
+// Beat writes the heartbeat on the primary, it must run periodically when
+// HeartbeatLagProbe is used.
+func (r *ReplicaRouter) Beat(ctx context.Context) error {
+	return r.Primary.WithContext(ctx).Clauses(clause.OnConflict{UpdateAll: true}).
+		Create(&ReplicationHeartbeat{ID: 1, BeatAt: Now(r.Primary)}).Error
+}
+
+// ProbeLag refreshes the lag of every replica, a replica whose probe fails
+// is considered unhealthy until the next successful probe.
+func (r *ReplicaRouter) ProbeLag(ctx context.Context) {
+	for _, replica := range r.Replicas {
+		lag, err := r.Probe(ctx, replica.DB)
+
+		replica.mutex.Lock()
+		replica.lag = lag
+		replica.healthy = err == nil
+		replica.mutex.Unlock()
+	}
+}
+
+func (r *ReplicaRouter) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if r.Heartbeat {
+			_ = r.Beat(ctx)
+		}
+		r.ProbeLag(ctx)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Reader returns the session to read with under ctx. Without MaxStaleness
+// every read goes to the primary, otherwise the replicas within the allowed
+// staleness are used round robin and the primary when none qualifies.
+func (r *ReplicaRouter) Reader(ctx context.Context) *gorm.DB {
+	staleness, ok := ctx.Value(maxStalenessKey{}).(time.Duration)
+	if !ok || len(r.Replicas) == 0 {
+		return r.Primary.WithContext(ctx)
+	}
+
+	start := atomic.AddUint64(&r.next, 1)
+	for i := range r.Replicas {
+		replica := r.Replicas[(start+uint64(i))%uint64(len(r.Replicas))]
+		lag, healthy := replica.Lag()
+		if healthy && lag <= staleness {
+			atomic.AddInt64(&r.replicaReads, 1)
+			return replica.DB.WithContext(ctx)
+		}
+	}
+
+	atomic.AddInt64(&r.primaryFallbacks, 1)
+	return r.Primary.WithContext(ctx)
+}
+
+func (r *ReplicaRouter) Writer(ctx context.Context) *gorm.DB {
+	return r.Primary.WithContext(ctx)
+}
+
+func (r *ReplicaRouter) Stats() ReplicaRouterStats {
+	return ReplicaRouterStats{
+		ReplicaReads:     atomic.LoadInt64(&r.replicaReads),
+		PrimaryFallbacks: atomic.LoadInt64(&r.primaryFallbacks),
+	}
+}
@@ -0,0 +1,361 @@
+package learn_golang_gorm
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ReportFormatCSV  = "csv"
+	ReportFormatJSON = "json"
+)
+
+const (
+	ReportRunRunning   = "running"
+	ReportRunSucceeded = "succeeded"
+	ReportRunFailed    = "failed"
+)
+
+var ErrUnknownReportFormat = errors.New("unknown report format")
+
+// Report is a read only query run on Schedule, see ParseSchedule.
+type Report struct {
+	Name     string
+	Schedule string
+	Format   string
+	Query    func(ctx context.Context, db *gorm.DB) (QueryResult, error)
+}
+
+// ReportSQL builds the query of a report from a plain SQL statement.
+func ReportSQL(query string, args ...interface{}) func(ctx context.Context, db *gorm.DB) (QueryResult, error) {
+	return func(ctx context.Context, db *gorm.DB) (QueryResult, error) {
+		var result QueryResult
+		err := RunInTransaction(ctx, db, func(tx *gorm.DB) error {
+			rows, err := tx.Raw(query, args...).Rows()
+			if err != nil {
+				return err
+			}
+			defer rows.Close()
+
+			result, err = scanQueryResult(rows, 0)
+			return err
+		}, ReadOnly())
+		return result, err
+	}
+}
+
+var (
+	BalanceSummaryReport = Report{
+		Name:     "balance_summary",
+		Schedule: "@daily",
+		Format:   ReportFormatCSV,
+		Query: ReportSQL("select count(*) as wallets, coalesce(sum(balance), 0) as total_balance, " +
+			"coalesce(avg(balance), 0) as average_balance, coalesce(max(balance), 0) as max_balance from wallets"),
+	}
+	SignupsPerDayReport = Report{
+		Name:     "signups_per_day",
+		Schedule: "@daily",
+		Format:   ReportFormatCSV,
+		Query: ReportSQL("select date(created_at) as day, count(*) as signups from users " +
+			"where created_at >= current_date - interval 30 day group by date(created_at) order by day"),
+	}
+	TopProductsReport = Report{
+		Name:     "top_products",
+		Schedule: "@weekly",
+		Format:   ReportFormatCSV,
+		Query: ReportSQL("select p.id, p.name, count(l.user_id) as likes, p.review_count, p.average_rating " +
+			"from products p left join user_like_product l on l.product_id = p.id " +
+			"group by p.id, p.name, p.review_count, p.average_rating order by likes desc, p.average_rating desc limit 20"),
+	}
+)
+
+// ReportRun is the history of a report, a run is inserted before the report
+// is queried so a scheduled time is only ever run once across schedulers.
+type ReportRun struct {
+	ID          int64      `gorm:"primary_key;column:id;autoIncrement"`
+	Report      string     `gorm:"column:report;uniqueIndex:idx_report_runs_report_scheduled"`
+	ScheduledAt time.Time  `gorm:"column:scheduled_at;uniqueIndex:idx_report_runs_report_scheduled"`
+	Format      string     `gorm:"column:format"`
+	Status      string     `gorm:"column:status"`
+	Rows        int        `gorm:"column:row_count"`
+	Bytes       int        `gorm:"column:bytes"`
+	Error       string     `gorm:"column:error"`
+	StartedAt   time.Time  `gorm:"column:started_at"`
+	FinishedAt  *time.Time `gorm:"column:finished_at"`
+}
+
+func (r *ReportRun) TableName() string {
+	return "report_runs"
+}
+
+func (r *ReportRun) FileName() string {
+	return fmt.Sprintf("%s-%s.%s", r.Report, r.ScheduledAt.Format("20060102-1504"), r.Format)
+}
+
+// ReportDelivery hands the rendered output of a run to its readers.
+type ReportDelivery interface {
+	Deliver(ctx context.Context, run ReportRun, data []byte) error
+}
+
+type ReportDeliveryFunc func(ctx context.Context, run ReportRun, data []byte) error
+
+func (f ReportDeliveryFunc) Deliver(ctx context.Context, run ReportRun, data []byte) error {
+	return f(ctx, run, data)
+}
+
+type FileDelivery struct {
+	Dir string
+}
+
+func (d FileDelivery) Deliver(ctx context.Context, run ReportRun, data []byte) error {
+	dir := filepath.Join(d.Dir, run.Report)
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(dir, run.FileName()), data, 0o644)
+}
+
+// WebhookDelivery posts the output, the run is described by the
+// X-Report-Name and X-Report-Scheduled-At headers.
+type WebhookDelivery struct {
+	URL    string
+	Client *http.Client
+}
+
+func (d WebhookDelivery) Deliver(ctx context.Context, run ReportRun, data []byte) error {
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, d.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	request.Header.Set("Content-Type", reportContentType(run.Format))
+	request.Header.Set("X-Report-Name", run.Report)
+	request.Header.Set("X-Report-Scheduled-At", run.ScheduledAt.Format(time.RFC3339))
+
+	client := d.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	response, err := client.Do(request)
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= 300 {
+		return fmt.Errorf("webhook responded %s", response.Status)
+	}
+	return nil
+}
+
+// EmailDelivery mails the output as an attachment through an SMTP server.
+type EmailDelivery struct {
+	Addr string
+	Auth smtp.Auth
+	From string
+	To   []string
+}
+
+func (d EmailDelivery) Deliver(ctx context.Context, run ReportRun, data []byte) error {
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+
+	fmt.Fprintf(&body, "From: %s\r\nTo: %s\r\nSubject: Report %s %s\r\n", d.From, strings.Join(d.To, ", "),
+		run.Report, run.ScheduledAt.Format("2006-01-02 15:04"))
+	fmt.Fprintf(&body, "MIME-Version: 1.0\r\nContent-Type: multipart/mixed; boundary=%s\r\n\r\n", writer.Boundary())
+
+	text, err := writer.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=utf-8"}})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(text, "Report %s with %d rows is attached.\r\n", run.Report, run.Rows)
+
+	attachment, err := writer.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {reportContentType(run.Format)},
+		"Content-Transfer-Encoding": {"base64"},
+		"Content-Disposition":       {fmt.Sprintf("attachment; filename=%q", run.FileName())},
+	})
+	if err != nil {
+		return err
+	}
+	encoded := base64.StdEncoding.EncodeToString(data)
+	for len(encoded) > 76 {
+		fmt.Fprintf(attachment, "%s\r\n", encoded[:76])
+		encoded = encoded[76:]
+	}
+	fmt.Fprintf(attachment, "%s\r\n", encoded)
+
+	err = writer.Close()
+	if err != nil {
+		return err
+	}
+	return smtp.SendMail(d.Addr, d.Auth, d.From, d.To, body.Bytes())
+}
+
+func reportContentType(format string) string {
+	if format == ReportFormatJSON {
+		return "application/json"
+	}
+	return "text/csv"
+}
+
+func renderReport(result QueryResult, format string) ([]byte, error) {
+	var buffer bytes.Buffer
+	var err error
+	switch format {
+	case ReportFormatCSV:
+		err = result.WriteCSV(&buffer)
+	case ReportFormatJSON:
+		err = result.WriteJSON(&buffer)
+	default:
+		err = ErrUnknownReportFormat
+	}
+	return buffer.Bytes(), err
+}
+
+type ReportScheduler struct {
+	DB       *gorm.DB
+	Delivery ReportDelivery
+	Reports  []Report
+	OnError  func(report string, err error)
+	started  time.Time
+}
+
+func NewReportScheduler(db *gorm.DB, delivery ReportDelivery, reports ...Report) *ReportScheduler {
+	return &ReportScheduler{
+		DB:       db,
+		Delivery: delivery,
+		Reports:  reports,
+		started:  Now(db),
+	}
+}
+
+// due returns the latest scheduled time of report up to now that has no run
+// yet, missed runs in between are skipped. Reports never run before are
+// first due after the scheduler started.
+func (s *ReportScheduler) due(ctx context.Context, report Report, schedule Schedule, now time.Time) (time.Time, bool, error) {
+	var last ReportRun
+	err := s.DB.WithContext(ctx).Where("report = ?", report.Name).Order("scheduled_at desc").Limit(1).Find(&last).Error
+	if err != nil {
+		return time.Time{}, false, err
+	}
+
+	from := s.started
+	if last.ID != 0 {
+		from = last.ScheduledAt
+	}
+
+	var due time.Time
+	for next := schedule.Next(from); !next.IsZero() && !next.After(now); next = schedule.Next(next) {
+		due = next
+	}
+	return due, !due.IsZero(), nil
+}
+
+// run claims the run of report at scheduledAt and runs it, it tells whether
+// this scheduler claimed the run.
+func (s *ReportScheduler) run(ctx context.Context, report Report, scheduledAt time.Time) (bool, error) {
+	db := s.DB.WithContext(ctx)
+	run := ReportRun{
+		Report:      report.Name,
+		ScheduledAt: scheduledAt,
+		Format:      report.Format,
+		Status:      ReportRunRunning,
+		StartedAt:   Now(s.DB),
+	}
+	result := db.Clauses(clause.Insert{Modifier: "IGNORE"}).Create(&run)
+	if result.Error != nil || result.RowsAffected == 0 {
+		// another scheduler claimed this run
+		return false, result.Error
+	}
+
+	data, err := report.Query(WithOperationClass(ctx, OperationReport), s.DB)
+	var output []byte
+	if err == nil {
+		run.Rows = len(data.Rows)
+		output, err = renderReport(data, report.Format)
+	}
+	if err == nil {
+		run.Bytes = len(output)
+		err = s.Delivery.Deliver(ctx, run, output)
+	}
+
+	finished := Now(s.DB)
+	updates := map[string]interface{}{
+		"status":      ReportRunSucceeded,
+		"row_count":   run.Rows,
+		"bytes":       run.Bytes,
+		"finished_at": finished,
+	}
+	if err != nil {
+		updates["status"] = ReportRunFailed
+		updates["error"] = err.Error()
+	}
+	updateErr := db.Model(&run).Updates(updates).Error
+	if err != nil {
+		return true, err
+	}
+	return true, updateErr
+}
+
+// RunDue runs every report whose scheduled time has come and returns the
+// number of runs it started, runs claimed by another scheduler are not
+// counted.
+func (s *ReportScheduler) RunDue(ctx context.Context, now time.Time) (int, error) {
+	started := 0
+	var errs []error
+	for _, report := range s.Reports {
+		schedule, err := ParseSchedule(report.Schedule)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("report %s: %w", report.Name, err))
+			continue
+		}
+
+		scheduledAt, ok, err := s.due(ctx, report, schedule, now)
+		if err == nil && ok {
+			var claimed bool
+			claimed, err = s.run(ctx, report, scheduledAt)
+			if claimed {
+				started++
+			}
+		}
+		if err != nil {
+			if s.OnError != nil {
+				s.OnError(report.Name, err)
+			}
+			errs = append(errs, fmt.Errorf("report %s: %w", report.Name, err))
+		}
+	}
+	return started, errors.Join(errs...)
+}
+
+// Run checks for due reports every interval until ctx is done, failed runs
+// are kept in report_runs and reported to OnError.
+func (s *ReportScheduler) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		_, _ = s.RunDue(ctx, Now(s.DB))
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+	"gorm.io/gorm/schema"
+)
+
+var ErrVersionConflict = errors.New("row was modified concurrently")
+
+// Repository is the data access of model T with primary key K. Get, Update
+// and Delete of a missing or soft deleted row fail with
+// gorm.ErrRecordNotFound, List returns a page of the rows matching the
+// filter in primary key order, Truncated when more rows follow it. A model with a version column is locked
+// optimistically: Update increments the version of row, and fails with
+// ErrVersionConflict when the stored row has another version than row.
+type Repository[T any, K comparable] interface {
+	Create(ctx context.Context, row *T) error
+	Get(ctx context.Context, id K) (T, error)
+	List(ctx context.Context, page int, size int, filter Filter) (Limited[T], error)
+	Update(ctx context.Context, row *T) error
+	Delete(ctx context.Context, id K) error
+}
+
+// GormRepository is the Repository of T on a database, filtered with
+// Fields. Behind APIKeyService.Middleware reads need the API key to hold
+// ReadScope and writes WriteScope, empty scopes are not checked. The pages
+// of List hold at most MaxRows rows when it is positive, besides
+// MaxPageSize.
+type GormRepository[T any, K comparable] struct {
+	DB         *gorm.DB
+	Fields     FilterFields
+	ReadScope  string
+	WriteScope string
+	MaxRows    int
+}
+
+func (r *GormRepository[T, K]) require(ctx context.Context, scope string) error {
+	if scope == "" {
+		return nil
+	}
+	return RequireScope(ctx, scope)
+}
+
+func NewGormRepository[T any, K comparable](db *gorm.DB, fields FilterFields) *GormRepository[T, K] {
+	return &GormRepository[T, K]{DB: db, Fields: fields}
+}
+
+func (r *GormRepository[T, K]) Create(ctx context.Context, row *T) error {
+	err := r.require(ctx, r.WriteScope)
+	if err != nil {
+		return err
+	}
+	return SessionDB(ctx, r.DB).Create(row).Error
+}
+
+func (r *GormRepository[T, K]) Get(ctx context.Context, id K) (T, error) {
+	var row T
+	err := r.require(ctx, r.ReadScope)
+	if err != nil {
+		return row, err
+	}
+	err = SessionDB(ctx, r.DB).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Take(&row).Error
+	return row, err
+}
+
+func (r *GormRepository[T, K]) List(ctx context.Context, page int, size int, filter Filter) (Limited[T], error) {
+	err := r.require(ctx, r.ReadScope)
+	if err != nil {
+		return Limited[T]{}, err
+	}
+	scope, err := filter.Scope(r.Fields)
+	if err != nil {
+		return Limited[T]{}, err
+	}
+
+	if r.MaxRows > 0 {
+		if size < 1 {
+			size = DefaultPageSize
+		}
+		size = min(size, r.MaxRows)
+	}
+	offset, limit := PageBounds(page, size)
+	return FindLimited[T](SessionDB(ctx, r.DB).Scopes(scope).Offset(offset).
+		Order(clause.OrderByColumn{Column: clause.PrimaryColumn}), limit)
+}
+
+// Update saves every column of row but the primary key and created_at.
+func (r *GormRepository[T, K]) Update(ctx context.Context, row *T) error {
+	err := r.require(ctx, r.WriteScope)
+	if err != nil {
+		return err
+	}
+	db := SessionDB(ctx, r.DB)
+	stmt := &gorm.Statement{DB: db}
+	err = stmt.Parse(row)
+	if err != nil {
+		return err
+	}
+
+	query := db.Model(row)
+	value := reflect.ValueOf(row).Elem()
+	version := versionField(stmt.Schema)
+	if version != nil {
+		current, _ := version.ValueOf(ctx, value)
+		query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: version.DBName}, Value: current})
+		addVersion(ctx, version, value, 1)
+	}
+	result := query.Select("*").Omit("created_at").Updates(row)
+	if result.Error != nil || result.RowsAffected > 0 {
+		if result.Error != nil && version != nil {
+			addVersion(ctx, version, value, -1)
+		}
+		return result.Error
+	}
+	if version != nil {
+		addVersion(ctx, version, value, -1)
+	}
+
+	// MySQL counts changed rows only, an update writing the same values is
+	// not a missing row, with a version it is a conflict
+	var count int64
+	err = db.Model(new(T)).Where(clause.Eq{Column: clause.PrimaryColumn, Value: primaryKey(db, row)}).Count(&count).Error
+	switch {
+	case err == nil && count == 0:
+		err = gorm.ErrRecordNotFound
+	case err == nil && version != nil:
+		err = ErrVersionConflict
+	}
+	return err
+}
+
+func (r *GormRepository[T, K]) Delete(ctx context.Context, id K) error {
+	err := r.require(ctx, r.WriteScope)
+	if err != nil {
+		return err
+	}
+	result := SessionDB(ctx, r.DB).Where(clause.Eq{Column: clause.PrimaryColumn, Value: id}).Delete(new(T))
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// primaryKey returns the primary key value of row, nil when the model has
+// none.
+func primaryKey(db *gorm.DB, row interface{}) interface{} {
+	stmt := &gorm.Statement{DB: db}
+	if stmt.Parse(row) != nil || stmt.Schema.PrioritizedPrimaryField == nil {
+		return nil
+	}
+	value, _ := stmt.Schema.PrioritizedPrimaryField.ValueOf(db.Statement.Context, reflect.ValueOf(row).Elem())
+	return value
+}
+
+// versionField is the integer version column of the optimistically locked
+// models, nil for the others.
+func versionField(s *schema.Schema) *schema.Field {
+	field := s.LookUpField("version")
+	if field == nil || (field.GORMDataType != schema.Int && field.GORMDataType != schema.Uint) {
+		return nil
+	}
+	return field
+}
+
+func addVersion(ctx context.Context, field *schema.Field, row reflect.Value, delta int64) {
+	value := field.ReflectValueOf(ctx, row)
+	if field.GORMDataType == schema.Uint {
+		value.SetUint(uint64(int64(value.Uint()) + delta))
+		return
+	}
+	value.SetInt(value.Int() + delta)
+}
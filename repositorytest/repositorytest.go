@@ -0,0 +1,280 @@
+// Package repositorytest checks that a repository keeps the contract of
+// learn_golang_gorm.Repository, so the GORM one, the in-memory fake and
+// the decorators wrapping them all behave alike:
+//
+//	repositorytest.Run(t, func(t *testing.T) learn_golang_gorm.Repository[Todo, uint] {
+//		return learn_golang_gorm.NewGormRepository[Todo, uint](db, learn_golang_gorm.TodoFilterFields)
+//	}, repositorytest.Fixture[Todo, uint]{
+//		New:     func(text string) Todo { return Todo{Title: text} },
+//		Field:   "title",
+//		Missing: 1 << 31,
+//	})
+package repositorytest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	learn_golang_gorm "learn-golang-gorm"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+const DefaultPrefix = "repositorytest"
+
+// Fixture describes the rows of model T the suite creates.
+type Fixture[T any, K comparable] struct {
+	// New returns a row whose text column is text, without primary key
+	// when the repository assigns it.
+	New func(text string) T
+	// Field is the filter field of the text column.
+	Field string
+	// Prefix starts the text of every row of the suite, it tells them apart
+	// from other rows of the repository. Empty is DefaultPrefix.
+	Prefix string
+	// Missing is a primary key no row has.
+	Missing K
+}
+
+// Run runs the suite, each test on a repository from newRepository that
+// has no rows of the suite yet. Optimistic locking is only tested for
+// models with a version column.
+func Run[T any, K comparable](t *testing.T, newRepository func(t *testing.T) learn_golang_gorm.Repository[T, K], fixture Fixture[T, K]) {
+	parsed, err := schema.Parse(new(T), &sync.Map{}, schema.NamingStrategy{})
+	require.NoError(t, err)
+	require.NotNil(t, parsed.PrioritizedPrimaryField, "%s has no primary key", parsed.Name)
+	if fixture.Prefix == "" {
+		fixture.Prefix = DefaultPrefix
+	}
+
+	s := &suite[T, K]{fixture: fixture, schema: parsed, version: parsed.LookUpField("version")}
+	tests := []struct {
+		name string
+		run  func(t *testing.T, repository learn_golang_gorm.Repository[T, K])
+	}{
+		{"CRUD", s.testCRUD},
+		{"Pagination", s.testPagination},
+		{"Filters", s.testFilters},
+		{"Delete", s.testDelete},
+		{"OptimisticLocking", s.testOptimisticLocking},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.run(t, newRepository(t))
+		})
+	}
+}
+
+type suite[T any, K comparable] struct {
+	fixture Fixture[T, K]
+	schema  *schema.Schema
+	version *schema.Field
+}
+
+func (s *suite[T, K]) text(i int) string {
+	return fmt.Sprintf("%s %03d", s.fixture.Prefix, i)
+}
+
+// scope restricts filter to the rows of the suite.
+func (s *suite[T, K]) scope(filter learn_golang_gorm.Filter) learn_golang_gorm.Filter {
+	return learn_golang_gorm.Filter{And: []learn_golang_gorm.Filter{
+		{Field: s.fixture.Field, Op: "like", Value: s.fixture.Prefix + " %"},
+		filter,
+	}}
+}
+
+func (s *suite[T, K]) condition(op string, value interface{}) learn_golang_gorm.Filter {
+	return learn_golang_gorm.Filter{Field: s.fixture.Field, Op: op, Value: value}
+}
+
+func (s *suite[T, K]) key(row T) K {
+	value, _ := s.schema.PrioritizedPrimaryField.ValueOf(context.Background(), reflect.ValueOf(&row).Elem())
+	key, _ := value.(K)
+	return key
+}
+
+func (s *suite[T, K]) keys(rows []T) []K {
+	keys := []K{}
+	for _, row := range rows {
+		keys = append(keys, s.key(row))
+	}
+	return keys
+}
+
+func (s *suite[T, K]) versionOf(row T) int64 {
+	value := s.version.ReflectValueOf(context.Background(), reflect.ValueOf(&row).Elem())
+	if value.CanInt() {
+		return value.Int()
+	}
+	return int64(value.Uint())
+}
+
+// create creates the rows 1 to n of the suite.
+func (s *suite[T, K]) create(t *testing.T, repository learn_golang_gorm.Repository[T, K], n int) []T {
+	rows := make([]T, 0, n)
+	for i := 1; i <= n; i++ {
+		row := s.fixture.New(s.text(i))
+		require.NoError(t, repository.Create(context.Background(), &row))
+		rows = append(rows, row)
+	}
+	return rows
+}
+
+// replacement returns a row with text in place of row, with its primary
+// key and version.
+func (s *suite[T, K]) replacement(t *testing.T, row T, text string) T {
+	ctx := context.Background()
+	from := reflect.ValueOf(&row).Elem()
+	replacement := s.fixture.New(text)
+	to := reflect.ValueOf(&replacement).Elem()
+	for _, field := range []*schema.Field{s.schema.PrioritizedPrimaryField, s.version} {
+		if field == nil {
+			continue
+		}
+		value, _ := field.ValueOf(ctx, from)
+		require.NoError(t, field.Set(ctx, to, value))
+	}
+	return replacement
+}
+
+func (s *suite[T, K]) list(t *testing.T, repository learn_golang_gorm.Repository[T, K], page int, size int, filter learn_golang_gorm.Filter) []K {
+	rows, err := repository.List(context.Background(), page, size, s.scope(filter))
+	require.NoError(t, err)
+	return s.keys(rows.Rows)
+}
+
+func (s *suite[T, K]) testCRUD(t *testing.T, repository learn_golang_gorm.Repository[T, K]) {
+	ctx := context.Background()
+	rows := s.create(t, repository, 3)
+	keys := s.keys(rows)
+	var zero K
+	for i, key := range keys {
+		assert.NotEqual(t, zero, key, "key of row %d", i+1)
+	}
+	assert.NotEqual(t, keys[0], keys[1])
+
+	found, err := repository.Get(ctx, keys[0])
+	assert.NoError(t, err)
+	assert.Equal(t, keys[0], s.key(found))
+	_, err = repository.Get(ctx, s.fixture.Missing)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "get a missing row: %v", err)
+
+	duplicate := rows[0]
+	err = repository.Create(ctx, &duplicate)
+	assert.Equal(t, http.StatusConflict, learn_golang_gorm.HTTPStatus(err), "create a duplicate key: %v", err)
+
+	updated := s.replacement(t, rows[1], s.text(9))
+	assert.NoError(t, repository.Update(ctx, &updated))
+	assert.Equal(t, []K{keys[1]}, s.list(t, repository, 1, 10, s.condition("eq", s.text(9))))
+	assert.NoError(t, repository.Update(ctx, &updated), "update without changes")
+
+	missing := s.fixture.New(s.text(8))
+	value := reflect.ValueOf(&missing).Elem()
+	require.NoError(t, s.schema.PrioritizedPrimaryField.Set(ctx, value, s.fixture.Missing))
+	err = repository.Update(ctx, &missing)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "update a missing row: %v", err)
+	assert.Empty(t, s.list(t, repository, 1, 10, s.condition("eq", s.text(8))))
+}
+
+func (s *suite[T, K]) testPagination(t *testing.T, repository learn_golang_gorm.Repository[T, K]) {
+	keys := s.keys(s.create(t, repository, 5))
+	all := learn_golang_gorm.Filter{}
+	assert.Equal(t, keys, s.list(t, repository, 1, 10, all), "rows in primary key order")
+	assert.Equal(t, keys[:2], s.list(t, repository, 1, 2, all))
+	assert.Equal(t, keys[2:4], s.list(t, repository, 2, 2, all))
+	assert.Equal(t, keys[4:], s.list(t, repository, 3, 2, all))
+	assert.Empty(t, s.list(t, repository, 4, 2, all))
+	assert.Equal(t, keys[:2], s.list(t, repository, 0, 2, all), "page 0 is the first page")
+	assert.Equal(t, keys, s.list(t, repository, 1, 0, all), "size 0 is the default size")
+
+	rows, err := repository.List(context.Background(), 2, 2, s.scope(all))
+	require.NoError(t, err)
+	assert.True(t, rows.Truncated, "more rows follow page 2")
+	rows, err = repository.List(context.Background(), 3, 2, s.scope(all))
+	require.NoError(t, err)
+	assert.False(t, rows.Truncated, "no rows follow the last page")
+}
+
+func (s *suite[T, K]) testFilters(t *testing.T, repository learn_golang_gorm.Repository[T, K]) {
+	keys := s.keys(s.create(t, repository, 5))
+	tests := []struct {
+		name     string
+		filter   learn_golang_gorm.Filter
+		expected []K
+	}{
+		{"eq ignores case", s.condition("eq", strings.ToUpper(s.text(2))), keys[1:2]},
+		{"ne", s.condition("ne", s.text(2)), []K{keys[0], keys[2], keys[3], keys[4]}},
+		{"lt", s.condition("lt", s.text(3)), keys[:2]},
+		{"gte", s.condition("gte", s.text(4)), keys[3:]},
+		{"in", s.condition("in", []interface{}{s.text(1), s.text(4), s.text(999)}), []K{keys[0], keys[3]}},
+		{"like", s.condition("like", "%3"), keys[2:3]},
+		{"or", learn_golang_gorm.Filter{Or: []learn_golang_gorm.Filter{
+			s.condition("eq", s.text(1)), s.condition("eq", s.text(5)),
+		}}, []K{keys[0], keys[4]}},
+		{"and", learn_golang_gorm.Filter{And: []learn_golang_gorm.Filter{
+			s.condition("gt", s.text(1)), s.condition("lt", s.text(4)),
+		}}, keys[1:3]},
+		{"no match", s.condition("eq", s.text(999)), []K{}},
+	}
+	for _, test := range tests {
+		assert.Equal(t, test.expected, s.list(t, repository, 1, 10, test.filter), test.name)
+	}
+
+	_, err := repository.List(context.Background(), 1, 10, learn_golang_gorm.Filter{Field: "no such field", Op: "eq", Value: "x"})
+	assert.True(t, errors.Is(err, learn_golang_gorm.ErrInvalidFilter), "filter on an unknown field: %v", err)
+	_, err = repository.List(context.Background(), 1, 10, s.condition("no such op", "x"))
+	assert.True(t, errors.Is(err, learn_golang_gorm.ErrInvalidFilter), "filter with an unknown operator: %v", err)
+}
+
+func (s *suite[T, K]) testDelete(t *testing.T, repository learn_golang_gorm.Repository[T, K]) {
+	ctx := context.Background()
+	rows := s.create(t, repository, 3)
+	keys := s.keys(rows)
+
+	assert.NoError(t, repository.Delete(ctx, keys[1]))
+	assert.Equal(t, []K{keys[0], keys[2]}, s.list(t, repository, 1, 10, learn_golang_gorm.Filter{}))
+	_, err := repository.Get(ctx, keys[1])
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "get a deleted row: %v", err)
+	deleted := s.replacement(t, rows[1], s.text(9))
+	err = repository.Update(ctx, &deleted)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "update a deleted row: %v", err)
+	assert.Empty(t, s.list(t, repository, 1, 10, s.condition("eq", s.text(9))))
+	err = repository.Delete(ctx, keys[1])
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "delete a deleted row: %v", err)
+	err = repository.Delete(ctx, s.fixture.Missing)
+	assert.True(t, errors.Is(err, gorm.ErrRecordNotFound), "delete a missing row: %v", err)
+}
+
+func (s *suite[T, K]) testOptimisticLocking(t *testing.T, repository learn_golang_gorm.Repository[T, K]) {
+	if s.version == nil {
+		t.Skipf("%s has no version column", s.schema.Name)
+	}
+	ctx := context.Background()
+	row := s.create(t, repository, 1)[0]
+	version := s.versionOf(row)
+
+	updated := s.replacement(t, row, s.text(2))
+	stale := s.replacement(t, row, s.text(3))
+	require.NoError(t, repository.Update(ctx, &updated))
+	assert.Equal(t, version+1, s.versionOf(updated))
+
+	err := repository.Update(ctx, &stale)
+	assert.True(t, errors.Is(err, learn_golang_gorm.ErrVersionConflict), "update a stale row: %v", err)
+	assert.Equal(t, version, s.versionOf(stale), "the version of a conflicting row is kept")
+	found, err := repository.Get(ctx, s.key(row))
+	require.NoError(t, err)
+	assert.Equal(t, version+1, s.versionOf(found))
+	assert.Equal(t, []K{s.key(row)}, s.list(t, repository, 1, 10, s.condition("eq", s.text(2))))
+
+	assert.NoError(t, repository.Update(ctx, &updated), "update with the current version")
+	assert.Equal(t, version+2, s.versionOf(updated))
+}
@@ -0,0 +1,86 @@
+package repositorytest
+
+import (
+	"testing"
+	"time"
+
+	learn_golang_gorm "learn-golang-gorm"
+	"learn-golang-gorm/testutil"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+// note is an optimistically locked model.
+type note struct {
+	ID        uint           `gorm:"primary_key;column:id;autoIncrement"`
+	Text      string         `gorm:"column:text"`
+	Version   int64          `gorm:"column:version"`
+	CreatedAt time.Time      `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time      `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	DeletedAt gorm.DeletedAt `gorm:"column:deleted_at"`
+}
+
+func (n *note) TableName() string {
+	return "repositorytest_notes"
+}
+
+var (
+	noteFields = learn_golang_gorm.FilterFields{Table: "repositorytest_notes", Columns: map[string]string{"text": "text"}}
+	notes      = Fixture[note, uint]{
+		New:     func(text string) note { return note{Text: text} },
+		Field:   "text",
+		Missing: 1 << 31,
+	}
+	todos = Fixture[learn_golang_gorm.Todo, uint]{
+		New: func(text string) learn_golang_gorm.Todo {
+			return learn_golang_gorm.Todo{UserId: DefaultPrefix, Title: text}
+		},
+		Field:   "title",
+		Missing: 1 << 31,
+	}
+)
+
+func TestMemoryRepository(t *testing.T) {
+	t.Run("Todo", func(t *testing.T) {
+		Run(t, func(t *testing.T) learn_golang_gorm.Repository[learn_golang_gorm.Todo, uint] {
+			repository, err := learn_golang_gorm.NewMemoryRepository[learn_golang_gorm.Todo, uint](learn_golang_gorm.TodoFilterFields)
+			assert.NoError(t, err)
+			return repository
+		}, todos)
+	})
+	t.Run("note", func(t *testing.T) {
+		Run(t, func(t *testing.T) learn_golang_gorm.Repository[note, uint] {
+			repository, err := learn_golang_gorm.NewMemoryRepository[note, uint](noteFields)
+			assert.NoError(t, err)
+			return repository
+		}, notes)
+	})
+}
+
+func TestGormRepository(t *testing.T) {
+	database, err := testutil.CreateDatabase(learn_golang_gorm.DefaultConfig())
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer database.Drop()
+	db := database.DB
+	assert.NoError(t, db.AutoMigrate(&note{}))
+
+	t.Run("Todo", func(t *testing.T) {
+		Run(t, func(t *testing.T) learn_golang_gorm.Repository[learn_golang_gorm.Todo, uint] {
+			t.Cleanup(func() {
+				db.Unscoped().Delete(&learn_golang_gorm.Todo{}, "user_id = ?", DefaultPrefix)
+			})
+			return learn_golang_gorm.NewGormRepository[learn_golang_gorm.Todo, uint](db, learn_golang_gorm.TodoFilterFields)
+		}, todos)
+	})
+	t.Run("note", func(t *testing.T) {
+		Run(t, func(t *testing.T) learn_golang_gorm.Repository[note, uint] {
+			t.Cleanup(func() {
+				db.Unscoped().Delete(&note{}, "text LIKE ?", DefaultPrefix+" %")
+			})
+			return learn_golang_gorm.NewGormRepository[note, uint](db, noteFields)
+		}, notes)
+	})
+}
@@ -0,0 +1,83 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+var transientMySQLErrors = map[uint16]bool{
+	1040: true, // too many connections
+	1053: true, // server shutdown in progress
+	2006: true, // server has gone away
+	2013: true, // lost connection during query
+}
+
+// IsTransientError reports whether err is a connection level failure that
+// is likely to succeed on another connection.
+func IsTransientError(err error) bool {
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		return transientMySQLErrors[mysqlErr.Number]
+	}
+	return false
+}
+
+type RetryPolicy struct {
+	MaxAttempts int
+	Backoff     time.Duration
+	MaxBackoff  time.Duration
+}
+
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 3,
+	Backoff:     100 * time.Millisecond,
+	MaxBackoff:  2 * time.Second,
+}
+
+func (p RetryPolicy) do(ctx context.Context, fn func() error) error {
+	backoff := p.Backoff
+	for attempt := 1; ; attempt++ {
+		err := fn()
+		if err == nil || attempt >= p.MaxAttempts || !IsTransientError(err) {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if p.MaxBackoff > 0 && backoff > p.MaxBackoff {
+			backoff = p.MaxBackoff
+		}
+	}
+}
+
+// RetryRead runs fn in a READ ONLY transaction and retries it on transient
+// errors. database/sql drops the broken connection, so every attempt runs on
+// a fresh one, and the read only transaction guarantees fn can not write.
+func RetryRead(ctx context.Context, db *gorm.DB, policy RetryPolicy, fn func(tx *gorm.DB) error) error {
+	return policy.do(ctx, func() error {
+		return RunInTransaction(ctx, db, fn, ReadOnly())
+	})
+}
+
+// RetryIdempotentWrite is the explicit opt in for writes: fn runs in a
+// transaction that is retried as a whole, so it must be safe to repeat, e.g.
+// upserts or updates to absolute values, never increments or plain inserts.
+func RetryIdempotentWrite(ctx context.Context, db *gorm.DB, policy RetryPolicy, fn func(tx *gorm.DB) error) error {
+	return policy.do(ctx, func() error {
+		return RunInTransaction(ctx, db, fn)
+	})
+}
@@ -0,0 +1,93 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	ReviewSortHelpful = "helpful"
+	ReviewSortNewest  = "newest"
+)
+
+var ErrInvalidRating = errors.New("rating must be between 1 and 5")
+
+type Review struct {
+	ID           int64     `gorm:"primary_key;column:id;autoIncrement"`
+	UserID       string    `gorm:"column:user_id;uniqueIndex:idx_reviews_user_product"`
+	ProductID    string    `gorm:"column:product_id;uniqueIndex:idx_reviews_user_product"`
+	Rating       int       `gorm:"column:rating"`
+	Text         string    `gorm:"column:text"`
+	HelpfulCount int64     `gorm:"column:helpful_count"`
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	User         User      `gorm:"foreignKey:user_id;references:id"`
+	Product      Product   `gorm:"foreignKey:product_id;references:id"`
+}
+
+func (r *Review) TableName() string {
+	return "reviews"
+}
+
+func CreateReview(db *gorm.DB, review *Review) error {
+	if review.Rating < 1 || review.Rating > 5 {
+		return ErrInvalidRating
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Create(review).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("update products set average_rating = (average_rating * review_count + ?) / (review_count + 1), "+
+			"review_count = review_count + 1 where id = ?", review.Rating, review.ProductID).Error
+	})
+}
+
+func DeleteReview(db *gorm.DB, id int64) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var review Review
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).Take(&review, "id = ?", id).Error
+		if err != nil {
+			return err
+		}
+
+		err = tx.Delete(&review).Error
+		if err != nil {
+			return err
+		}
+
+		return tx.Exec("update products set average_rating = case when review_count <= 1 then 0 "+
+			"else (average_rating * review_count - ?) / (review_count - 1) end, "+
+			"review_count = greatest(review_count - 1, 0) where id = ?", review.Rating, review.ProductID).Error
+	})
+}
+
+func MarkReviewHelpful(db *gorm.DB, id int64) error {
+	return db.Model(&Review{}).Where("id = ?", id).
+		Update("helpful_count", gorm.Expr("helpful_count + ?", 1)).Error
+}
+
+func ListReviews(db *gorm.DB, productID string, sort string, page int, size int) ([]Review, error) {
+	query := db.Preload("User").Where("product_id = ?", productID)
+	if sort == ReviewSortHelpful {
+		query = query.Order("helpful_count desc")
+	}
+	query = query.Order("created_at desc").Order("id desc")
+
+	var reviews []Review
+	err := query.Scopes(Paginate(page, size)).Find(&reviews).Error
+	return reviews, err
+}
+
+// RecomputeProductRatings rebuilds the aggregate columns from the reviews
+// table, it is meant to run periodically to repair any drift.
+func RecomputeProductRatings(db *gorm.DB) error {
+	return db.Exec("update products p left join (select product_id, avg(rating) as average_rating, count(*) as review_count " +
+		"from reviews group by product_id) r on r.product_id = p.id " +
+		"set p.average_rating = coalesce(r.average_rating, 0), p.review_count = coalesce(r.review_count, 0)").Error
+}
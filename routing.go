@@ -0,0 +1,93 @@
+package learn_golang_gorm
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+const DatabaseLogging = "logging"
+
+// DefaultTableRoutes moves the append only logs to their own database.
+var DefaultTableRoutes = map[string]string{
+	"user_logs":  DatabaseLogging,
+	"audit_logs": DatabaseLogging,
+}
+
+var ErrCrossDatabaseTransaction = errors.New("transaction spans databases")
+
+type CrossDatabaseError struct {
+	Table    string
+	Database string
+}
+
+func (e *CrossDatabaseError) Error() string {
+	return fmt.Sprintf("%s lives in the %s database, it can not join a transaction of another database", e.Table, e.Database)
+}
+
+func (e *CrossDatabaseError) Unwrap() error {
+	return ErrCrossDatabaseTransaction
+}
+
+// TableRouter sends the statements on some tables to another database,
+// e.g. user_logs to a logging database. Statements in a transaction can
+// not be routed, writing a routed table in a transaction of the main
+// database fails with a *CrossDatabaseError, as on a connection pinned
+// with Connection. Raw SQL and joins are not routed.
+type TableRouter struct {
+	Routes    map[string]string
+	Databases map[string]*gorm.DB
+}
+
+// RouteTables registers the router on db, routes maps tables to names of
+// databases.
+func RouteTables(db *gorm.DB, routes map[string]string, databases map[string]*gorm.DB) (*TableRouter, error) {
+	router := &TableRouter{Routes: routes, Databases: databases}
+	for table, name := range routes {
+		if databases[name] == nil {
+			return nil, fmt.Errorf("table %s is routed to the unknown database %s", table, name)
+		}
+	}
+
+	callbacks := db.Callback()
+	// before the transaction of writes begins, so it begins on the routed database
+	err := callbacks.Create().Before("gorm:begin_transaction").Register("routing:create", router.route)
+	if err != nil {
+		return nil, err
+	}
+	err = callbacks.Update().Before("gorm:begin_transaction").Register("routing:update", router.route)
+	if err != nil {
+		return nil, err
+	}
+	err = callbacks.Delete().Before("gorm:begin_transaction").Register("routing:delete", router.route)
+	if err != nil {
+		return nil, err
+	}
+	err = callbacks.Query().Before("gorm:query").Register("routing:query", router.route)
+	if err != nil {
+		return nil, err
+	}
+	return router, callbacks.Row().Before("gorm:row").Register("routing:row", router.route)
+}
+
+// Database returns the database of table, nil when it is not routed. Use
+// it to migrate or to run transactions on routed tables.
+func (r *TableRouter) Database(table string) *gorm.DB {
+	return r.Databases[r.Routes[table]]
+}
+
+func (r *TableRouter) route(db *gorm.DB) {
+	name, ok := r.Routes[db.Statement.Table]
+	if !ok || db.Error != nil {
+		return
+	}
+	_, inTransaction := db.Statement.ConnPool.(gorm.TxCommitter)
+	_, pinned := db.Statement.ConnPool.(*sql.Conn)
+	if inTransaction || pinned {
+		_ = db.AddError(&CrossDatabaseError{Table: db.Statement.Table, Database: name})
+		return
+	}
+	db.Statement.ConnPool = r.Databases[name].Config.ConnPool
+}
@@ -0,0 +1,105 @@
+package learn_golang_gorm
+
+import (
+	"database/sql"
+	"reflect"
+	"sync"
+
+	"gorm.io/gorm"
+)
+
+// RowScanner scans the rows of a result into one set of column buffers,
+// reused for every row and, through a pool, by the next scanner. A row is
+// only valid until the next Scan.
+type RowScanner struct {
+	Columns []string
+
+	values []sql.RawBytes
+	dest   []interface{}
+}
+
+var rowScannerPool = sync.Pool{
+	New: func() interface{} {
+		return &RowScanner{}
+	},
+}
+
+func NewRowScanner(rows *sql.Rows) (*RowScanner, error) {
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	s := rowScannerPool.Get().(*RowScanner)
+	s.Columns = columns
+	if cap(s.values) < len(columns) {
+		s.values = make([]sql.RawBytes, len(columns))
+		s.dest = make([]interface{}, len(columns))
+	}
+	s.values, s.dest = s.values[:len(columns)], s.dest[:len(columns)]
+	for i := range s.values {
+		s.dest[i] = &s.values[i]
+	}
+	return s, nil
+}
+
+// Scan reads the current row, a NULL column is nil.
+func (s *RowScanner) Scan(rows *sql.Rows) ([]sql.RawBytes, error) {
+	err := rows.Scan(s.dest...)
+	return s.values, err
+}
+
+// Release gives the buffers back to the pool, the scanner is not used
+// after.
+func (s *RowScanner) Release() {
+	s.Columns = nil
+	rowScannerPool.Put(s)
+}
+
+// StreamRows hands each row of query to fn scanned into the same T, the
+// column pointers are resolved once instead of per row. fn copies what it
+// keeps. Columns that may be NULL need pointer or sql.Null fields, like with
+// database/sql, and columns T has no field for are skipped.
+func StreamRows[T any](query *gorm.DB, fn func(row *T) error) error {
+	var row T
+	stmt := &gorm.Statement{DB: query}
+	err := stmt.Parse(&row)
+	if err != nil {
+		return err
+	}
+
+	rows, err := query.Model(&row).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+
+	value := reflect.ValueOf(&row).Elem()
+	dest := make([]interface{}, len(columns))
+	for i, column := range columns {
+		field := stmt.Schema.LookUpField(column)
+		if field == nil || field.DataType == "" {
+			dest[i] = new(sql.RawBytes)
+			continue
+		}
+		dest[i] = field.ReflectValueOf(query.Statement.Context, value).Addr().Interface()
+	}
+
+	var zero T
+	for rows.Next() {
+		row = zero
+		err = rows.Scan(dest...)
+		if err != nil {
+			return err
+		}
+		err = fn(&row)
+		if err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
@@ -0,0 +1,200 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnknownSearchResource = fmt.Errorf("%w: unknown search resource", ErrInvalidRequest)
+
+// SearchResource is what a saved search runs on, its filter and sort are
+// checked against Filters and Sorts on every run, so a field dropped from
+// the whitelist stops working in stored searches too. Owner is the column
+// of the user owning a row, a search only finds the rows of its user, a
+// search on users only its user, and the Omit columns are never loaded, not
+// even through a shared link.
+type SearchResource struct {
+	Model   interface{}
+	Filters FilterFields
+	Sorts   SortFields
+	Owner   string
+	Omit    []string
+}
+
+var searchResources = map[string]SearchResource{
+	"users":    {Model: &User{}, Filters: UserFilterFields, Sorts: UserSortFields, Owner: "id", Omit: []string{"password"}},
+	"products": {Model: &Product{}, Filters: ProductFilterFields, Sorts: ProductSortFields},
+	"todos":    {Model: &Todo{}, Filters: TodoFilterFields, Sorts: TodoSortFields, Owner: "user_id"},
+}
+
+// SavedSearch is a named filter of a user, ShareToken is set while it is
+// shared by link.
+type SavedSearch struct {
+	ID         int64           `gorm:"primary_key;column:id;autoIncrement"`
+	UserID     string          `gorm:"column:user_id;index"`
+	Name       string          `gorm:"column:name"`
+	Resource   string          `gorm:"column:resource"`
+	Filter     json.RawMessage `gorm:"column:filter;type:json"`
+	Sort       string          `gorm:"column:sort"`
+	ShareToken *string         `gorm:"column:share_token;type:varchar(64);uniqueIndex"`
+	CreatedAt  time.Time       `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt  time.Time       `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (s *SavedSearch) TableName() string {
+	return "saved_searches"
+}
+
+// query is the filter and order of the search, or why it is invalid.
+func (s *SavedSearch) query() (SearchResource, Filter, clause.OrderBy, error) {
+	resource, ok := searchResources[s.Resource]
+	if !ok {
+		return SearchResource{}, Filter{}, clause.OrderBy{}, fmt.Errorf("%w: %q", ErrUnknownSearchResource, s.Resource)
+	}
+	var filter Filter
+	if len(s.Filter) > 0 {
+		var err error
+		filter, err = ParseFilter(s.Filter)
+		if err != nil {
+			return SearchResource{}, Filter{}, clause.OrderBy{}, err
+		}
+	}
+	_, err := filter.Expression(resource.Filters)
+	if err != nil {
+		return SearchResource{}, Filter{}, clause.OrderBy{}, err
+	}
+	order, err := ParseSort(s.Sort, resource.Sorts)
+	if err != nil {
+		return SearchResource{}, Filter{}, clause.OrderBy{}, err
+	}
+	return resource, filter, order, nil
+}
+
+// SavedSearches keeps the searches of the users, with Guard set their
+// filters are held to Budget when saved and run. List returns at most
+// MaxRows searches.
+type SavedSearches struct {
+	DB      *gorm.DB
+	Guard   *FilterGuard
+	Budget  FilterBudget
+	MaxRows int
+}
+
+func NewSavedSearches(db *gorm.DB) *SavedSearches {
+	return &SavedSearches{DB: db}
+}
+
+// Save stores search for its user, a search that would not run is
+// rejected.
+func (s *SavedSearches) Save(ctx context.Context, search *SavedSearch) error {
+	resource, filter, _, err := search.query()
+	if err == nil && s.Guard != nil {
+		_, err = s.Guard.Check(ctx, filter, resource.Filters, s.Budget)
+	}
+	if err != nil {
+		return err
+	}
+	search.ShareToken = nil
+	return s.DB.WithContext(ctx).Create(search).Error
+}
+
+func (s *SavedSearches) Get(ctx context.Context, userID string, id int64) (SavedSearch, error) {
+	var search SavedSearch
+	err := s.DB.WithContext(ctx).Take(&search, "id = ? AND user_id = ?", id, userID).Error
+	return search, err
+}
+
+func (s *SavedSearches) List(ctx context.Context, userID string) (Limited[SavedSearch], error) {
+	return FindLimited[SavedSearch](s.DB.WithContext(ctx).Where("user_id = ?", userID).Order("name").Order("id"), s.MaxRows)
+}
+
+func (s *SavedSearches) Delete(ctx context.Context, userID string, id int64) error {
+	result := s.DB.WithContext(ctx).Delete(&SavedSearch{}, "id = ? AND user_id = ?", id, userID)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+// Share returns the token of the link to the search, sharing it again
+// gives the same link.
+func (s *SavedSearches) Share(ctx context.Context, userID string, id int64) (string, error) {
+	search, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return "", err
+	}
+	if search.ShareToken != nil {
+		return *search.ShareToken, nil
+	}
+
+	token, err := newToken(s.DB)
+	if err != nil {
+		return "", err
+	}
+	err = s.DB.WithContext(ctx).Model(&search).Update("share_token", token).Error
+	return token, err
+}
+
+// Unshare makes the links given out for the search stop working.
+func (s *SavedSearches) Unshare(ctx context.Context, userID string, id int64) error {
+	result := s.DB.WithContext(ctx).Model(&SavedSearch{}).Where("id = ? AND user_id = ?", id, userID).
+		Update("share_token", nil)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+
+func (s *SavedSearches) Shared(ctx context.Context, token string) (SavedSearch, error) {
+	var search SavedSearch
+	err := s.DB.WithContext(ctx).Take(&search, "share_token = ?", token).Error
+	return search, err
+}
+
+func (s *SavedSearches) run(ctx context.Context, search SavedSearch, page int, size int, dest interface{}) error {
+	resource, filter, order, err := search.query()
+	if err == nil && s.Guard != nil {
+		filter, err = s.Guard.Check(ctx, filter, resource.Filters, s.Budget)
+	}
+	if err != nil {
+		return err
+	}
+	scope, err := filter.Scope(resource.Filters)
+	if err != nil {
+		return err
+	}
+	model := reflect.New(reflect.TypeOf(resource.Model).Elem()).Interface()
+	query := s.DB.WithContext(ctx).Model(model).Scopes(scope)
+	if resource.Owner != "" {
+		query = query.Where(clause.Eq{Column: clause.Column{Table: clause.CurrentTable, Name: resource.Owner}, Value: search.UserID})
+	}
+	if len(resource.Omit) > 0 {
+		query = query.Omit(resource.Omit...)
+	}
+	return query.Clauses(order).Scopes(Paginate(page, size)).Find(dest).Error
+}
+
+// Run loads the page of the rows the saved search id of userID finds into
+// dest, a pointer to a slice of the model of its resource.
+func (s *SavedSearches) Run(ctx context.Context, userID string, id int64, page int, size int, dest interface{}) error {
+	search, err := s.Get(ctx, userID, id)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx, search, page, size, dest)
+}
+
+// RunShared runs the search shared with token, for anyone holding the link.
+func (s *SavedSearches) RunShared(ctx context.Context, token string, page int, size int, dest interface{}) error {
+	search, err := s.Shared(ctx, token)
+	if err != nil {
+		return err
+	}
+	return s.run(ctx, search, page, size, dest)
+}
@@ -0,0 +1,328 @@
+// Package scaffold generates the files of a new model following the
+// conventions of the learn_golang_gorm package: the model, its repository
+// and service, the migration creating its table and a test.
+package scaffold
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"go/format"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+	"unicode"
+)
+
+var (
+	ErrInvalidName  = errors.New("model name must be a Go identifier starting with an upper case letter")
+	ErrInvalidField = errors.New("field must be name:type[:index|:unique], with type string, text, int, int64, bool, float, time or date, suffixed with ? when nullable")
+	ErrFileExists   = errors.New("file already exists")
+)
+
+var initialisms = map[string]string{"id": "ID", "ip": "IP", "url": "URL", "api": "API", "uuid": "UUID", "http": "HTTP"}
+
+type fieldType struct {
+	GoType string
+	Column string
+}
+
+var fieldTypes = map[string]fieldType{
+	"string": {GoType: "string", Column: "varchar(255)"},
+	"text":   {GoType: "string", Column: "text"},
+	"int":    {GoType: "int"},
+	"int64":  {GoType: "int64"},
+	"bool":   {GoType: "bool"},
+	"float":  {GoType: "float64"},
+	"time":   {GoType: "time.Time"},
+	"date":   {GoType: "time.Time", Column: "date"},
+}
+
+type Field struct {
+	Name     string
+	Column   string
+	Type     string
+	GoType   string
+	SQLType  string
+	Nullable bool
+	Index    bool
+	Unique   bool
+}
+
+// Tag is the gorm tag of the field in the style of the package models.
+func (f Field) Tag() string {
+	tag := "column:" + f.Column
+	if f.SQLType != "" {
+		tag += ";type:" + f.SQLType
+	}
+	if f.Index {
+		tag += ";index"
+	}
+	if f.Unique {
+		tag += ";uniqueIndex"
+	}
+	return tag
+}
+
+// Sample is a Go expression of a value of the field used by the generated
+// test, update gives a different one.
+func (f Field) Sample(update bool) string {
+	switch f.Type {
+	case "string", "text":
+		if update {
+			return fmt.Sprintf("%q", f.Name+" updated")
+		}
+		return fmt.Sprintf("%q", f.Name)
+	case "int", "int64", "float":
+		if update {
+			return "2"
+		}
+		return "1"
+	case "bool":
+		return fmt.Sprint(!update)
+	}
+	if update {
+		return "time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)"
+	}
+	return "time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)"
+}
+
+type Model struct {
+	Name    string
+	Package string
+	Table   string
+	File    string
+	Version int64
+	Fields  []Field
+
+	// Handlers adds REST handlers bound to the service.
+	Handlers bool
+}
+
+func (m Model) Var() string {
+	runes := []rune(m.Name)
+	runes[0] = unicode.ToLower(runes[0])
+	return string(runes)
+}
+
+func (m Model) Vars() string {
+	return Plural(m.Var())
+}
+
+// Path is the URL path the handlers are mounted on.
+func (m Model) Path() string {
+	return strings.ReplaceAll(m.Table, "_", "-")
+}
+
+// Filterable are the fields the list handler filters on by equality.
+func (m Model) Filterable() []Field {
+	var fields []Field
+	for _, field := range m.Fields {
+		switch field.Type {
+		case "string", "int", "int64", "bool":
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (m Model) Receiver() string {
+	return strings.ToLower(m.Name[:1])
+}
+
+// Required are the fields the generated test sets, the nullable ones are
+// left NULL.
+func (m Model) Required() []Field {
+	var fields []Field
+	for _, field := range m.Fields {
+		if !field.Nullable {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+// Comparable are the required fields read back unchanged, times lose their
+// precision and location in the database.
+func (m Model) Comparable() []Field {
+	var fields []Field
+	for _, field := range m.Required() {
+		if field.Type != "time" && field.Type != "date" {
+			fields = append(fields, field)
+		}
+	}
+	return fields
+}
+
+func (m Model) TestUsesTime() bool {
+	return len(m.Required()) > len(m.Comparable())
+}
+
+// Snake converts FirstName, firstName and first_name to first_name.
+func Snake(name string) string {
+	var builder strings.Builder
+	runes := []rune(name)
+	for i, r := range runes {
+		if unicode.IsUpper(r) {
+			lowerBefore := i > 0 && unicode.IsLower(runes[i-1])
+			lowerAfter := i > 0 && i+1 < len(runes) && unicode.IsUpper(runes[i-1]) && unicode.IsLower(runes[i+1])
+			if lowerBefore || lowerAfter {
+				builder.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// Camel converts first_name to FirstName and user_id to UserID.
+func Camel(name string) string {
+	var builder strings.Builder
+	for _, word := range strings.Split(Snake(name), "_") {
+		if word == "" {
+			continue
+		}
+		if initialism, ok := initialisms[word]; ok {
+			builder.WriteString(initialism)
+			continue
+		}
+		builder.WriteString(strings.ToUpper(word[:1]) + word[1:])
+	}
+	return builder.String()
+}
+
+func Plural(name string) string {
+	switch {
+	case strings.HasSuffix(name, "y") && len(name) > 1 && !strings.ContainsRune("aeiou", rune(name[len(name)-2])):
+		return name[:len(name)-1] + "ies"
+	case strings.HasSuffix(name, "s"), strings.HasSuffix(name, "x"), strings.HasSuffix(name, "ch"), strings.HasSuffix(name, "sh"):
+		return name + "es"
+	}
+	return name + "s"
+}
+
+func isIdentifier(name string) bool {
+	for i, r := range name {
+		if !unicode.IsLetter(r) && r != '_' && (i == 0 || !unicode.IsDigit(r)) {
+			return false
+		}
+	}
+	return name != ""
+}
+
+// ParseField parses name:type[:index|:unique], a type ending in ? is
+// nullable.
+func ParseField(spec string) (Field, error) {
+	parts := strings.Split(spec, ":")
+	if len(parts) < 2 || len(parts) > 3 || !isIdentifier(parts[0]) {
+		return Field{}, fmt.Errorf("%w: %q", ErrInvalidField, spec)
+	}
+
+	field := Field{Name: Camel(parts[0]), Column: Snake(parts[0]), Type: strings.TrimSuffix(parts[1], "?")}
+	field.Nullable = strings.HasSuffix(parts[1], "?")
+	kind, ok := fieldTypes[field.Type]
+	if !ok || field.Column == "id" || field.Column == "created_at" || field.Column == "updated_at" {
+		return Field{}, fmt.Errorf("%w: %q", ErrInvalidField, spec)
+	}
+	field.GoType, field.SQLType = kind.GoType, kind.Column
+	if field.Nullable {
+		field.GoType = "*" + field.GoType
+	}
+
+	if len(parts) == 3 {
+		switch parts[2] {
+		case "index":
+			field.Index = true
+		case "unique":
+			field.Unique = true
+		default:
+			return Field{}, fmt.Errorf("%w: %q", ErrInvalidField, spec)
+		}
+	}
+	return field, nil
+}
+
+// NewModel describes model name with fields, the migration creating its
+// table gets version.
+func NewModel(name string, version int64, fields ...string) (Model, error) {
+	if !isIdentifier(name) || !unicode.IsUpper([]rune(name)[0]) {
+		return Model{}, ErrInvalidName
+	}
+
+	snake := Snake(name)
+	model := Model{Name: name, Package: "learn_golang_gorm", Table: Plural(snake), File: snake, Version: version}
+	for _, spec := range fields {
+		field, err := ParseField(spec)
+		if err != nil {
+			return Model{}, err
+		}
+		model.Fields = append(model.Fields, field)
+	}
+	return model, nil
+}
+
+type File struct {
+	Name    string
+	Content []byte
+}
+
+var templates = template.Must(template.New("scaffold").Parse(modelTemplate + repositoryTemplate + serviceTemplate + migrationTemplate + testTemplate + handlerTemplate))
+
+func (m Model) render(name string, file string) (File, error) {
+	var buffer bytes.Buffer
+	err := templates.ExecuteTemplate(&buffer, name, m)
+	if err != nil {
+		return File{}, err
+	}
+	source, err := format.Source(buffer.Bytes())
+	if err != nil {
+		return File{}, fmt.Errorf("%s: %w", file, err)
+	}
+	return File{Name: file, Content: source}, nil
+}
+
+// Files renders every file of the model.
+func (m Model) Files() ([]File, error) {
+	outputs := []struct{ template, file string }{
+		{"model", m.File + ".go"},
+		{"repository", m.File + "_repository.go"},
+		{"service", m.File + "_service.go"},
+		{"migration", m.File + "_migration.go"},
+		{"test", m.File + "_test.go"},
+	}
+	if m.Handlers {
+		outputs = append(outputs, struct{ template, file string }{"handler", m.File + "_handler.go"})
+	}
+
+	files := make([]File, 0, len(outputs))
+	for _, output := range outputs {
+		file, err := m.render(output.template, output.file)
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, file)
+	}
+	return files, nil
+}
+
+// Write writes files into dir, existing files are only replaced with force.
+func Write(dir string, files []File, force bool) error {
+	if !force {
+		for _, file := range files {
+			_, err := os.Stat(filepath.Join(dir, file.Name))
+			if err == nil {
+				return fmt.Errorf("%w: %s", ErrFileExists, file.Name)
+			}
+		}
+	}
+
+	for _, file := range files {
+		err := os.WriteFile(filepath.Join(dir, file.Name), file.Content, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
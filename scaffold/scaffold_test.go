@@ -0,0 +1,70 @@
+package scaffold
+
+import (
+	"go/parser"
+	"go/token"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNames(t *testing.T) {
+	assert.Equal(t, "product_review", Snake("ProductReview"))
+	assert.Equal(t, "api_key", Snake("APIKey"))
+	assert.Equal(t, "UserID", Camel("user_id"))
+	assert.Equal(t, "LastIP", Camel("lastIp"))
+	assert.Equal(t, "categories", Plural("category"))
+	assert.Equal(t, "addresses", Plural("address"))
+	assert.Equal(t, "keys", Plural("key"))
+}
+
+func TestParseField(t *testing.T) {
+	field, err := ParseField("email:string:unique")
+	assert.Nil(t, err)
+	assert.Equal(t, "Email", field.Name)
+	assert.Equal(t, "column:email;type:varchar(255);uniqueIndex", field.Tag())
+
+	field, err = ParseField("due_at:time?")
+	assert.Nil(t, err)
+	assert.Equal(t, "*time.Time", field.GoType)
+
+	for _, spec := range []string{"email", "email:blob", "id:int", "email:string:primary", "1st:int"} {
+		_, err = ParseField(spec)
+		assert.ErrorIs(t, err, ErrInvalidField, spec)
+	}
+}
+
+func TestFilesAreValidGo(t *testing.T) {
+	_, err := NewModel("invoice", 1)
+	assert.Equal(t, ErrInvalidName, err)
+
+	model, err := NewModel("Invoice", 42, "number:string:unique", "total:int64", "paid_at:time?", "issued_on:date")
+	assert.Nil(t, err)
+	assert.Equal(t, "invoices", model.Table)
+
+	files, err := model.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(files))
+	for _, file := range files {
+		_, err := parser.ParseFile(token.NewFileSet(), file.Name, file.Content, 0)
+		assert.Nil(t, err, file.Name)
+	}
+	assert.Contains(t, string(files[3].Content), "Version: 42,")
+}
+
+func TestHandlerFiles(t *testing.T) {
+	model, err := NewModel("LineItem", 7, "note:text")
+	assert.Nil(t, err)
+	model.Handlers = true
+	assert.Equal(t, "line-items", model.Path())
+	assert.Empty(t, model.Filterable())
+
+	files, err := model.Files()
+	assert.Nil(t, err)
+	assert.Equal(t, 6, len(files))
+	assert.Equal(t, "line_item_handler.go", files[5].Name)
+	for _, file := range files {
+		_, err := parser.ParseFile(token.NewFileSet(), file.Name, file.Content, 0)
+		assert.Nil(t, err, file.Name)
+	}
+}
@@ -0,0 +1,428 @@
+package scaffold
+
+const modelTemplate = `{{define "model"}}package {{.Package}}
+
+import "time"
+
+type {{.Name}} struct {
+	ID int64 ` + "`" + `gorm:"primary_key;column:id;autoIncrement"` + "`" + `
+{{- range .Fields}}
+	{{.Name}} {{.GoType}} ` + "`" + `gorm:"{{.Tag}}"` + "`" + `
+{{- end}}
+	CreatedAt time.Time ` + "`" + `gorm:"column:created_at;autoCreateTime"` + "`" + `
+	UpdatedAt time.Time ` + "`" + `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"` + "`" + `
+}
+
+func ({{.Receiver}} *{{.Name}}) TableName() string {
+	return "{{.Table}}"
+}
+
+func init() {
+	RegisterModel(&{{.Name}}{})
+}
+{{end}}`
+
+const repositoryTemplate = `{{define "repository"}}package {{.Package}}
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+type {{.Name}}Repository struct {
+	DB *gorm.DB
+}
+
+func New{{.Name}}Repository(db *gorm.DB) *{{.Name}}Repository {
+	return &{{.Name}}Repository{DB: db}
+}
+
+func (r *{{.Name}}Repository) Create(ctx context.Context, {{.Var}} *{{.Name}}) error {
+	return SessionDB(ctx, r.DB).Create({{.Var}}).Error
+}
+
+func (r *{{.Name}}Repository) Get(ctx context.Context, id int64) ({{.Name}}, error) {
+	var {{.Var}} {{.Name}}
+	err := SessionDB(ctx, r.DB).Take(&{{.Var}}, "id = ?", id).Error
+	return {{.Var}}, err
+}
+
+// List returns a page of the {{.Table}} matching filters ordered by id.
+func (r *{{.Name}}Repository) List(ctx context.Context, page int, size int, filters ...func(db *gorm.DB) *gorm.DB) ([]{{.Name}}, error) {
+	var {{.Vars}} []{{.Name}}
+	err := SessionDB(ctx, r.DB).Scopes(filters...).Scopes(Paginate(page, size)).Order("id").Find(&{{.Vars}}).Error
+	return {{.Vars}}, err
+}
+
+// ListAll returns at most maxRows of the {{.Table}} matching filters
+// ordered by id, Truncated when more matched.
+func (r *{{.Name}}Repository) ListAll(ctx context.Context, maxRows int, filters ...func(db *gorm.DB) *gorm.DB) (Limited[{{.Name}}], error) {
+	return FindLimited[{{.Name}}](SessionDB(ctx, r.DB).Scopes(filters...).Order("id"), maxRows)
+}
+
+// Stream hands all {{.Table}} matching filters to fn in batches.
+func (r *{{.Name}}Repository) Stream(ctx context.Context, batchSize int, fn func(batch []{{.Name}}) error, filters ...func(db *gorm.DB) *gorm.DB) error {
+	return Stream(SessionDB(ctx, r.DB).Scopes(filters...), batchSize, fn)
+}
+
+func (r *{{.Name}}Repository) Update(ctx context.Context, {{.Var}} *{{.Name}}) error {
+	return SessionDB(ctx, r.DB).Model({{.Var}}).Select("*").Omit("id", "created_at").Updates({{.Var}}).Error
+}
+
+func (r *{{.Name}}Repository) Delete(ctx context.Context, id int64) error {
+	result := SessionDB(ctx, r.DB).Delete(&{{.Name}}{}, "id = ?", id)
+	if result.Error == nil && result.RowsAffected == 0 {
+		return gorm.ErrRecordNotFound
+	}
+	return result.Error
+}
+{{end}}`
+
+const serviceTemplate = `{{define "service"}}package {{.Package}}
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+)
+
+// {{.Name}}Service holds the business rules of {{.Table}}, add validation
+// and side effects here and keep the repository to data access.
+type {{.Name}}Service struct {
+	Repository *{{.Name}}Repository
+}
+
+func New{{.Name}}Service(repository *{{.Name}}Repository) *{{.Name}}Service {
+	return &{{.Name}}Service{Repository: repository}
+}
+
+func (s *{{.Name}}Service) Create(ctx context.Context, {{.Var}} *{{.Name}}) error {
+	return s.Repository.Create(ctx, {{.Var}})
+}
+
+func (s *{{.Name}}Service) Get(ctx context.Context, id int64) ({{.Name}}, error) {
+	return s.Repository.Get(ctx, id)
+}
+
+func (s *{{.Name}}Service) List(ctx context.Context, page int, size int, filters ...func(db *gorm.DB) *gorm.DB) ([]{{.Name}}, error) {
+	return s.Repository.List(ctx, page, size, filters...)
+}
+
+func (s *{{.Name}}Service) Update(ctx context.Context, {{.Var}} *{{.Name}}) error {
+	return s.Repository.Update(ctx, {{.Var}})
+}
+
+func (s *{{.Name}}Service) Delete(ctx context.Context, id int64) error {
+	return s.Repository.Delete(ctx, id)
+}
+{{end}}`
+
+const migrationTemplate = `{{define "migration"}}package {{.Package}}
+
+import "gorm.io/gorm"
+
+func init() {
+	RegisterMigration(Migration{
+		Version: {{.Version}},
+		Name:    "create {{.Table}}",
+		Up: func(tx *gorm.DB) error {
+			return tx.Migrator().CreateTable(&{{.Name}}{})
+		},
+		Down: func(tx *gorm.DB) error {
+			return tx.Migrator().DropTable(&{{.Name}}{})
+		},
+	})
+}
+{{end}}`
+
+const testTemplate = `{{define "test"}}package {{.Package}}
+
+import (
+{{- if .Handlers}}
+	"bytes"
+{{- end}}
+	"context"
+{{- if .Handlers}}
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+{{- end}}
+	"testing"
+{{- if .TestUsesTime}}
+	"time"
+{{- end}}
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+)
+
+func Test{{.Name}}Repository(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&{{.Name}}{})
+	assert.Nil(t, err)
+
+	ctx := context.Background()
+	service := New{{.Name}}Service(New{{.Name}}Repository(db))
+
+	{{.Var}} := {{.Name}}{
+{{- range .Required}}
+		{{.Name}}: {{.Sample false}},
+{{- end}}
+	}
+	assert.Nil(t, service.Create(ctx, &{{.Var}}))
+	assert.NotZero(t, {{.Var}}.ID)
+
+	found, err := service.Get(ctx, {{.Var}}.ID)
+	assert.Nil(t, err)
+{{- range .Comparable}}
+	assert.Equal(t, {{$.Var}}.{{.Name}}, found.{{.Name}})
+{{- end}}
+{{range .Required}}
+	{{$.Var}}.{{.Name}} = {{.Sample true}}
+{{- end}}
+	assert.Nil(t, service.Update(ctx, &{{.Var}}))
+	found, err = service.Get(ctx, {{.Var}}.ID)
+	assert.Nil(t, err)
+{{- range .Comparable}}
+	assert.Equal(t, {{$.Var}}.{{.Name}}, found.{{.Name}})
+{{- end}}
+
+	page, err := service.List(ctx, 1, MaxPageSize)
+	assert.Nil(t, err)
+	assert.NotEmpty(t, page)
+
+	assert.Nil(t, service.Delete(ctx, {{.Var}}.ID))
+	_, err = service.Get(ctx, {{.Var}}.ID)
+	assert.Equal(t, gorm.ErrRecordNotFound, err)
+}
+{{- if .Handlers}}
+
+func Test{{.Name}}Handler(t *testing.T) {
+	err := db.Migrator().AutoMigrate(&{{.Name}}{})
+	assert.Nil(t, err)
+
+	server := httptest.NewServer(http.StripPrefix("/{{.Path}}", New{{.Name}}Handler(New{{.Name}}Service(New{{.Name}}Repository(db)))))
+	defer server.Close()
+
+	body, err := json.Marshal({{.Name}}{
+{{- range .Required}}
+		{{.Name}}: {{.Sample false}},
+{{- end}}
+	})
+	assert.Nil(t, err)
+	response, err := http.Post(server.URL+"/", "application/json", bytes.NewReader(body))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusCreated, response.StatusCode)
+	var created {{.Name}}
+	assert.Nil(t, json.NewDecoder(response.Body).Decode(&created))
+	response.Body.Close()
+	url := fmt.Sprintf("%s/%d", server.URL, created.ID)
+
+	response, err = http.Get(url)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response.Body.Close()
+
+	response, err = http.Get(server.URL + "/?page=1&size=10")
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusOK, response.StatusCode)
+	response.Body.Close()
+
+	request, _ := http.NewRequest(http.MethodDelete, url, nil)
+	response, err = http.DefaultClient.Do(request)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNoContent, response.StatusCode)
+
+	response, err = http.Get(url)
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusNotFound, response.StatusCode)
+	response.Body.Close()
+
+	response, err = http.Post(server.URL+"/", "application/json", bytes.NewReader([]byte("{")))
+	assert.Nil(t, err)
+	assert.Equal(t, http.StatusBadRequest, response.StatusCode)
+	response.Body.Close()
+}
+{{- end}}
+{{end}}`
+
+const handlerTemplate = `{{define "handler"}}package {{.Package}}
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+// {{.Name}}Handler serves the REST endpoints of {{.Table}}, mount it below
+// its prefix:
+//
+//	mux.Handle("/{{.Path}}/", http.StripPrefix("/{{.Path}}", New{{.Name}}Handler(service)))
+type {{.Name}}Handler struct {
+	Service *{{.Name}}Service
+}
+
+func New{{.Name}}Handler(service *{{.Name}}Service) *{{.Name}}Handler {
+	return &{{.Name}}Handler{Service: service}
+}
+
+func init() {
+	RegisterAPIResource(APIResource{
+		Path:    "/{{.Path}}",
+		Model:   &{{.Name}}{},
+		Filters: []string{ {{- range $i, $field := .Filterable}}{{if $i}}, {{end}}"{{$field.Column}}"{{end -}} },
+	})
+}
+
+func (h *{{.Name}}Handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(r.URL.Path, "/")
+	if path == "" {
+		switch r.Method {
+		case http.MethodGet:
+			h.list(w, r)
+		case http.MethodPost:
+			h.create(w, r)
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(path, 10, 64)
+	if err != nil {
+		WriteError(w, gorm.ErrRecordNotFound)
+		return
+	}
+	switch r.Method {
+	case http.MethodGet:
+		h.get(w, r, id)
+	case http.MethodPut:
+		h.update(w, r, id)
+	case http.MethodDelete:
+		h.delete(w, r, id)
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (h *{{.Name}}Handler) decode(r *http.Request, {{.Var}} *{{.Name}}) error {
+	err := json.NewDecoder(io.LimitReader(r.Body, 1<<20)).Decode({{.Var}})
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalidRequest, err)
+	}
+	return nil
+}
+
+// filters turns ?column=value of the filterable columns into equality
+// filters.
+func (h *{{.Name}}Handler) filters(r *http.Request) ([]func(db *gorm.DB) *gorm.DB, error) {
+	var filters []func(db *gorm.DB) *gorm.DB
+{{- if .Filterable}}
+	query := r.URL.Query()
+{{- end}}
+{{- range .Filterable}}
+	if value := query.Get("{{.Column}}"); value != "" {
+{{- if eq .Type "string"}}
+		filters = append(filters, func(db *gorm.DB) *gorm.DB {
+			return db.Where("{{.Column}} = ?", value)
+		})
+{{- else}}
+{{- if eq .Type "bool"}}
+		parsed, err := strconv.ParseBool(value)
+{{- else}}
+		parsed, err := strconv.ParseInt(value, 10, 64)
+{{- end}}
+		if err != nil {
+			return nil, fmt.Errorf("%w: {{.Column}} %v", ErrInvalidRequest, err)
+		}
+		filters = append(filters, func(db *gorm.DB) *gorm.DB {
+			return db.Where("{{.Column}} = ?", parsed)
+		})
+{{- end}}
+	}
+{{- end}}
+	return filters, nil
+}
+
+// list answers a page of {{.Table}}, selected with ?page= and ?size=.
+func (h *{{.Name}}Handler) list(w http.ResponseWriter, r *http.Request) {
+	filters, err := h.filters(r)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	page, _ := strconv.Atoi(r.URL.Query().Get("page"))
+	size, _ := strconv.Atoi(r.URL.Query().Get("size"))
+
+	{{.Vars}}, err := h.Service.List(r.Context(), page, size, filters...)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, {{.Vars}})
+}
+
+func (h *{{.Name}}Handler) get(w http.ResponseWriter, r *http.Request, id int64) {
+	{{.Var}}, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, {{.Var}})
+}
+
+func (h *{{.Name}}Handler) create(w http.ResponseWriter, r *http.Request) {
+	var {{.Var}} {{.Name}}
+	err := h.decode(r, &{{.Var}})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	{{.Var}}.ID = 0
+
+	err = h.Service.Create(r.Context(), &{{.Var}})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusCreated, {{.Var}})
+}
+
+// update replaces the fields present in the body, the others keep their
+// value.
+func (h *{{.Name}}Handler) update(w http.ResponseWriter, r *http.Request, id int64) {
+	{{.Var}}, err := h.Service.Get(r.Context(), id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	err = h.decode(r, &{{.Var}})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	{{.Var}}.ID = id
+
+	err = h.Service.Update(r.Context(), &{{.Var}})
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	WriteJSON(w, http.StatusOK, {{.Var}})
+}
+
+func (h *{{.Name}}Handler) delete(w http.ResponseWriter, r *http.Request, id int64) {
+	err := h.Service.Delete(r.Context(), id)
+	if err != nil {
+		WriteError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+{{end}}`
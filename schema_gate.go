@@ -0,0 +1,87 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var ErrReadOnlyMode = errors.New("database is in read only mode")
+
+type SchemaVersionError struct {
+	Current  int64
+	Required int64
+}
+
+func (e *SchemaVersionError) Error() string {
+	return fmt.Sprintf("schema version %d is older than the required version %d, run the migrations first", e.Current, e.Required)
+}
+
+// RequiredSchemaVersion is the version of the last registered migration, the
+// code may use every column added up to it.
+func RequiredSchemaVersion() int64 {
+	if len(migrations) == 0 {
+		return 0
+	}
+	return migrations[len(migrations)-1].Version
+}
+
+// CheckSchemaVersion returns a *SchemaVersionError when the database has not
+// been migrated to RequiredSchemaVersion yet.
+func CheckSchemaVersion(db *gorm.DB) error {
+	current := int64(0)
+	if db.Migrator().HasTable(&SchemaMigration{}) {
+		var err error
+		current, err = SchemaVersion(db)
+		if err != nil {
+			return err
+		}
+	}
+
+	required := RequiredSchemaVersion()
+	if current < required {
+		return &SchemaVersionError{Current: current, Required: required}
+	}
+	return nil
+}
+
+func rejectWrites(db *gorm.DB) {
+	_ = db.AddError(ErrReadOnlyMode)
+}
+
+// EnableReadOnlyMode makes every create, update, delete and Exec on db fail
+// with ErrReadOnlyMode, queries keep working.
+func EnableReadOnlyMode(db *gorm.DB) error {
+	callbacks := db.Callback()
+	err := callbacks.Create().Before("gorm:create").Register("read_only:create", rejectWrites)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().Before("gorm:update").Register("read_only:update", rejectWrites)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Delete().Before("gorm:delete").Register("read_only:delete", rejectWrites)
+	if err != nil {
+		return err
+	}
+	return callbacks.Raw().Before("gorm:raw").Register("read_only:raw", rejectWrites)
+}
+
+// SchemaGate runs at startup. With an outdated schema it refuses to start,
+// or when readOnlyFallback is set it switches db to read only mode and
+// reports readOnly so the application can announce it.
+func SchemaGate(db *gorm.DB, readOnlyFallback bool) (readOnly bool, err error) {
+	err = CheckSchemaVersion(db)
+	var versionErr *SchemaVersionError
+	if !errors.As(err, &versionErr) || !readOnlyFallback {
+		return false, err
+	}
+
+	err = EnableReadOnlyMode(db)
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
@@ -0,0 +1,161 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gorm.io/gorm"
+)
+
+const (
+	SearchKindUser      = "user"
+	SearchKindProduct   = "product"
+	SearchKindGuestBook = "guest_book"
+)
+
+type SearchResult struct {
+	Kind      string
+	ID        string
+	Title     string
+	Score     int
+	User      *User
+	Product   *Product
+	GuestBook *GuestBook
+}
+
+func escapeLike(value string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(value)
+}
+
+// searchScore ranks an exact match above a prefix match above a match
+// anywhere in the text.
+func searchScore(text string, query string) int {
+	text = strings.ToLower(text)
+	query = strings.ToLower(query)
+	switch {
+	case text == query:
+		return 3
+	case strings.HasPrefix(text, query):
+		return 2
+	case strings.Contains(text, query):
+		return 1
+	}
+	return 0
+}
+
+func searchUsers(db *gorm.DB, query string, limit int) ([]SearchResult, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	var users []User
+	err := db.Where("concat_ws(' ', first_name, middle_name, last_name) LIKE ?", pattern).
+		Limit(limit).Find(&users).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(users))
+	for i := range users {
+		user := &users[i]
+		title := strings.Join(strings.Fields(user.Name.FirstName+" "+user.Name.MiddleName+" "+user.Name.LastName), " ")
+		results = append(results, SearchResult{
+			Kind:  SearchKindUser,
+			ID:    user.ID,
+			Title: title,
+			Score: searchScore(title, query),
+			User:  user,
+		})
+	}
+	return results, nil
+}
+
+func searchProducts(db *gorm.DB, query string, limit int) ([]SearchResult, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	var products []Product
+	err := db.Where("name LIKE ?", pattern).
+		Or("id IN (?)", db.Model(&ProductTranslation{}).Select("product_id").
+			Where("name LIKE ? OR description LIKE ?", pattern, pattern)).
+		Limit(limit).Find(&products).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(products))
+	for i := range products {
+		product := &products[i]
+		results = append(results, SearchResult{
+			Kind:    SearchKindProduct,
+			ID:      product.ID,
+			Title:   product.Name,
+			Score:   searchScore(product.Name, query),
+			Product: product,
+		})
+	}
+	return results, nil
+}
+
+func searchGuestBooks(db *gorm.DB, query string, limit int) ([]SearchResult, error) {
+	pattern := "%" + escapeLike(query) + "%"
+
+	var guestBooks []GuestBook
+	err := db.Where("message LIKE ?", pattern).
+		Order("created_at desc").Limit(limit).Find(&guestBooks).Error
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]SearchResult, 0, len(guestBooks))
+	for i := range guestBooks {
+		guestBook := &guestBooks[i]
+		results = append(results, SearchResult{
+			Kind:      SearchKindGuestBook,
+			ID:        strconv.FormatInt(guestBook.ID, 10),
+			Title:     guestBook.Name,
+			Score:     searchScore(guestBook.Message, query),
+			GuestBook: guestBook,
+		})
+	}
+	return results, nil
+}
+
+// GlobalSearch queries every searchable entity concurrently, each with at
+// most limit rows, and returns the combined results ranked by score. All
+// queries share ctx so one deadline bounds the whole search.
+func GlobalSearch(ctx context.Context, db *gorm.DB, query string, limit int) ([]SearchResult, error) {
+	searches := []func(db *gorm.DB, query string, limit int) ([]SearchResult, error){
+		searchUsers,
+		searchProducts,
+		searchGuestBooks,
+	}
+
+	group := NewQueryGroup(ctx, db)
+	partials := make([][]SearchResult, len(searches))
+	for i, search := range searches {
+		i, search := i, search
+		group.Go(func(tx *gorm.DB) error {
+			var err error
+			partials[i], err = search(tx, query, limit)
+			return err
+		})
+	}
+
+	err := group.Wait()
+	if err != nil {
+		return nil, err
+	}
+
+	var results []SearchResult
+	for _, partial := range partials {
+		results = append(results, partial...)
+	}
+
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].Title < results[j].Title
+	})
+	return results, nil
+}
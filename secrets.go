@@ -0,0 +1,298 @@
+package learn_golang_gorm
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var ErrCredentialsNotFound = errors.New("credentials not found in secret")
+
+// CredentialSource fetches the database user and password from where the
+// secret is kept, unlike a CredentialProvider it is only asked periodically.
+type CredentialSource interface {
+	Fetch(ctx context.Context) (Credentials, error)
+}
+
+type EnvCredentialSource struct {
+	UserVar     string
+	PasswordVar string
+}
+
+func (s EnvCredentialSource) Fetch(ctx context.Context) (Credentials, error) {
+	password, ok := os.LookupEnv(s.PasswordVar)
+	if !ok {
+		return Credentials{}, ErrCredentialsNotFound
+	}
+	return Credentials{User: os.Getenv(s.UserVar), Password: password}, nil
+}
+
+type secretFields struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+func (f secretFields) credentials() (Credentials, error) {
+	if f.Username == "" || f.Password == "" {
+		return Credentials{}, ErrCredentialsNotFound
+	}
+	return Credentials{User: f.Username, Password: f.Password}, nil
+}
+
+func httpClientOrDefault(client *http.Client) *http.Client {
+	if client == nil {
+		return http.DefaultClient
+	}
+	return client
+}
+
+func readSecretResponse(response *http.Response, dest interface{}) error {
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return err
+	}
+	if response.StatusCode != http.StatusOK {
+		return fmt.Errorf("secret request failed with %s: %s", response.Status, strings.TrimSpace(string(body)))
+	}
+	return json.Unmarshal(body, dest)
+}
+
+// VaultCredentialSource reads username and password from a Vault path, both
+// KV version 2 secrets and dynamic database credentials are supported. The
+// lease duration of dynamic credentials becomes their expiry.
+type VaultCredentialSource struct {
+	Address string
+	Token   string
+	Path    string
+	Client  *http.Client
+}
+
+func (s VaultCredentialSource) Fetch(ctx context.Context) (Credentials, error) {
+	url := strings.TrimSuffix(s.Address, "/") + "/v1/" + strings.TrimPrefix(s.Path, "/")
+	request, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return Credentials{}, err
+	}
+	request.Header.Set("X-Vault-Token", s.Token)
+
+	response, err := httpClientOrDefault(s.Client).Do(request)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var secret struct {
+		LeaseDuration int64 `json:"lease_duration"`
+		Data          struct {
+			secretFields
+			Data *secretFields `json:"data"`
+		} `json:"data"`
+	}
+	err = readSecretResponse(response, &secret)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	fields := secret.Data.secretFields
+	if secret.Data.Data != nil {
+		fields = *secret.Data.Data
+	}
+	credentials, err := fields.credentials()
+	if err != nil {
+		return Credentials{}, err
+	}
+	if secret.LeaseDuration > 0 {
+		credentials.ExpiresAt = time.Now().Add(time.Duration(secret.LeaseDuration) * time.Second)
+	}
+	return credentials, nil
+}
+
+// AWSSecretsManagerSource reads a secret whose value is the JSON document
+// {"username": ..., "password": ...}, as stored by RDS managed rotation.
+type AWSSecretsManagerSource struct {
+	SecretID        string
+	Region          string
+	Endpoint        string
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	Client          *http.Client
+	Now             func() time.Time
+}
+
+func NewAWSSecretsManagerSource(secretID string, region string) (*AWSSecretsManagerSource, error) {
+	source := &AWSSecretsManagerSource{
+		SecretID:        secretID,
+		Region:          region,
+		Endpoint:        "https://secretsmanager." + region + ".amazonaws.com",
+		AccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
+		SecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
+		SessionToken:    os.Getenv("AWS_SESSION_TOKEN"),
+		Now:             time.Now,
+	}
+	if source.AccessKeyID == "" || source.SecretAccessKey == "" {
+		return nil, ErrMissingAWSCredentials
+	}
+	return source, nil
+}
+
+// sign adds a Signature Version 4 Authorization header to request.
+func (s *AWSSecretsManagerSource) sign(request *http.Request, body []byte) {
+	now := s.Now().UTC()
+	date := now.Format("20060102")
+	amzDate := now.Format("20060102T150405Z")
+	scope := date + "/" + s.Region + "/secretsmanager/aws4_request"
+
+	request.Header.Set("X-Amz-Date", amzDate)
+	if s.SessionToken != "" {
+		request.Header.Set("X-Amz-Security-Token", s.SessionToken)
+	}
+
+	headers := []string{"content-type", "host", "x-amz-date", "x-amz-target"}
+	if s.SessionToken != "" {
+		headers = append(headers, "x-amz-security-token")
+	}
+	var canonicalHeaders strings.Builder
+	for _, header := range headers {
+		value := request.Header.Get(header)
+		if header == "host" {
+			value = request.URL.Host
+		}
+		canonicalHeaders.WriteString(header + ":" + strings.TrimSpace(value) + "\n")
+	}
+	signedHeaders := strings.Join(headers, ";")
+
+	bodyHash := sha256.Sum256(body)
+	canonicalRequest := strings.Join([]string{
+		request.Method, "/", "", canonicalHeaders.String(), signedHeaders, hex.EncodeToString(bodyHash[:]),
+	}, "\n")
+	requestHash := sha256.Sum256([]byte(canonicalRequest))
+	stringToSign := strings.Join([]string{
+		"AWS4-HMAC-SHA256", amzDate, scope, hex.EncodeToString(requestHash[:]),
+	}, "\n")
+
+	key := awsSigningKey(s.SecretAccessKey, date, s.Region, "secretsmanager")
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	request.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential="+s.AccessKeyID+"/"+scope+
+		", SignedHeaders="+signedHeaders+", Signature="+signature)
+}
+
+func (s *AWSSecretsManagerSource) Fetch(ctx context.Context) (Credentials, error) {
+	body, err := json.Marshal(map[string]string{"SecretId": s.SecretID})
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(s.Endpoint, "/")+"/", bytes.NewReader(body))
+	if err != nil {
+		return Credentials{}, err
+	}
+	request.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	request.Header.Set("X-Amz-Target", "secretsmanager.GetSecretValue")
+	s.sign(request, body)
+
+	response, err := httpClientOrDefault(s.Client).Do(request)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var secret struct {
+		SecretString string `json:"SecretString"`
+	}
+	err = readSecretResponse(response, &secret)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	var fields secretFields
+	err = json.Unmarshal([]byte(secret.SecretString), &fields)
+	if err != nil {
+		return Credentials{}, err
+	}
+	return fields.credentials()
+}
+
+// RotatingCredentials serves the last credentials fetched from Source to
+// every new connection. Run refreshes them periodically and calls OnRotate
+// when they changed, connections opened with the old credentials keep
+// running their queries and are closed once they are back in the pool.
+type RotatingCredentials struct {
+	Source   CredentialSource
+	OnRotate func()
+	OnError  func(err error)
+
+	mutex   sync.RWMutex
+	current Credentials
+	loaded  bool
+}
+
+func NewRotatingCredentials(source CredentialSource) *RotatingCredentials {
+	return &RotatingCredentials{Source: source}
+}
+
+func (c *RotatingCredentials) Credentials(ctx context.Context, host string) (Credentials, error) {
+	c.mutex.RLock()
+	current, loaded := c.current, c.loaded
+	c.mutex.RUnlock()
+	if loaded {
+		return current, nil
+	}
+
+	err := c.Refresh(ctx)
+	if err != nil {
+		return Credentials{}, err
+	}
+
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return c.current, nil
+}
+
+func (c *RotatingCredentials) Refresh(ctx context.Context) error {
+	credentials, err := c.Source.Fetch(ctx)
+	if err != nil {
+		return err
+	}
+
+	c.mutex.Lock()
+	rotated := c.loaded && (credentials.User != c.current.User || credentials.Password != c.current.Password)
+	c.current = credentials
+	c.loaded = true
+	c.mutex.Unlock()
+
+	if rotated && c.OnRotate != nil {
+		c.OnRotate()
+	}
+	return nil
+}
+
+// Run refreshes every interval until ctx is done, failed refreshes keep the
+// previous credentials.
+func (c *RotatingCredentials) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := c.Refresh(ctx)
+		if err != nil && c.OnError != nil {
+			c.OnError(err)
+		}
+	}
+}
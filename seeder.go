@@ -0,0 +1,195 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Seed sets, a profile lists the ones its environment gets.
+const (
+	SeedSetMinimal  = "minimal"
+	SeedSetDemo     = "demo"
+	SeedSetLoadTest = "load-test"
+)
+
+var (
+	ErrUnknownSeeder = errors.New("unknown seeder")
+	ErrSeederCycle   = errors.New("seeders depend on each other in a cycle")
+)
+
+// Seeder is a named Seed tagged with the seed sets it belongs to. The
+// seeders it DependsOn run before it, whatever their sets. Seeders run
+// again on every provisioning, so they skip rows that already exist.
+type Seeder struct {
+	Name      string
+	Sets      []string
+	DependsOn []string
+	Run       Seed
+}
+
+var seeders = map[string]Seeder{}
+
+// RegisterSeeder adds s to the seeders selected by SelectSeeders, names
+// must be unique.
+func RegisterSeeder(s Seeder) {
+	if _, ok := seeders[s.Name]; ok {
+		panic(fmt.Sprintf("seeder %s is registered twice", s.Name))
+	}
+	seeders[s.Name] = s
+}
+
+// SelectSeeders returns the seeders of the sets, or only the named ones
+// when only is not empty, together with their dependencies, each after the
+// seeders it depends on.
+func SelectSeeders(sets []string, only []string) ([]Seeder, error) {
+	var names []string
+	if len(only) > 0 {
+		names = only
+	} else {
+		for name, seeder := range seeders {
+			for _, set := range seeder.Sets {
+				if contains(sets, set) {
+					names = append(names, name)
+					break
+				}
+			}
+		}
+	}
+	sort.Strings(names)
+
+	var selected []Seeder
+	state := map[string]int{}
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch state[name] {
+		case 1:
+			return fmt.Errorf("%w: %s", ErrSeederCycle, name)
+		case 2:
+			return nil
+		}
+		seeder, ok := seeders[name]
+		if !ok {
+			return fmt.Errorf("%w: %s", ErrUnknownSeeder, name)
+		}
+
+		state[name] = 1
+		for _, dependency := range seeder.DependsOn {
+			err := visit(dependency)
+			if err != nil {
+				return err
+			}
+		}
+		state[name] = 2
+		selected = append(selected, seeder)
+		return nil
+	}
+	for _, name := range names {
+		err := visit(name)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return selected, nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
+
+// RunSeeders runs the seeders SelectSeeders picks, stopping at the first
+// failing one.
+func RunSeeders(ctx context.Context, db *gorm.DB, sets []string, only []string) error {
+	selected, err := SelectSeeders(sets, only)
+	if err != nil {
+		return err
+	}
+	db = db.WithContext(ctx)
+	for _, seeder := range selected {
+		err = seeder.Run(db)
+		if err != nil {
+			return fmt.Errorf("seeder %s: %w", seeder.Name, err)
+		}
+	}
+	return nil
+}
+
+// SeedSets returns a Seed running the seeders of sets, to provision a
+// namespace with, e.g. SeedSets(config.SeedSets...).
+func SeedSets(sets ...string) Seed {
+	return func(db *gorm.DB) error {
+		return RunSeeders(db.Statement.Context, db, sets, nil)
+	}
+}
+
+func createMissing(db *gorm.DB, rows interface{}) error {
+	return db.Clauses(clause.OnConflict{DoNothing: true}).Create(rows).Error
+}
+
+func init() {
+	RegisterSeeder(Seeder{
+		Name: "settings",
+		Sets: []string{SeedSetMinimal},
+		Run: func(db *gorm.DB) error {
+			return createMissing(db, &Setting{Key: SettingMaintenance, Value: "off"})
+		},
+	})
+	RegisterSeeder(Seeder{
+		Name: "demo-users",
+		Sets: []string{SeedSetDemo},
+		Run: func(db *gorm.DB) error {
+			return createMissing(db, &[]User{
+				{ID: "demo-1", Password: "rahasia", Name: Name{FirstName: "Lingga", LastName: "Pratama"}, Email: "lingga@example.com"},
+				{ID: "demo-2", Password: "rahasia", Name: Name{FirstName: "Wahyu", LastName: "Saputra"}, Email: "wahyu@example.com"},
+				{ID: "demo-3", Password: "rahasia", Name: Name{FirstName: "Siti", LastName: "Lestari"}, Email: "siti@example.com"},
+			})
+		},
+	})
+	RegisterSeeder(Seeder{
+		Name:      "demo-wallets",
+		Sets:      []string{SeedSetDemo},
+		DependsOn: []string{"demo-users"},
+		Run: func(db *gorm.DB) error {
+			return createMissing(db, &[]Wallet{
+				{ID: "demo-wallet-1", UserID: "demo-1", Balance: 1000000},
+				{ID: "demo-wallet-2", UserID: "demo-2", Balance: 250000},
+			})
+		},
+	})
+	RegisterSeeder(Seeder{
+		Name: "demo-products",
+		Sets: []string{SeedSetDemo},
+		Run: func(db *gorm.DB) error {
+			return createMissing(db, &[]Product{
+				{ID: "demo-product-1", Name: "Nusantara Laptop", Category: "computers", Price: 12000000, Stock: 10},
+				{ID: "demo-product-2", Name: "Garuda Keyboard", Category: "accessories", Price: 750000, Stock: 50},
+				{ID: "demo-product-3", Name: "Merapi Monitor", Category: "computers", Price: 3500000, Stock: 20},
+			})
+		},
+	})
+	RegisterSeeder(Seeder{
+		Name:      "demo-todos",
+		Sets:      []string{SeedSetDemo},
+		DependsOn: []string{"demo-users"},
+		Run: func(db *gorm.DB) error {
+			var count int64
+			err := db.Model(&Todo{}).Where("user_id = ?", "demo-1").Count(&count).Error
+			if err != nil || count > 0 {
+				return err
+			}
+			return db.Create(&[]Todo{
+				{UserId: "demo-1", Title: "Buy groceries", Description: "Demo todo"},
+				{UserId: "demo-1", Title: "Write report", Description: "Demo todo"},
+			}).Error
+		},
+	})
+}
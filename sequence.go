@@ -0,0 +1,94 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidBlockSize = errors.New("block size must be greater than zero")
+
+type Sequence struct {
+	Name      string    `gorm:"primary_key;column:name"`
+	NextValue int64     `gorm:"column:next_value"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (s *Sequence) TableName() string {
+	return "sequences"
+}
+
+// AllocateIDs reserves n consecutive ids from the named sequence and returns
+// the first one, the reserved block is [first, first+n).
+func AllocateIDs(db *gorm.DB, name string, n int64) (int64, error) {
+	if n <= 0 {
+		return 0, ErrInvalidBlockSize
+	}
+
+	var first int64
+	err := db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Sequence{Name: name, NextValue: 1}).Error
+		if err != nil {
+			return err
+		}
+
+		result := tx.Model(&Sequence{}).Where("name = ?", name).
+			Update("next_value", gorm.Expr("next_value + ?", n))
+		if result.Error != nil {
+			return result.Error
+		}
+
+		var sequence Sequence
+		err = tx.Take(&sequence, "name = ?", name).Error
+		if err != nil {
+			return err
+		}
+
+		first = sequence.NextValue - n
+		return nil
+	})
+
+	return first, err
+}
+
+type SequenceAllocator struct {
+	db        *gorm.DB
+	name      string
+	blockSize int64
+
+	mutex sync.Mutex
+	next  int64
+	end   int64
+}
+
+func NewSequenceAllocator(db *gorm.DB, name string, blockSize int64) *SequenceAllocator {
+	return &SequenceAllocator{
+		db:        db,
+		name:      name,
+		blockSize: blockSize,
+	}
+}
+
+// Next hands out ids from the locally cached block and only goes to the
+// database once the block is exhausted.
+func (a *SequenceAllocator) Next() (int64, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.next >= a.end {
+		first, err := AllocateIDs(a.db, a.name, a.blockSize)
+		if err != nil {
+			return 0, err
+		}
+		a.next = first
+		a.end = first + a.blockSize
+	}
+
+	id := a.next
+	a.next++
+	return id, nil
+}
@@ -0,0 +1,145 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+var ErrSessionExpired = errors.New("session is expired or revoked")
+
+// Session is a login of a user on one device. Only the SHA-256 of the token
+// is stored, so a leaked table does not leak usable tokens.
+type Session struct {
+	ID         int64      `gorm:"primary_key;column:id;autoIncrement"`
+	UserID     string     `gorm:"column:user_id;index"`
+	TokenHash  string     `gorm:"column:token_hash;type:char(64);uniqueIndex"`
+	DeviceName string     `gorm:"column:device_name"`
+	UserAgent  string     `gorm:"column:user_agent"`
+	LastIP     INET       `gorm:"column:last_ip;type:varbinary(16)"`
+	LastSeenAt time.Time  `gorm:"column:last_seen_at"`
+	ExpiresAt  time.Time  `gorm:"column:expires_at;index"`
+	RevokedAt  *time.Time `gorm:"column:revoked_at"`
+	CreatedAt  time.Time  `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (s *Session) TableName() string {
+	return "sessions"
+}
+
+// SessionStore issues sessions valid for TTL after their last use. To
+// spare a write per request, uses within RefreshInterval of the last
+// recorded one do not extend the session. With WriteBehind the extensions
+// are queued there instead of written.
+type SessionStore struct {
+	DB              *gorm.DB
+	TTL             time.Duration
+	RefreshInterval time.Duration
+	WriteBehind     *WriteBehind
+	MaxRows         int
+}
+
+func NewSessionStore(db *gorm.DB) *SessionStore {
+	return &SessionStore{
+		DB:              db,
+		TTL:             30 * 24 * time.Hour,
+		RefreshInterval: time.Minute,
+	}
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken(db *gorm.DB) (string, error) {
+	token := make([]byte, 32)
+	_, err := io.ReadFull(Entropy(db), token)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(token), nil
+}
+
+// Create starts a session for userID and returns its token, the address and
+// user agent are taken from the client info of ctx.
+func (s *SessionStore) Create(ctx context.Context, userID string, deviceName string) (string, Session, error) {
+	token, err := newToken(s.DB)
+	if err != nil {
+		return "", Session{}, err
+	}
+
+	now := Now(s.DB)
+	session := Session{
+		UserID:     userID,
+		TokenHash:  hashToken(token),
+		DeviceName: deviceName,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(s.TTL),
+	}
+	if info, ok := ClientInfoFromContext(ctx); ok {
+		session.LastIP = info.IP
+		session.UserAgent = info.UserAgent
+	}
+	return token, session, s.DB.WithContext(ctx).Create(&session).Error
+}
+
+// Validate returns the session of token and slides its expiration.
+func (s *SessionStore) Validate(ctx context.Context, token string) (Session, error) {
+	db := s.DB.WithContext(ctx)
+	now := Now(s.DB)
+
+	var session Session
+	err := db.Where("token_hash = ? AND revoked_at IS NULL AND expires_at > ?", hashToken(token), now).
+		Take(&session).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return session, ErrSessionExpired
+	}
+	if err != nil || now.Sub(session.LastSeenAt) < s.RefreshInterval {
+		return session, err
+	}
+
+	session.LastSeenAt = now
+	session.ExpiresAt = now.Add(s.TTL)
+	if info, ok := ClientInfoFromContext(ctx); ok && info.IP.IsValid() {
+		session.LastIP = info.IP
+	}
+	if s.WriteBehind != nil {
+		return session, s.WriteBehind.Update("sessions", map[string]interface{}{"id": session.ID}, map[string]interface{}{
+			"last_seen_at": session.LastSeenAt, "expires_at": session.ExpiresAt, "last_ip": session.LastIP,
+		})
+	}
+	err = db.Model(&session).Select("last_seen_at", "expires_at", "last_ip").Updates(&session).Error
+	return session, err
+}
+
+func (s *SessionStore) Revoke(ctx context.Context, token string) error {
+	return s.DB.WithContext(ctx).Model(&Session{}).
+		Where("token_hash = ? AND revoked_at IS NULL", hashToken(token)).
+		Update("revoked_at", Now(s.DB)).Error
+}
+
+// RevokeAll logs the user out everywhere, except from the session of
+// keepToken when it is not empty.
+func (s *SessionStore) RevokeAll(ctx context.Context, userID string, keepToken string) (int64, error) {
+	query := s.DB.WithContext(ctx).Model(&Session{}).Where("user_id = ? AND revoked_at IS NULL", userID)
+	if keepToken != "" {
+		query = query.Where("token_hash <> ?", hashToken(keepToken))
+	}
+	result := query.Update("revoked_at", Now(s.DB))
+	return result.RowsAffected, result.Error
+}
+
+// Active lists the sessions of a user that can still be used, most recently
+// seen first, at most MaxRows of them.
+func (s *SessionStore) Active(ctx context.Context, userID string) (Limited[Session], error) {
+	return FindLimited[Session](s.DB.WithContext(ctx).
+		Where("user_id = ? AND revoked_at IS NULL AND expires_at > ?", userID, Now(s.DB)).
+		Order("last_seen_at desc"), s.MaxRows)
+}
@@ -0,0 +1,48 @@
+package learn_golang_gorm
+
+import (
+	"context"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/logger"
+)
+
+// SessionOptions are the gorm settings of the queries of one request. They
+// replace the ones of gorm.Config as a whole, a false field turns the
+// setting off even when the db has it on.
+type SessionOptions struct {
+	QueryFields          bool
+	FullSaveAssociations bool
+	DryRun               bool
+	// Logger keeps the logger of the db when nil.
+	Logger logger.Interface
+}
+
+type sessionOptionsKey struct{}
+
+// WithSessionOptions returns a context whose queries made through SessionDB
+// use opts, e.g. a request handler turning on DryRun for a preview.
+func WithSessionOptions(ctx context.Context, opts SessionOptions) context.Context {
+	return context.WithValue(ctx, sessionOptionsKey{}, opts)
+}
+
+func SessionOptionsFromContext(ctx context.Context) (SessionOptions, bool) {
+	opts, ok := ctx.Value(sessionOptionsKey{}).(SessionOptions)
+	return opts, ok
+}
+
+// SessionDB is db bound to ctx with the session options of ctx, without
+// any it is db.WithContext(ctx).
+func SessionDB(ctx context.Context, db *gorm.DB) *gorm.DB {
+	opts, ok := SessionOptionsFromContext(ctx)
+	if !ok {
+		return db.WithContext(ctx)
+	}
+
+	tx := db.Session(&gorm.Session{Context: ctx, Logger: opts.Logger})
+	// the session has its own copy of the config
+	tx.Config.QueryFields = opts.QueryFields
+	tx.Config.FullSaveAssociations = opts.FullSaveAssociations
+	tx.Config.DryRun = opts.DryRun
+	return tx
+}
@@ -0,0 +1,83 @@
+package learn_golang_gorm
+
+import (
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// SetQuery combines queries with UNION, INTERSECT and EXCEPT, left to
+// right. Every query selects Columns in the same order, so rows of a live
+// table and of its archive line up.
+type SetQuery struct {
+	Columns []string
+
+	sql     string
+	queries []interface{}
+}
+
+// Combine starts a SetQuery from first, columns are the ones every query
+// selects, the ones of first when empty.
+func Combine(first *gorm.DB, columns ...string) *SetQuery {
+	q := &SetQuery{Columns: columns, sql: "(?)"}
+	q.queries = append(q.queries, q.selecting(first))
+	return q
+}
+
+func (q *SetQuery) selecting(db *gorm.DB) *gorm.DB {
+	if len(q.Columns) == 0 {
+		return db
+	}
+	return db.Select(q.Columns)
+}
+
+func (q *SetQuery) combine(operator string, other *gorm.DB) *SetQuery {
+	if len(q.queries) > 1 {
+		q.sql = "(" + q.sql + ")"
+	}
+	q.sql += " " + operator + " (?)"
+	q.queries = append(q.queries, q.selecting(other))
+	return q
+}
+
+func (q *SetQuery) Union(other *gorm.DB) *SetQuery {
+	return q.combine("union", other)
+}
+
+func (q *SetQuery) UnionAll(other *gorm.DB) *SetQuery {
+	return q.combine("union all", other)
+}
+
+func (q *SetQuery) Intersect(other *gorm.DB) *SetQuery {
+	return q.combine("intersect", other)
+}
+
+func (q *SetQuery) Except(other *gorm.DB) *SetQuery {
+	return q.combine("except", other)
+}
+
+// Query selects from the combined rows, order and pagination on it apply
+// to the whole result, e.g. q.Query().Order("id").Scopes(Paginate(2, 20)).
+// Soft deletes are filtered by each query, not again on the result.
+func (q *SetQuery) Query() *gorm.DB {
+	first := q.queries[0].(*gorm.DB)
+	return first.Session(&gorm.Session{NewDB: true}).Unscoped().
+		Table("(?) as combined", clause.Expr{SQL: q.sql, Vars: q.queries})
+}
+
+// UnionAll combines queries selecting columns, keeping duplicates, e.g. the
+// live todos of a user with the archived ones.
+func UnionAll(columns []string, first *gorm.DB, rest ...*gorm.DB) *gorm.DB {
+	q := Combine(first, columns...)
+	for _, query := range rest {
+		q.UnionAll(query)
+	}
+	return q.Query()
+}
+
+func Union(columns []string, first *gorm.DB, rest ...*gorm.DB) *gorm.DB {
+	q := Combine(first, columns...)
+	for _, query := range rest {
+		q.Union(query)
+	}
+	return q.Query()
+}
@@ -0,0 +1,364 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+const (
+	OperationRead   = "read"
+	OperationWrite  = "write"
+	OperationReport = "report"
+)
+
+const (
+	IndicatorSuccess = "success"
+	IndicatorLatency = "latency"
+)
+
+type operationClassKey struct{}
+
+// WithOperationClass counts the statements run with ctx in class instead
+// of the read or write class of their operation.
+func WithOperationClass(ctx context.Context, class string) context.Context {
+	return context.WithValue(ctx, operationClassKey{}, class)
+}
+
+func OperationClassFromContext(ctx context.Context) (string, bool) {
+	class, ok := ctx.Value(operationClassKey{}).(string)
+	return class, ok
+}
+
+// Objective is the service level of a class of statements over a rolling
+// Window: Success of them without error and LatencyTarget of them within
+// Latency, e.g. 0.999 and 0.99. Resolution is the width of the buckets the
+// window is counted in, a minute when zero.
+type Objective struct {
+	Class         string
+	Success       float64
+	Latency       time.Duration
+	LatencyTarget float64
+	Window        time.Duration
+	Resolution    time.Duration
+}
+
+var DefaultObjectives = []Objective{
+	{Class: OperationRead, Success: 0.999, Latency: 100 * time.Millisecond, LatencyTarget: 0.99, Window: 7 * 24 * time.Hour},
+	{Class: OperationWrite, Success: 0.999, Latency: 250 * time.Millisecond, LatencyTarget: 0.99, Window: 7 * 24 * time.Hour},
+	{Class: OperationReport, Success: 0.99, Latency: 30 * time.Second, LatencyTarget: 0.95, Window: 7 * 24 * time.Hour},
+}
+
+// BurnRateRule fires when the error budget is spent Threshold times faster
+// than it lasts the window, measured over Lookback.
+type BurnRateRule struct {
+	Lookback  time.Duration
+	Threshold float64
+}
+
+// DefaultBurnRateRules page on a fast burn and warn on a slow one.
+var DefaultBurnRateRules = []BurnRateRule{
+	{Lookback: time.Hour, Threshold: 14.4},
+	{Lookback: 6 * time.Hour, Threshold: 6},
+}
+
+// BudgetAlert is a rule firing for an indicator of a class.
+type BudgetAlert struct {
+	Class     string
+	Indicator string
+	Rule      BurnRateRule
+	BurnRate  float64
+}
+
+// SLOStatus is a class over its whole window, the budgets are the part of
+// the error budget left, negative once overspent.
+type SLOStatus struct {
+	Class         string
+	Total         int64
+	Failed        int64
+	Slow          int64
+	SuccessBudget float64
+	LatencyBudget float64
+}
+
+type sloBucket struct {
+	start  int64
+	total  int64
+	failed int64
+	slow   int64
+}
+
+type sloWindow struct {
+	objective Objective
+	buckets   []sloBucket
+}
+
+// add counts into the bucket of now, reusing the slot of a bucket fallen
+// out of the window.
+func (w *sloWindow) add(now time.Time, failed, slow bool) {
+	start := now.UnixNano() / int64(w.objective.Resolution)
+	bucket := &w.buckets[start%int64(len(w.buckets))]
+	if bucket.start != start {
+		*bucket = sloBucket{start: start}
+	}
+	bucket.total++
+	if failed {
+		bucket.failed++
+	}
+	if slow {
+		bucket.slow++
+	}
+}
+
+func (w *sloWindow) sum(now time.Time, lookback time.Duration) sloBucket {
+	end := now.UnixNano() / int64(w.objective.Resolution)
+	first := end - int64(lookback/w.objective.Resolution) + 1
+	var sum sloBucket
+	for _, bucket := range w.buckets {
+		if bucket.start >= first && bucket.start <= end {
+			sum.total += bucket.total
+			sum.failed += bucket.failed
+			sum.slow += bucket.slow
+		}
+	}
+	return sum
+}
+
+// SLOTracker counts the statements of each class against its objective,
+// see Track.
+type SLOTracker struct {
+	Rules   []BurnRateRule
+	Clock   Clock
+	OnAlert func(alert BudgetAlert)
+
+	mutex   sync.Mutex
+	windows map[string]*sloWindow
+	firing  map[BudgetAlert]bool
+}
+
+func NewSLOTracker(objectives ...Objective) *SLOTracker {
+	t := &SLOTracker{Rules: DefaultBurnRateRules, windows: map[string]*sloWindow{}, firing: map[BudgetAlert]bool{}}
+	for _, objective := range objectives {
+		if objective.Resolution <= 0 {
+			objective.Resolution = time.Minute
+		}
+		size := int(objective.Window / objective.Resolution)
+		if size < 1 {
+			size = 1
+		}
+		t.windows[objective.Class] = &sloWindow{objective: objective, buckets: make([]sloBucket, size)}
+	}
+	return t
+}
+
+func (t *SLOTracker) now() time.Time {
+	if t.Clock == nil {
+		return SystemClock.Now()
+	}
+	return t.Clock.Now()
+}
+
+// Record counts a statement of class, a class without objective is
+// ignored. Not found is a successful answer.
+func (t *SLOTracker) Record(class string, elapsed time.Duration, err error) {
+	failed := err != nil && !errors.Is(err, gorm.ErrRecordNotFound)
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	window, ok := t.windows[class]
+	if ok {
+		window.add(t.now(), failed, elapsed > window.objective.Latency)
+	}
+}
+
+// BurnRate is how many times faster than allowed the budget of the
+// indicator was spent over lookback, 1 spends it exactly in the window.
+func (t *SLOTracker) BurnRate(class, indicator string, lookback time.Duration) float64 {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	window, ok := t.windows[class]
+	if !ok {
+		return 0
+	}
+	return burnRate(window.objective, indicator, window.sum(t.now(), lookback))
+}
+
+func burnRate(objective Objective, indicator string, sum sloBucket) float64 {
+	if sum.total == 0 {
+		return 0
+	}
+	bad, target := sum.failed, objective.Success
+	if indicator == IndicatorLatency {
+		bad, target = sum.slow, objective.LatencyTarget
+	}
+	if target >= 1 {
+		if bad > 0 {
+			return float64(sum.total)
+		}
+		return 0
+	}
+	return float64(bad) / float64(sum.total) / (1 - target)
+}
+
+func (t *SLOTracker) Status(class string) SLOStatus {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	status := SLOStatus{Class: class, SuccessBudget: 1, LatencyBudget: 1}
+	window, ok := t.windows[class]
+	if !ok {
+		return status
+	}
+
+	sum := window.sum(t.now(), window.objective.Window)
+	status.Total, status.Failed, status.Slow = sum.total, sum.failed, sum.slow
+	status.SuccessBudget = 1 - burnRate(window.objective, IndicatorSuccess, sum)
+	status.LatencyBudget = 1 - burnRate(window.objective, IndicatorLatency, sum)
+	return status
+}
+
+// Evaluate returns the alerts firing now and hands the ones that were not
+// firing at the last evaluation to OnAlert.
+func (t *SLOTracker) Evaluate() []BudgetAlert {
+	t.mutex.Lock()
+	now := t.now()
+	var alerts, started []BudgetAlert
+	firing := map[BudgetAlert]bool{}
+	classes := make([]string, 0, len(t.windows))
+	for class := range t.windows {
+		classes = append(classes, class)
+	}
+	sort.Strings(classes)
+	for _, class := range classes {
+		window := t.windows[class]
+		for _, rule := range t.Rules {
+			sum := window.sum(now, rule.Lookback)
+			for _, indicator := range []string{IndicatorSuccess, IndicatorLatency} {
+				rate := burnRate(window.objective, indicator, sum)
+				if rate < rule.Threshold {
+					continue
+				}
+				key := BudgetAlert{Class: class, Indicator: indicator, Rule: rule}
+				firing[key] = true
+				if !t.firing[key] {
+					started = append(started, BudgetAlert{Class: class, Indicator: indicator, Rule: rule, BurnRate: rate})
+				}
+				alerts = append(alerts, BudgetAlert{Class: class, Indicator: indicator, Rule: rule, BurnRate: rate})
+			}
+		}
+	}
+	t.firing = firing
+	t.mutex.Unlock()
+
+	if t.OnAlert != nil {
+		for _, alert := range started {
+			t.OnAlert(alert)
+		}
+	}
+	return alerts
+}
+
+// Watch evaluates the alerts every interval until ctx is done.
+func (t *SLOTracker) Watch(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		t.Evaluate()
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+func startTracking(db *gorm.DB) {
+	db.InstanceSet("slo:begin", time.Now())
+}
+
+// endTracking counts the statement in the class of its context, or in
+// class, raw SQL reading data is a read.
+func (t *SLOTracker) endTracking(operationClass string) func(db *gorm.DB) {
+	return func(db *gorm.DB) {
+		begin, ok := db.InstanceGet("slo:begin")
+		if !ok {
+			return
+		}
+		class := operationClass
+		if contextClass, ok := OperationClassFromContext(db.Statement.Context); ok {
+			class = contextClass
+		} else if class == OperationWrite && isReadSQL(db.Statement.SQL.String()) {
+			class = OperationRead
+		}
+		t.Record(class, time.Since(begin.(time.Time)), db.Error)
+	}
+}
+
+func isReadSQL(sql string) bool {
+	fields := strings.Fields(sql)
+	if len(fields) == 0 {
+		return false
+	}
+	switch strings.ToLower(fields[0]) {
+	case "select", "with", "show", "explain":
+		return true
+	}
+	return false
+}
+
+// Track counts every statement on db, creates, updates, deletes and raw
+// SQL as writes and queries as reads, unless the context has a class.
+func (t *SLOTracker) Track(db *gorm.DB) error {
+	callbacks := db.Callback()
+	err := callbacks.Create().Before("*").Register("slo:start", startTracking)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Create().After("*").Register("slo:end", t.endTracking(OperationWrite))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Query().Before("*").Register("slo:start", startTracking)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Query().After("*").Register("slo:end", t.endTracking(OperationRead))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().Before("*").Register("slo:start", startTracking)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Update().After("*").Register("slo:end", t.endTracking(OperationWrite))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Delete().Before("*").Register("slo:start", startTracking)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Delete().After("*").Register("slo:end", t.endTracking(OperationWrite))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Row().Before("*").Register("slo:start", startTracking)
+	if err != nil {
+		return err
+	}
+	err = callbacks.Row().After("*").Register("slo:end", t.endTracking(OperationRead))
+	if err != nil {
+		return err
+	}
+	err = callbacks.Raw().Before("*").Register("slo:start", startTracking)
+	if err != nil {
+		return err
+	}
+	return callbacks.Raw().After("*").Register("slo:end", t.endTracking(OperationWrite))
+}
@@ -0,0 +1,100 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"gorm.io/gorm/clause"
+)
+
+var ErrUnknownSortField = fmt.Errorf("%w: unknown sort field", ErrInvalidRequest)
+
+type SortFieldError struct {
+	Field   string
+	Allowed []string
+}
+
+func (e *SortFieldError) Error() string {
+	return fmt.Sprintf("unknown sort field %q, sort by one of %s", e.Field, strings.Join(e.Allowed, ", "))
+}
+
+func (e *SortFieldError) Unwrap() error {
+	return ErrUnknownSortField
+}
+
+// SortFields is the sort whitelist of a model, Columns maps the names the
+// API sorts by to columns and Key breaks ties so pages stay stable.
+type SortFields struct {
+	Columns map[string]string
+	Key     string
+}
+
+var (
+	UserSortFields = SortFields{
+		Columns: map[string]string{
+			"name":       "first_name",
+			"email":      "email",
+			"created_at": "created_at",
+		},
+		Key: "id",
+	}
+	ProductSortFields = SortFields{
+		Columns: map[string]string{
+			"name":       "name",
+			"price":      "price",
+			"rating":     "average_rating",
+			"likes":      "likes_count",
+			"created_at": "created_at",
+		},
+		Key: "id",
+	}
+	TodoSortFields = SortFields{
+		Columns: map[string]string{
+			"title":        "title",
+			"created_at":   "created_at",
+			"completed_at": "completed_at",
+		},
+		Key: "id",
+	}
+)
+
+func (f SortFields) allowed() []string {
+	names := make([]string, 0, len(f.Columns))
+	for name := range f.Columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseSort turns a sort parameter like "name,-created_at", a minus for
+// descending, into an order by the whitelisted columns of fields ending with
+// its Key. Use it with db.Clauses.
+func ParseSort(value string, fields SortFields) (clause.OrderBy, error) {
+	var order clause.OrderBy
+	seen := map[string]bool{}
+	for _, name := range strings.Split(value, ",") {
+		name = strings.TrimSpace(name)
+		desc := strings.HasPrefix(name, "-")
+		name = strings.TrimLeft(name, "+-")
+		if name == "" {
+			continue
+		}
+
+		column, ok := fields.Columns[name]
+		if !ok {
+			return clause.OrderBy{}, &SortFieldError{Field: name, Allowed: fields.allowed()}
+		}
+		if seen[column] {
+			continue
+		}
+		seen[column] = true
+		order.Columns = append(order.Columns, clause.OrderByColumn{Column: clause.Column{Name: column}, Desc: desc})
+	}
+
+	if fields.Key != "" && !seen[fields.Key] {
+		order.Columns = append(order.Columns, clause.OrderByColumn{Column: clause.Column{Name: fields.Key}})
+	}
+	return order, nil
+}
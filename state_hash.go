@@ -0,0 +1,140 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"sort"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// TableHashes hashes the contents of each table: its columns and its rows
+// in primary key order, timestamps in UTC, so equal contents always hash
+// the same however the connection is configured. Without tables it hashes
+// the tables of the registered models.
+func TableHashes(ctx context.Context, db *gorm.DB, tables ...string) (map[string]string, error) {
+	db = db.WithContext(ctx)
+	if len(tables) == 0 {
+		var err error
+		tables, err = ModelTables(db)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	hashes := make(map[string]string, len(tables))
+	for _, table := range tables {
+		sum, err := tableHash(db, table)
+		if err != nil {
+			return nil, fmt.Errorf("hash %s: %w", table, err)
+		}
+		hashes[table] = sum
+	}
+	return hashes, nil
+}
+
+// StateHash combines the TableHashes of tables into one, compare it before
+// and after an operation to assert that it changed nothing else.
+func StateHash(ctx context.Context, db *gorm.DB, tables ...string) (string, error) {
+	hashes, err := TableHashes(ctx, db, tables...)
+	if err != nil {
+		return "", err
+	}
+
+	names := make([]string, 0, len(hashes))
+	for table := range hashes {
+		names = append(names, table)
+	}
+	sort.Strings(names)
+
+	state := sha256.New()
+	for _, table := range names {
+		writeHashValue(state, []byte(table))
+		writeHashValue(state, []byte(hashes[table]))
+	}
+	return hex.EncodeToString(state.Sum(nil)), nil
+}
+
+func tableHash(db *gorm.DB, table string) (string, error) {
+	var columns, order []string
+	err := db.Raw(`SELECT column_name FROM information_schema.columns WHERE table_schema = DATABASE()
+		AND table_name = ? ORDER BY ordinal_position`, table).Scan(&columns).Error
+	if err == nil {
+		err = db.Raw(`SELECT column_name FROM information_schema.key_column_usage WHERE table_schema = DATABASE()
+			AND table_name = ? AND constraint_name = 'PRIMARY' ORDER BY ordinal_position`, table).Scan(&order).Error
+	}
+	if err != nil {
+		return "", err
+	}
+	if len(order) == 0 {
+		// without primary key the rows are ordered by all their columns
+		order = columns
+	}
+
+	query := db.Table(table).Select(quoteColumns(db, columns))
+	for _, column := range order {
+		query = query.Order(clause.OrderByColumn{Column: clause.Column{Name: column}})
+	}
+	rows, err := query.Rows()
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	state := sha256.New()
+	for _, column := range columns {
+		writeHashValue(state, []byte(column))
+	}
+
+	values := make([]interface{}, len(columns))
+	dest := make([]interface{}, len(columns))
+	for i := range values {
+		dest[i] = &values[i]
+	}
+	for rows.Next() {
+		err = rows.Scan(dest...)
+		if err != nil {
+			return "", err
+		}
+		for _, value := range values {
+			writeHashValue(state, normalizeHashValue(value))
+		}
+	}
+	if rows.Err() != nil {
+		return "", rows.Err()
+	}
+	return hex.EncodeToString(state.Sum(nil)), nil
+}
+
+// normalizeHashValue gives NULL a value no column holds, the other values
+// are prefixed with a length when written.
+func normalizeHashValue(value interface{}) []byte {
+	switch value := value.(type) {
+	case nil:
+		return nil
+	case []byte:
+		return append([]byte{'b'}, value...)
+	case time.Time:
+		return []byte("t" + value.UTC().Format(time.RFC3339Nano))
+	default:
+		return []byte(fmt.Sprintf("v%v", value))
+	}
+}
+
+func writeHashValue(state hash.Hash, value []byte) {
+	var length [8]byte
+	if value == nil {
+		binary.BigEndian.PutUint64(length[:], ^uint64(0))
+		state.Write(length[:])
+		return
+	}
+	binary.BigEndian.PutUint64(length[:], uint64(len(value)))
+	state.Write(length[:])
+	state.Write(value)
+}
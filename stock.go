@@ -0,0 +1,71 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"fmt"
+
+	"gorm.io/gorm"
+)
+
+var ErrOutOfStock = errors.New("product is out of stock")
+
+// ReserveStock decrements the stock of every product in quantities, keyed by
+// product id, or none of them when one product does not have enough stock.
+func ReserveStock(db *gorm.DB, quantities map[string]int64) error {
+	ids := make([]string, 0, len(quantities))
+	for id, quantity := range quantities {
+		if quantity <= 0 {
+			return ErrInvalidQuantity
+		}
+		ids = append(ids, id)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var products []Product
+		err := LockRows(tx, &products, ids)
+		if err != nil {
+			return err
+		}
+		if len(products) != len(ids) {
+			return gorm.ErrRecordNotFound
+		}
+
+		for _, product := range products {
+			quantity := quantities[product.ID]
+			if product.Stock < quantity {
+				return fmt.Errorf("%w: %s", ErrOutOfStock, product.ID)
+			}
+
+			err = tx.Model(&Product{}).Where("id = ?", product.ID).
+				Update("stock", gorm.Expr("stock - ?", quantity)).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func ReleaseStock(db *gorm.DB, quantities map[string]int64) error {
+	ids := make([]string, 0, len(quantities))
+	for id := range quantities {
+		ids = append(ids, id)
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var products []Product
+		err := LockRows(tx, &products, ids)
+		if err != nil {
+			return err
+		}
+
+		for _, product := range products {
+			err = tx.Model(&Product{}).Where("id = ?", product.ID).
+				Update("stock", gorm.Expr("stock + ?", quantities[product.ID])).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
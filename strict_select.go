@@ -0,0 +1,99 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"sync/atomic"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrSelectAll = errors.New("query selects every column, select the needed columns or scan into a DTO")
+
+type selectAllKey struct{}
+
+// WithSelectAll lets the queries of ctx read whole rows under StrictSelect,
+// for the exports and admin screens that really need every column.
+func WithSelectAll(ctx context.Context) context.Context {
+	return context.WithValue(ctx, selectAllKey{}, true)
+}
+
+func allowsSelectAll(ctx context.Context) bool {
+	allowed, _ := ctx.Value(selectAllKey{}).(bool)
+	return allowed
+}
+
+// StrictSelect flags the queries on Tables, every table when empty, that
+// fall back to SELECT *. They are counted and reported to OnSelectAll, and
+// fail with ErrSelectAll when Reject is set.
+type StrictSelect struct {
+	Tables      []string
+	Reject      bool
+	OnSelectAll func(table string)
+
+	selectAll atomic.Int64
+}
+
+func NewStrictSelect(tables ...string) *StrictSelect {
+	return &StrictSelect{Tables: tables}
+}
+
+// SelectAll is the number of queries that read whole rows since Enforce.
+func (s *StrictSelect) SelectAll() int64 {
+	return s.selectAll.Load()
+}
+
+func (s *StrictSelect) checks(table string) bool {
+	if len(s.Tables) == 0 {
+		return true
+	}
+	for _, checked := range s.Tables {
+		if checked == table {
+			return true
+		}
+	}
+	return false
+}
+
+// selectsAll mirrors how gorm builds the select: explicit columns, a select
+// clause, QueryFields or a destination that is not the model, a DTO, all
+// list the columns.
+func selectsAll(db *gorm.DB) bool {
+	stmt := db.Statement
+	if stmt.SQL.Len() > 0 || stmt.Schema == nil || len(stmt.Selects) > 0 || len(stmt.Omits) > 0 || db.QueryFields {
+		return false
+	}
+	if _, ok := stmt.Clauses[clause.Select{}.Name()]; ok {
+		return false
+	}
+	if !stmt.ReflectValue.IsValid() {
+		return true
+	}
+
+	destType := stmt.ReflectValue.Type()
+	for destType.Kind() == reflect.Slice || destType.Kind() == reflect.Array || destType.Kind() == reflect.Ptr {
+		destType = destType.Elem()
+	}
+	return destType == stmt.Schema.ModelType
+}
+
+func (s *StrictSelect) check(db *gorm.DB) {
+	if db.Error != nil || allowsSelectAll(db.Statement.Context) || !s.checks(db.Statement.Table) || !selectsAll(db) {
+		return
+	}
+
+	s.selectAll.Add(1)
+	if s.OnSelectAll != nil {
+		s.OnSelectAll(db.Statement.Table)
+	}
+	if s.Reject {
+		_ = db.AddError(ErrSelectAll)
+	}
+}
+
+// Enforce registers the check of every Find, Take, First and preload on db.
+func (s *StrictSelect) Enforce(db *gorm.DB) error {
+	return db.Callback().Query().Before("gorm:query").Register("strict_select:query", s.check)
+}
@@ -0,0 +1,186 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"math"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// TableStat is the size of a table as reported by information_schema. The
+// row count is the optimizer estimate and MySQL caches these values for
+// information_schema_stats_expiry seconds.
+type TableStat struct {
+	Table         string
+	Rows          int64
+	DataBytes     int64
+	IndexBytes    int64
+	AutoIncrement *uint64
+	MaxID         uint64
+}
+
+// Headroom is the share of the auto increment range still unused, 1 for
+// tables without an auto increment column.
+func (s TableStat) Headroom() float64 {
+	if s.AutoIncrement == nil || s.MaxID == 0 {
+		return 1
+	}
+	return 1 - float64(*s.AutoIncrement)/float64(s.MaxID)
+}
+
+type TableStatSnapshot struct {
+	ID            int64     `gorm:"primary_key;column:id;autoIncrement"`
+	Table         string    `gorm:"column:table_name;index:idx_table_stats_table_captured"`
+	Rows          int64     `gorm:"column:row_count"`
+	DataBytes     int64     `gorm:"column:data_bytes"`
+	IndexBytes    int64     `gorm:"column:index_bytes"`
+	AutoIncrement *uint64   `gorm:"column:auto_increment"`
+	CapturedAt    time.Time `gorm:"column:captured_at;index:idx_table_stats_table_captured"`
+}
+
+func (s *TableStatSnapshot) TableName() string {
+	return "table_stats"
+}
+
+type TableGrowth struct {
+	Table       string
+	From        time.Time
+	To          time.Time
+	Rows        int64
+	Bytes       int64
+	RowsPerDay  float64
+	BytesPerDay float64
+}
+
+var autoIncrementLimits = map[string]uint64{
+	"tinyint":   math.MaxInt8,
+	"smallint":  math.MaxInt16,
+	"mediumint": 1<<23 - 1,
+	"int":       math.MaxInt32,
+	"bigint":    math.MaxInt64,
+}
+
+func autoIncrementLimit(dataType string, columnType string) uint64 {
+	limit := autoIncrementLimits[strings.ToLower(dataType)]
+	if strings.Contains(strings.ToLower(columnType), "unsigned") {
+		limit = limit*2 + 1
+	}
+	return limit
+}
+
+// ModelTables returns the table names of the registered models.
+func ModelTables(db *gorm.DB) ([]string, error) {
+	tables := make([]string, 0, len(models))
+	for _, model := range models {
+		stmt := &gorm.Statement{DB: db}
+		err := stmt.Parse(model)
+		if err != nil {
+			return nil, err
+		}
+		tables = append(tables, stmt.Schema.Table)
+	}
+	return tables, nil
+}
+
+// TableStats reports every registered model whose table exists.
+func TableStats(ctx context.Context, db *gorm.DB) ([]TableStat, error) {
+	tables, err := ModelTables(db)
+	if err != nil {
+		return nil, err
+	}
+
+	var rows []struct {
+		TableName     string
+		TableRows     int64
+		DataLength    int64
+		IndexLength   int64
+		AutoIncrement *uint64
+		DataType      *string
+		ColumnType    *string
+	}
+	err = db.WithContext(ctx).Raw(`SELECT t.table_name AS table_name, coalesce(t.table_rows, 0) AS table_rows,
+		coalesce(t.data_length, 0) AS data_length, coalesce(t.index_length, 0) AS index_length,
+		t.auto_increment AS auto_increment, c.data_type AS data_type, c.column_type AS column_type
+		FROM information_schema.tables t
+		LEFT JOIN information_schema.columns c ON c.table_schema = t.table_schema
+			AND c.table_name = t.table_name AND c.extra LIKE '%auto_increment%'
+		WHERE t.table_schema = DATABASE() AND t.table_name IN ?
+		ORDER BY t.data_length + t.index_length DESC`, tables).Scan(&rows).Error
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]TableStat, len(rows))
+	for i, row := range rows {
+		stats[i] = TableStat{
+			Table:      row.TableName,
+			Rows:       row.TableRows,
+			DataBytes:  row.DataLength,
+			IndexBytes: row.IndexLength,
+		}
+		if row.DataType != nil && row.ColumnType != nil {
+			stats[i].AutoIncrement = row.AutoIncrement
+			stats[i].MaxID = autoIncrementLimit(*row.DataType, *row.ColumnType)
+		}
+	}
+	return stats, nil
+}
+
+// RecordTableStats stores the current TableStats in table_stats, run it
+// periodically to track growth.
+func RecordTableStats(ctx context.Context, db *gorm.DB) ([]TableStat, error) {
+	stats, err := TableStats(ctx, db)
+	if err != nil || len(stats) == 0 {
+		return stats, err
+	}
+
+	now := Now(db)
+	snapshots := make([]TableStatSnapshot, len(stats))
+	for i, stat := range stats {
+		snapshots[i] = TableStatSnapshot{
+			Table:         stat.Table,
+			Rows:          stat.Rows,
+			DataBytes:     stat.DataBytes,
+			IndexBytes:    stat.IndexBytes,
+			AutoIncrement: stat.AutoIncrement,
+			CapturedAt:    now,
+		}
+	}
+	return stats, db.WithContext(ctx).Create(&snapshots).Error
+}
+
+// TableGrowthSince compares the first and the last snapshot of every table
+// recorded since the given time.
+func TableGrowthSince(ctx context.Context, db *gorm.DB, since time.Time) ([]TableGrowth, error) {
+	var snapshots []TableStatSnapshot
+	err := db.WithContext(ctx).Where("captured_at >= ?", since).Order("table_name, captured_at").Find(&snapshots).Error
+	if err != nil {
+		return nil, err
+	}
+
+	var growth []TableGrowth
+	for start := 0; start < len(snapshots); {
+		end := start
+		for end+1 < len(snapshots) && snapshots[end+1].Table == snapshots[start].Table {
+			end++
+		}
+
+		first, last := snapshots[start], snapshots[end]
+		entry := TableGrowth{
+			Table: first.Table,
+			From:  first.CapturedAt,
+			To:    last.CapturedAt,
+			Rows:  last.Rows - first.Rows,
+			Bytes: last.DataBytes + last.IndexBytes - first.DataBytes - first.IndexBytes,
+		}
+		if days := last.CapturedAt.Sub(first.CapturedAt).Hours() / 24; days > 0 {
+			entry.RowsPerDay = float64(entry.Rows) / days
+			entry.BytesPerDay = float64(entry.Bytes) / days
+		}
+		growth = append(growth, entry)
+		start = end + 1
+	}
+	return growth, nil
+}
@@ -0,0 +1,178 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type Tag struct {
+	ID        int64     `gorm:"primary_key;column:id;autoIncrement"`
+	Name      string    `gorm:"column:name;uniqueIndex"`
+	CreatedAt time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (t *Tag) TableName() string {
+	return "tags"
+}
+
+type Tagging struct {
+	ID           int64     `gorm:"primary_key;column:id;autoIncrement"`
+	TagID        int64     `gorm:"column:tag_id;uniqueIndex:idx_taggings_tag_taggable"`
+	TaggableType string    `gorm:"column:taggable_type;uniqueIndex:idx_taggings_tag_taggable;index:idx_taggings_taggable"`
+	TaggableID   string    `gorm:"column:taggable_id;uniqueIndex:idx_taggings_tag_taggable;index:idx_taggings_taggable"`
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime"`
+	Tag          Tag       `gorm:"foreignKey:tag_id;references:id"`
+}
+
+func (t *Tagging) TableName() string {
+	return "taggings"
+}
+
+type TagCount struct {
+	Name  string
+	Count int64
+}
+
+// TagService tags any model with a single primary key (Product, Todo,
+// GuestBook), the taggable type is the table name of the model.
+// TagService tags rows of any model, the finds load at most MaxRows rows,
+// DefaultMaxRows when not positive.
+type TagService struct {
+	MaxRows int
+
+	db *gorm.DB
+}
+
+func NewTagService(db *gorm.DB) *TagService {
+	return &TagService{db: db}
+}
+
+func (s *TagService) taggable(model interface{}) (string, string, error) {
+	stmt := &gorm.Statement{DB: s.db}
+	err := stmt.Parse(model)
+	if err != nil {
+		return "", "", err
+	}
+
+	field := stmt.Schema.PrioritizedPrimaryField
+	if field == nil {
+		return "", "", fmt.Errorf("%s has no primary key", stmt.Schema.Name)
+	}
+
+	value, zero := field.ValueOf(context.Background(), reflect.Indirect(reflect.ValueOf(model)))
+	if zero {
+		return "", "", fmt.Errorf("%s has empty primary key", stmt.Schema.Name)
+	}
+
+	return stmt.Schema.Table, fmt.Sprint(value), nil
+}
+
+func (s *TagService) Attach(model interface{}, names ...string) error {
+	taggableType, taggableID, err := s.taggable(model)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Transaction(func(tx *gorm.DB) error {
+		for _, name := range names {
+			err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Tag{Name: name}).Error
+			if err != nil {
+				return err
+			}
+		}
+
+		var tags []Tag
+		err := tx.Where("name IN ?", names).Find(&tags).Error
+		if err != nil {
+			return err
+		}
+
+		for _, tag := range tags {
+			err = tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Tagging{
+				TagID:        tag.ID,
+				TaggableType: taggableType,
+				TaggableID:   taggableID,
+			}).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func (s *TagService) Detach(model interface{}, names ...string) error {
+	taggableType, taggableID, err := s.taggable(model)
+	if err != nil {
+		return err
+	}
+
+	return s.db.Where("taggable_type = ? AND taggable_id = ?", taggableType, taggableID).
+		Where("tag_id IN (?)", s.db.Model(&Tag{}).Select("id").Where("name IN ?", names)).
+		Delete(&Tagging{}).Error
+}
+
+func (s *TagService) TagsOf(model interface{}) ([]Tag, error) {
+	taggableType, taggableID, err := s.taggable(model)
+	if err != nil {
+		return nil, err
+	}
+
+	var tags []Tag
+	err = s.db.Joins("join taggings on taggings.tag_id = tags.id").
+		Where("taggings.taggable_type = ? AND taggings.taggable_id = ?", taggableType, taggableID).
+		Order("tags.name asc").
+		Find(&tags).Error
+	return tags, err
+}
+
+func (s *TagService) taggedIDs(taggableType string, names []string) *gorm.DB {
+	return s.db.Model(&Tagging{}).Select("taggings.taggable_id").
+		Joins("join tags on tags.id = taggings.tag_id").
+		Where("taggings.taggable_type = ? AND tags.name IN ?", taggableType, names)
+}
+
+// FindByAnyTag loads into dest (e.g. *[]Product) the rows tagged with at
+// least one of names, it tells whether more than MaxRows rows matched.
+func (s *TagService) FindByAnyTag(dest interface{}, names ...string) (bool, error) {
+	stmt := &gorm.Statement{DB: s.db}
+	err := stmt.Parse(dest)
+	if err != nil {
+		return false, err
+	}
+
+	return findLimited(s.db.Where("id IN (?)", s.taggedIDs(stmt.Schema.Table, names)).Order("id"), s.MaxRows, dest)
+}
+
+// FindByAllTags loads into dest the rows tagged with all of names like
+// FindByAnyTag, the relational division is done by counting the matching
+// distinct tags.
+func (s *TagService) FindByAllTags(dest interface{}, names ...string) (bool, error) {
+	stmt := &gorm.Statement{DB: s.db}
+	err := stmt.Parse(dest)
+	if err != nil {
+		return false, err
+	}
+
+	ids := s.taggedIDs(stmt.Schema.Table, names).
+		Group("taggings.taggable_id").
+		Having("count(distinct tags.id) = ?", len(names))
+	return findLimited(s.db.Where("id IN (?)", ids).Order("id"), s.MaxRows, dest)
+}
+
+func (s *TagService) PopularTags(limit int) ([]TagCount, error) {
+	var counts []TagCount
+	err := s.db.Model(&Tagging{}).Select("tags.name as name", "count(*) as count").
+		Joins("join tags on tags.id = taggings.tag_id").
+		Group("tags.name").
+		Order("count desc").Order("name asc").
+		Limit(limit).
+		Find(&counts).Error
+	return counts, err
+}
@@ -0,0 +1,111 @@
+package testutil
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+// Where is a condition for the assertions, like tx.Where("id = ?", id).
+// The assertions also take maps and structs, and nil for every row.
+func Where(sql string, vars ...interface{}) clause.Expr {
+	return clause.Expr{SQL: sql, Vars: vars}
+}
+
+func describe(tx *gorm.DB, model interface{}, where interface{}) string {
+	stmt := &gorm.Statement{DB: tx}
+	table := fmt.Sprintf("%T", model)
+	if err := stmt.Parse(model); err == nil {
+		table = stmt.Schema.Table
+	}
+	switch where := where.(type) {
+	case nil:
+		return table
+	case clause.Expr:
+		return fmt.Sprintf("%s where %s", table, tx.Dialector.Explain(where.SQL, where.Vars...))
+	default:
+		return fmt.Sprintf("%s where %+v", table, where)
+	}
+}
+
+func scope(tx *gorm.DB, model interface{}, where interface{}) *gorm.DB {
+	query := tx.Session(&gorm.Session{NewDB: true}).Model(model)
+	if where != nil {
+		query = query.Where(where)
+	}
+	return query
+}
+
+// AssertRowCount asserts that expected rows of model's table match where,
+// soft deleted rows are not counted.
+func AssertRowCount(t testing.TB, tx *gorm.DB, model interface{}, where interface{}, expected int64) bool {
+	t.Helper()
+	var count int64
+	err := scope(tx, model, where).Count(&count).Error
+	if !assert.NoError(t, err, "count %s", describe(tx, model, where)) {
+		return false
+	}
+	return assert.Equal(t, expected, count, "rows of %s", describe(tx, model, where))
+}
+
+// AssertExists asserts that at least one row matches where.
+func AssertExists(t testing.TB, tx *gorm.DB, model interface{}, where interface{}) bool {
+	t.Helper()
+	var count int64
+	err := scope(tx, model, where).Count(&count).Error
+	if !assert.NoError(t, err, "count %s", describe(tx, model, where)) {
+		return false
+	}
+	return assert.True(t, count > 0, "no row of %s", describe(tx, model, where))
+}
+
+// AssertNotExists asserts that no row matches where.
+func AssertNotExists(t testing.TB, tx *gorm.DB, model interface{}, where interface{}) bool {
+	t.Helper()
+	return AssertRowCount(t, tx, model, where, 0)
+}
+
+// AssertSoftDeleted asserts that rows match where and all of them are soft
+// deleted.
+func AssertSoftDeleted(t testing.TB, tx *gorm.DB, model interface{}, where interface{}) bool {
+	t.Helper()
+	var total, visible int64
+	err := scope(tx, model, where).Unscoped().Count(&total).Error
+	if err == nil {
+		err = scope(tx, model, where).Count(&visible).Error
+	}
+	if !assert.NoError(t, err, "count %s", describe(tx, model, where)) {
+		return false
+	}
+	if !assert.True(t, total > 0, "no row of %s, deleted or not", describe(tx, model, where)) {
+		return false
+	}
+	return assert.Equal(t, int64(0), visible, "rows of %s not soft deleted", describe(tx, model, where))
+}
+
+// AssertColumnEquals asserts that column holds expected in every row
+// matching where, and that there is such a row. The column is scanned into
+// the type of expected.
+func AssertColumnEquals(t testing.TB, tx *gorm.DB, model interface{}, where interface{}, column string, expected interface{}) bool {
+	t.Helper()
+	values := reflect.New(reflect.SliceOf(reflect.TypeOf(expected)))
+	err := scope(tx, model, where).Pluck(column, values.Interface()).Error
+	if !assert.NoError(t, err, "read %s of %s", column, describe(tx, model, where)) {
+		return false
+	}
+
+	values = values.Elem()
+	if !assert.True(t, values.Len() > 0, "no row of %s", describe(tx, model, where)) {
+		return false
+	}
+	for i := 0; i < values.Len(); i++ {
+		if !assert.Equal(t, expected, values.Index(i).Interface(), "%s of %s", column, describe(tx, model, where)) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,45 @@
+package testutil
+
+import (
+	"fmt"
+	"testing"
+
+	learn_golang_gorm "learn-golang-gorm"
+
+	"github.com/stretchr/testify/assert"
+	"gorm.io/driver/mysql"
+	"gorm.io/gorm"
+)
+
+type recordingT struct {
+	testing.TB
+	errors []string
+}
+
+func (t *recordingT) Helper() {}
+
+func (t *recordingT) Errorf(format string, args ...interface{}) {
+	t.errors = append(t.errors, fmt.Sprintf(format, args...))
+}
+
+func TestAssertRowCount(t *testing.T) {
+	// a dry run counts no rows without a server
+	db, err := gorm.Open(mysql.New(mysql.Config{DSN: "root:password@tcp(127.0.0.1:1)/test", SkipInitializeWithVersion: true}),
+		&gorm.Config{DryRun: true, DisableAutomaticPing: true})
+	assert.Nil(t, err)
+
+	recording := &recordingT{TB: t}
+	assert.True(t, AssertRowCount(recording, db, &learn_golang_gorm.User{}, nil, 0))
+	assert.True(t, AssertNotExists(recording, db, &learn_golang_gorm.User{}, Where("id = ?", "1")))
+	assert.Empty(t, recording.errors)
+
+	assert.False(t, AssertRowCount(recording, db, &learn_golang_gorm.User{}, Where("id = ?", "1"), 1))
+	assert.Len(t, recording.errors, 1)
+	assert.Contains(t, recording.errors[0], "rows of users where id = '1'")
+
+	assert.False(t, AssertSoftDeleted(recording, db, &learn_golang_gorm.Todo{}, map[string]interface{}{"user_id": "1"}))
+	assert.Contains(t, recording.errors[1], "no row of todos where map[user_id:1]")
+
+	assert.False(t, AssertColumnEquals(recording, db, &learn_golang_gorm.Product{}, nil, "price", int64(1000)))
+	assert.Contains(t, recording.errors[2], "no row of products")
+}
@@ -0,0 +1,76 @@
+// Package testutil helps tests that need a database.
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	learn_golang_gorm "learn-golang-gorm"
+
+	"gorm.io/gorm"
+)
+
+// Database is a migrated database of its own for a test binary, so test
+// packages can run in parallel against one MySQL server.
+type Database struct {
+	DB        *gorm.DB
+	Config    learn_golang_gorm.Config
+	Namespace string
+}
+
+var databases int64
+
+// DatabaseName returns a namespace no other running test binary uses.
+func DatabaseName() string {
+	return fmt.Sprintf("test_%d_%s_%d", os.Getpid(), strconv.FormatInt(time.Now().UnixNano(), 36), atomic.AddInt64(&databases, 1))
+}
+
+// CreateDatabase provisions a fresh namespace next to the database of
+// config, migrated and seeded.
+func CreateDatabase(config learn_golang_gorm.Config, seeds ...learn_golang_gorm.Seed) (*Database, error) {
+	namespace := DatabaseName()
+	db, err := learn_golang_gorm.CreateNamespace(context.Background(), config, namespace, seeds...)
+	if err != nil {
+		_ = learn_golang_gorm.DropNamespace(context.Background(), config, namespace)
+		return nil, err
+	}
+	config.Namespace = namespace
+	return &Database{DB: db, Config: config, Namespace: namespace}, nil
+}
+
+// Drop closes the connection and drops the database.
+func (d *Database) Drop() error {
+	if sqlDB, err := d.DB.DB(); err == nil {
+		_ = sqlDB.Close()
+	}
+	return learn_golang_gorm.DropNamespace(context.Background(), d.Config, d.Namespace)
+}
+
+// Main runs the tests of a package against a database of their own that
+// is dropped afterwards, call it from TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		os.Exit(testutil.Main(m, learn_golang_gorm.DefaultConfig(), func(database *gorm.DB) {
+//			db = database
+//		}))
+//	}
+func Main(m *testing.M, config learn_golang_gorm.Config, setup func(db *gorm.DB), seeds ...learn_golang_gorm.Seed) int {
+	database, err := CreateDatabase(config, seeds...)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "testutil:", err)
+		return 1
+	}
+	setup(database.DB)
+
+	code := m.Run()
+	err = database.Drop()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "testutil:", err)
+	}
+	return code
+}
@@ -0,0 +1,14 @@
+package testutil
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatabaseNameIsUniqueNamespace(t *testing.T) {
+	first, second := DatabaseName(), DatabaseName()
+	assert.NotEqual(t, first, second)
+	assert.Regexp(t, regexp.MustCompile(`^[a-z0-9_]{1,32}$`), first)
+}
@@ -1,16 +1,28 @@
 package learn_golang_gorm
 
 import (
+	"time"
+
 	"gorm.io/gorm"
 )
 
 type Todo struct {
 	gorm.Model
-	UserId      string `gorm:"column:user_id"`
-	Title       string `gorm:"column:title"`
-	Description string `gorm:"column:description"`
+	UserId         string     `gorm:"column:user_id"`
+	ParentID       *uint      `gorm:"column:parent_id"`
+	Title          string     `gorm:"column:title"`
+	Description    string     `gorm:"column:description"`
+	CompletedAt    *time.Time `gorm:"column:completed_at"`
+	Recurrence     string     `gorm:"column:recurrence"`
+	TemplateID     *uint      `gorm:"column:template_id;uniqueIndex:idx_todos_template_occurrence"`
+	OccurrenceDate *time.Time `gorm:"column:occurrence_date;type:date;uniqueIndex:idx_todos_template_occurrence"`
+	Children       []Todo     `gorm:"foreignKey:parent_id;references:id"`
 }
 
 func (t *Todo) TableName() string {
 	return "todos"
 }
+
+func (t *Todo) Completed() bool {
+	return t.CompletedAt != nil
+}
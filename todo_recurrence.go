@@ -0,0 +1,140 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+const (
+	FrequencyDaily   = "DAILY"
+	FrequencyWeekly  = "WEEKLY"
+	FrequencyMonthly = "MONTHLY"
+	FrequencyYearly  = "YEARLY"
+)
+
+var ErrInvalidRecurrence = errors.New("invalid recurrence rule")
+
+// RecurrenceRule is the subset of RRULE supported by Todo.Recurrence, for
+// example "FREQ=WEEKLY;INTERVAL=2;COUNT=10" or "FREQ=DAILY;UNTIL=20241231".
+type RecurrenceRule struct {
+	Frequency string
+	Interval  int
+	Count     int
+	Until     *time.Time
+}
+
+func ParseRecurrence(rule string) (RecurrenceRule, error) {
+	result := RecurrenceRule{Interval: 1}
+	for _, part := range strings.Split(rule, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), "=")
+		if !ok {
+			return RecurrenceRule{}, fmt.Errorf("%w: %q", ErrInvalidRecurrence, part)
+		}
+
+		switch strings.ToUpper(key) {
+		case "FREQ":
+			result.Frequency = strings.ToUpper(value)
+		case "INTERVAL", "COUNT":
+			number, err := strconv.Atoi(value)
+			if err != nil || number < 1 {
+				return RecurrenceRule{}, fmt.Errorf("%w: %q", ErrInvalidRecurrence, part)
+			}
+			if strings.ToUpper(key) == "INTERVAL" {
+				result.Interval = number
+			} else {
+				result.Count = number
+			}
+		case "UNTIL":
+			until, err := time.ParseInLocation("20060102", value, time.Local)
+			if err != nil {
+				return RecurrenceRule{}, fmt.Errorf("%w: %q", ErrInvalidRecurrence, part)
+			}
+			result.Until = &until
+		default:
+			return RecurrenceRule{}, fmt.Errorf("%w: unsupported %q", ErrInvalidRecurrence, key)
+		}
+	}
+
+	switch result.Frequency {
+	case FrequencyDaily, FrequencyWeekly, FrequencyMonthly, FrequencyYearly:
+		return result, nil
+	}
+	return RecurrenceRule{}, fmt.Errorf("%w: unsupported frequency %q", ErrInvalidRecurrence, result.Frequency)
+}
+
+func (r RecurrenceRule) nth(anchor time.Time, n int) time.Time {
+	step := n * r.Interval
+	switch r.Frequency {
+	case FrequencyWeekly:
+		return anchor.AddDate(0, 0, 7*step)
+	case FrequencyMonthly:
+		return anchor.AddDate(0, step, 0)
+	case FrequencyYearly:
+		return anchor.AddDate(step, 0, 0)
+	}
+	return anchor.AddDate(0, 0, step)
+}
+
+// Occurrences returns the dates of the rule starting at anchor that fall in
+// [from, to], COUNT is always counted from anchor.
+func (r RecurrenceRule) Occurrences(anchor time.Time, from time.Time, to time.Time) []time.Time {
+	anchor = truncateToDate(anchor)
+
+	var dates []time.Time
+	for n := 0; r.Count == 0 || n < r.Count; n++ {
+		date := r.nth(anchor, n)
+		if date.After(to) || (r.Until != nil && date.After(*r.Until)) {
+			break
+		}
+		if !date.Before(truncateToDate(from)) {
+			dates = append(dates, date)
+		}
+	}
+	return dates
+}
+
+func truncateToDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location())
+}
+
+// MaterializeRecurringTodos creates the concrete todos of every template
+// between today and until, each batch of templates is processed in one
+// transaction and (template_id, occurrence_date) makes reruns idempotent.
+func MaterializeRecurringTodos(db *gorm.DB, until time.Time, batchSize int) (int64, error) {
+	var created int64
+	var templates []Todo
+	result := db.Where("recurrence <> '' AND template_id IS NULL").FindInBatches(&templates, batchSize, func(_ *gorm.DB, _ int) error {
+		return db.Transaction(func(tx *gorm.DB) error {
+			for _, template := range templates {
+				rule, err := ParseRecurrence(template.Recurrence)
+				if err != nil {
+					return fmt.Errorf("todo %d: %w", template.ID, err)
+				}
+
+				templateID := template.ID
+				for _, date := range rule.Occurrences(template.CreatedAt, Now(tx), until) {
+					occurrenceDate := date
+					result := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&Todo{
+						UserId:         template.UserId,
+						Title:          template.Title,
+						Description:    template.Description,
+						TemplateID:     &templateID,
+						OccurrenceDate: &occurrenceDate,
+					})
+					if result.Error != nil {
+						return result.Error
+					}
+					created += result.RowsAffected
+				}
+			}
+			return nil
+		})
+	})
+	return created, result.Error
+}
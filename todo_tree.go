@@ -0,0 +1,155 @@
+package learn_golang_gorm
+
+import "gorm.io/gorm"
+
+func supportsRecursiveCTE(db *gorm.DB) bool {
+	switch db.Dialector.Name() {
+	case "mysql", "postgres", "sqlite", "sqlserver":
+		return true
+	}
+	return false
+}
+
+func findTodoSubtree(db *gorm.DB, rootID uint) ([]Todo, error) {
+	var todos []Todo
+	if supportsRecursiveCTE(db) {
+		tree := db.Raw("select * from todos where id = ? and deleted_at is null "+
+			"union all "+
+			"select t.* from todos t join tree on t.parent_id = tree.id where t.deleted_at is null", rootID)
+		err := db.Scopes(WithRecursiveCTE("tree", tree)).Table("tree").Order("id").Find(&todos).Error
+		return todos, err
+	}
+
+	ids := []uint{rootID}
+	for len(ids) > 0 {
+		var level []Todo
+		err := db.Where("id IN ?", ids).Order("id").Find(&level).Error
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, level...)
+
+		ids = nil
+		err = db.Model(&Todo{}).Where("parent_id IN ?", idsOfTodos(level)).Pluck("id", &ids).Error
+		if err != nil {
+			return nil, err
+		}
+	}
+	return todos, nil
+}
+
+func idsOfTodos(todos []Todo) []uint {
+	ids := make([]uint, 0, len(todos))
+	for _, todo := range todos {
+		ids = append(ids, todo.ID)
+	}
+	return ids
+}
+
+// FindTodoTree returns the todo with all of its subtasks, at any depth,
+// attached to Children.
+func FindTodoTree(db *gorm.DB, rootID uint) (Todo, error) {
+	todos, err := findTodoSubtree(db, rootID)
+	if err != nil {
+		return Todo{}, err
+	}
+	if len(todos) == 0 {
+		return Todo{}, gorm.ErrRecordNotFound
+	}
+
+	children := map[uint][]int{}
+	for i, todo := range todos {
+		if todo.ParentID != nil {
+			children[*todo.ParentID] = append(children[*todo.ParentID], i)
+		}
+	}
+
+	var build func(i int) Todo
+	build = func(i int) Todo {
+		todo := todos[i]
+		todo.Children = nil
+		for _, child := range children[todo.ID] {
+			todo.Children = append(todo.Children, build(child))
+		}
+		return todo
+	}
+
+	for i, todo := range todos {
+		if todo.ID == rootID {
+			return build(i), nil
+		}
+	}
+	return Todo{}, gorm.ErrRecordNotFound
+}
+
+// CompleteTodo marks the todo completed and walks up the hierarchy,
+// completing every parent whose subtasks are now all completed.
+func CompleteTodo(db *gorm.DB, id uint) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		now := Now(tx)
+		for {
+			var todo Todo
+			err := tx.Take(&todo, "id = ?", id).Error
+			if err != nil {
+				return err
+			}
+
+			if !todo.Completed() {
+				err = tx.Model(&todo).Update("completed_at", now).Error
+				if err != nil {
+					return err
+				}
+			}
+
+			if todo.ParentID == nil {
+				return nil
+			}
+
+			var pending int64
+			err = tx.Model(&Todo{}).Where("parent_id = ? AND completed_at IS NULL", *todo.ParentID).
+				Count(&pending).Error
+			if err != nil {
+				return err
+			}
+			if pending > 0 {
+				return nil
+			}
+
+			id = *todo.ParentID
+		}
+	})
+}
+
+func AddSubtask(db *gorm.DB, parentID uint, subtask *Todo) error {
+	return db.Transaction(func(tx *gorm.DB) error {
+		var parent Todo
+		err := tx.Take(&parent, "id = ?", parentID).Error
+		if err != nil {
+			return err
+		}
+
+		subtask.ParentID = &parent.ID
+		if subtask.UserId == "" {
+			subtask.UserId = parent.UserId
+		}
+		err = tx.Create(subtask).Error
+		if err != nil {
+			return err
+		}
+
+		for parent.Completed() {
+			err = tx.Model(&Todo{}).Where("id = ?", parent.ID).Update("completed_at", nil).Error
+			if err != nil || parent.ParentID == nil {
+				return err
+			}
+
+			id := *parent.ParentID
+			parent = Todo{}
+			err = tx.Take(&parent, "id = ?", id).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
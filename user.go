@@ -7,15 +7,18 @@ import (
 )
 
 type User struct {
-	ID           string    `gorm:"primary_key;column:id;<-:create"`
-	Password     string    `gorm:"column:password"`
-	Name         Name      `gorm:"embedded"`
-	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime;<-:create"`
-	UpdatedAt    time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
-	Information  string    `gorm:"-"`
-	Wallet       Wallet    `gorm:"foreignKey:user_id;references:id"`
-	Addresses    []Address `gorm:"foreignKey:user_id;references:id"`
-	LikeProducts []Product `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:user_id;references:id;joinReferences:product_id"`
+	ID           string      `gorm:"primary_key;column:id;<-:create"`
+	Password     string      `gorm:"column:password"`
+	Name         Name        `gorm:"embedded"`
+	FullName     string      `gorm:"column:full_name;->;-:migration"`
+	Email        Email       `gorm:"column:email;type:varchar(255);index"`
+	Phone        PhoneNumber `gorm:"column:phone;type:varchar(20);index"`
+	CreatedAt    time.Time   `gorm:"column:created_at;autoCreateTime;<-:create"`
+	UpdatedAt    time.Time   `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+	Information  string      `gorm:"-"`
+	Wallet       Wallet      `gorm:"foreignKey:user_id;references:id"`
+	Addresses    []Address   `gorm:"foreignKey:user_id;references:id"`
+	LikeProducts []Product   `gorm:"many2many:user_like_product;foreignKey:id;joinForeignKey:user_id;references:id;joinReferences:product_id"`
 }
 
 func (u *User) TableName() string {
@@ -24,7 +27,7 @@ func (u *User) TableName() string {
 
 func (u *User) BeforeCreate(db *gorm.DB) error {
 	if u.ID == "" {
-		u.ID = "user-" + time.Now().Format("20060102150405")
+		u.ID = "user-" + Now(db).Format("20060102150405")
 	}
 	return nil
 }
@@ -39,6 +42,8 @@ type UserLog struct {
 	ID        int    `gorm:"primary_key;column:id;autoIncrement"`
 	UserID    string `gorm:"column:user_id"`
 	Action    string `gorm:"action"`
+	IP        INET   `gorm:"column:ip;type:varbinary(16)"`
+	UserAgent string `gorm:"column:user_agent"`
 	CreatedAt int64  `gorm:"column:created_at;autoCreateTime:milli"`
 	UpdatedAt int64  `gorm:"column:updated_at;autoCreateTime:milli;autoUpdateTime:milli"`
 }
@@ -46,3 +51,17 @@ type UserLog struct {
 func (l *UserLog) TableName() string {
 	return "user_logs"
 }
+
+func (l *UserLog) BeforeCreate(db *gorm.DB) error {
+	info, ok := ClientInfoFromContext(db.Statement.Context)
+	if !ok {
+		return nil
+	}
+	if !l.IP.IsValid() {
+		l.IP = info.IP
+	}
+	if l.UserAgent == "" {
+		l.UserAgent = info.UserAgent
+	}
+	return nil
+}
@@ -0,0 +1,156 @@
+package learn_golang_gorm
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+var ErrInvalidPreference = errors.New("invalid preference")
+
+type Preferences struct {
+	Language           string `json:"language"`
+	Timezone           string `json:"timezone"`
+	Theme              string `json:"theme"`
+	EmailNotifications bool   `json:"email_notifications"`
+	PageSize           int    `json:"page_size"`
+}
+
+var DefaultPreferences = Preferences{
+	Language:           "id",
+	Timezone:           "Asia/Jakarta",
+	Theme:              "light",
+	EmailNotifications: true,
+	PageSize:           DefaultPageSize,
+}
+
+func (p Preferences) Validate() error {
+	if p.Theme != "light" && p.Theme != "dark" {
+		return fmt.Errorf("%w: unknown theme %q", ErrInvalidPreference, p.Theme)
+	}
+	if p.PageSize < 1 || p.PageSize > MaxPageSize {
+		return fmt.Errorf("%w: page_size must be between 1 and %d", ErrInvalidPreference, MaxPageSize)
+	}
+	if _, err := time.LoadLocation(p.Timezone); err != nil {
+		return fmt.Errorf("%w: unknown timezone %q", ErrInvalidPreference, p.Timezone)
+	}
+	return nil
+}
+
+func preferenceKeys() map[string]bool {
+	keys := map[string]bool{}
+	preferencesType := reflect.TypeOf(Preferences{})
+	for i := 0; i < preferencesType.NumField(); i++ {
+		keys[strings.Split(preferencesType.Field(i).Tag.Get("json"), ",")[0]] = true
+	}
+	return keys
+}
+
+type UserPreference struct {
+	UserID      string          `gorm:"primary_key;column:user_id"`
+	Preferences json.RawMessage `gorm:"column:preferences;type:json"`
+	CreatedAt   time.Time       `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt   time.Time       `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (p *UserPreference) TableName() string {
+	return "user_preferences"
+}
+
+// GetPreferences returns the stored preferences of the user merged over
+// DefaultPreferences, a user without stored preferences gets the defaults.
+func GetPreferences(db *gorm.DB, userID string) (Preferences, error) {
+	preferences := DefaultPreferences
+
+	var stored UserPreference
+	err := db.Take(&stored, "user_id = ?", userID).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return preferences, nil
+	}
+	if err != nil {
+		return preferences, err
+	}
+
+	if len(stored.Preferences) > 0 {
+		err = json.Unmarshal(stored.Preferences, &preferences)
+	}
+	return preferences, err
+}
+
+// SetPreferences validates the partial update against Preferences, unknown
+// keys and wrongly typed values are rejected, and only writes the given keys
+// with JSON_SET.
+func SetPreferences(db *gorm.DB, userID string, values map[string]interface{}) error {
+	if len(values) == 0 {
+		return nil
+	}
+
+	allowed := preferenceKeys()
+	for key := range values {
+		if !allowed[key] {
+			return fmt.Errorf("%w: unknown key %q", ErrInvalidPreference, key)
+		}
+	}
+
+	patch, err := json.Marshal(values)
+	if err != nil {
+		return err
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		merged, err := GetPreferences(tx, userID)
+		if err != nil {
+			return err
+		}
+
+		decoder := json.NewDecoder(bytes.NewReader(patch))
+		decoder.DisallowUnknownFields()
+		err = decoder.Decode(&merged)
+		if err != nil {
+			return fmt.Errorf("%w: %s", ErrInvalidPreference, err.Error())
+		}
+
+		err = merged.Validate()
+		if err != nil {
+			return err
+		}
+
+		err = tx.Clauses(clause.OnConflict{DoNothing: true}).
+			Create(&UserPreference{UserID: userID, Preferences: json.RawMessage("{}")}).Error
+		if err != nil {
+			return err
+		}
+
+		keys := make([]string, 0, len(values))
+		for key := range values {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		sql := "preferences"
+		args := []interface{}{}
+		for _, key := range keys {
+			value, err := json.Marshal(values[key])
+			if err != nil {
+				return err
+			}
+			sql += ", '$." + key + "', cast(? as json)"
+			args = append(args, string(value))
+		}
+
+		return tx.Model(&UserPreference{}).Where("user_id = ?", userID).
+			Update("preferences", gorm.Expr("json_set("+sql+")", args...)).Error
+	})
+}
+
+func SetPreference(db *gorm.DB, userID string, key string, value interface{}) error {
+	return SetPreferences(db, userID, map[string]interface{}{key: value})
+}
@@ -0,0 +1,34 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+	"time"
+)
+
+type WalletTransaction struct {
+	ID          int64     `gorm:"primary_key;column:id;autoIncrement"`
+	WalletID    string    `gorm:"column:wallet_id;index"`
+	Amount      int64     `gorm:"column:amount"`
+	Balance     int64     `gorm:"column:balance"`
+	Description string    `gorm:"column:description"`
+	HashChain   HashChain `gorm:"embedded"`
+	CreatedAt   time.Time `gorm:"column:created_at;autoCreateTime"`
+}
+
+func (t *WalletTransaction) TableName() string {
+	return "wallet_transactions"
+}
+
+func (t *WalletTransaction) ChainID() int64 {
+	return t.ID
+}
+
+func (t *WalletTransaction) ChainLink() *HashChain {
+	return &t.HashChain
+}
+
+// ChainContent covers CreatedAt, which AppendChained sets from the clock of
+// its transaction.
+func (t *WalletTransaction) ChainContent() string {
+	return fmt.Sprintf("%s|%d|%d|%s|%d", t.WalletID, t.Amount, t.Balance, t.Description, t.CreatedAt.UnixMilli())
+}
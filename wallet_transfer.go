@@ -0,0 +1,68 @@
+package learn_golang_gorm
+
+import (
+	"errors"
+
+	"gorm.io/gorm"
+)
+
+var (
+	ErrInsufficientBalance = errors.New("insufficient balance")
+	ErrInvalidAmount       = errors.New("amount must be greater than zero")
+	ErrSameWallet          = errors.New("cannot transfer to the same wallet")
+)
+
+func TransferBalance(db *gorm.DB, fromWalletID string, toWalletID string, amount int64) error {
+	if amount <= 0 {
+		return ErrInvalidAmount
+	}
+	if fromWalletID == toWalletID {
+		return ErrSameWallet
+	}
+
+	return db.Transaction(func(tx *gorm.DB) error {
+		var wallets []Wallet
+		err := LockRows(tx, &wallets, []string{fromWalletID, toWalletID})
+		if err != nil {
+			return err
+		}
+		if len(wallets) != 2 {
+			return gorm.ErrRecordNotFound
+		}
+
+		for _, wallet := range wallets {
+			if wallet.ID == fromWalletID && wallet.Balance < amount {
+				return ErrInsufficientBalance
+			}
+		}
+
+		for _, wallet := range wallets {
+			delta := amount
+			description := "transfer from " + fromWalletID
+			if wallet.ID == fromWalletID {
+				delta = -amount
+				description = "transfer to " + toWalletID
+			}
+
+			err = tx.Model(&Wallet{}).Where("id = ?", wallet.ID).
+				Update("balance", gorm.Expr("balance + ?", delta)).Error
+			if err != nil {
+				return err
+			}
+
+			err = AppendChained(tx, &WalletTransaction{
+				WalletID:    wallet.ID,
+				Amount:      delta,
+				Balance:     wallet.Balance + delta,
+				Description: description,
+			})
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = PostJournal(tx, "transfer from "+fromWalletID+" to "+toWalletID,
+			Debit(WalletAccount(fromWalletID), amount), Credit(WalletAccount(toWalletID), amount))
+		return err
+	})
+}
@@ -0,0 +1,81 @@
+package learn_golang_gorm
+
+import (
+	"fmt"
+	"strings"
+
+	"gorm.io/gorm"
+	"gorm.io/gorm/schema"
+)
+
+var ErrUnknownAssociation = fmt.Errorf("%w: unknown association", ErrInvalidRequest)
+
+// countSubquery counts the rows of the association for the row of the outer
+// query, soft deleted ones left out like Preload does.
+func countSubquery(stmt *gorm.Statement, relation *schema.Relationship) string {
+	const alias = "counted"
+	from := relation.FieldSchema
+	if relation.Type == schema.Many2Many {
+		from = relation.JoinTable
+	}
+
+	var conditions []string
+	for _, reference := range relation.References {
+		if relation.Type == schema.Many2Many && !reference.OwnPrimaryKey {
+			continue
+		}
+		if reference.PrimaryKey == nil {
+			conditions = append(conditions, fmt.Sprintf("%s.%s = %s", stmt.Quote(alias), stmt.Quote(reference.ForeignKey.DBName),
+				"'"+strings.ReplaceAll(reference.PrimaryValue, "'", "''")+"'"))
+			continue
+		}
+
+		inner, outer := reference.ForeignKey, reference.PrimaryKey
+		if relation.Type == schema.BelongsTo {
+			inner, outer = reference.PrimaryKey, reference.ForeignKey
+		}
+		conditions = append(conditions, fmt.Sprintf("%s.%s = %s.%s", stmt.Quote(alias), stmt.Quote(inner.DBName),
+			stmt.Quote(stmt.Table), stmt.Quote(outer.DBName)))
+	}
+	for _, field := range from.Fields {
+		if field.FieldType == deletedAtType {
+			conditions = append(conditions, fmt.Sprintf("%s.%s is null", stmt.Quote(alias), stmt.Quote(field.DBName)))
+		}
+	}
+
+	return fmt.Sprintf("(select count(*) from %s %s where %s)", stmt.Quote(from.Table), stmt.Quote(alias),
+		strings.Join(conditions, " and "))
+}
+
+// WithCounts selects the number of rows of each association as
+// <association>_count, e.g. AddressesCount and LikeProductsCount of a DTO
+// scanned from users, without loading the rows themselves.
+func WithCounts(associations ...string) func(db *gorm.DB) *gorm.DB {
+	return func(db *gorm.DB) *gorm.DB {
+		stmt := db.Statement
+		model := stmt.Model
+		if model == nil {
+			model = stmt.Dest
+		}
+		err := stmt.Parse(model)
+		if err != nil {
+			_ = db.AddError(err)
+			return db
+		}
+
+		columns := stmt.Selects
+		if len(columns) == 0 {
+			columns = []string{stmt.Quote(stmt.Table) + ".*"}
+		}
+		for _, association := range associations {
+			relation, ok := stmt.Schema.Relationships.Relations[association]
+			if !ok {
+				_ = db.AddError(fmt.Errorf("%w: %s of %s", ErrUnknownAssociation, association, stmt.Schema.Name))
+				return db
+			}
+			column := stmt.NamingStrategy.ColumnName("", association+"Count")
+			columns = append(columns, countSubquery(stmt, relation)+" as "+stmt.Quote(column))
+		}
+		return db.Select(columns)
+	}
+}
@@ -0,0 +1,260 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+func init() {
+	// values of queued updates are journaled as interfaces
+	gob.Register(time.Time{})
+	gob.Register(INET{})
+}
+
+// deferredUpdate sets Values on the row of Table matching Key.
+type deferredUpdate struct {
+	Table  string
+	Key    map[string]interface{}
+	Values map[string]interface{}
+}
+
+func (u deferredUpdate) id() string {
+	columns := make([]string, 0, len(u.Key))
+	for column := range u.Key {
+		columns = append(columns, column)
+	}
+	sort.Strings(columns)
+	var id strings.Builder
+	id.WriteString(u.Table)
+	for _, column := range columns {
+		fmt.Fprintf(&id, "|%s=%v", column, u.Key[column])
+	}
+	return id.String()
+}
+
+// WriteBehind defers low value updates, like last seen times, and applies
+// them in one transaction every FlushInterval or once MaxPending rows wait.
+// Updates of the same row are coalesced, the latest value of a column
+// wins, so they must set absolute values, never increments.
+//
+// With a journal the updates are appended to a file until applied and
+// replayed by the next NewWriteBehind after a crash, some may be applied
+// twice. The file is not synced on every update, a crash of the host can
+// lose the last ones.
+type WriteBehind struct {
+	DB            *gorm.DB
+	FlushInterval time.Duration
+	MaxPending    int
+	OnError       func(err error)
+
+	mutex   sync.Mutex
+	pending map[string]*deferredUpdate
+	full    chan struct{}
+	journal string
+	file    *os.File
+	encoder *gob.Encoder
+}
+
+// NewWriteBehind returns a queue journaled in the file journal, or kept in
+// memory only when it is empty, with the updates left in the journal.
+func NewWriteBehind(db *gorm.DB, journal string) (*WriteBehind, error) {
+	w := &WriteBehind{
+		DB:            db,
+		FlushInterval: 5 * time.Second,
+		MaxPending:    1000,
+		pending:       map[string]*deferredUpdate{},
+		full:          make(chan struct{}, 1),
+		journal:       journal,
+	}
+	if journal == "" {
+		return w, nil
+	}
+
+	file, err := os.Open(journal)
+	if err == nil {
+		decoder := gob.NewDecoder(file)
+		for {
+			var update deferredUpdate
+			err = decoder.Decode(&update)
+			if err != nil {
+				break
+			}
+			w.merge(&update)
+		}
+		file.Close()
+		// a crash while appending leaves a truncated last update
+		if err != io.EOF && !errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, err
+		}
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+	return w, w.compact()
+}
+
+func (w *WriteBehind) merge(update *deferredUpdate) {
+	id := update.id()
+	queued, ok := w.pending[id]
+	if !ok {
+		w.pending[id] = update
+		return
+	}
+	for column, value := range update.Values {
+		queued.Values[column] = value
+	}
+}
+
+// compact rewrites the journal with the pending updates, w.mutex must be
+// held.
+func (w *WriteBehind) compact() error {
+	if w.journal == "" {
+		return nil
+	}
+	file, err := os.Create(w.journal + ".tmp")
+	if err != nil {
+		return err
+	}
+	encoder := gob.NewEncoder(file)
+	for _, update := range w.pending {
+		err = encoder.Encode(update)
+		if err != nil {
+			file.Close()
+			return err
+		}
+	}
+	err = file.Sync()
+	if err == nil {
+		err = os.Rename(w.journal+".tmp", w.journal)
+	}
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	if w.file != nil {
+		w.file.Close()
+	}
+	w.file, w.encoder = file, encoder
+	return nil
+}
+
+// Update queues setting values on the row of table matching key, e.g.
+// Update("sessions", map[string]interface{}{"id": 1}, map[string]interface{}{"last_seen_at": now}).
+func (w *WriteBehind) Update(table string, key map[string]interface{}, values map[string]interface{}) error {
+	update := &deferredUpdate{Table: table, Key: key, Values: map[string]interface{}{}}
+	for column, value := range values {
+		update.Values[column] = value
+	}
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.encoder != nil {
+		err := w.encoder.Encode(update)
+		if err != nil {
+			return err
+		}
+	}
+	w.merge(update)
+	if w.MaxPending > 0 && len(w.pending) >= w.MaxPending {
+		select {
+		case w.full <- struct{}{}:
+		default:
+		}
+	}
+	return nil
+}
+
+// Pending is the number of rows with queued updates.
+func (w *WriteBehind) Pending() int {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return len(w.pending)
+}
+
+// Flush applies the queued updates in one transaction, in key order so
+// concurrent flushes do not deadlock. Failed updates stay queued, the
+// values queued since win over theirs.
+func (w *WriteBehind) Flush(ctx context.Context) error {
+	w.mutex.Lock()
+	batch := w.pending
+	w.pending = map[string]*deferredUpdate{}
+	w.mutex.Unlock()
+	if len(batch) == 0 {
+		return nil
+	}
+
+	ids := make([]string, 0, len(batch))
+	for id := range batch {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	err := w.DB.WithContext(ctx).Transaction(func(tx *gorm.DB) error {
+		for _, id := range ids {
+			update := batch[id]
+			err := tx.Table(update.Table).Where(update.Key).Updates(update.Values).Error
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if err != nil {
+		newer := w.pending
+		w.pending = batch
+		for _, update := range newer {
+			w.merge(update)
+		}
+		return err
+	}
+	return w.compact()
+}
+
+// Run flushes the queue until ctx is done, and a last time before
+// returning. Errors are reported to OnError and do not stop it.
+func (w *WriteBehind) Run(ctx context.Context) error {
+	ticker := time.NewTicker(w.FlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			err := w.Flush(context.WithoutCancel(ctx))
+			if err != nil && w.OnError != nil {
+				w.OnError(err)
+			}
+			return ctx.Err()
+		case <-ticker.C:
+		case <-w.full:
+		}
+
+		err := w.Flush(ctx)
+		if err != nil && ctx.Err() == nil && w.OnError != nil {
+			w.OnError(err)
+		}
+	}
+}
+
+// Close releases the journal, call it after Run returned.
+func (w *WriteBehind) Close() error {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file, w.encoder = nil, nil
+	return err
+}
@@ -0,0 +1,263 @@
+package learn_golang_gorm
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"gorm.io/gorm"
+)
+
+const (
+	XAStarted    = "started"
+	XACommitting = "committing"
+	XACommitted  = "committed"
+	XAAborted    = "aborted"
+)
+
+const xidPrefix = "gormxa-"
+
+// ErrTransactionInDoubt is returned when the commit was decided but a
+// participant could not be told, XACoordinator.Recover finishes it.
+var ErrTransactionInDoubt = errors.New("distributed transaction is committed but not applied everywhere yet")
+
+// XATransaction journals a distributed transaction on the coordinator, the
+// participants commit once Status is committing.
+type XATransaction struct {
+	XID          string    `gorm:"primary_key;column:xid;type:varchar(64)"`
+	Participants string    `gorm:"column:participants"`
+	Status       string    `gorm:"column:status;index"`
+	CreatedAt    time.Time `gorm:"column:created_at;autoCreateTime"`
+	UpdatedAt    time.Time `gorm:"column:updated_at;autoCreateTime;autoUpdateTime"`
+}
+
+func (t *XATransaction) TableName() string {
+	return "xa_transactions"
+}
+
+// XACoordinator runs transactions spanning several databases, e.g. the
+// main and the logging one, with MySQL XA two phase commit. Transactions
+// left unfinished by a crash are committed or rolled back by Recover,
+// which must run at startup and periodically.
+//
+// Where XA is not available, write the main database first and make the
+// other writes retryable or compensate them, like LogWriter does for logs.
+type XACoordinator struct {
+	Journal      *gorm.DB
+	Participants map[string]*gorm.DB
+	// Timeout is the age after which Recover rolls back transactions that
+	// did not reach the commit decision.
+	Timeout time.Duration
+}
+
+func NewXACoordinator(journal *gorm.DB, participants map[string]*gorm.DB) *XACoordinator {
+	return &XACoordinator{Journal: journal, Participants: participants, Timeout: 10 * time.Minute}
+}
+
+func newXID(db *gorm.DB) (string, error) {
+	random := make([]byte, 12)
+	_, err := io.ReadFull(Entropy(db), random)
+	if err != nil {
+		return "", err
+	}
+	return xidPrefix + hex.EncodeToString(random), nil
+}
+
+// xa runs an XA statement on the branch of a participant, the name of the
+// participant. XA statements can not be prepared, so the xid is inlined as
+// hex literals.
+func xa(db *gorm.DB, statement string, xid string, branch string) error {
+	return db.Exec(fmt.Sprintf("XA %s X'%s',X'%s'", statement,
+		hex.EncodeToString([]byte(xid)), hex.EncodeToString([]byte(branch)))).Error
+}
+
+// isUnknownXID reports XAER_NOTA, the participant has no such transaction
+// because it was already finished.
+func isUnknownXID(err error) bool {
+	var mysqlErr *mysql.MySQLError
+	return errors.As(err, &mysqlErr) && mysqlErr.Number == 1397
+}
+
+func (c *XACoordinator) setStatus(ctx context.Context, xid string, status string) error {
+	return c.Journal.WithContext(context.WithoutCancel(ctx)).Model(&XATransaction{}).
+		Where("xid = ?", xid).Update("status", status).Error
+}
+
+// Run calls fn with a session per participant, all on one XA transaction,
+// and commits them all or none. The sessions skip the default transaction
+// of gorm, fn must not begin transactions on them.
+func (c *XACoordinator) Run(ctx context.Context, fn func(participants map[string]*gorm.DB) error) error {
+	names := make([]string, 0, len(c.Participants))
+	for name := range c.Participants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	xid, err := newXID(c.Journal)
+	if err != nil {
+		return err
+	}
+	err = c.Journal.WithContext(ctx).Create(&XATransaction{
+		XID: xid, Participants: strings.Join(names, ","), Status: XAStarted,
+	}).Error
+	if err != nil {
+		return err
+	}
+
+	// XA transactions belong to a connection, every participant keeps one
+	sessions := map[string]*gorm.DB{}
+	var pin func(i int) error
+	pin = func(i int) error {
+		if i < len(names) {
+			return c.Participants[names[i]].WithContext(ctx).Connection(func(conn *gorm.DB) error {
+				sessions[names[i]] = conn.Session(&gorm.Session{SkipDefaultTransaction: true})
+				return pin(i + 1)
+			})
+		}
+		return c.run(ctx, xid, names, sessions, fn)
+	}
+	return pin(0)
+}
+
+func (c *XACoordinator) run(ctx context.Context, xid string, names []string, sessions map[string]*gorm.DB, fn func(participants map[string]*gorm.DB) error) error {
+	started := 0
+	var err error
+	for _, name := range names {
+		err = xa(sessions[name], "START", xid, name)
+		if err != nil {
+			break
+		}
+		started++
+	}
+	if err == nil {
+		err = fn(sessions)
+	}
+	for _, name := range names[:started] {
+		endErr := xa(sessions[name], "END", xid, name)
+		if err == nil {
+			err = endErr
+		}
+	}
+	for _, name := range names[:started] {
+		if err != nil {
+			break
+		}
+		err = xa(sessions[name], "PREPARE", xid, name)
+	}
+
+	if err == nil {
+		err = c.setStatus(ctx, xid, XACommitting)
+	}
+	if err != nil {
+		for _, name := range names[:started] {
+			_ = xa(sessions[name].WithContext(context.WithoutCancel(ctx)), "ROLLBACK", xid, name)
+		}
+		_ = c.setStatus(ctx, xid, XAAborted)
+		return err
+	}
+
+	var commitErr error
+	for _, name := range names {
+		err = xa(sessions[name].WithContext(context.WithoutCancel(ctx)), "COMMIT", xid, name)
+		if err != nil && commitErr == nil {
+			commitErr = fmt.Errorf("%w: %s: %v", ErrTransactionInDoubt, name, err)
+		}
+	}
+	if commitErr != nil {
+		return commitErr
+	}
+	return c.setStatus(ctx, xid, XACommitted)
+}
+
+// finish commits or rolls back a branch of xid, one that is not known has
+// already been finished.
+func finish(ctx context.Context, db *gorm.DB, xid string, branch string, commit bool) error {
+	statement := "ROLLBACK"
+	if commit {
+		statement = "COMMIT"
+	}
+	err := xa(db.WithContext(ctx), statement, xid, branch)
+	if isUnknownXID(err) {
+		return nil
+	}
+	return err
+}
+
+// Recover completes the journaled transactions, committing those that
+// reached the decision and rolling back those older than Timeout that did
+// not, then finishes transactions the participants still hold prepared.
+// It returns how many transactions it finished.
+func (c *XACoordinator) Recover(ctx context.Context) (int, error) {
+	var journal []XATransaction
+	err := c.Journal.WithContext(ctx).
+		Where("status = ? OR (status = ? AND created_at < ?)", XACommitting, XAStarted, Now(c.Journal).Add(-c.Timeout)).
+		Order("created_at").Find(&journal).Error
+	if err != nil {
+		return 0, err
+	}
+
+	finished := 0
+	for _, transaction := range journal {
+		commit := transaction.Status == XACommitting
+		for _, name := range strings.Split(transaction.Participants, ",") {
+			participant := c.Participants[name]
+			if participant == nil {
+				return finished, fmt.Errorf("transaction %s has the unknown participant %s", transaction.XID, name)
+			}
+			err = finish(ctx, participant, transaction.XID, name, commit)
+			if err != nil {
+				return finished, err
+			}
+		}
+
+		status := XAAborted
+		if commit {
+			status = XACommitted
+		}
+		err = c.setStatus(ctx, transaction.XID, status)
+		if err != nil {
+			return finished, err
+		}
+		finished++
+	}
+
+	for _, participant := range c.Participants {
+		var prepared []struct {
+			GtridLength int    `gorm:"column:gtrid_length"`
+			Data        string `gorm:"column:data"`
+		}
+		err = participant.WithContext(ctx).Raw("XA RECOVER").Scan(&prepared).Error
+		if err != nil {
+			return finished, err
+		}
+		for _, transaction := range prepared {
+			if !strings.HasPrefix(transaction.Data, xidPrefix) || transaction.GtridLength > len(transaction.Data) {
+				continue
+			}
+			xid, branch := transaction.Data[:transaction.GtridLength], transaction.Data[transaction.GtridLength:]
+			var statuses []string
+			err = c.Journal.WithContext(ctx).Model(&XATransaction{}).Where("xid = ?", xid).
+				Pluck("status", &statuses).Error
+			if err != nil {
+				return finished, err
+			}
+			// still running, Run finishes it
+			if len(statuses) > 0 && statuses[0] == XAStarted {
+				continue
+			}
+			commit := len(statuses) > 0 && (statuses[0] == XACommitting || statuses[0] == XACommitted)
+			err = finish(ctx, participant, xid, branch, commit)
+			if err != nil {
+				return finished, err
+			}
+			finished++
+		}
+	}
+	return finished, nil
+}